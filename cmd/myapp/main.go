@@ -2,12 +2,21 @@
 package main
 
 import (
+	"context"
 	"myapp/internal/config"
+	"myapp/internal/grpcserver"
 	"myapp/internal/middleware"
+	"myapp/internal/migrations"
+	"myapp/internal/outbox"
+	"myapp/internal/repository"
 	"myapp/internal/router"
+	"myapp/internal/services"
+	"myapp/internal/tlsconfig"
 	"myapp/internal/utils"
+	"myapp/internal/webhooks"
 	"net/http"
 	"os"
+	"time"
 )
 
 func main() {
@@ -16,12 +25,62 @@ func main() {
 	log.Infof("Loading mongoClient..")
 	// Carica la configurazione e inizializza la connessione a MongoDB
 	config.GetMongoClient()
+	log.Infof("Ensuring indexes..")
+	// Crea gli indici delle collezioni (es. TTL delle chiavi di idempotenza)
+	if err := repository.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Unable to ensure indexes: %v", err)
+	}
+	log.Infof("Running schema migrations..")
+	// Applica o verifica le migrazioni dello schema secondo MIGRATIONS_MODE (auto, check o off)
+	if err := migrations.RunOnStartup(context.Background()); err != nil {
+		log.Fatalf("Unable to run schema migrations: %v", err)
+	}
+	log.Infof("Starting outbox relay..")
+	// Avvia il relay che pubblica gli eventi dell'outbox sui sink configurati
+	if utils.EnvOrDefault("OUTBOX_RELAY_ENABLED", "true") == "true" {
+		sink, err := outbox.NewSinkFromEnv()
+		if err != nil {
+			log.Fatalf("Unable to configure outbox sinks: %v", err)
+		}
+		go outbox.NewRelayFromEnv(sink).Run(context.Background())
+	}
+	log.Infof("Starting user event feed..")
+	// Segue gli eventi dell'outbox di tutte le repliche per invalidare la cache degli utenti e alimentare gli stream degli eventi
+	go services.RunUserEventFeed(context.Background())
+	log.Infof("Starting webhook dispatcher..")
+	// Avvia il dispatcher che consegna gli eventi alle sottoscrizioni webhook
+	if utils.EnvOrDefault("WEBHOOK_DISPATCHER_ENABLED", "true") == "true" {
+		go webhooks.NewDispatcherFromEnv().Run(context.Background())
+	}
 	log.Infof("Configuring zipkin tracer..")
 	// Configura il tracer di Zipkin
 	tracer := middleware.SetupZipkinTracer()
+	// Con TLS_CERT_FILE e TLS_KEY_FILE i server HTTP e gRPC rispondono solo in TLS (mutual TLS con TLS_CLIENT_CA_FILE)
+	tlsConfig, reloader, err := tlsconfig.ServerConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure TLS: %v", err)
+	}
+	if reloader != nil {
+		// Ricarica certificati e CA quando vengono rinnovati, senza riavviare il servizio
+		go reloader.Watch(context.Background(), utils.EnvDurationOrDefault("TLS_RELOAD_INTERVAL", 30*time.Second))
+	}
+	// Avvia il server gRPC accanto alle API REST
+	if utils.EnvOrDefault("GRPC_ENABLED", "true") == "true" {
+		grpcAddr := ":" + utils.EnvOrDefault("GRPC_PORT", "9090")
+		log.Infof("Starting gRPC server on %s (TLS: %t)", grpcAddr, tlsConfig != nil)
+		go func() {
+			log.Fatal(grpcserver.ListenAndServe(grpcAddr, tracer, tlsConfig))
+		}()
+	}
 	log.Infof("Configuring routes..")
 	// Configura e avvia il router
 	r := router.SetupRouter(tracer)
+	server := &http.Server{Addr: ":8080", Handler: r}
+	if tlsConfig != nil {
+		server.TLSConfig = tlsConfig
+		log.Infof("Starting HTTPS server on %s", os.Getenv("SERVICE_IP"))
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
 	log.Infof("Starting server on %s", os.Getenv("SERVICE_IP"))
-	log.Fatal(http.ListenAndServe(":8080", r))
+	log.Fatal(server.ListenAndServe())
 }
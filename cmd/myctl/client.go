@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"myapp/internal/config"
+	"myapp/internal/migrations"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// errUserNotFound indica che l'utente richiesto non esiste
+	errUserNotFound = errors.New("user not found")
+	// errDirectOnly indica un comando che richiede l'accesso diretto al database
+	errDirectOnly = errors.New("this command is only available in direct mode (-mode direct)")
+)
+
+// healthStatus è lo stato restituito dal comando health
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// userClient sono le operazioni di myctl, implementate sul database (directClient) o sulle API HTTP (httpClient)
+type userClient interface {
+	ListUsers(ctx context.Context, query models.UserQuery) (*models.UserPage, error)
+	GetUser(ctx context.Context, id string) (*models.User, error)
+	CreateUser(ctx context.Context, user models.User) (*models.User, error)
+	UpdateUser(ctx context.Context, id string, user models.User) (*models.User, error)
+	DeleteUser(ctx context.Context, id string) error
+	ExportUsers(ctx context.Context, w io.Writer, format string) error
+	ImportUsers(ctx context.Context, r io.Reader, opts models.ImportOptions) (*models.ImportReport, error)
+	EnsureIndexes(ctx context.Context) error
+	MigrationStatus(ctx context.Context) ([]models.MigrationStatus, error)
+	MigrateUp(ctx context.Context, target int, dryRun bool) ([]models.MigrationStatus, error)
+	MigrateDown(ctx context.Context, target int, dryRun bool) ([]models.MigrationStatus, error)
+	Health(ctx context.Context) (*healthStatus, error)
+}
+
+// directClient usa il livello services, quindi le modifiche scrivono gli eventi nell'outbox come quelle fatte via API
+type directClient struct{}
+
+func (directClient) ListUsers(ctx context.Context, query models.UserQuery) (*models.UserPage, error) {
+	return services.QueryUsers(ctx, query)
+}
+
+func (directClient) GetUser(ctx context.Context, id string) (*models.User, error) {
+	user, err := services.GetUserByID(ctx, id)
+	return user, notFound(err)
+}
+
+func (directClient) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
+	if err := services.ValidateUser(user); err != nil {
+		return nil, err
+	}
+	return services.CreateUser(ctx, user)
+}
+
+func (directClient) UpdateUser(ctx context.Context, id string, user models.User) (*models.User, error) {
+	if err := services.ValidateUser(user); err != nil {
+		return nil, err
+	}
+	updated, err := services.UpdateUser(ctx, id, user)
+	return updated, notFound(err)
+}
+
+func (directClient) DeleteUser(ctx context.Context, id string) error {
+	return notFound(services.DeleteUserByID(ctx, id))
+}
+
+func (directClient) ExportUsers(ctx context.Context, w io.Writer, format string) error {
+	_, err := services.ExportUsers(ctx, w, format)
+	return err
+}
+
+func (directClient) ImportUsers(ctx context.Context, r io.Reader, opts models.ImportOptions) (*models.ImportReport, error) {
+	return services.ImportUsers(ctx, r, opts)
+}
+
+func (directClient) EnsureIndexes(ctx context.Context) error {
+	return repository.EnsureIndexes(ctx)
+}
+
+func (directClient) MigrationStatus(ctx context.Context) ([]models.MigrationStatus, error) {
+	return migrations.Status(ctx)
+}
+
+func (directClient) MigrateUp(ctx context.Context, target int, dryRun bool) ([]models.MigrationStatus, error) {
+	return migrations.Up(ctx, target, dryRun)
+}
+
+func (directClient) MigrateDown(ctx context.Context, target int, dryRun bool) ([]models.MigrationStatus, error) {
+	return migrations.Down(ctx, target, dryRun)
+}
+
+// Health usa config.Connect invece di config.Ping: un database irraggiungibile è riportato come DOWN
+// invece di terminare myctl durante la connessione
+func (directClient) Health(ctx context.Context) (*healthStatus, error) {
+	err := config.Connect(ctx)
+	if err == nil {
+		err = config.Ping(ctx)
+	}
+	if err != nil {
+		return &healthStatus{Status: "DOWN", Checks: map[string]string{"mongodb": "DOWN"}}, err
+	}
+	return &healthStatus{Status: "UP", Checks: map[string]string{"mongodb": "UP"}}, nil
+}
+
+// notFound converte gli errori di utente inesistente (o ID non valido) in errUserNotFound
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+		return errUserNotFound
+	}
+	return err
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"myapp/internal/migrations"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// newFlagSet crea il FlagSet del sottocomando in esecuzione
+func newFlagSet(c *cli, name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: myctl %s\n", c.usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs legge i flag del sottocomando e verifica il numero di argomenti posizionali.
+// I flag possono seguire gli argomenti (es. update <id> -name x).
+func parseArgs(flags *flag.FlagSet, args []string, positional int) ([]string, error) {
+	var values []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		values = append(values, args[0])
+		args = args[1:]
+	}
+	if len(values) != positional {
+		flags.Usage()
+		return nil, errUsage
+	}
+	return values, nil
+}
+
+func runList(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet(c, "list")
+	query := models.UserQuery{}
+	flags.StringVar(&query.NameContains, "name", "", "filtra per nome (contiene, case-insensitive)")
+	flags.StringVar(&query.EmailContains, "email", "", "filtra per email (contiene, case-insensitive)")
+	flags.StringVar(&query.SortField, "sort", constants.SORT_FIELD_ID, "ordinamento: id, name o email")
+	flags.BoolVar(&query.Descending, "desc", false, "ordinamento decrescente")
+	flags.IntVar(&query.Limit, "limit", 20, "numero di utenti (max 100)")
+	flags.StringVar(&query.After, "after", "", "cursore restituito dalla pagina precedente")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	if query.Limit < 1 || query.Limit > 100 {
+		return errors.New("limit must be between 1 and 100")
+	}
+
+	page, err := c.client.ListUsers(ctx, query)
+	if err != nil {
+		return err
+	}
+	users := make([]models.User, len(page.Items))
+	for i, item := range page.Items {
+		users[i] = item.User
+	}
+	if c.output != outputTable {
+		return c.render(page, nil)
+	}
+	if err := c.renderUsers(users); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "%d of %d users\n", len(users), page.TotalCount)
+	if page.HasNextPage {
+		fmt.Fprintf(c.stderr, "next page: myctl list -after %s\n", page.Items[len(page.Items)-1].Cursor)
+	}
+	return nil
+}
+
+func runGet(ctx context.Context, c *cli, args []string) error {
+	values, err := parseArgs(newFlagSet(c, "get"), args, 1)
+	if err != nil {
+		return err
+	}
+	user, err := c.client.GetUser(ctx, values[0])
+	if err != nil {
+		return err
+	}
+	return c.renderUser(user)
+}
+
+func runCreate(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet(c, "create")
+	var user models.User
+	flags.StringVar(&user.Name, "name", "", "nome")
+	flags.StringVar(&user.Email, "email", "", "email")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	created, err := c.client.CreateUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	return c.renderUser(created)
+}
+
+func runUpdate(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet(c, "update")
+	name := flags.String("name", "", "nuovo nome")
+	email := flags.String("email", "", "nuova email")
+	values, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	if *name == "" && *email == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	// L'aggiornamento sostituisce l'utente: i campi non indicati vengono letti dallo stato attuale
+	user, err := c.client.GetUser(ctx, values[0])
+	if err != nil {
+		return err
+	}
+	if *name != "" {
+		user.Name = *name
+	}
+	if *email != "" {
+		user.Email = *email
+	}
+	updated, err := c.client.UpdateUser(ctx, values[0], *user)
+	if err != nil {
+		return err
+	}
+	return c.renderUser(updated)
+}
+
+func runDelete(ctx context.Context, c *cli, args []string) error {
+	values, err := parseArgs(newFlagSet(c, "delete"), args, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.client.DeleteUser(ctx, values[0]); err != nil {
+		return err
+	}
+	return c.renderMessage("deleted", fmt.Sprintf("user %s deleted", values[0]))
+}
+
+func runExport(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet(c, "export")
+	format := flags.String("format", "", "csv, ndjson o json (default: dall'estensione di -file, altrimenti json)")
+	file := flags.String("file", "", "file di destinazione (default stdout)")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	resolved, err := fileFormat(*format, *file)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = c.stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := c.client.ExportUsers(ctx, w, resolved); err != nil {
+		return err
+	}
+	if *file != "" {
+		fmt.Fprintf(c.stderr, "users exported to %s\n", *file)
+	}
+	return nil
+}
+
+func runImport(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet(c, "import")
+	format := flags.String("format", "", "csv, ndjson o json (default: dall'estensione di -file)")
+	file := flags.String("file", "", "file da importare")
+	opts := models.ImportOptions{}
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "valida senza scrivere")
+	flags.StringVar(&opts.OnConflict, "on-conflict", constants.CONFLICT_SKIP, "skip, overwrite o fail se l'ID esiste già")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	if *file == "" {
+		flags.Usage()
+		return errUsage
+	}
+	if opts.OnConflict != constants.CONFLICT_SKIP && opts.OnConflict != constants.CONFLICT_OVERWRITE && opts.OnConflict != constants.CONFLICT_FAIL {
+		return errors.New("on-conflict must be one of: skip, overwrite, fail")
+	}
+	var err error
+	if opts.Format, err = fileFormat(*format, *file); err != nil {
+		return err
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	report, importErr := c.client.ImportUsers(ctx, f, opts)
+	if report != nil {
+		if err := c.renderImportReport(report); err != nil {
+			return err
+		}
+	}
+	return importErr
+}
+
+func runIndexes(ctx context.Context, c *cli, args []string) error {
+	if _, err := parseArgs(newFlagSet(c, "indexes"), args, 0); err != nil {
+		return err
+	}
+	if err := c.client.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+	return c.renderMessage("ok", "indexes ensured")
+}
+
+func runMigrate(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet(c, "migrate")
+	target := flags.Int("to", -1, "versione di destinazione (up: default l'ultima; down: obbligatoria, 0 annulla tutte)")
+	dryRun := flags.Bool("dry-run", false, "mostra le migrazioni senza eseguirle")
+	values, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var statuses []models.MigrationStatus
+	switch values[0] {
+	case "status":
+		statuses, err = c.client.MigrationStatus(ctx)
+	case "up":
+		if *target < 0 {
+			*target = migrations.Latest()
+		}
+		statuses, err = c.client.MigrateUp(ctx, *target, *dryRun)
+	case "down":
+		// Annullare le migrazioni può perdere dati: la versione di destinazione va indicata esplicitamente
+		if *target < 0 {
+			flags.Usage()
+			return errUsage
+		}
+		statuses, err = c.client.MigrateDown(ctx, *target, *dryRun)
+	default:
+		flags.Usage()
+		return errUsage
+	}
+	// Anche in caso di errore vengono mostrate le migrazioni completate prima del fallimento
+	if statuses != nil {
+		renderErr := c.render(statuses, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED\tAPPLIED AT")
+			for _, status := range statuses {
+				appliedAt := ""
+				if status.AppliedAt != nil {
+					appliedAt = status.AppliedAt.Format(time.RFC3339)
+				}
+				applied := fmt.Sprint(status.Applied)
+				if status.Unknown {
+					applied += " (unknown)"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Description, applied, appliedAt)
+			}
+		})
+		if renderErr != nil {
+			return renderErr
+		}
+	}
+	if err == nil && *dryRun {
+		fmt.Fprintln(c.stderr, "dry run: no migrations executed")
+	}
+	return err
+}
+
+func runHealth(ctx context.Context, c *cli, args []string) error {
+	if _, err := parseArgs(newFlagSet(c, "health"), args, 0); err != nil {
+		return err
+	}
+	health, err := c.client.Health(ctx)
+	if health != nil {
+		renderErr := c.render(health, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "STATUS\t%s\n", health.Status)
+			for name, status := range health.Checks {
+				fmt.Fprintf(w, "%s\t%s\n", strings.ToUpper(name), status)
+			}
+		})
+		if renderErr != nil {
+			return renderErr
+		}
+	}
+	return err
+}
+
+// fileFormat restituisce il formato indicato o, se assente, quello dedotto dall'estensione del file
+func fileFormat(format, file string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".csv":
+			format = constants.FORMAT_CSV
+		case ".ndjson", ".jsonl":
+			format = constants.FORMAT_NDJSON
+		default:
+			format = constants.FORMAT_JSON
+		}
+	}
+	if _, ok := contentTypes[format]; !ok {
+		return "", fmt.Errorf("unsupported format %q: must be one of csv, ndjson, json", format)
+	}
+	return format, nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// contentTypes è il media type di ogni formato di import/export
+var contentTypes = map[string]string{
+	constants.FORMAT_CSV:    constants.CONTENT_TYPE_CSV,
+	constants.FORMAT_NDJSON: constants.CONTENT_TYPE_NDJSON,
+	constants.FORMAT_JSON:   constants.CONTENT_TYPE_JSON,
+}
+
+// usersQuery è la query GraphQL usata da list: a differenza di GET /users supporta filtri, ordinamento e cursori
+const usersQuery = `query ($filter: UserFilter, $sort: UserSort, $first: Int, $after: String) {
+  users(filter: $filter, sort: $sort, first: $first, after: $after) {
+    totalCount
+    edges { cursor node { id name email } }
+    pageInfo { hasNextPage }
+  }
+}`
+
+// httpClient usa le API REST e GraphQL di un'istanza in esecuzione
+type httpClient struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// apiError è una risposta di errore del servizio
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.Status, e.Message)
+}
+
+func newHTTPClient(baseURL string, tlsConfig *tls.Config, apiKey string) *httpClient {
+	// Il timeout complessivo è quello del contesto del comando
+	client := &http.Client{}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+	return &httpClient{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, client: client}
+}
+
+func (h *httpClient) ListUsers(ctx context.Context, query models.UserQuery) (*models.UserPage, error) {
+	variables := map[string]interface{}{"first": query.Limit}
+	if query.After != "" {
+		variables["after"] = query.After
+	}
+	filter := map[string]interface{}{}
+	if query.NameContains != "" {
+		filter["nameContains"] = query.NameContains
+	}
+	if query.EmailContains != "" {
+		filter["emailContains"] = query.EmailContains
+	}
+	variables["filter"] = filter
+	sort := map[string]interface{}{"direction": "ASC"}
+	if query.SortField != "" {
+		sort["field"] = strings.ToUpper(query.SortField)
+	}
+	if query.Descending {
+		sort["direction"] = "DESC"
+	}
+	variables["sort"] = sort
+
+	body, _ := json.Marshal(map[string]interface{}{"query": usersQuery, "variables": variables})
+	resp, err := h.do(ctx, http.MethodPost, constants.API_V1+constants.GRAPHQL, bytes.NewReader(body), constants.CONTENT_TYPE_JSON, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data struct {
+			Users *struct {
+				TotalCount int64 `json:"totalCount"`
+				Edges      []struct {
+					Cursor string      `json:"cursor"`
+					Node   models.User `json:"node"`
+				} `json:"edges"`
+				PageInfo struct {
+					HasNextPage bool `json:"hasNextPage"`
+				} `json:"pageInfo"`
+			} `json:"users"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding GraphQL response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return nil, errors.New(result.Errors[0].Message)
+	}
+	if resp.StatusCode != http.StatusOK || result.Data.Users == nil {
+		return nil, &apiError{Status: resp.StatusCode}
+	}
+
+	users := result.Data.Users
+	page := &models.UserPage{TotalCount: users.TotalCount, HasNextPage: users.PageInfo.HasNextPage, Items: make([]models.UserPageItem, len(users.Edges))}
+	for i, edge := range users.Edges {
+		page.Items[i] = models.UserPageItem{Cursor: edge.Cursor, User: edge.Node}
+	}
+	return page, nil
+}
+
+func (h *httpClient) GetUser(ctx context.Context, id string) (*models.User, error) {
+	var user models.User
+	err := h.doJSON(ctx, http.MethodGet, userPath(id), nil, &user)
+	return &user, err
+}
+
+func (h *httpClient) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
+	var created models.User
+	err := h.doJSON(ctx, http.MethodPost, constants.API_V1+constants.USERS, user, &created)
+	return &created, err
+}
+
+func (h *httpClient) UpdateUser(ctx context.Context, id string, user models.User) (*models.User, error) {
+	var updated models.User
+	err := h.doJSON(ctx, http.MethodPut, userPath(id), user, &updated)
+	return &updated, err
+}
+
+func (h *httpClient) DeleteUser(ctx context.Context, id string) error {
+	return h.doJSON(ctx, http.MethodDelete, userPath(id), nil, nil)
+}
+
+func (h *httpClient) ExportUsers(ctx context.Context, w io.Writer, format string) error {
+	resp, err := h.do(ctx, http.MethodGet, constants.API_V1+constants.USERS+constants.EXPORT, nil, "", http.Header{"Accept": {contentTypes[format]}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (h *httpClient) ImportUsers(ctx context.Context, r io.Reader, opts models.ImportOptions) (*models.ImportReport, error) {
+	query := url.Values{"dryRun": {strconv.FormatBool(opts.DryRun)}, "onConflict": {opts.OnConflict}}
+	resp, err := h.do(ctx, http.MethodPost, constants.API_V1+constants.USERS+constants.IMPORT+"?"+query.Encode(), r, contentTypes[opts.Format], nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Anche le risposte di errore (400, 409, 500) contengono il report dell'import
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var envelope struct {
+		Output *models.ImportReport `json:"output"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Output == nil || envelope.Output.Format == "" {
+		return nil, errorFromBody(resp.StatusCode, data)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return envelope.Output, &apiError{Status: resp.StatusCode, Message: "import failed"}
+	}
+	return envelope.Output, nil
+}
+
+func (h *httpClient) EnsureIndexes(ctx context.Context) error {
+	return errDirectOnly
+}
+
+func (h *httpClient) MigrationStatus(ctx context.Context) ([]models.MigrationStatus, error) {
+	return nil, errDirectOnly
+}
+
+func (h *httpClient) MigrateUp(ctx context.Context, target int, dryRun bool) ([]models.MigrationStatus, error) {
+	return nil, errDirectOnly
+}
+
+func (h *httpClient) MigrateDown(ctx context.Context, target int, dryRun bool) ([]models.MigrationStatus, error) {
+	return nil, errDirectOnly
+}
+
+func (h *httpClient) Health(ctx context.Context) (*healthStatus, error) {
+	resp, err := h.do(ctx, http.MethodGet, constants.HEALTH, nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Con MongoDB non raggiungibile il servizio risponde 503 con lo stato dei controlli
+	var envelope struct {
+		Output *healthStatus `json:"output"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || envelope.Output == nil {
+		return nil, &apiError{Status: resp.StatusCode}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return envelope.Output, &apiError{Status: resp.StatusCode, Message: "service is " + envelope.Output.Status}
+	}
+	return envelope.Output, nil
+}
+
+// doJSON invia payload come JSON e decodifica il campo output della risposta in out
+func (h *httpClient) doJSON(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(data), constants.CONTENT_TYPE_JSON
+	}
+	resp, err := h.do(ctx, method, path, body, contentType, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && strings.HasPrefix(path, constants.API_V1+constants.USERS+"/") {
+		return errUserNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	envelope := struct {
+		Output interface{} `json:"output"`
+	}{Output: out}
+	return json.NewDecoder(resp.Body).Decode(&envelope)
+}
+
+func (h *httpClient) do(ctx context.Context, method, path string, body io.Reader, contentType string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set(constants.ACTOR_HEADER, middleware.GetActor(ctx))
+	if h.apiKey != "" {
+		req.Header.Set(constants.API_KEY_HEADER, h.apiKey)
+	}
+	if tenant, ok := tenancy.FromContext(ctx); ok {
+		req.Header.Set(constants.TENANT_HEADER, tenant)
+	}
+	return h.client.Do(req)
+}
+
+// responseError legge il messaggio di errore da una risposta utils.Response
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return errorFromBody(resp.StatusCode, data)
+}
+
+// errorFromBody estrae il messaggio da RespondWithError, che annida l'errore nel campo output
+func errorFromBody(status int, data []byte) error {
+	var envelope struct {
+		Output struct {
+			ErrorMessages map[string]interface{} `json:"errorMessages"`
+		} `json:"output"`
+		ErrorMessages map[string]interface{} `json:"errorMessages"`
+	}
+	_ = json.Unmarshal(data, &envelope)
+	for _, messages := range []map[string]interface{}{envelope.Output.ErrorMessages, envelope.ErrorMessages} {
+		if message, ok := messages["message"].(string); ok {
+			return &apiError{Status: status, Message: message}
+		}
+	}
+	return &apiError{Status: status}
+}
+
+func userPath(id string) string {
+	return constants.API_V1 + constants.USERS + "/" + url.PathEscape(id)
+}
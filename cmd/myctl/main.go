@@ -0,0 +1,167 @@
+// main.go
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"myapp/internal/middleware"
+	"myapp/internal/tenancy"
+	"myapp/internal/tlsconfig"
+	"myapp/internal/utils"
+	"os"
+	"os/user"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// myctl è la CLI per gestire gli utenti senza passare da mongosh.
+// Lavora direttamente sul database (modalità direct, stesso livello services del microservizio)
+// oppure sulle API di un'istanza in esecuzione (modalità http).
+
+const (
+	modeDirect = "direct"
+	modeHTTP   = "http"
+)
+
+// cli contiene le opzioni globali e il client scelto per la modalità
+type cli struct {
+	client userClient
+	output string
+	usage  string // uso del sottocomando in esecuzione
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command è un sottocomando di myctl
+type command struct {
+	usage       string
+	description string
+	run         func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"list":    {"list [-name s] [-email s] [-sort id|name|email] [-desc] [-limit n] [-after cursor]", "Elenca gli utenti", runList},
+	"get":     {"get <id>", "Mostra un utente", runGet},
+	"create":  {"create -name s -email s", "Crea un utente", runCreate},
+	"update":  {"update <id> [-name s] [-email s]", "Aggiorna un utente; i campi non indicati restano invariati", runUpdate},
+	"delete":  {"delete <id>", "Elimina un utente", runDelete},
+	"export":  {"export [-format csv|ndjson|json] [-file path]", "Esporta gli utenti (default su stdout)", runExport},
+	"import":  {"import -file path [-format csv|ndjson|json] [-dry-run] [-on-conflict skip|overwrite|fail]", "Importa gli utenti da file", runImport},
+	"indexes": {"indexes", "Crea gli indici delle collezioni (solo modalità direct)", runIndexes},
+	"migrate": {"migrate status | up [-to n] [-dry-run] | down -to n [-dry-run]", "Mostra, applica o annulla le migrazioni dello schema (solo modalità direct)", runMigrate},
+	"health":  {"health", "Verifica lo stato del servizio o del database", runHealth},
+}
+
+// errUsage indica argomenti non validi: il messaggio è già stato stampato
+var errUsage = errors.New("usage error")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run esegue myctl e restituisce l'exit code: 0 ok, 1 errore, 2 uso non corretto
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("myctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	mode := flags.String("mode", utils.EnvOrDefault("MYCTL_MODE", modeHTTP), "direct (database) o http (servizio in esecuzione)")
+	server := flags.String("server", utils.EnvOrDefault("MYCTL_SERVER", "http://localhost:8080"), "URL del servizio in modalità http")
+	output := flags.String("output", utils.EnvOrDefault("MYCTL_OUTPUT", outputTable), "formato di output: table, json o yaml")
+	timeout := flags.Duration("timeout", utils.EnvDurationOrDefault("MYCTL_TIMEOUT", 5*time.Minute), "durata massima del comando")
+	tenant := flags.String("tenant", utils.EnvOrDefault("MYCTL_TENANT", ""), "tenant su cui operare (richiesto se TENANCY_MODE non è off)")
+	caFile := flags.String("cacert", utils.EnvOrDefault("MYCTL_CA_FILE", ""), "CA per verificare il certificato del servizio in HTTPS (default: CA di sistema)")
+	certFile := flags.String("cert", utils.EnvOrDefault("MYCTL_CERT_FILE", ""), "certificato client per il mutual TLS")
+	keyFile := flags.String("key", utils.EnvOrDefault("MYCTL_KEY_FILE", ""), "chiave del certificato client per il mutual TLS")
+	apiKey := flags.String("api-key", utils.EnvOrDefault("MYCTL_API_KEY", ""), "API key inviata al servizio in modalità http (preferire MYCTL_API_KEY: gli argomenti sono visibili agli altri utenti)")
+	verbose := flags.Bool("v", false, "mostra i log applicativi (modalità direct)")
+	flags.Usage = func() { printUsage(flags) }
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		printUsage(flags)
+		return 2
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", flags.Arg(0))
+		printUsage(flags)
+		return 2
+	}
+	if *output != outputTable && *output != outputJSON && *output != outputYAML {
+		fmt.Fprintf(stderr, "invalid output %q: must be one of table, json, yaml\n", *output)
+		return 2
+	}
+	if *tenant != "" && tenancy.Validate(*tenant) != nil {
+		fmt.Fprintf(stderr, "invalid tenant %q: lowercase letters, digits, '-' and '_' only\n", *tenant)
+		return 2
+	}
+
+	c := &cli{output: *output, usage: cmd.usage, stdout: stdout, stderr: stderr}
+	switch *mode {
+	case modeDirect:
+		// I log del microservizio vanno su stderr per non sporcare l'output; senza -v solo avvisi ed errori
+		logger := utils.GetLogger()
+		logger.SetOutput(stderr)
+		if !*verbose {
+			logger.SetLevel(logrus.WarnLevel)
+		}
+		c.client = directClient{}
+	case modeHTTP:
+		tlsConfig, err := tlsconfig.ClientConfig(*caFile, *certFile, *keyFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid TLS configuration: %v\n", err)
+			return 2
+		}
+		c.client = newHTTPClient(*server, tlsConfig, *apiKey)
+	default:
+		fmt.Fprintf(stderr, "invalid mode %q: must be one of direct, http\n", *mode)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	// Le modifiche fatte in modalità direct sono registrate nell'audit log con l'utente del sistema operativo
+	ctx = middleware.WithActor(ctx, "myctl:"+osUsername())
+	if *tenant != "" {
+		ctx = tenancy.WithTenant(ctx, *tenant)
+	}
+
+	if err := cmd.run(ctx, c, flags.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// osUsername restituisce l'utente del sistema operativo che esegue myctl
+func osUsername() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return "unknown"
+}
+
+func printUsage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintln(out, "Usage: myctl [options] <command> [arguments]")
+	fmt.Fprintln(out, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n             myctl %s\n", name, commands[name].description, commands[name].usage)
+	}
+	fmt.Fprintln(out, "\nOptions:")
+	flags.PrintDefaults()
+}
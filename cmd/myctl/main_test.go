@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDirectHealthReportsUnreachableDatabase(t *testing.T) {
+	// Nessun server in ascolto sulla porta 1: la selezione del server fallisce subito
+	t.Setenv("MONGO_URI", "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=200&connectTimeoutMS=200")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-mode", "direct", "health"}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1 (stderr: %s)", code, stderr.String())
+	}
+	output := stdout.String()
+	if !strings.Contains(output, "STATUS") || !strings.Contains(output, "DOWN") {
+		t.Errorf("output = %q, want the DOWN status", output)
+	}
+	if !strings.Contains(stderr.String(), "error:") {
+		t.Errorf("stderr = %q, want the connection error", stderr.String())
+	}
+}
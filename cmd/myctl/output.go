@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"myapp/internal/models"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// render stampa value nel formato scelto; table scrive la rappresentazione tabellare
+func (c *cli) render(value interface{}, table func(w *tabwriter.Writer)) error {
+	switch c.output {
+	case outputJSON:
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case outputYAML:
+		// Passa dal JSON per usare gli stessi nomi dei campi delle API
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(c.stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(generic); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	}
+}
+
+// renderUsers stampa un elenco di utenti
+func (c *cli) renderUsers(users []models.User) error {
+	return c.render(users, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tEMAIL")
+		for _, user := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", user.ID, user.Name, user.Email)
+		}
+	})
+}
+
+// renderUser stampa un singolo utente
+func (c *cli) renderUser(user *models.User) error {
+	if c.output == outputTable {
+		return c.renderUsers([]models.User{*user})
+	}
+	return c.render(user, nil)
+}
+
+// renderImportReport stampa il riepilogo di un import
+func (c *cli) renderImportReport(report *models.ImportReport) error {
+	return c.render(report, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "FORMAT\t%s\n", report.Format)
+		fmt.Fprintf(w, "DRY RUN\t%t\n", report.DryRun)
+		fmt.Fprintf(w, "ON CONFLICT\t%s\n", report.OnConflict)
+		fmt.Fprintf(w, "TOTAL\t%d\n", report.Total)
+		fmt.Fprintf(w, "VALID\t%d\n", report.Valid)
+		fmt.Fprintf(w, "INVALID\t%d\n", report.Invalid)
+		fmt.Fprintf(w, "INSERTED\t%d\n", report.Inserted)
+		fmt.Fprintf(w, "UPDATED\t%d\n", report.Updated)
+		fmt.Fprintf(w, "SKIPPED\t%d\n", report.Skipped)
+		fmt.Fprintf(w, "FAILED\t%d\n", report.Failed)
+		fmt.Fprintf(w, "ABORTED\t%t\n", report.Aborted)
+		if len(report.Errors) == 0 {
+			return
+		}
+		fmt.Fprintln(w, "\nRECORD\tID\tERROR")
+		for _, importError := range report.Errors {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", importError.Record, importError.ID, importError.Error)
+		}
+		if report.ErrorsTruncated {
+			fmt.Fprintln(w, "...\t\t(errors truncated)")
+		}
+	})
+}
+
+// renderMessage stampa un esito senza dati (es. delete)
+func (c *cli) renderMessage(status, message string) error {
+	return c.render(map[string]string{"status": status, "message": message}, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, message)
+	})
+}
@@ -0,0 +1,164 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Le chiavi hanno la forma myapp_<id>_<secret>: l'id (pubblico) individua il documento senza scansioni,
+// il secret è verificato con l'hash salato. Il prefisso rende le chiavi riconoscibili dai secret scanner.
+const (
+	prefix      = "myapp_"
+	idBytes     = 12
+	secretBytes = 32
+	saltBytes   = 16
+)
+
+// Scope delle API key: lettura e scrittura per ogni gruppo di rotte; la scrittura include la lettura
+const (
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeWebhooksRead  = "webhooks:read"
+	ScopeWebhooksWrite = "webhooks:write"
+	ScopeAdminRead     = "admin:read"
+	ScopeAdminWrite    = "admin:write"
+)
+
+// Scopes sono tutti gli scope assegnabili a una API key
+var Scopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeWebhooksRead, ScopeWebhooksWrite, ScopeAdminRead, ScopeAdminWrite}
+
+var (
+	// ErrInvalidKey indica una chiave malformata, inesistente o con un secret errato
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrRevokedKey indica una chiave revocata
+	ErrRevokedKey = errors.New("API key revoked")
+	// ErrExpiredKey indica una chiave scaduta
+	ErrExpiredKey = errors.New("API key expired")
+)
+
+// Generate crea una nuova chiave: restituisce l'id, il secret da salvare come hash e il valore in chiaro da consegnare al client
+func Generate() (id, secret, key string, err error) {
+	idRaw := make([]byte, idBytes)
+	secretRaw := make([]byte, secretBytes)
+	if _, err := rand.Read(idRaw); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secretRaw); err != nil {
+		return "", "", "", err
+	}
+	id = hex.EncodeToString(idRaw)
+	secret = base64.RawURLEncoding.EncodeToString(secretRaw)
+	return id, secret, prefix + id + "_" + secret, nil
+}
+
+// Parse separa id e secret di una chiave; ErrInvalidKey se non ha il formato atteso
+func Parse(key string) (id, secret string, err error) {
+	rest, ok := strings.CutPrefix(key, prefix)
+	if !ok {
+		return "", "", ErrInvalidKey
+	}
+	// L'id è esadecimale, quindi il primo "_" lo separa dal secret (che in base64url può contenerne)
+	id, secret, ok = strings.Cut(rest, "_")
+	if !ok || len(id) != 2*idBytes || secret == "" {
+		return "", "", ErrInvalidKey
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", "", ErrInvalidKey
+	}
+	return id, secret, nil
+}
+
+// HashSecret genera un sale casuale e restituisce sale e hash del secret, entrambi in esadecimale
+func HashSecret(secret string) (salt, hash string, err error) {
+	saltRaw := make([]byte, saltBytes)
+	if _, err := rand.Read(saltRaw); err != nil {
+		return "", "", err
+	}
+	salt = hex.EncodeToString(saltRaw)
+	return salt, hashWithSalt(secret, saltRaw), nil
+}
+
+// hashWithSalt calcola SHA-256(sale || secret). Il secret ha 256 bit casuali: un hash lento come per le password
+// non aggiungerebbe sicurezza e rallenterebbe ogni richiesta
+func hashWithSalt(secret string, salt []byte) string {
+	sum := sha256.Sum256(append(slices.Clone(salt), secret...))
+	return hex.EncodeToString(sum[:])
+}
+
+// verify confronta in tempo costante il secret con l'hash salvato
+func verify(secret string, key *models.APIKey) bool {
+	salt, err := hex.DecodeString(key.Salt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashWithSalt(secret, salt)), []byte(key.Hash)) == 1
+}
+
+// ValidScope indica se lo scope può essere assegnato a una API key
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// Allows indica se gli scope concessi comprendono quello richiesto (users:write comprende users:read)
+func Allows(granted []string, required string) bool {
+	if slices.Contains(granted, required) {
+		return true
+	}
+	if resource, ok := strings.CutSuffix(required, ":read"); ok {
+		return slices.Contains(granted, resource+":write")
+	}
+	return false
+}
+
+// Authenticate verifica una chiave presentata da un client e restituisce la API key corrispondente.
+// Gli errori di verifica sono ErrInvalidKey, ErrRevokedKey ed ErrExpiredKey; gli altri sono errori del database.
+// L'ultimo utilizzo è aggiornato in background al più una volta ogni lastUsedInterval.
+func Authenticate(ctx context.Context, key string, lastUsedInterval time.Duration) (*models.APIKey, error) {
+	id, secret, err := Parse(key)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := repository.FindAPIKeyForAuth(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !verify(secret, stored) {
+		return nil, ErrInvalidKey
+	}
+	now := time.Now().UTC()
+	if stored.RevokedAt != nil {
+		return nil, ErrRevokedKey
+	}
+	if stored.ExpiresAt != nil && !now.Before(*stored.ExpiresAt) {
+		return nil, ErrExpiredKey
+	}
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= lastUsedInterval {
+		go touch(stored.ID, now)
+	}
+	return stored, nil
+}
+
+// touch registra l'ultimo utilizzo con un contesto proprio: la richiesta può concludersi prima dell'aggiornamento
+func touch(id string, usedAt time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := repository.TouchAPIKey(ctx, id, usedAt); err != nil {
+		utils.WithContext().WithField("function", "apikey.touch").Warnf("Error updating last use of API key %s: %v", id, err)
+	}
+}
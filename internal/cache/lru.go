@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU è una cache in memoria a capacità limitata: quando è piena rimuove l'elemento usato meno di recente.
+// Ogni elemento ha una scadenza propria; gli elementi scaduti non vengono restituiti e sono rimossi alla lettura.
+// È sicura per l'uso concorrente.
+type LRU[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // dal più recente al meno recente
+	items    map[K]*list.Element
+}
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// NewLRU crea una cache che contiene al massimo capacity elementi
+func NewLRU[K comparable, V any](capacity int) *LRU[K, V] {
+	return &LRU[K, V]{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		items:    make(map[K]*list.Element),
+	}
+}
+
+// Get restituisce il valore associato alla chiave se presente e non scaduto
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	element, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	item := element.Value.(*entry[K, V])
+	if time.Now().After(item.expiresAt) {
+		c.removeElement(element)
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return item.value, true
+}
+
+// Set inserisce o sostituisce il valore con la durata indicata.
+// Restituisce true se è stato rimosso un altro elemento per fare spazio.
+func (c *LRU[K, V]) Set(key K, value V, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	if element, ok := c.items[key]; ok {
+		item := element.Value.(*entry[K, V])
+		item.value, item.expiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return false
+	}
+
+	c.items[key] = c.order.PushFront(&entry[K, V]{key: key, value: value, expiresAt: expiresAt})
+	if c.order.Len() <= c.capacity {
+		return false
+	}
+	c.removeElement(c.order.Back())
+	return true
+}
+
+// Delete rimuove la chiave se presente
+func (c *LRU[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// Purge rimuove tutti gli elementi
+func (c *LRU[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.items)
+}
+
+// Len restituisce il numero di elementi presenti, compresi quelli scaduti non ancora rimossi
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// removeElement rimuove l'elemento dalla lista e dall'indice; va chiamata con il lock acquisito
+func (c *LRU[K, V]) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*entry[K, V]).key)
+}
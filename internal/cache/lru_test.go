@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsTheLeastRecentlyUsed(t *testing.T) {
+	lru := NewLRU[string, int](2)
+	lru.Set("a", 1, time.Minute)
+	lru.Set("b", 2, time.Minute)
+	lru.Get("a") // b diventa il meno recente
+	if evicted := lru.Set("c", 3, time.Minute); !evicted {
+		t.Error("a full cache did not evict")
+	}
+	if _, ok := lru.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := lru.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	if evicted := lru.Set("a", 10, time.Minute); evicted {
+		t.Error("replacing a value evicted another one")
+	}
+	if value, _ := lru.Get("a"); value != 10 {
+		t.Errorf("a = %d, want the replaced value", value)
+	}
+}
+
+func TestLRUExpiresEntries(t *testing.T) {
+	lru := NewLRU[string, int](10)
+	lru.Set("short", 1, 10*time.Millisecond)
+	lru.Set("long", 2, time.Minute)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := lru.Get("short"); ok {
+		t.Error("expired entry returned")
+	}
+	if lru.Len() != 1 {
+		t.Errorf("len %d, want the expired entry removed on read", lru.Len())
+	}
+	if _, ok := lru.Get("long"); !ok {
+		t.Error("entry expired early")
+	}
+}
+
+func TestLRUDeleteAndPurge(t *testing.T) {
+	lru := NewLRU[string, int](10)
+	lru.Set("a", 1, time.Minute)
+	lru.Set("b", 2, time.Minute)
+	lru.Delete("a")
+	lru.Delete("missing")
+	if _, ok := lru.Get("a"); ok || lru.Len() != 1 {
+		t.Errorf("a still cached after Delete (len %d)", lru.Len())
+	}
+	lru.Purge()
+	if _, ok := lru.Get("b"); ok || lru.Len() != 0 {
+		t.Errorf("b still cached after Purge (len %d)", lru.Len())
+	}
+	lru.Set("c", 3, time.Minute)
+	if _, ok := lru.Get("c"); !ok {
+		t.Error("cache unusable after Purge")
+	}
+}
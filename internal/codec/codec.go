@@ -0,0 +1,241 @@
+package codec
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNotAcceptable indica un header Accept che non ammette nessuno dei formati registrati
+	ErrNotAcceptable = errors.New("not acceptable")
+	// ErrUnsupportedMediaType indica un Content-Type del corpo della richiesta senza codec
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// Codec serializza le risposte e deserializza i corpi delle richieste in un formato
+type Codec interface {
+	// MediaType è il media type principale del formato, usato come Content-Type delle risposte
+	MediaType() string
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+// Tabular è implementato dai formati tabellari (CSV), che non possono rappresentare l'envelope
+// {output, errorMessages} e ricevono solo il contenuto di output
+type Tabular interface {
+	Tabular()
+}
+
+// registration è un codec con i media type con cui può essere richiesto
+type registration struct {
+	codec      Codec
+	mediaTypes []string
+}
+
+// registry contiene i codec registrati; il primo è il formato di default
+var registry []registration
+
+func init() {
+	Register(jsonCodec{})
+	Register(xmlCodec{}, "text/xml")
+	Register(msgpackCodec{}, "application/x-msgpack", "application/vnd.msgpack")
+	Register(csvCodec{})
+}
+
+// Register aggiunge un codec al registry con eventuali media type alternativi.
+// Va chiamata durante l'inizializzazione: il registry non è protetto per l'accesso concorrente.
+func Register(c Codec, aliases ...string) {
+	mediaTypes := []string{c.MediaType()}
+	for _, alias := range aliases {
+		mediaTypes = append(mediaTypes, strings.ToLower(alias))
+	}
+	registry = append(registry, registration{codec: c, mediaTypes: mediaTypes})
+}
+
+// Default restituisce il codec di default (JSON)
+func Default() Codec {
+	return registry[0].codec
+}
+
+// MediaTypes restituisce i media type principali dei codec registrati, per i messaggi di errore
+func MediaTypes() []string {
+	mediaTypes := make([]string, 0, len(registry))
+	for _, reg := range registry {
+		mediaTypes = append(mediaTypes, reg.codec.MediaType())
+	}
+	return mediaTypes
+}
+
+// IsTabular indica se il codec è tabellare
+func IsTabular(c Codec) bool {
+	_, ok := c.(Tabular)
+	return ok
+}
+
+// lookup restituisce il codec registrato per un media type. Un media type con suffisso strutturato
+// (RFC 6839, es. application/vnd.myapp.v1+json o application/problem+xml) usa il codec del suffisso.
+func lookup(mediaType string) (Codec, bool) {
+	for _, reg := range registry {
+		for _, candidate := range reg.mediaTypes {
+			if candidate == mediaType {
+				return reg.codec, true
+			}
+		}
+	}
+	if typ, subtype, ok := strings.Cut(mediaType, "/"); ok {
+		if i := strings.LastIndex(subtype, "+"); i >= 0 {
+			return lookup(typ + "/" + subtype[i+1:])
+		}
+	}
+	return nil, false
+}
+
+// ForContentType restituisce il codec per decodificare un corpo con il Content-Type indicato (JSON se assente)
+func ForContentType(contentType string) (Codec, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return Default(), nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedMediaType
+	}
+	if c, ok := lookup(mediaType); ok {
+		return c, nil
+	}
+	return nil, ErrUnsupportedMediaType
+}
+
+// mediaRange è un elemento dell'header Accept
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+	position     int
+}
+
+// specificity restituisce quanto il range è specifico per il media type (-1 se non corrisponde):
+// per RFC 9110 la qualità di un media type è quella del range più specifico che lo comprende
+func (m mediaRange) specificity(mediaType string) int {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	switch {
+	case m.typ == "*" && m.subtype == "*":
+		return 0
+	case m.typ == typ && m.subtype == "*":
+		return 1
+	case m.typ == typ && m.subtype == subtype:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// parseAccept legge i media range dell'header Accept con le rispettive qualità (parametro q, default 1)
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for position, element := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(element))
+		if err != nil {
+			continue
+		}
+		typ, subtype, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+		// I media type con suffisso strutturato sono ricondotti al codec del suffisso
+		if c, found := lookup(mediaType); found && subtype != "*" {
+			typ, subtype, _ = strings.Cut(c.MediaType(), "/")
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil && parsed >= 0 && parsed <= 1 {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q, position: position})
+	}
+	return ranges
+}
+
+// Negotiate sceglie il codec della risposta dall'header Accept: il formato con la qualità più alta,
+// a parità quello elencato per primo dal client, e il default se l'header è assente.
+// ErrNotAcceptable se nessun formato registrato è accettato.
+func Negotiate(accept string) (Codec, error) {
+	if strings.TrimSpace(accept) == "" {
+		return Default(), nil
+	}
+	ranges := parseAccept(accept)
+
+	type candidate struct {
+		codec    Codec
+		q        float64
+		position int
+		order    int
+	}
+	var candidates []candidate
+	for order, reg := range registry {
+		best := candidate{codec: reg.codec, q: 0, order: order}
+		for _, mediaType := range reg.mediaTypes {
+			specificity, q, position := -1, 0.0, 0
+			for _, r := range ranges {
+				if s := r.specificity(mediaType); s > specificity {
+					specificity, q, position = s, r.q, r.position
+				}
+			}
+			if specificity >= 0 && q > best.q {
+				best.q, best.position = q, position
+			}
+		}
+		if best.q > 0 {
+			candidates = append(candidates, best)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNotAcceptable
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		if candidates[i].position != candidates[j].position {
+			return candidates[i].position < candidates[j].position
+		}
+		return candidates[i].order < candidates[j].order
+	})
+	return candidates[0].codec, nil
+}
+
+// responseWriter porta con sé il codec negoziato per la richiesta
+type responseWriter struct {
+	http.ResponseWriter
+	codec Codec
+}
+
+// Unwrap consente a http.ResponseController e a FromResponseWriter di raggiungere il writer originale
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// NewResponseWriter associa al writer il codec con cui scrivere le risposte
+func NewResponseWriter(w http.ResponseWriter, c Codec) http.ResponseWriter {
+	return &responseWriter{ResponseWriter: w, codec: c}
+}
+
+// FromResponseWriter restituisce il codec associato al writer, anche se avvolto da altri middleware
+// che implementano Unwrap, oppure il default se la rotta non negozia il formato
+func FromResponseWriter(w http.ResponseWriter) Codec {
+	for w != nil {
+		if rw, ok := w.(*responseWriter); ok {
+			return rw.codec
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = unwrapper.Unwrap()
+	}
+	return Default()
+}
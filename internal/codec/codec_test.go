@@ -0,0 +1,74 @@
+package codec
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   string // media type scelto, "" per ErrNotAcceptable
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/xml", "application/xml"},
+		{"text/xml", "application/xml"},
+		{"application/vnd.msgpack", "application/msgpack"},
+		// La qualità più alta vince sull'ordine
+		{"application/json;q=0.5, text/csv", "text/csv"},
+		{"text/csv;q=0.2, application/xml;q=0.8", "application/xml"},
+		// A parità di qualità vince il formato elencato per primo
+		{"text/csv, application/xml", "text/csv"},
+		{"application/xml;q=0.5, text/csv;q=0.5", "application/xml"},
+		// q=0 esclude il formato anche se un range generico lo ammetterebbe: vale il range più specifico
+		{"application/json;q=0, */*", "application/xml"},
+		{"text/*;q=0.1, text/csv;q=0, application/xml;q=0.05", "application/xml"},
+		{"application/*;q=0.3, application/msgpack", "application/msgpack"},
+		// I suffissi strutturati usano il codec del suffisso
+		{"application/problem+xml", "application/xml"},
+		{"application/vnd.myapp.v1+json;q=0.9, text/csv;q=0.8", "application/json"},
+		// Un valore di q non valido vale 1
+		{"text/csv;q=abc, application/xml;q=0.9", "text/csv"},
+		{"image/png", ""},
+		{"application/json;q=0", ""},
+	}
+	for _, tc := range cases {
+		c, err := Negotiate(tc.accept)
+		if tc.want == "" {
+			if !errors.Is(err, ErrNotAcceptable) {
+				t.Errorf("%q: got %v, %v, want ErrNotAcceptable", tc.accept, c, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.accept, err)
+			continue
+		}
+		if c.MediaType() != tc.want {
+			t.Errorf("%q: negotiated %s, want %s", tc.accept, c.MediaType(), tc.want)
+		}
+	}
+}
+
+func TestForContentType(t *testing.T) {
+	cases := map[string]string{
+		"":                                "application/json",
+		"application/json; charset=utf-8": "application/json",
+		"text/xml":                        "application/xml",
+		"application/x-msgpack":           "application/msgpack",
+		"application/merge-patch+json":    "application/json",
+		"text/csv":                        "text/csv",
+	}
+	for contentType, want := range cases {
+		c, err := ForContentType(contentType)
+		if err != nil || c.MediaType() != want {
+			t.Errorf("%q: got %v, %v, want %s", contentType, c, err, want)
+		}
+	}
+	for _, contentType := range []string{"application/pdf", "not a media type"} {
+		if _, err := ForContentType(contentType); !errors.Is(err, ErrUnsupportedMediaType) {
+			t.Errorf("%q: err = %v, want ErrUnsupportedMediaType", contentType, err)
+		}
+	}
+}
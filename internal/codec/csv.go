@@ -0,0 +1,218 @@
+package codec
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// csvCodec rappresenta i valori come tabella CSV (text/csv) con una riga di intestazione:
+//   - una lista produce una riga per elemento;
+//   - un oggetto con un solo campo lista di oggetti (pagine, esiti dei batch) produce una riga per elemento
+//     di quel campo, e gli altri campi (totali, numero di pagina) non sono rappresentati;
+//   - un altro oggetto produce una sola riga.
+//
+// Gli oggetti annidati sono appiattiti con i nomi separati da punti (es. errorMessages.message),
+// le liste annidate sono scritte come JSON nella cella. La decodifica segue le stesse regole.
+type csvCodec struct{}
+
+var errEmptyCSV = errors.New("csv: no rows")
+
+func (csvCodec) MediaType() string {
+	return "text/csv"
+}
+
+// Tabular indica che il codec riceve solo il contenuto di output
+func (csvCodec) Tabular() {}
+
+func (csvCodec) Encode(w io.Writer, v interface{}) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+	var rows []object
+	switch value := tree.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		rows = tableRows(value)
+	case object:
+		if list, ok := tableList(value); ok {
+			rows = tableRows(list)
+		} else {
+			rows = []object{value}
+		}
+	default:
+		rows = []object{{{key: "value", value: value}}}
+	}
+
+	// Le colonne sono l'unione dei campi delle righe nell'ordine in cui compaiono
+	var columns []string
+	index := make(map[string]int)
+	cells := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		cells[i] = make(map[string]string)
+		flatten("", row, cells[i], func(column string) {
+			if _, ok := index[column]; !ok {
+				index[column] = len(columns)
+				columns = append(columns, column)
+			}
+		})
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, row := range cells {
+		for i, column := range columns {
+			record[i] = row[column]
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// tableList restituisce l'unico campo dell'oggetto che è una lista di oggetti
+func tableList(obj object) ([]interface{}, bool) {
+	var found []interface{}
+	for _, f := range obj {
+		list, ok := f.value.([]interface{})
+		if !ok || len(list) == 0 {
+			continue
+		}
+		if _, isObject := list[0].(object); !isObject {
+			continue
+		}
+		if found != nil {
+			return nil, false
+		}
+		found = list
+	}
+	return found, found != nil
+}
+
+// tableRows converte gli elementi di una lista in righe; gli elementi che non sono oggetti finiscono nella colonna value
+func tableRows(list []interface{}) []object {
+	rows := make([]object, 0, len(list))
+	for _, item := range list {
+		if obj, ok := item.(object); ok {
+			rows = append(rows, obj)
+		} else {
+			rows = append(rows, object{{key: "value", value: item}})
+		}
+	}
+	return rows
+}
+
+// flatten scrive nelle celle i campi dell'oggetto con il prefisso indicato, segnalando le colonne incontrate
+func flatten(prefix string, obj object, cells map[string]string, column func(string)) {
+	for _, f := range obj {
+		name := prefix + f.key
+		switch value := f.value.(type) {
+		case object:
+			flatten(name+".", value, cells, column)
+			continue
+		case []interface{}:
+			data, _ := json.Marshal(plain(value))
+			cells[name] = string(data)
+		case nil:
+			cells[name] = ""
+		default:
+			cells[name] = fmt.Sprint(value)
+		}
+		column(name)
+	}
+}
+
+// plain converte un valore dell'albero in tipi serializzabili da encoding/json
+func plain(value interface{}) interface{} {
+	switch value := value.(type) {
+	case object:
+		obj := make(map[string]interface{}, len(value))
+		for _, f := range value {
+			obj[f.key] = plain(f.value)
+		}
+		return obj
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			list[i] = plain(item)
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+func (csvCodec) Decode(r io.Reader, v interface{}) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return errEmptyCSV
+		}
+		return err
+	}
+	var rows []*element
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rows = append(rows, csvRow(header, record))
+	}
+	if len(rows) == 0 {
+		return errEmptyCSV
+	}
+
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return decodeElement(&element{children: rows}, v)
+	case t.Kind() == reflect.Struct:
+		if name, ok := tableField(t); ok {
+			return decodeElement(&element{children: []*element{{name: name, children: rows}}}, v)
+		}
+	}
+	if len(rows) > 1 {
+		return fmt.Errorf("csv: expected a single row, got %d", len(rows))
+	}
+	return decodeElement(rows[0], v)
+}
+
+// csvRow converte un record in un elemento, ricostruendo gli oggetti annidati dai nomi con i punti; le celle vuote sono omesse
+func csvRow(header, record []string) *element {
+	row := &element{name: "item"}
+	for i, column := range header {
+		if i >= len(record) || record[i] == "" {
+			continue
+		}
+		parent := row
+		path := strings.Split(column, ".")
+		for _, name := range path[:len(path)-1] {
+			next := parent.child(name)
+			if next == nil {
+				next = &element{name: name}
+				parent.children = append(parent.children, next)
+			}
+			parent = next
+		}
+		parent.children = append(parent.children, &element{name: path[len(path)-1], text: record[i]})
+	}
+	return row
+}
@@ -0,0 +1,26 @@
+package codec
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonCodec è il formato di default (application/json)
+type jsonCodec struct{}
+
+func (jsonCodec) MediaType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
@@ -0,0 +1,288 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// msgpackCodec rappresenta i valori in MessagePack (application/msgpack), il formato compatto dei job batch.
+// Oggetti, liste, stringhe, numeri, booleani e nil usano i tipi nativi del formato; le estensioni non sono supportate.
+type msgpackCodec struct{}
+
+var errMsgpackExtension = errors.New("msgpack extension types are not supported")
+
+func (msgpackCodec) MediaType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, tree); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// writeMsgpack scrive un valore dell'albero con la codifica più compatta
+func writeMsgpack(buf *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if value {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case string:
+		writeMsgpackString(buf, value)
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+		} else if u, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			_ = binary.Write(buf, binary.BigEndian, u)
+		} else {
+			f, err := value.Float64()
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(0xcb)
+			_ = binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+		}
+	case []interface{}:
+		writeMsgpackHeader(buf, len(value), 0x90, 16, 0xdc, 0xdd)
+		for _, item := range value {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case object:
+		writeMsgpackHeader(buf, len(value), 0x80, 16, 0xde, 0xdf)
+		for _, f := range value {
+			writeMsgpackString(buf, f.key)
+			if err := writeMsgpack(buf, f.value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported value %T", value)
+	}
+	return nil
+}
+
+// writeMsgpackHeader scrive l'intestazione di una lista o di una mappa: formato fix, 16 o 32 bit
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixLimit int, code16, code32 byte) {
+	switch {
+	case n < fixLimit:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackString(buf *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.WriteString(s)
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i >= -32 && i < 0:
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		_ = binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		_ = binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		_ = binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+func (msgpackCodec) Decode(r io.Reader, v interface{}) error {
+	value, err := readMsgpack(bufio.NewReader(r), 0)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// readMsgpack legge un valore come tipo nativo Go (map[string]interface{}, []interface{}, string, numeri, bool, nil)
+func readMsgpack(r *bufio.Reader, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errTooDeep
+	}
+	code, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xe0 == 0xa0:
+		return readMsgpackString(r, int(code&0x1f))
+	case code&0xf0 == 0x90:
+		return readMsgpackArray(r, int(code&0x0f), depth)
+	case code&0xf0 == 0x80:
+		return readMsgpackMap(r, int(code&0x0f), depth)
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readUint(r, 1<<(code-0xcc))
+		return n, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		n, err := readUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		// Estende il segno dal numero di bit letti
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, nil
+	case 0xca:
+		n, err := readUint(r, 4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := readUint(r, 8)
+		return math.Float64frombits(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := readUint(r, 1<<(code-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readUint(r, 1<<(code-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackBytes(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readUint(r, 2<<(code-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, int(n), depth)
+	case 0xde, 0xdf:
+		n, err := readUint(r, 2<<(code-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, int(n), depth)
+	default:
+		return nil, errMsgpackExtension
+	}
+}
+
+// readUint legge un intero senza segno big-endian di size byte
+func readUint(r *bufio.Reader, size int) (uint64, error) {
+	var n uint64
+	for i := 0; i < size; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+// readMsgpackBytes legge n byte; il buffer cresce con i dati letti, quindi una lunghezza dichiarata
+// enorme non provoca allocazioni prima che i dati arrivino davvero
+func readMsgpackBytes(r *bufio.Reader, n int) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func readMsgpackString(r *bufio.Reader, n int) (string, error) {
+	data, err := readMsgpackBytes(r, n)
+	return string(data), err
+}
+
+func readMsgpackArray(r *bufio.Reader, n, depth int) ([]interface{}, error) {
+	list := make([]interface{}, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		value, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+func readMsgpackMap(r *bufio.Reader, n, depth int) (map[string]interface{}, error) {
+	obj := make(map[string]interface{}, min(n, 1024))
+	for i := 0; i < n; i++ {
+		key, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		// JSON ammette solo chiavi stringa
+		obj[fmt.Sprint(key)] = value
+	}
+	return obj, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,290 @@
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// I codec diversi da JSON riusano le regole di encoding/json (tag, omitempty, MarshalJSON):
+// in scrittura il valore viene serializzato in JSON e riletto come albero ordinato,
+// in lettura il documento viene convertito in JSON e decodificato con json.Unmarshal.
+
+// maxDepth limita l'annidamento dei documenti in ingresso
+const maxDepth = 64
+
+var errTooDeep = errors.New("document nesting too deep")
+
+// field è una coppia chiave/valore di un oggetto, nell'ordine in cui è stata serializzata
+type field struct {
+	key   string
+	value interface{}
+}
+
+// object è un oggetto JSON che conserva l'ordine dei campi
+type object []field
+
+// toTree serializza v in JSON e lo rilegge come albero di object, []interface{}, json.Number, string, bool e nil
+func toTree(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return readTree(decoder)
+}
+
+// readTree legge il prossimo valore dal decoder
+func readTree(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		obj := object{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readTree(decoder)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, field{key: key.(string), value: value})
+		}
+		_, err := decoder.Token()
+		return obj, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for decoder.More() {
+			value, err := readTree(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := decoder.Token()
+		return list, err
+	default:
+		return token, nil
+	}
+}
+
+// element è un nodo di un documento senza tipi (XML, riga CSV): ha un testo o dei figli
+type element struct {
+	name     string
+	text     string
+	children []*element
+}
+
+// child restituisce il primo figlio con il nome indicato, senza distinguere maiuscole e minuscole come encoding/json
+func (e *element) child(name string) *element {
+	for _, c := range e.children {
+		if c.name == name {
+			return c
+		}
+	}
+	for _, c := range e.children {
+		if strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// decodeElement decodifica l'elemento in v usando il tipo di v per interpretare i testi
+func decodeElement(e *element, v interface{}) error {
+	data, err := json.Marshal(coerce(e, reflect.TypeOf(v)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// coerce converte l'elemento in un valore serializzabile in JSON compatibile con il tipo t:
+// i numeri diventano json.Number e i booleani bool, così json.Unmarshal segnala i valori non validi
+func coerce(e *element, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() == reflect.Interface {
+		return generic(e)
+	}
+	// Tipi con una rappresentazione testuale (es. time.Time)
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return e.text
+	}
+	text := strings.TrimSpace(e.text)
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if len(e.children) == 0 {
+			return rawOrNil(text, '{')
+		}
+		obj := make(map[string]interface{})
+		for _, f := range jsonFields(t) {
+			if c := e.child(f.name); c != nil {
+				obj[f.name] = coerce(c, f.typ)
+			}
+		}
+		return obj
+	case reflect.Map:
+		if len(e.children) == 0 {
+			return rawOrNil(text, '{')
+		}
+		obj := make(map[string]interface{})
+		for _, c := range e.children {
+			obj[c.name] = coerce(c, t.Elem())
+		}
+		return obj
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return text // []byte è base64 anche in JSON
+		}
+		if len(e.children) == 0 {
+			if text == "" || text[0] == '[' {
+				return rawOrNil(text, '[')
+			}
+			return []interface{}{coerce(e, t.Elem())}
+		}
+		list := make([]interface{}, 0, len(e.children))
+		for _, c := range e.children {
+			list = append(list, coerce(c, t.Elem()))
+		}
+		return list
+	case reflect.String:
+		return e.text
+	case reflect.Bool:
+		if text == "" {
+			return nil
+		}
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+		return e.text
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if text == "" {
+			return nil
+		}
+		return json.Number(text)
+	default:
+		return e.text
+	}
+}
+
+// rawOrNil restituisce il testo come JSON se inizia con il delimitatore atteso (es. una cella CSV con un oggetto),
+// nil se vuoto, altrimenti il testo (che json.Unmarshal rifiuterà con un errore di tipo)
+func rawOrNil(text string, delimiter byte) interface{} {
+	switch {
+	case text == "":
+		return nil
+	case text[0] == delimiter:
+		return json.RawMessage(text)
+	default:
+		return text
+	}
+}
+
+// generic converte l'elemento senza un tipo di riferimento: testo, lista (figli tutti "item" o con lo stesso nome ripetuto) o oggetto
+func generic(e *element) interface{} {
+	if len(e.children) == 0 {
+		return e.text
+	}
+	obj := make(map[string]interface{})
+	items := true
+	for _, c := range e.children {
+		if c.name != "item" {
+			items = false
+		}
+		value := generic(c)
+		if existing, ok := obj[c.name]; ok {
+			if list, isList := existing.([]interface{}); isList {
+				obj[c.name] = append(list, value)
+			} else {
+				obj[c.name] = []interface{}{existing, value}
+			}
+			continue
+		}
+		obj[c.name] = value
+	}
+	if items {
+		list := make([]interface{}, 0, len(e.children))
+		for _, c := range e.children {
+			list = append(list, generic(c))
+		}
+		return list
+	}
+	return obj
+}
+
+// jsonField è un campo di una struct con il nome usato da encoding/json
+type jsonField struct {
+	name string
+	typ  reflect.Type
+}
+
+// jsonFields restituisce i campi serializzati da encoding/json, con i campi delle struct embedded promossi
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				fields = append(fields, jsonFields(embedded)...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, jsonField{name: name, typ: f.Type})
+	}
+	return fields
+}
+
+// tableField restituisce il nome dell'unico campo lista di oggetti della struct (es. items di una richiesta batch),
+// usato dai formati tabellari per rappresentarne le righe
+func tableField(t reflect.Type) (string, bool) {
+	name, found := "", false
+	for _, f := range jsonFields(t) {
+		typ := f.typ
+		if typ.Kind() != reflect.Slice {
+			continue
+		}
+		elem := typ.Elem()
+		for elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			continue
+		}
+		if found {
+			return "", false
+		}
+		name, found = f.name, true
+	}
+	return name, found
+}
@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// xmlCodec rappresenta i valori in XML (application/xml, text/xml) per le integrazioni legacy.
+// Gli oggetti diventano elementi con i nomi dei campi JSON, le liste una sequenza di elementi <item>;
+// la radice è <response>. I nomi non validi in XML sono scritti come <entry key="...">.
+type xmlCodec struct{}
+
+// xmlName riconosce i nomi dei campi utilizzabili come nomi di elementi
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+func (xmlCodec) MediaType() string {
+	return "application/xml"
+}
+
+func (xmlCodec) Encode(w io.Writer, v interface{}) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	if err := writeXML(encoder, "response", tree); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+// writeXML scrive il valore come elemento con il nome indicato
+func writeXML(encoder *xml.Encoder, name string, value interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !xmlName.MatchString(name) {
+		start = xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}}}
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case object:
+		for _, f := range value {
+			if err := writeXML(encoder, f.key, f.value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if err := writeXML(encoder, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	case json.Number:
+		if err := encoder.EncodeToken(xml.CharData(value)); err != nil {
+			return err
+		}
+	default:
+		if err := encoder.EncodeToken(xml.CharData(fmt.Sprint(value))); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+func (xmlCodec) Decode(r io.Reader, v interface{}) error {
+	root, err := readXML(xml.NewDecoder(r))
+	if err != nil {
+		return err
+	}
+	return decodeElement(root, v)
+}
+
+// readXML legge l'elemento radice del documento
+func readXML(decoder *xml.Decoder) (*element, error) {
+	var stack []*element
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if len(stack) >= maxDepth {
+				return nil, errTooDeep
+			}
+			e := &element{name: token.Name.Local}
+			// <entry key="..."> rappresenta un campo con un nome non valido in XML
+			if e.name == "entry" {
+				for _, attr := range token.Attr {
+					if attr.Name.Local == "key" {
+						e.name = attr.Value
+					}
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, e)
+			}
+			stack = append(stack, e)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(token)
+			}
+		case xml.EndElement:
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return e, nil
+			}
+		}
+	}
+}
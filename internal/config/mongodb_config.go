@@ -2,6 +2,7 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"myapp/internal/utils"
 	"sync"
 	"time"
@@ -14,6 +15,7 @@ import (
 var (
 	mongoClientInstance *mongo.Client
 	databaseInstance    *mongo.Database
+	connectErr          error
 	once                sync.Once
 )
 
@@ -33,27 +35,72 @@ func GetDatabase() *mongo.Database {
 	return databaseInstance
 }
 
-// loadConfig carica la configurazione e stabilisce la connessione con MongoDB
+// Connect stabilisce la connessione con MongoDB come GetMongoClient ma, invece di terminare il processo, restituisce
+// l'errore: serve a chi deve riportare il database come irraggiungibile (es. myctl health). Dopo un errore
+// la connessione non è disponibile e GetMongoClient restituisce nil.
+func Connect(ctx context.Context) error {
+	once.Do(func() {
+		connectErr = connect(ctx)
+	})
+	return connectErr
+}
+
+// SetDatabase sostituisce la connessione a MongoDB, ad esempio con il deployment simulato di mtest nei test
+func SetDatabase(client *mongo.Client, database *mongo.Database) {
+	once.Do(func() {})
+	mongoClientInstance, databaseInstance = client, database
+}
+
+// WithTransaction esegue fn in una transazione MongoDB; fn deve usare il contesto ricevuto per tutte le operazioni.
+// Le transazioni richiedono un replica set (vedi docker-compose.yml). La transazione viene ritentata
+// automaticamente dal driver in caso di errori transitori, quindi fn deve essere idempotente.
+func WithTransaction(ctx context.Context, fn func(txCtx context.Context) error) error {
+	session, err := GetMongoClient().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// Ping verifica che il primario di MongoDB sia raggiungibile
+func Ping(ctx context.Context) error {
+	return GetMongoClient().Ping(ctx, readpref.Primary())
+}
+
+// loadConfig carica la configurazione e stabilisce la connessione con MongoDB, terminando il processo in caso di errore
 func loadConfig() {
-	log := utils.WithContext().WithField("function", "loadConfig")
+	if err := connect(context.Background()); err != nil {
+		utils.WithContext().WithField("function", "loadConfig").Fatal(err)
+	}
+}
+
+// connect carica la configurazione e stabilisce la connessione con MongoDB, verificando che il primario sia raggiungibile
+func connect(ctx context.Context) error {
+	log := utils.WithContext().WithField("function", "connect")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	mongoURI := utils.EnvOrDefault("MONGO_URI", "mongodb://localhost:27017")
 
-	var err error
-	mongoClientInstance, err = mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	err = mongoClientInstance.Ping(ctx, readpref.Primary())
+	err = client.Ping(ctx, readpref.Primary())
 	if err != nil {
-		log.Fatalf("Error on establishing a connection with the cluster: %v", err)
+		_ = client.Disconnect(context.Background())
+		return fmt.Errorf("error on establishing a connection with the cluster: %w", err)
 	}
 
 	databaseName := utils.EnvOrDefault("MONGO_DATABASE", "myapp")
-	databaseInstance = mongoClientInstance.Database(databaseName)
+	mongoClientInstance, databaseInstance = client, client.Database(databaseName)
 	log.Infof("Connected to MongoDB at %s", mongoURI)
+	return nil
 }
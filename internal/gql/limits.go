@@ -0,0 +1,105 @@
+package gql
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// defaultFirst è il numero di elementi di una connection quando first non è indicato
+const defaultFirst = 20
+
+// queryLimits calcola profondità e complessità di un'operazione per rifiutare le query troppo costose prima di eseguirle.
+// La complessità è il numero di campi risolti: i campi dentro una connection (users) contano tante volte
+// quanti sono gli elementi richiesti con first.
+type queryLimits struct {
+	maxDepth      int
+	maxComplexity int
+	fragments     map[string]*ast.FragmentDefinition
+	variables     map[string]interface{}
+}
+
+// check restituisce un errore se l'operazione supera i limiti configurati
+func (l *queryLimits) check(document *ast.Document, operationName string) []gqlerrors.FormattedError {
+	l.fragments = make(map[string]*ast.FragmentDefinition)
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			l.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operations = append(operations, definition)
+			}
+		}
+	}
+
+	var errs []gqlerrors.FormattedError
+	for _, operation := range operations {
+		depth, complexity := l.measure(operation.SelectionSet, 0, map[string]bool{})
+		if depth > l.maxDepth {
+			errs = append(errs, gqlerrors.NewFormattedError(fmt.Sprintf("query depth %d exceeds the maximum of %d", depth, l.maxDepth)))
+		}
+		if complexity > l.maxComplexity {
+			errs = append(errs, gqlerrors.NewFormattedError(fmt.Sprintf("query complexity %d exceeds the maximum of %d", complexity, l.maxComplexity)))
+		}
+	}
+	return errs
+}
+
+// measure restituisce la profondità massima e la complessità di un selection set.
+// visiting evita i cicli tra frammenti (rifiutati comunque dalla validazione).
+func (l *queryLimits) measure(selectionSet *ast.SelectionSet, depth int, visiting map[string]bool) (int, int) {
+	if selectionSet == nil {
+		return depth, 0
+	}
+	maxDepth, complexity := depth, 0
+	for _, selection := range selectionSet.Selections {
+		var childDepth, childComplexity int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			childDepth, childComplexity = l.measure(selection.SelectionSet, depth+1, visiting)
+			childComplexity = 1 + childComplexity*l.multiplier(selection)
+		case *ast.InlineFragment:
+			childDepth, childComplexity = l.measure(selection.SelectionSet, depth, visiting)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := l.fragments[name]
+			if !ok || visiting[name] {
+				continue
+			}
+			visiting[name] = true
+			childDepth, childComplexity = l.measure(fragment.SelectionSet, depth, visiting)
+			delete(visiting, name)
+		}
+		maxDepth = max(maxDepth, childDepth)
+		complexity += childComplexity
+	}
+	return maxDepth, complexity
+}
+
+// multiplier restituisce quante volte vengono risolti i campi figli: first per le connection, 1 altrimenti
+func (l *queryLimits) multiplier(field *ast.Field) int {
+	if field.Name.Value != "users" {
+		return 1
+	}
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "first" {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil && n > 0 {
+				return n
+			}
+		case *ast.Variable:
+			// Le variabili arrivano dal JSON della richiesta, quindi i numeri sono float64
+			if n, ok := l.variables[value.Name.Value].(float64); ok && n > 0 {
+				return int(n)
+			}
+		}
+	}
+	return defaultFirst
+}
@@ -0,0 +1,84 @@
+package gql
+
+import (
+	"context"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"sync"
+)
+
+// loaderKey è la chiave del contesto con il userLoader della richiesta
+type loaderKey struct{}
+
+// userLoader raccoglie le letture di utenti per ID richieste durante l'esecuzione di una query
+// e le esegue con una sola chiamata a services.GetUsersByIDs (dataloader).
+// Ogni Load restituisce un thunk: graphql-go risolve prima tutti i campi dello stesso livello,
+// poi invoca i thunk, quindi tutti gli ID di un livello finiscono nello stesso batch.
+// I risultati sono memorizzati per la durata della richiesta.
+type userLoader struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	pending map[string]struct{}
+	results map[string]*models.User // nil = utente inesistente
+	err     error
+}
+
+// withUserLoader aggiunge al contesto un nuovo userLoader, da creare per ogni richiesta
+func withUserLoader(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loaderKey{}, &userLoader{
+		ctx:     ctx,
+		pending: make(map[string]struct{}),
+		results: make(map[string]*models.User),
+	})
+}
+
+// loaderFrom restituisce il userLoader della richiesta
+func loaderFrom(ctx context.Context) *userLoader {
+	return ctx.Value(loaderKey{}).(*userLoader)
+}
+
+// Load accoda l'ID al prossimo batch e restituisce il thunk che ne legge il risultato
+func (l *userLoader) Load(id string) func() (interface{}, error) {
+	l.mu.Lock()
+	if _, done := l.results[id]; !done {
+		l.pending[id] = struct{}{}
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, queued := l.pending[id]; queued {
+			l.dispatch()
+		}
+		if l.err != nil {
+			return nil, l.err
+		}
+		if user := l.results[id]; user != nil {
+			return *user, nil
+		}
+		return nil, nil
+	}
+}
+
+// dispatch carica tutti gli ID in attesa; va chiamata con il lock acquisito
+func (l *userLoader) dispatch() {
+	ids := make([]string, 0, len(l.pending))
+	for id := range l.pending {
+		ids = append(ids, id)
+	}
+	clear(l.pending)
+
+	users, err := services.GetUsersByIDs(l.ctx, ids)
+	if err != nil {
+		l.err = err
+		return
+	}
+	for _, id := range ids {
+		if user, found := users[id]; found {
+			l.results[id] = &user
+		} else {
+			l.results[id] = nil
+		}
+	}
+}
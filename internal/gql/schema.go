@@ -0,0 +1,408 @@
+package gql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/internal/apikey"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxFirst è il numero massimo di utenti restituibili da una singola pagina di users
+const maxFirst = 100
+
+var (
+	schemaOnce sync.Once
+	schema     graphql.Schema
+	limits     queryLimits
+)
+
+// getSchema restituisce lo schema GraphQL, costruito alla prima chiamata
+func getSchema() graphql.Schema {
+	schemaOnce.Do(func() {
+		var err error
+		schema, err = graphql.NewSchema(graphql.SchemaConfig{Query: queryType(), Mutation: mutationType()})
+		if err != nil {
+			// Lo schema è statico: un errore qui è un errore di programmazione
+			panic(fmt.Sprintf("invalid GraphQL schema: %v", err))
+		}
+		limits = queryLimits{
+			maxDepth:      utils.EnvIntOrDefault("GRAPHQL_MAX_DEPTH", 15),
+			maxComplexity: utils.EnvIntOrDefault("GRAPHQL_MAX_COMPLEXITY", 1000),
+		}
+	})
+	return schema
+}
+
+// IsMutation indica se l'operazione richiesta è una mutation; le mutation non sono ammesse via GET
+func IsMutation(query, operationName string) bool {
+	document, err := parse(query)
+	if err != nil {
+		return false
+	}
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			if operationName == "" || (operation.Name != nil && operation.Name.Value == operationName) {
+				if operation.Operation == ast.OperationTypeMutation {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Execute esegue una richiesta GraphQL: parsing, validazione, controllo di profondità e complessità ed esecuzione.
+// Gli errori sono restituiti nel campo errors del risultato, come previsto dalla specifica.
+func Execute(ctx context.Context, query, operationName string, variables map[string]interface{}) *graphql.Result {
+	schema := getSchema()
+
+	document, err := parse(query)
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if validation := graphql.ValidateDocument(&schema, document, nil); !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+	requestLimits := limits
+	requestLimits.variables = variables
+	if errs := requestLimits.check(document, operationName); len(errs) > 0 {
+		return &graphql.Result{Errors: errs}
+	}
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        schema,
+		AST:           document,
+		OperationName: operationName,
+		Args:          variables,
+		Context:       withUserLoader(ctx),
+	})
+}
+
+func parse(query string) (*ast.Document, error) {
+	return parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(query), Name: "GraphQL request"})})
+}
+
+var userStatusType = graphql.NewEnum(graphql.EnumConfig{
+	Name: "UserStatus",
+	Values: graphql.EnumValueConfigMap{
+		"PENDING":     &graphql.EnumValueConfig{Value: constants.USER_STATUS_PENDING},
+		"ACTIVE":      &graphql.EnumValueConfig{Value: constants.USER_STATUS_ACTIVE},
+		"SUSPENDED":   &graphql.EnumValueConfig{Value: constants.USER_STATUS_SUSPENDED},
+		"DEACTIVATED": &graphql.EnumValueConfig{Value: constants.USER_STATUS_DEACTIVATED},
+	},
+})
+
+var userType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"name":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"email": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"status": &graphql.Field{
+			Type: graphql.NewNonNull(userStatusType),
+			// Gli utenti creati prima dell'introduzione degli stati sono attivi
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if status := p.Source.(models.User).Status; status != "" {
+					return status, nil
+				}
+				return constants.USER_STATUS_ACTIVE, nil
+			},
+		},
+		"statusReason": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if reason := p.Source.(models.User).StatusReason; reason != "" {
+					return reason, nil
+				}
+				return nil, nil
+			},
+		},
+	},
+})
+
+var userEdgeType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "UserEdge",
+	Fields: graphql.Fields{
+		"cursor": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(models.UserPageItem).Cursor, nil },
+		},
+		"node": &graphql.Field{
+			Type:    graphql.NewNonNull(userType),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(models.UserPageItem).User, nil },
+		},
+	},
+})
+
+var pageInfoType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PageInfo",
+	Fields: graphql.Fields{
+		"hasNextPage": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Boolean),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*models.UserPage).HasNextPage, nil
+			},
+		},
+		"endCursor": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				page := p.Source.(*models.UserPage)
+				if len(page.Items) == 0 {
+					return nil, nil
+				}
+				return page.Items[len(page.Items)-1].Cursor, nil
+			},
+		},
+	},
+})
+
+var userConnectionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "UserConnection",
+	Fields: graphql.Fields{
+		"edges": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userEdgeType))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(*models.UserPage).Items, nil },
+		},
+		"pageInfo": &graphql.Field{
+			Type:    graphql.NewNonNull(pageInfoType),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source, nil },
+		},
+		"totalCount": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(*models.UserPage).TotalCount, nil },
+		},
+	},
+})
+
+var userFilterType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "UserFilter",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"nameContains":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"emailContains": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"status":        &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(userStatusType))},
+	},
+})
+
+var userSortFieldType = graphql.NewEnum(graphql.EnumConfig{
+	Name: "UserSortField",
+	Values: graphql.EnumValueConfigMap{
+		"ID":    &graphql.EnumValueConfig{Value: constants.SORT_FIELD_ID},
+		"NAME":  &graphql.EnumValueConfig{Value: constants.SORT_FIELD_NAME},
+		"EMAIL": &graphql.EnumValueConfig{Value: constants.SORT_FIELD_EMAIL},
+	},
+})
+
+var sortDirectionType = graphql.NewEnum(graphql.EnumConfig{
+	Name: "SortDirection",
+	Values: graphql.EnumValueConfigMap{
+		"ASC":  &graphql.EnumValueConfig{Value: false},
+		"DESC": &graphql.EnumValueConfig{Value: true},
+	},
+})
+
+var userSortType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "UserSort",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"field":     &graphql.InputObjectFieldConfig{Type: userSortFieldType, DefaultValue: constants.SORT_FIELD_ID},
+		"direction": &graphql.InputObjectFieldConfig{Type: sortDirectionType, DefaultValue: false},
+	},
+})
+
+var userInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "UserInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"email": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+func queryType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type:        userType,
+				Description: "Utente con l'ID indicato, null se non esiste",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if err := middleware.CheckUserOwnership(p.Context, p.Args["id"].(string), apikey.ScopeAdminRead); err != nil {
+						return nil, err
+					}
+					if !primitive.IsValidObjectID(p.Args["id"].(string)) {
+						return nil, nil
+					}
+					return loaderFrom(p.Context).Load(p.Args["id"].(string)), nil
+				},
+			},
+			"users": &graphql.Field{
+				Type:        graphql.NewNonNull(userConnectionType),
+				Description: "Pagina di utenti filtrata e ordinata, con paginazione a cursore",
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: userFilterType},
+					"sort":   &graphql.ArgumentConfig{Type: userSortType},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultFirst},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: resolveUsers,
+			},
+		},
+	})
+}
+
+func mutationType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(userInputType)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if err := middleware.CheckUserOwnership(p.Context, "", apikey.ScopeAdminWrite); err != nil {
+						return nil, err
+					}
+					user := userFromInput(p.Args["input"])
+					if err := services.ValidateUser(user); err != nil {
+						return nil, err
+					}
+					created, err := services.CreateUser(p.Context, user)
+					// Gli utenti creati da GraphQL non hanno attributi: la creazione fallisce se lo schema del tenant ne richiede
+					if errors.Is(err, services.ErrInvalidAttributes) || errors.Is(err, services.ErrInvalidStatus) || errors.Is(err, services.ErrEmailInUse) {
+						return nil, err
+					}
+					if err != nil {
+						return nil, userError(err, "Error creating user")
+					}
+					return *created, nil
+				},
+			},
+			"updateUser": &graphql.Field{
+				Type:        userType,
+				Description: "Aggiorna l'utente; restituisce null se non esiste",
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(userInputType)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if err := middleware.CheckUserOwnership(p.Context, p.Args["id"].(string), apikey.ScopeAdminWrite); err != nil {
+						return nil, err
+					}
+					if !primitive.IsValidObjectID(p.Args["id"].(string)) {
+						return nil, nil
+					}
+					user := userFromInput(p.Args["input"])
+					if err := services.ValidateUser(user); err != nil {
+						return nil, err
+					}
+					updated, err := services.UpdateUser(p.Context, p.Args["id"].(string), user)
+					if isNotFound(err) {
+						return nil, nil
+					}
+					if errors.Is(err, services.ErrEmailInUse) {
+						return nil, err
+					}
+					if err != nil {
+						return nil, userError(err, "Error updating user")
+					}
+					return *updated, nil
+				},
+			},
+			"deleteUser": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Elimina l'utente; restituisce false se non esiste",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if err := middleware.CheckUserOwnership(p.Context, p.Args["id"].(string), apikey.ScopeAdminWrite); err != nil {
+						return nil, err
+					}
+					if !primitive.IsValidObjectID(p.Args["id"].(string)) {
+						return false, nil
+					}
+					err := services.DeleteUserByID(p.Context, p.Args["id"].(string))
+					if isNotFound(err) {
+						return false, nil
+					}
+					if err != nil {
+						return nil, userError(err, "Error deleting user")
+					}
+					return true, nil
+				},
+			},
+		},
+	})
+}
+
+// resolveUsers converte gli argomenti di users in una UserQuery
+func resolveUsers(p graphql.ResolveParams) (interface{}, error) {
+	// L'elenco contiene altri utenti: con un access token richiede admin:read
+	if err := middleware.CheckUserOwnership(p.Context, "", apikey.ScopeAdminRead); err != nil {
+		return nil, err
+	}
+	first, _ := p.Args["first"].(int)
+	if first < 1 || first > maxFirst {
+		return nil, fmt.Errorf("first must be between 1 and %d", maxFirst)
+	}
+	query := models.UserQuery{Limit: first}
+	if after, ok := p.Args["after"].(string); ok {
+		query.After = after
+	}
+	if filter, ok := p.Args["filter"].(map[string]interface{}); ok {
+		query.NameContains, _ = filter["nameContains"].(string)
+		query.EmailContains, _ = filter["emailContains"].(string)
+		statuses, _ := filter["status"].([]interface{})
+		for _, status := range statuses {
+			query.Statuses = append(query.Statuses, status.(string))
+		}
+	}
+	if sort, ok := p.Args["sort"].(map[string]interface{}); ok {
+		query.SortField, _ = sort["field"].(string)
+		query.Descending, _ = sort["direction"].(bool)
+	}
+
+	page, err := services.QueryUsers(p.Context, query)
+	if errors.Is(err, services.ErrInvalidCursor) || errors.Is(err, services.ErrInvalidSortField) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, userError(err, "Error retrieving users")
+	}
+	return page, nil
+}
+
+func userFromInput(input interface{}) models.User {
+	fields, _ := input.(map[string]interface{})
+	name, _ := fields["name"].(string)
+	email, _ := fields["email"].(string)
+	return models.User{Name: name, Email: email}
+}
+
+// isNotFound indica un utente inesistente. Un ID non valido è trattato come non trovato, come per REST, ma i resolver
+// lo verificano prima con primitive.IsValidObjectID: 24 caratteri non esadecimali non producono primitive.ErrInvalidHex
+func isNotFound(err error) bool {
+	return errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex)
+}
+
+// userError registra l'errore e restituisce al client un messaggio generico, senza dettagli interni
+func userError(err error, message string) error {
+	utils.WithContext().Errorf("%s: %v", message, err)
+	return errors.New(message)
+}
@@ -0,0 +1,33 @@
+package gql
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestUserResolversTreatInvalidIDsAsNotFound(t *testing.T) {
+	// Un ID di 24 caratteri non esadecimali non è un ObjectID ma ha la lunghezza giusta
+	for _, id := range []string{"zzzzzzzzzzzzzzzzzzzzzzzz", "not-an-id"} {
+		t.Run(id, func(t *testing.T) {
+			variables := map[string]interface{}{"id": id}
+			tests := []struct {
+				query string
+				want  map[string]interface{}
+			}{
+				{`query($id: ID!) { user(id: $id) { id } }`, map[string]interface{}{"user": nil}},
+				{`mutation($id: ID!) { updateUser(id: $id, input: {name: "Mario Rossi", email: "mario@example.com"}) { id } }`, map[string]interface{}{"updateUser": nil}},
+				{`mutation($id: ID!) { deleteUser(id: $id) }`, map[string]interface{}{"deleteUser": false}},
+			}
+			for _, tt := range tests {
+				result := Execute(context.Background(), tt.query, "", variables)
+				if len(result.Errors) > 0 {
+					t.Fatalf("%s: errors = %v", tt.query, result.Errors)
+				}
+				if !reflect.DeepEqual(result.Data, tt.want) {
+					t.Errorf("%s: data = %v, want %v", tt.query, result.Data, tt.want)
+				}
+			}
+		})
+	}
+}
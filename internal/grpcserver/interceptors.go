@@ -0,0 +1,349 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/apikey"
+	"myapp/internal/grpcserver/userv1"
+	"myapp/internal/jwt"
+	"myapp/internal/middleware"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net"
+	"runtime/debug"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// correlationIDMetadata è la chiave dei metadata gRPC equivalente all'header X-Correlation-ID
+	correlationIDMetadata = "x-correlation-id"
+	// actorMetadata è la chiave dei metadata gRPC equivalente all'header X-Actor
+	actorMetadata = "x-actor"
+)
+
+// recoveryUnaryInterceptor converte un panic in un errore Internal, come ErrorHandlerMiddleware per HTTP
+func recoveryUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(info.FullMethod, r)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// recoveryStreamInterceptor converte un panic in un errore Internal per le chiamate in streaming
+func recoveryStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(info.FullMethod, r)
+		}
+	}()
+	return handler(srv, stream)
+}
+
+// recovered registra il panic e restituisce l'errore da inviare al client
+func recovered(method string, r interface{}) error {
+	utils.WithContext().Errorf("Recovered from panic in %s: %v\n%s", method, r, debug.Stack())
+	return status.Error(codes.Internal, "Internal Server Error")
+}
+
+// correlationUnaryInterceptor propaga il correlation ID ricevuto nei metadata (o ne genera uno nuovo)
+// nel contesto e lo restituisce negli header della risposta, come CorrelationIDMiddleware per HTTP
+func correlationUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := withCorrelationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(withAuditContext(ctx), req)
+}
+
+// correlationStreamInterceptor è la versione per le chiamate in streaming di correlationUnaryInterceptor
+func correlationStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := withCorrelationID(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: stream, ctx: withAuditContext(ctx)})
+}
+
+// withCorrelationID aggiunge il correlation ID al contesto e agli header della risposta
+func withCorrelationID(ctx context.Context) (context.Context, error) {
+	var correlationID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(correlationIDMetadata); len(values) > 0 {
+			correlationID = values[0]
+		}
+	}
+	if correlationID == "" {
+		generated, err := utils.GenerateUUID()
+		if err != nil {
+			return nil, status.Error(codes.Internal, "Unable to generate correlation ID")
+		}
+		correlationID = generated
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(correlationIDMetadata, correlationID))
+	return context.WithValue(ctx, middleware.CorrelationIDKey, correlationID), nil
+}
+
+// withAuditContext aggiunge al contesto attore (metadata x-actor) e IP del client, come AuditContextMiddleware per HTTP
+func withAuditContext(ctx context.Context) context.Context {
+	actor := middleware.AnonymousActor
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(actorMetadata); len(values) > 0 && strings.TrimSpace(values[0]) != "" {
+			actor = strings.TrimSpace(values[0])
+		}
+	}
+	ctx = middleware.WithActor(ctx, actor)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip := p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		ctx = middleware.WithClientIP(ctx, ip)
+	}
+	return ctx
+}
+
+// rateLimitInterceptors limitano le chiamate con gli stessi valori di RateLimiterMiddleware (5 al secondo, burst di 3).
+// Ogni client ha il proprio limite, individuato da middleware.ClientIdentity (API key, utente, certificato o IP):
+// vanno quindi registrati dopo authInterceptors.
+func rateLimitInterceptors() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	return limitInterceptors(middleware.NewClientLimiters(5, 3), middleware.ClientIdentity)
+}
+
+// ipRateLimitInterceptors limitano le chiamate per IP come IPRateLimiterMiddleware: registrati prima di authInterceptors,
+// limitano anche i tentativi con credenziali non valide
+func ipRateLimitInterceptors() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	return limitInterceptors(middleware.NewIPLimitersFromEnv(), middleware.IPIdentity)
+}
+
+// limitInterceptors consumano una chiamata dal limiter dell'identità restituita da identity.
+// Il limite è condiviso tra chiamate unary e in streaming; health check e reflection non sono limitati.
+func limitInterceptors(limiters *middleware.ClientLimiters, identity func(context.Context) string) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	exhausted := status.Error(codes.ResourceExhausted, "Too Many Requests - slow down my friend")
+
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !isInfrastructureMethod(info.FullMethod) && !limiters.Allow(identity(ctx)) {
+			return nil, exhausted
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !isInfrastructureMethod(info.FullMethod) && !limiters.Allow(identity(stream.Context())) {
+			return exhausted
+		}
+		return handler(srv, stream)
+	}
+	return unary, stream
+}
+
+// authInterceptors autenticano le chiamate come ClientCertMiddleware, BearerTokenMiddleware e APIKeyMiddleware per HTTP,
+// dal certificato client verificato della connessione TLS e dai metadata authorization ("Bearer <token>" o "ApiKey <chiave>")
+// e x-api-key, e verificano lo scope del metodo con le regole
+// di RequireScope: senza credenziali la chiamata è rifiutata con Unauthenticated se authRequired (AUTH_REQUIRED),
+// credenziali senza lo scope con PermissionDenied. Health check e reflection non richiedono credenziali.
+func authInterceptors(authRequired bool) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	certs := middleware.NewClientCertAuthenticatorFromEnv()
+	apiKeys := middleware.NewAPIKeyAuthenticatorFromEnv()
+	bearer := middleware.NewBearerAuthenticatorFromEnv()
+
+	authorize := func(ctx context.Context, fullMethod string) (context.Context, error) {
+		ctx, err := authenticate(ctx, certs, apiKeys, bearer)
+		if err != nil {
+			return nil, err
+		}
+		scope := methodScope(fullMethod)
+		switch err := middleware.CheckScope(ctx, scope, authRequired); {
+		case errors.Is(err, middleware.ErrAuthenticationRequired):
+			return nil, status.Error(codes.Unauthenticated, "Authentication required")
+		case errors.Is(err, middleware.ErrMissingScope):
+			return nil, status.Error(codes.PermissionDenied, "Credentials lack scope "+scope)
+		}
+		return ctx, nil
+	}
+
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isInfrastructureMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOwnership(ctx, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isInfrastructureMethod(info.FullMethod) {
+			return handler(srv, stream)
+		}
+		ctx, err := authorize(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		if err := checkOwnership(ctx, info.FullMethod, nil); err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	}
+	return unary, stream
+}
+
+// authenticate verifica le credenziali e le aggiunge al contesto nell'ordine dei middleware HTTP: il certificato client
+// della connessione (con TLS), l'access token e poi la API key, che prevale. Senza credenziali il contesto resta invariato.
+func authenticate(ctx context.Context, certs *middleware.ClientCertAuthenticator, apiKeys *middleware.APIKeyAuthenticator, bearer *middleware.BearerAuthenticator) (context.Context, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			authenticated, err := certs.Authenticate(ctx, &info.State)
+			if err != nil {
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			}
+			ctx = authenticated
+		}
+	}
+
+	authorization := firstMetadata(ctx, "authorization")
+
+	if token, ok := middleware.AuthorizationCredentials(authorization, constants.BEARER_SCHEME); ok && bearer != nil {
+		authenticated, err := bearer.Authenticate(ctx, token)
+		switch {
+		case err != nil:
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		ctx = authenticated
+	}
+
+	if key := middleware.APIKeyCredentials(authorization, firstMetadata(ctx, strings.ToLower(constants.API_KEY_HEADER))); key != "" {
+		authenticated, err := apiKeys.Authenticate(ctx, key)
+		switch {
+		case errors.Is(err, apikey.ErrInvalidKey), errors.Is(err, apikey.ErrRevokedKey), errors.Is(err, apikey.ErrExpiredKey):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		case err != nil:
+			utils.WithContext().Errorf("Error verifying API key: %v", err)
+			return nil, status.Error(codes.Internal, "Error verifying API key")
+		}
+		ctx = authenticated
+	}
+	return ctx, nil
+}
+
+// checkOwnership applica middleware.CheckUserOwnership con l'utente indicato dalla richiesta (GetUser, UpdateUser,
+// DeleteUser) o nessuno (ListUsers, WatchUsers, CreateUser): con un access token un utente accede solo a sé stesso,
+// salvo admin:read per le letture e admin:write per le modifiche. Gli stream ricevono la richiesta dopo l'interceptor,
+// quindi sono verificati senza utente.
+func checkOwnership(ctx context.Context, fullMethod string, req interface{}) error {
+	scope := apikey.ScopeAdminWrite
+	if readMethods[fullMethod] {
+		scope = apikey.ScopeAdminRead
+	}
+	userID := ""
+	if target, ok := req.(interface{ GetId() string }); ok {
+		userID = target.GetId()
+	}
+	if err := middleware.CheckUserOwnership(ctx, userID, scope); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// readMethods sono i metodi di UserService che richiedono solo users:read; gli altri richiedono users:write
+var readMethods = map[string]bool{
+	userv1.UserService_GetUser_FullMethodName:    true,
+	userv1.UserService_ListUsers_FullMethodName:  true,
+	userv1.UserService_WatchUsers_FullMethodName: true,
+}
+
+// methodScope restituisce lo scope richiesto dal metodo, come RequireScope fa con il metodo HTTP
+func methodScope(fullMethod string) string {
+	if readMethods[fullMethod] {
+		return apikey.ScopeUsersRead
+	}
+	return apikey.ScopeUsersWrite
+}
+
+// firstMetadata restituisce il primo valore della chiave nei metadata della chiamata ("" se assente)
+func firstMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// tenantInterceptors aggiungono al contesto il tenant della chiamata, risolto come TenantMiddleware per HTTP
+// dalle credenziali autenticate, dai metadata equivalenti agli header (x-tenant-id, authorization) e dall'authority:
+// vanno quindi registrati dopo authInterceptors.
+// Con TENANCY_MODE=off non fanno nulla; health check e reflection non richiedono un tenant.
+func tenantInterceptors() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	if !tenancy.Enabled() {
+		unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(ctx, req)
+		}
+		stream := func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, stream)
+		}
+		return unary, stream
+	}
+	resolver := middleware.NewTenantResolverFromEnv()
+
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isInfrastructureMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := withTenant(ctx, resolver)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isInfrastructureMethod(info.FullMethod) {
+			return handler(srv, stream)
+		}
+		ctx, err := withTenant(stream.Context(), resolver)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	}
+	return unary, stream
+}
+
+// withTenant risolve il tenant dai metadata della chiamata e lo aggiunge al contesto
+func withTenant(ctx context.Context, resolver *middleware.TenantResolver) (context.Context, error) {
+	tenant, err := resolver.Resolve(ctx, firstMetadata(ctx, strings.ToLower(resolver.HeaderName())), firstMetadata(ctx, "authorization"), firstMetadata(ctx, ":authority"))
+	switch {
+	case errors.Is(err, jwt.ErrInvalidToken):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, middleware.ErrTenantMismatch), errors.Is(err, middleware.ErrCredentialsTenantMismatch), errors.Is(err, tenancy.ErrUnknownTenant):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return tenancy.WithTenant(ctx, tenant), nil
+}
+
+// isInfrastructureMethod indica i servizi standard di gRPC (grpc.health.v1, grpc.reflection.*)
+func isInfrastructureMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/grpc.")
+}
+
+// contextStream sostituisce il contesto di uno stream gRPC
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
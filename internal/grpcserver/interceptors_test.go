@@ -0,0 +1,200 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"myapp/internal/grpcserver/userv1"
+	"myapp/internal/jwt"
+	"myapp/internal/middleware"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testJWTSecret   = "grpc-test-secret"
+	testAdminAPIKey = "admin-key-for-grpc-interceptor-tests"
+)
+
+func accessToken(t *testing.T, subject, scope string) string {
+	t.Helper()
+	token, err := jwt.Sign(jwt.Claims{
+		jwt.ClaimSubject:   subject,
+		jwt.ClaimType:      jwt.TypeAccess,
+		jwt.ClaimScope:     scope,
+		jwt.ClaimExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}, []byte(testJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// callUnary esegue l'interceptor su una chiamata unary con i metadata indicati e restituisce il codice dell'esito
+// e il contesto ricevuto dall'handler
+func callUnary(interceptor grpc.UnaryServerInterceptor, method string, md metadata.MD) (codes.Code, context.Context) {
+	return callUnaryWithRequest(interceptor, method, md, nil)
+}
+
+// callUnaryWithRequest è come callUnary con il messaggio di richiesta indicato
+func callUnaryWithRequest(interceptor grpc.UnaryServerInterceptor, method string, md metadata.MD, req interface{}) (codes.Code, context.Context) {
+	var handled context.Context
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled = ctx
+		return nil, nil
+	})
+	return status.Code(err), handled
+}
+
+func TestAuthInterceptorsRequireCredentialsAndScopes(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("ADMIN_API_KEY", testAdminAPIKey)
+	unary, _ := authInterceptors(true)
+
+	reader := "Bearer " + accessToken(t, "user-1", "users:read admin:read")
+	writer := "bearer " + accessToken(t, "user-2", "users:write admin:write")
+	cases := []struct {
+		name   string
+		method string
+		md     metadata.MD
+		want   codes.Code
+	}{
+		{"anonymous read", userv1.UserService_GetUser_FullMethodName, metadata.MD{}, codes.Unauthenticated},
+		{"anonymous delete", userv1.UserService_DeleteUser_FullMethodName, metadata.MD{}, codes.Unauthenticated},
+		{"health check", "/grpc.health.v1.Health/Check", metadata.MD{}, codes.OK},
+		{"read scope reads", userv1.UserService_ListUsers_FullMethodName, metadata.Pairs("authorization", reader), codes.OK},
+		{"read scope cannot create", userv1.UserService_CreateUser_FullMethodName, metadata.Pairs("authorization", reader), codes.PermissionDenied},
+		{"read scope cannot update", userv1.UserService_UpdateUser_FullMethodName, metadata.Pairs("authorization", reader), codes.PermissionDenied},
+		{"write scope deletes", userv1.UserService_DeleteUser_FullMethodName, metadata.Pairs("authorization", writer), codes.OK},
+		{"invalid token", userv1.UserService_GetUser_FullMethodName, metadata.Pairs("authorization", "Bearer not-a-token"), codes.Unauthenticated},
+		{"invalid API key", userv1.UserService_GetUser_FullMethodName, metadata.Pairs("x-api-key", "myapp_invalid"), codes.Unauthenticated},
+		{"admin API key", userv1.UserService_CreateUser_FullMethodName, metadata.Pairs("authorization", "ApiKey "+testAdminAPIKey), codes.OK},
+	}
+	for _, tc := range cases {
+		if got, _ := callUnary(unary, tc.method, tc.md); got != tc.want {
+			t.Errorf("%s: code %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAuthInterceptorsAllowAnonymousWhenNotRequired(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	unary, _ := authInterceptors(false)
+
+	if got, _ := callUnary(unary, userv1.UserService_CreateUser_FullMethodName, metadata.MD{}); got != codes.OK {
+		t.Errorf("anonymous call: code %v, want OK", got)
+	}
+	// Le credenziali presentate sono comunque verificate, con i loro scope
+	reader := metadata.Pairs("authorization", "Bearer "+accessToken(t, "user-1", "users:read"))
+	if got, _ := callUnary(unary, userv1.UserService_CreateUser_FullMethodName, reader); got != codes.PermissionDenied {
+		t.Errorf("read-only token: code %v, want PermissionDenied", got)
+	}
+}
+
+func TestRateLimitIsPerClient(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	auth, _ := authInterceptors(false)
+	limit, _ := rateLimitInterceptors()
+	chained := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return auth(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return limit(ctx, req, info, handler)
+		})
+	}
+
+	first := metadata.Pairs("authorization", "Bearer "+accessToken(t, "user-1", "users:read admin:read"))
+	exhausted := false
+	for i := 0; i < 20 && !exhausted; i++ {
+		code, _ := callUnary(chained, userv1.UserService_GetUser_FullMethodName, first)
+		exhausted = code == codes.ResourceExhausted
+	}
+	if !exhausted {
+		t.Fatal("first client was never limited")
+	}
+
+	second := metadata.Pairs("authorization", "Bearer "+accessToken(t, "user-2", "users:read admin:read"))
+	code, ctx := callUnary(chained, userv1.UserService_GetUser_FullMethodName, second)
+	if code != codes.OK {
+		t.Fatalf("second client: code %v, want OK", code)
+	}
+	if identity := middleware.ClientIdentity(ctx); identity != middleware.UserActorPrefix+"user-2" {
+		t.Errorf("client identity %q", identity)
+	}
+}
+
+func TestAuthInterceptorsLetUsersModifyOnlyThemselves(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	unary, _ := authInterceptors(true)
+
+	writer := metadata.Pairs("authorization", "Bearer "+accessToken(t, "user-1", "users:write"))
+	cases := []struct {
+		name   string
+		method string
+		req    interface{}
+		want   codes.Code
+	}{
+		{"update self", userv1.UserService_UpdateUser_FullMethodName, &userv1.UpdateUserRequest{Id: "user-1"}, codes.OK},
+		{"delete self", userv1.UserService_DeleteUser_FullMethodName, &userv1.DeleteUserRequest{Id: "user-1"}, codes.OK},
+		{"update another user", userv1.UserService_UpdateUser_FullMethodName, &userv1.UpdateUserRequest{Id: "user-2"}, codes.PermissionDenied},
+		{"delete another user", userv1.UserService_DeleteUser_FullMethodName, &userv1.DeleteUserRequest{Id: "user-2"}, codes.PermissionDenied},
+		{"create", userv1.UserService_CreateUser_FullMethodName, &userv1.CreateUserRequest{}, codes.PermissionDenied},
+		{"read self", userv1.UserService_GetUser_FullMethodName, &userv1.GetUserRequest{Id: "user-1"}, codes.OK},
+		{"read another user", userv1.UserService_GetUser_FullMethodName, &userv1.GetUserRequest{Id: "user-2"}, codes.PermissionDenied},
+		{"list users", userv1.UserService_ListUsers_FullMethodName, &userv1.ListUsersRequest{}, codes.PermissionDenied},
+	}
+	for _, tc := range cases {
+		if got, _ := callUnaryWithRequest(unary, tc.method, writer, tc.req); got != tc.want {
+			t.Errorf("%s: code %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	admin := metadata.Pairs("authorization", "Bearer "+accessToken(t, "user-1", "users:write admin:write"))
+	if got, _ := callUnaryWithRequest(unary, userv1.UserService_DeleteUser_FullMethodName, admin, &userv1.DeleteUserRequest{Id: "user-2"}); got != codes.OK {
+		t.Errorf("admin deletes another user: code %v, want OK", got)
+	}
+}
+
+func TestAuthInterceptorsUseTheVerifiedClientCertificate(t *testing.T) {
+	t.Setenv("TLS_CLIENT_SCOPES", "billing=users:read admin:read")
+	unary, _ := authInterceptors(true)
+
+	// peerWith restituisce il contesto di una connessione TLS con il certificato client verificato indicato
+	peerWith := func(commonName string) context.Context {
+		state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}}}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{})
+		return peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	}
+	call := func(ctx context.Context, method string) (codes.Code, context.Context) {
+		var handled context.Context
+		_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			handled = ctx
+			return nil, nil
+		})
+		return status.Code(err), handled
+	}
+
+	code, ctx := call(peerWith("billing"), userv1.UserService_ListUsers_FullMethodName)
+	if code != codes.OK {
+		t.Fatalf("mapped certificate: code %v, want OK", code)
+	}
+	if identity := middleware.GetClientCertIdentity(ctx); identity != "billing" {
+		t.Errorf("certificate identity %q, want billing", identity)
+	}
+	if got, _ := call(peerWith("billing"), userv1.UserService_CreateUser_FullMethodName); got != codes.PermissionDenied {
+		t.Errorf("read-only certificate creates: code %v, want PermissionDenied", got)
+	}
+	if got, _ := call(peerWith("unknown"), userv1.UserService_ListUsers_FullMethodName); got != codes.Unauthenticated {
+		t.Errorf("unmapped certificate: code %v, want Unauthenticated", got)
+	}
+	if got, _ := call(peerWith(""), userv1.UserService_ListUsers_FullMethodName); got != codes.PermissionDenied {
+		t.Errorf("certificate without CN: code %v, want PermissionDenied", got)
+	}
+}
@@ -0,0 +1,185 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/grpcserver/userv1"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/stream"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// userServer implementa userv1.UserServiceServer sopra il livello services, come gli handler REST
+type userServer struct {
+	userv1.UnimplementedUserServiceServer
+}
+
+func (s *userServer) GetUser(ctx context.Context, req *userv1.GetUserRequest) (*userv1.User, error) {
+	if err := checkUserID(req.GetId()); err != nil {
+		return nil, err
+	}
+	user, err := services.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, userError(err, "Error retrieving user")
+	}
+	return toProtoUser(user), nil
+}
+
+func (s *userServer) ListUsers(ctx context.Context, req *userv1.ListUsersRequest) (*userv1.ListUsersResponse, error) {
+	pageSize := int(req.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize < 0 || pageSize > maxPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be between 1 and %d", maxPageSize)
+	}
+
+	users, nextPageToken, err := services.ListUsers(ctx, pageSize, req.GetPageToken())
+	if errors.Is(err, services.ErrInvalidPageToken) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error retrieving users")
+	}
+
+	resp := &userv1.ListUsersResponse{Users: make([]*userv1.User, len(users)), NextPageToken: nextPageToken}
+	for i := range users {
+		resp.Users[i] = toProtoUser(&users[i])
+	}
+	return resp, nil
+}
+
+func (s *userServer) CreateUser(ctx context.Context, req *userv1.CreateUserRequest) (*userv1.User, error) {
+	user := fromProtoUser(req.GetUser())
+	if err := services.ValidateUser(user); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	created, err := services.CreateUser(ctx, user)
+	// Gli utenti gRPC non hanno attributi: la creazione fallisce se lo schema del tenant ne richiede
+	if errors.Is(err, services.ErrInvalidAttributes) || errors.Is(err, services.ErrInvalidStatus) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error creating user")
+	}
+	return toProtoUser(created), nil
+}
+
+func (s *userServer) UpdateUser(ctx context.Context, req *userv1.UpdateUserRequest) (*userv1.User, error) {
+	if err := checkUserID(req.GetId()); err != nil {
+		return nil, err
+	}
+	user := fromProtoUser(req.GetUser())
+	if err := services.ValidateUser(user); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	updated, err := services.UpdateUser(ctx, req.GetId(), user)
+	if err != nil {
+		return nil, userError(err, "Error updating user")
+	}
+	return toProtoUser(updated), nil
+}
+
+func (s *userServer) DeleteUser(ctx context.Context, req *userv1.DeleteUserRequest) (*emptypb.Empty, error) {
+	if err := checkUserID(req.GetId()); err != nil {
+		return nil, err
+	}
+	if err := services.DeleteUserByID(ctx, req.GetId()); err != nil {
+		return nil, userError(err, "Error deleting user")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// WatchUsers invia le notifiche del broker usato anche dallo stream SSE GET /users/events
+func (s *userServer) WatchUsers(req *userv1.WatchUsersRequest, srv userv1.UserService_WatchUsersServer) error {
+	broker := stream.GetBroker()
+	tenant, _ := tenancy.FromContext(srv.Context())
+	subscriber, replay, complete := broker.Subscribe(tenant, req.GetUserId(), req.GetResumeAfter(), req.ResumeAfter != nil)
+	defer broker.Unsubscribe(subscriber)
+
+	if !complete {
+		// Alcuni eventi successivi a resume_after non sono più disponibili: il client deve ricaricare lo stato
+		if err := srv.Send(&userv1.UserEvent{Type: "Reset"}); err != nil {
+			return err
+		}
+	}
+	for _, message := range replay {
+		if err := srv.Send(toProtoEvent(message)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-srv.Context().Done():
+			return nil
+		case message, ok := <-subscriber.C:
+			if !ok {
+				return status.Error(codes.Unavailable, "subscriber too slow, reconnect with resume_after")
+			}
+			if err := srv.Send(toProtoEvent(message)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkUserID tratta come non trovato un ID che non è un ObjectID, come per REST, senza interrogare il database:
+// una stringa di 24 caratteri non esadecimali non produce primitive.ErrInvalidHex e finirebbe in un errore interno
+func checkUserID(id string) error {
+	if !primitive.IsValidObjectID(id) {
+		return status.Error(codes.NotFound, "User not found")
+	}
+	return nil
+}
+
+// userError converte gli errori del servizio utenti in errori gRPC; un ID non valido è trattato come non trovato, come per REST
+func userError(err error, message string) error {
+	if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+		return status.Error(codes.NotFound, "User not found")
+	}
+	if errors.Is(err, services.ErrEmailInUse) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	utils.WithContext().Errorf("%s: %v", message, err)
+	return status.Error(codes.Internal, message)
+}
+
+func toProtoUser(user *models.User) *userv1.User {
+	if user == nil {
+		return nil
+	}
+	return &userv1.User{Id: user.ID, Name: user.Name, Email: user.Email}
+}
+
+func fromProtoUser(user *userv1.User) models.User {
+	return models.User{Name: user.GetName(), Email: user.GetEmail()}
+}
+
+func toProtoEvent(message stream.Message) *userv1.UserEvent {
+	event := message.Event
+	return &userv1.UserEvent{
+		Sequence:      message.ID,
+		Id:            event.ID,
+		Type:          event.Type,
+		UserId:        event.UserID,
+		Before:        toProtoUser(event.Before),
+		After:         toProtoUser(event.After),
+		CorrelationId: event.CorrelationID,
+		OccurredAt:    timestamppb.New(event.OccurredAt),
+	}
+}
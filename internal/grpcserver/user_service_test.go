@@ -0,0 +1,34 @@
+package grpcserver
+
+import (
+	"context"
+	"myapp/internal/grpcserver/userv1"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUserRPCsTreatInvalidIDsAsNotFound(t *testing.T) {
+	server := &userServer{}
+	ctx := context.Background()
+	user := &userv1.User{Name: "Mario Rossi", Email: "mario@example.com"}
+
+	// Un ID di 24 caratteri non esadecimali non è un ObjectID ma ha la lunghezza giusta
+	for _, id := range []string{"zzzzzzzzzzzzzzzzzzzzzzzz", "not-an-id", ""} {
+		t.Run(id, func(t *testing.T) {
+			_, err := server.GetUser(ctx, &userv1.GetUserRequest{Id: id})
+			if code := status.Code(err); code != codes.NotFound {
+				t.Errorf("GetUser: code = %s, want NotFound", code)
+			}
+			_, err = server.UpdateUser(ctx, &userv1.UpdateUserRequest{Id: id, User: user})
+			if code := status.Code(err); code != codes.NotFound {
+				t.Errorf("UpdateUser: code = %s, want NotFound", code)
+			}
+			_, err = server.DeleteUser(ctx, &userv1.DeleteUserRequest{Id: id})
+			if code := status.Code(err); code != codes.NotFound {
+				t.Errorf("DeleteUser: code = %s, want NotFound", code)
+			}
+		})
+	}
+}
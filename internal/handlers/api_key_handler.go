@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// CreateAPIKey crea una API key per un client.
+// @Summary Create an API key
+// @Description Crea una API key con gli scope indicati (users:read, users:write, webhooks:read, webhooks:write, admin:read, admin:write) e una scadenza facoltativa. La chiave in chiaro è restituita solo in questa risposta. Richiede lo scope admin:write.
+// @Tags admin
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   apiKey  body  models.APIKeyRequest  true  "API key"
+// @Success 201 {object} models.APIKey
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/api-keys [post]
+func CreateAPIKey(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("CreateAPIKey Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione CreateAPIKey
+		span := tracer.StartSpan("CreateAPIKey")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.APIKeyRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		key, err := services.CreateAPIKey(zipkin.NewContext(r.Context(), span), req)
+		if err != nil {
+			respondAPIKeyError(w, err, "Error creating API key")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, key)
+	}
+}
+
+// GetAPIKeys restituisce le API key del tenant.
+// @Summary Get all API keys
+// @Description Recupera le API key, anche revocate e scadute, con scope e ultimo utilizzo (senza la chiave). Richiede lo scope admin:read.
+// @Tags admin
+// @Produce  json,xml,application/msgpack,text/csv
+// @Success 200 {array} models.APIKey
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/api-keys [get]
+func GetAPIKeys(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetAPIKeys Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetAPIKeys
+		span := tracer.StartSpan("GetAPIKeys")
+		defer span.Finish()
+
+		keys, err := services.GetAPIKeys(zipkin.NewContext(r.Context(), span))
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error retrieving API keys")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, keys)
+	}
+}
+
+// GetAPIKeyByID restituisce una API key.
+// @Summary Get an API key by ID
+// @Description Recupera una API key (senza la chiave). Richiede lo scope admin:read.
+// @Tags admin
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "API key ID"
+// @Success 200 {object} models.APIKey
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/api-keys/{id} [get]
+func GetAPIKeyByID(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetAPIKeyByID Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetAPIKeyByID
+		span := tracer.StartSpan("GetAPIKeyByID")
+		defer span.Finish()
+
+		key, err := services.GetAPIKeyByID(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"])
+		if err != nil {
+			respondAPIKeyError(w, err, "Error retrieving API key")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, key)
+	}
+}
+
+// RevokeAPIKey revoca una API key.
+// @Summary Revoke an API key
+// @Description Revoca una API key: le richieste successive con la chiave sono rifiutate con 401. La chiave resta nell'elenco con la data di revoca. Richiede lo scope admin:write.
+// @Tags admin
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "API key ID"
+// @Success 200 {object} models.APIKey
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/api-keys/{id} [delete]
+func RevokeAPIKey(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("RevokeAPIKey Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione RevokeAPIKey
+		span := tracer.StartSpan("RevokeAPIKey")
+		defer span.Finish()
+
+		key, err := services.RevokeAPIKey(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"])
+		if err != nil {
+			respondAPIKeyError(w, err, "Error revoking API key")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, key)
+	}
+}
+
+// respondAPIKeyError traduce gli errori del servizio delle API key nello status HTTP corrispondente
+func respondAPIKeyError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidAPIKey):
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		utils.WithContext().Errorf("%s: %v", message, err)
+		utils.RespondWithError(w, http.StatusInternalServerError, message)
+	}
+}
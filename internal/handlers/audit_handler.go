@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// GetUserHistory restituisce lo storico delle modifiche di un utente.
+// @Summary Get user history
+// @Description Voci dell'audit log dell'utente, dalla più recente, con attore, correlation ID, IP del client e modifiche campo per campo. Disponibile anche per gli utenti eliminati.
+// @Tags users
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "User ID"
+// @Param   page  query  int  false  "Pagina (da 1)"
+// @Param   pageSize  query  int  false  "Voci per pagina (max 100)"
+// @Success 200 {object} models.AuditPage
+// @Failure 400 {object} utils.Response
+// @Router /users/{id}/history [get]
+func GetUserHistory(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetUserHistory Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetUserHistory
+		span := tracer.StartSpan("GetUserHistory")
+		defer span.Finish()
+
+		page, pageSize, err := parsePagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := services.GetUserHistory(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"], page, pageSize)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error retrieving user history")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, result)
+	}
+}
+
+// QueryAuditLog cerca nell'audit log per attore, utente e periodo.
+// @Summary Query audit log
+// @Description Voci dell'audit log di tutti gli utenti, dalla più recente, filtrate per attore, utente e intervallo di tempo [from, to). Richiede una API key con lo scope admin:read.
+// @Tags admin
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   actor  query  string  false  "Attore che ha eseguito la modifica"
+// @Param   userId  query  string  false  "Utente modificato"
+// @Param   from  query  string  false  "Inizio dell'intervallo (RFC 3339, incluso)"
+// @Param   to  query  string  false  "Fine dell'intervallo (RFC 3339, escluso)"
+// @Param   page  query  int  false  "Pagina (da 1)"
+// @Param   pageSize  query  int  false  "Voci per pagina (max 100)"
+// @Success 200 {object} models.AuditPage
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/audit [get]
+func QueryAuditLog(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("QueryAuditLog Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione QueryAuditLog
+		span := tracer.StartSpan("QueryAuditLog")
+		defer span.Finish()
+
+		params := r.URL.Query()
+		query := models.AuditQuery{Actor: params.Get("actor"), UserID: params.Get("userId")}
+		var err error
+		if query.From, err = queryTime(r, "from"); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "from must be an RFC 3339 timestamp")
+			return
+		}
+		if query.To, err = queryTime(r, "to"); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "to must be an RFC 3339 timestamp")
+			return
+		}
+		if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+			utils.RespondWithError(w, http.StatusBadRequest, "from must be before to")
+			return
+		}
+
+		page, pageSize, err := parsePagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := services.QueryAuditLog(zipkin.NewContext(r.Context(), span), query, page, pageSize)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error querying audit log")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, result)
+	}
+}
+
+// queryTime legge un parametro RFC 3339 dalla query string, restituendo il tempo zero se assente
+func queryTime(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
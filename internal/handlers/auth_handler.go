@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// Login autentica un utente con email e password.
+// @Summary Log in
+// @Description Verifica email e password e restituisce un access token (Authorization: Bearer) e un refresh token. Dopo LOGIN_MAX_ATTEMPTS tentativi falliti consecutivi il login dell'utente è bloccato per LOGIN_LOCKOUT_DURATION; con AUTH_REQUIRE_VERIFIED_EMAIL l'email deve essere verificata (403). Gli utenti non attivi (pending, suspended, deactivated) ricevono 403.
+// @Tags auth
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   credentials  body  models.LoginRequest  true  "Email e password"
+// @Success 200 {object} models.TokenResponse
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 429 {object} utils.Response
+// @Failure 503 {object} utils.Response
+// @Router /auth/login [post]
+func Login(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("Login Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione Login
+		span := tracer.StartSpan("Login")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.LoginRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		tokens, err := services.Login(zipkin.NewContext(r.Context(), span), req)
+		if err != nil {
+			respondAuthError(w, err, "Error logging in")
+			return
+		}
+		// I token non devono finire nelle cache intermedie
+		w.Header().Set("Cache-Control", "no-store")
+		utils.RespondWithJSON(w, http.StatusOK, tokens)
+	}
+}
+
+// RefreshToken rinnova i token di una sessione.
+// @Summary Refresh tokens
+// @Description Sostituisce un refresh token con un nuovo access token e un nuovo refresh token. Ogni refresh token si usa una sola volta: il riuso di un token già sostituito revoca la sessione.
+// @Tags auth
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   refresh  body  models.RefreshRequest  true  "Refresh token"
+// @Success 200 {object} models.TokenResponse
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 503 {object} utils.Response
+// @Router /auth/refresh [post]
+func RefreshToken(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("RefreshToken Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione RefreshToken
+		span := tracer.StartSpan("RefreshToken")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.RefreshRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		tokens, err := services.RefreshTokens(zipkin.NewContext(r.Context(), span), req.RefreshToken)
+		if err != nil {
+			respondAuthError(w, err, "Error refreshing tokens")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		utils.RespondWithJSON(w, http.StatusOK, tokens)
+	}
+}
+
+// Logout chiude una sessione.
+// @Summary Log out
+// @Description Revoca il refresh token indicato e tutti quelli della stessa sessione. Gli access token già emessi restano validi fino alla scadenza (AUTH_ACCESS_TOKEN_TTL).
+// @Tags auth
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   refresh  body  models.RefreshRequest  true  "Refresh token"
+// @Success 204
+// @Failure 401 {object} utils.Response
+// @Failure 503 {object} utils.Response
+// @Router /auth/logout [post]
+func Logout(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("Logout Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione Logout
+		span := tracer.StartSpan("Logout")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.RefreshRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		if err := services.Logout(zipkin.NewContext(r.Context(), span), req.RefreshToken); err != nil {
+			respondAuthError(w, err, "Error logging out")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SetUserPassword imposta la password di un utente.
+// @Summary Set a user's password
+// @Description Imposta la password con cui l'utente esegue il login e revoca le sue sessioni. Un utente autenticato con un access token può cambiare solo la propria password e deve indicare quella attuale; una API key con lo scope users:write può impostare la password di qualsiasi utente. La password non è mai restituita.
+// @Tags users
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "User ID"
+// @Param   password  body  models.PasswordRequest  true  "Password"
+// @Success 204
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Router /users/{id}/password [put]
+func SetUserPassword(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("SetUserPassword Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione SetUserPassword
+		span := tracer.StartSpan("SetUserPassword")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		id := mux.Vars(r)["id"]
+		// Con un access token (e senza API key, che prevale) l'utente può cambiare solo la propria password
+		selfService := middleware.GetAPIKey(r.Context()) == nil
+		if selfService && middleware.GetTokenSubject(r.Context()) != id {
+			utils.RespondWithError(w, http.StatusForbidden, "Users can only change their own password")
+			return
+		}
+
+		var req models.PasswordRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		if err := services.SetPassword(zipkin.NewContext(r.Context(), span), id, req, selfService); err != nil {
+			respondAuthError(w, err, "Error setting password")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RequestEmailVerification invia un link per verificare un indirizzo email.
+// @Summary Request email verification
+// @Description Invia all'indirizzo indicato un link con un token monouso per confermarlo (durata EMAIL_VERIFICATION_TOKEN_TTL). La risposta è sempre 202, anche se nessun utente ha quell'indirizzo.
+// @Tags auth
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   email  body  models.EmailRequest  true  "Email"
+// @Success 202
+// @Failure 400 {object} utils.Response
+// @Failure 503 {object} utils.Response
+// @Router /auth/email-verification [post]
+func RequestEmailVerification(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("RequestEmailVerification Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione RequestEmailVerification
+		span := tracer.StartSpan("RequestEmailVerification")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.EmailRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		if err := services.RequestEmailVerification(zipkin.NewContext(r.Context(), span), req.Email); err != nil {
+			respondAuthError(w, err, "Error requesting email verification")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusAccepted, nil)
+	}
+}
+
+// ConfirmEmailVerification conferma un indirizzo email.
+// @Summary Confirm email verification
+// @Description Consuma il token ricevuto per email e registra l'indirizzo come verificato. Il token è rifiutato se l'email dell'utente è cambiata dopo l'invio.
+// @Tags auth
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   token  body  models.EmailTokenRequest  true  "Token"
+// @Success 204
+// @Failure 400 {object} utils.Response
+// @Failure 503 {object} utils.Response
+// @Router /auth/email-verification/confirm [post]
+func ConfirmEmailVerification(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("ConfirmEmailVerification Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione ConfirmEmailVerification
+		span := tracer.StartSpan("ConfirmEmailVerification")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.EmailTokenRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		if err := services.ConfirmEmailVerification(zipkin.NewContext(r.Context(), span), req.Token); err != nil {
+			respondAuthError(w, err, "Error verifying email")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RequestPasswordReset invia un link per reimpostare la password.
+// @Summary Request a password reset
+// @Description Invia all'indirizzo indicato un link con un token monouso per scegliere una nuova password (durata PASSWORD_RESET_TOKEN_TTL). La risposta è sempre 202, anche se nessun utente ha quell'indirizzo.
+// @Tags auth
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   email  body  models.EmailRequest  true  "Email"
+// @Success 202
+// @Failure 400 {object} utils.Response
+// @Failure 503 {object} utils.Response
+// @Router /auth/password-reset [post]
+func RequestPasswordReset(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("RequestPasswordReset Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione RequestPasswordReset
+		span := tracer.StartSpan("RequestPasswordReset")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.EmailRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		if err := services.RequestPasswordReset(zipkin.NewContext(r.Context(), span), req.Email); err != nil {
+			respondAuthError(w, err, "Error requesting password reset")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusAccepted, nil)
+	}
+}
+
+// ResetPassword reimposta la password con il token ricevuto per email.
+// @Summary Reset a password
+// @Description Consuma il token di reset, imposta la nuova password, sblocca il login e revoca le sessioni dell'utente.
+// @Tags auth
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   reset  body  models.PasswordResetRequest  true  "Token e nuova password"
+// @Success 204
+// @Failure 400 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Failure 503 {object} utils.Response
+// @Router /auth/password-reset/confirm [post]
+func ResetPassword(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("ResetPassword Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione ResetPassword
+		span := tracer.StartSpan("ResetPassword")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.PasswordResetRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		if err := services.ResetPassword(zipkin.NewContext(r.Context(), span), req); err != nil {
+			respondAuthError(w, err, "Error resetting password")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// respondAuthError traduce gli errori del servizio di autenticazione nello status HTTP corrispondente
+func respondAuthError(w http.ResponseWriter, err error, message string) {
+	var locked *services.AccountLockedError
+	switch {
+	case errors.As(err, &locked):
+		retryAfter := math.Ceil(time.Until(locked.Until).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(retryAfter, 1))))
+		utils.RespondWithError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, services.ErrInvalidCredentials), errors.Is(err, services.ErrInvalidRefreshToken):
+		utils.RespondWithError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, services.ErrInvalidPassword), errors.Is(err, services.ErrEmailRequired), errors.Is(err, services.ErrInvalidEmailToken):
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrWrongPassword), errors.Is(err, services.ErrEmailNotVerified), errors.Is(err, services.ErrAccountInactive):
+		utils.RespondWithError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrUserNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrEmailInUse):
+		utils.RespondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrAuthDisabled):
+		utils.RespondWithError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		utils.WithContext().Errorf("%s: %v", message, err)
+		utils.RespondWithError(w, http.StatusInternalServerError, message)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"myapp/internal/apikey"
+	"myapp/internal/gql"
+	"myapp/internal/middleware"
+	"myapp/internal/utils"
+	"net/http"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// graphQLRequest è il corpo di una richiesta GraphQL via POST
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQL esegue query e mutation GraphQL sugli utenti.
+// @Summary GraphQL endpoint
+// @Description Esegue una richiesta GraphQL (query user/users, mutation createUser/updateUser/deleteUser). Via GET sono ammesse solo query.
+// @Tags graphql
+// @Accept  json
+// @Produce  json
+// @Param   query  query  string  false  "Query GraphQL (solo GET)"
+// @Param   request  body  object  false  "Richiesta GraphQL {query, operationName, variables} (solo POST)"
+// @Success 200 {object} object
+// @Failure 400 {object} utils.Response
+// @Router /graphql [post]
+func GraphQL(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GraphQL Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GraphQL
+		span := tracer.StartSpan("GraphQL")
+		defer span.Finish()
+
+		var req graphQLRequest
+		if r.Method == http.MethodGet {
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if variables := r.URL.Query().Get("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+					utils.RespondWithError(w, http.StatusBadRequest, "Invalid variables")
+					return
+				}
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+		if req.Query == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Query is required")
+			return
+		}
+		// Le GET possono essere ripetute o messe in cache: le mutation richiedono POST
+		if r.Method == http.MethodGet && gql.IsMutation(req.Query, req.OperationName) {
+			w.Header().Set("Allow", http.MethodPost)
+			utils.RespondWithError(w, http.StatusMethodNotAllowed, "Mutations require POST")
+			return
+		}
+		// RequireScope ammette le credenziali con users:read anche in POST: le mutation richiedono users:write
+		if !middleware.HasScope(r.Context(), apikey.ScopeUsersWrite) && gql.IsMutation(req.Query, req.OperationName) {
+			utils.RespondWithError(w, http.StatusForbidden, "Credentials lack scope "+apikey.ScopeUsersWrite)
+			return
+		}
+
+		result := gql.Execute(zipkin.NewContext(r.Context(), span), req.Query, req.OperationName, req.Variables)
+
+		// I client GraphQL si aspettano {data, errors} al primo livello: la risposta non usa l'envelope di utils.Response
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Errorf("Error writing GraphQL response: %v", err)
+		}
+	}
+}
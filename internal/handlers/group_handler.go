@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"errors"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// CreateGroup crea un gruppo di utenti.
+// @Summary Create a group
+// @Description Crea un gruppo; il nome è univoco
+// @Tags groups
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   group  body  models.GroupRequest  true  "Gruppo"
+// @Success 201 {object} models.Group
+// @Failure 400 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Router /groups [post]
+func CreateGroup(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("CreateGroup Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione CreateGroup
+		span := tracer.StartSpan("CreateGroup")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.GroupRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		group, err := services.CreateGroup(zipkin.NewContext(r.Context(), span), req)
+		if err != nil {
+			respondGroupError(w, err, "Error creating group")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, group)
+	}
+}
+
+// GetGroups restituisce una pagina dei gruppi.
+// @Summary Get groups
+// @Description Recupera i gruppi in ordine di nome
+// @Tags groups
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   page  query  int  false  "Pagina (da 1)"
+// @Param   pageSize  query  int  false  "Gruppi per pagina (max 100)"
+// @Success 200 {object} models.GroupPage
+// @Failure 400 {object} utils.Response
+// @Router /groups [get]
+func GetGroups(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetGroups Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetGroups
+		span := tracer.StartSpan("GetGroups")
+		defer span.Finish()
+
+		page, pageSize, err := parsePagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := services.GetGroups(zipkin.NewContext(r.Context(), span), page, pageSize)
+		if err != nil {
+			respondGroupError(w, err, "Error retrieving groups")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, result)
+	}
+}
+
+// GetGroupByID restituisce un gruppo.
+// @Summary Get a group by ID
+// @Description Recupera un gruppo per ID
+// @Tags groups
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "Group ID"
+// @Success 200 {object} models.Group
+// @Failure 404 {object} utils.Response
+// @Router /groups/{id} [get]
+func GetGroupByID(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetGroupByID Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetGroupByID
+		span := tracer.StartSpan("GetGroupByID")
+		defer span.Finish()
+
+		group, err := services.GetGroupByID(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"])
+		if err != nil {
+			respondGroupError(w, err, "Error retrieving group")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, group)
+	}
+}
+
+// UpdateGroup sostituisce nome e descrizione di un gruppo.
+// @Summary Update a group
+// @Description Aggiorna nome e descrizione di un gruppo
+// @Tags groups
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "Group ID"
+// @Param   group  body  models.GroupRequest  true  "Gruppo"
+// @Success 200 {object} models.Group
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Router /groups/{id} [put]
+func UpdateGroup(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("UpdateGroup Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione UpdateGroup
+		span := tracer.StartSpan("UpdateGroup")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.GroupRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		group, err := services.UpdateGroup(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"], req)
+		if err != nil {
+			respondGroupError(w, err, "Error updating group")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, group)
+	}
+}
+
+// DeleteGroupByID elimina un gruppo e le appartenenze dei suoi membri.
+// @Summary Delete a group
+// @Description Elimina un gruppo; gli utenti non vengono eliminati
+// @Tags groups
+// @Param   id  path  string  true  "Group ID"
+// @Success 204 "No Content"
+// @Failure 404 {object} utils.Response
+// @Router /groups/{id} [delete]
+func DeleteGroupByID(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("DeleteGroupByID Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione DeleteGroupByID
+		span := tracer.StartSpan("DeleteGroupByID")
+		defer span.Finish()
+
+		if err := services.DeleteGroupByID(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"]); err != nil {
+			respondGroupError(w, err, "Error deleting group")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// GetGroupMembers restituisce una pagina dei membri di un gruppo.
+// @Summary Get group members
+// @Description Recupera i membri di un gruppo con il loro ruolo, in ordine di ID utente
+// @Tags groups
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "Group ID"
+// @Param   role  query  string  false  "owner, admin o member"
+// @Param   page  query  int  false  "Pagina (da 1)"
+// @Param   pageSize  query  int  false  "Membri per pagina (max 100)"
+// @Success 200 {object} models.GroupMembershipPage
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /groups/{id}/members [get]
+func GetGroupMembers(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetGroupMembers Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetGroupMembers
+		span := tracer.StartSpan("GetGroupMembers")
+		defer span.Finish()
+
+		page, pageSize, err := parsePagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := services.GetGroupMembers(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"], r.URL.Query().Get("role"), page, pageSize)
+		if err != nil {
+			respondGroupError(w, err, "Error retrieving group members")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, result)
+	}
+}
+
+// AddGroupMember aggiunge un utente a un gruppo.
+// @Summary Add a group member
+// @Description Aggiunge un utente esistente al gruppo con il ruolo indicato (member se assente)
+// @Tags groups
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "Group ID"
+// @Param   member  body  models.GroupMemberRequest  true  "Utente e ruolo"
+// @Success 201 {object} models.GroupMembership
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Router /groups/{id}/members [post]
+func AddGroupMember(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("AddGroupMember Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione AddGroupMember
+		span := tracer.StartSpan("AddGroupMember")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.GroupMemberRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		membership, err := services.AddGroupMember(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"], req)
+		if err != nil {
+			respondGroupError(w, err, "Error adding group member")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, membership)
+	}
+}
+
+// UpdateGroupMember cambia il ruolo di un membro del gruppo.
+// @Summary Change a group member role
+// @Description Cambia il ruolo di un membro del gruppo
+// @Tags groups
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "Group ID"
+// @Param   userId  path  string  true  "User ID"
+// @Param   member  body  models.GroupMemberRequest  true  "Ruolo"
+// @Success 200 {object} models.GroupMembership
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /groups/{id}/members/{userId} [put]
+func UpdateGroupMember(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("UpdateGroupMember Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione UpdateGroupMember
+		span := tracer.StartSpan("UpdateGroupMember")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.GroupMemberRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		params := mux.Vars(r)
+		membership, err := services.SetGroupMemberRole(zipkin.NewContext(r.Context(), span), params["id"], params["userId"], req.Role)
+		if err != nil {
+			respondGroupError(w, err, "Error updating group member")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, membership)
+	}
+}
+
+// RemoveGroupMember rimuove un utente da un gruppo.
+// @Summary Remove a group member
+// @Description Rimuove un utente dal gruppo; l'utente non viene eliminato
+// @Tags groups
+// @Param   id  path  string  true  "Group ID"
+// @Param   userId  path  string  true  "User ID"
+// @Success 204 "No Content"
+// @Failure 404 {object} utils.Response
+// @Router /groups/{id}/members/{userId} [delete]
+func RemoveGroupMember(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("RemoveGroupMember Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione RemoveGroupMember
+		span := tracer.StartSpan("RemoveGroupMember")
+		defer span.Finish()
+
+		params := mux.Vars(r)
+		if err := services.RemoveGroupMember(zipkin.NewContext(r.Context(), span), params["id"], params["userId"]); err != nil {
+			respondGroupError(w, err, "Error removing group member")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// GetUserGroups restituisce una pagina dei gruppi di un utente.
+// @Summary Get user groups
+// @Description Recupera i gruppi di cui l'utente è membro con il suo ruolo, in ordine di ID del gruppo
+// @Tags users
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "User ID"
+// @Param   page  query  int  false  "Pagina (da 1)"
+// @Param   pageSize  query  int  false  "Gruppi per pagina (max 100)"
+// @Success 200 {object} models.GroupMembershipPage
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /users/{id}/groups [get]
+func GetUserGroups(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetUserGroups Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetUserGroups
+		span := tracer.StartSpan("GetUserGroups")
+		defer span.Finish()
+
+		page, pageSize, err := parsePagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := services.GetUserGroups(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"], page, pageSize)
+		if err != nil {
+			respondGroupError(w, err, "Error retrieving user groups")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, result)
+	}
+}
+
+// respondGroupError traduce gli errori del servizio dei gruppi nello status HTTP corrispondente
+func respondGroupError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidGroup), errors.Is(err, services.ErrInvalidGroupRole), errors.Is(err, services.ErrGroupMemberRequired):
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrGroupNotFound), errors.Is(err, services.ErrGroupMemberNotFound), errors.Is(err, services.ErrUserNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrGroupNameInUse), errors.Is(err, services.ErrAlreadyGroupMember):
+		utils.RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		utils.WithContext().Errorf("%s: %v", message, err)
+		utils.RespondWithError(w, http.StatusInternalServerError, message)
+	}
+}
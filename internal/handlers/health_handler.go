@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"myapp/internal/config"
+	"myapp/internal/utils"
+	"net/http"
+	"time"
+)
+
+// HealthStatus è lo stato del servizio e delle sue dipendenze restituito da /health
+type HealthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Health verifica che il servizio possa raggiungere MongoDB.
+// @Summary Health check
+// @Description Restituisce UP se MongoDB è raggiungibile, altrimenti DOWN con status 503
+// @Tags health
+// @Produce  json
+// @Success 200 {object} HealthStatus
+// @Failure 503 {object} HealthStatus
+// @Router /health [get]
+func Health() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		health := HealthStatus{Status: "UP", Checks: map[string]string{"mongodb": "UP"}}
+		if err := config.Ping(ctx); err != nil {
+			utils.WithContext().Errorf("Health check failed: %v", err)
+			health = HealthStatus{Status: "DOWN", Checks: map[string]string{"mongodb": "DOWN"}}
+			utils.RespondWithJSON(w, http.StatusServiceUnavailable, health)
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, health)
+	}
+}
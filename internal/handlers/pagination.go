@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// maxPage limita il numero di pagina: lo skip (page-1)*pageSize resta entro 10^6 documenti,
+	// senza overflow e senza scansioni arbitrariamente lunghe
+	maxPage = 10000
+)
+
+// parsePagination legge i parametri page (1-based, al massimo maxPage) e pageSize dalla query string
+func parsePagination(r *http.Request) (int, int, error) {
+	page, err := queryInt(r, "page", 1)
+	if err != nil || page < 1 || page > maxPage {
+		return 0, 0, fmt.Errorf("page must be between 1 and %d", maxPage)
+	}
+	pageSize, err := queryInt(r, "pageSize", defaultPageSize)
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		return 0, 0, fmt.Errorf("pageSize must be between 1 and %d", maxPageSize)
+	}
+	return page, pageSize, nil
+}
+
+// queryInt legge un parametro intero dalla query string, restituendo defaultValue se assente
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParsePagination(t *testing.T) {
+	cases := []struct {
+		query          string
+		page, pageSize int
+		valid          bool
+	}{
+		{"", 1, defaultPageSize, true},
+		{"?page=3&pageSize=50", 3, 50, true},
+		{"?page=" + strconv.Itoa(maxPage) + "&pageSize=100", maxPage, 100, true},
+		{"?page=0", 0, 0, false},
+		{"?page=-1", 0, 0, false},
+		{"?page=abc", 0, 0, false},
+		{"?page=" + strconv.Itoa(maxPage+1), 0, 0, false},
+		// Uno skip oltre il massimo di int andava in overflow e diventava negativo
+		{"?page=9223372036854775807&pageSize=100", 0, 0, false},
+		{"?pageSize=0", 0, 0, false},
+		{"?pageSize=101", 0, 0, false},
+	}
+	for _, tc := range cases {
+		page, pageSize, err := parsePagination(httptest.NewRequest("GET", "/users/search"+tc.query, nil))
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("%q: page %d pageSize %d, want an error", tc.query, page, pageSize)
+			}
+			continue
+		}
+		if err != nil || page != tc.page || pageSize != tc.pageSize {
+			t.Errorf("%q: page %d pageSize %d err %v, want %d %d", tc.query, page, pageSize, err, tc.page, tc.pageSize)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// ListUserAttributeSchemas restituisce gli schemi degli attributi personalizzati di tutti i namespace del tenant.
+// @Summary List the user attribute schemas
+// @Description Recupera gli schemi JSON degli attributi personalizzati di tutti i namespace del tenant, in ordine di nome. Richiede lo scope admin:read.
+// @Tags admin
+// @Produce  json,xml,application/msgpack,text/csv
+// @Success 200 {array} models.UserAttributeSchema
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/user-attributes/schemas [get]
+func ListUserAttributeSchemas(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("ListUserAttributeSchemas Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione ListUserAttributeSchemas
+		span := tracer.StartSpan("ListUserAttributeSchemas")
+		defer span.Finish()
+
+		schemas, err := services.ListUserAttributeSchemas(zipkin.NewContext(r.Context(), span))
+		if err != nil {
+			respondAttributeSchemaError(w, err, "Error retrieving attribute schemas")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, schemas)
+	}
+}
+
+// GetUserAttributeSchema restituisce lo schema degli attributi personalizzati di un namespace del tenant
+// (default con /admin/user-attributes/schema).
+// @Summary Get a user attribute schema
+// @Description Recupera lo schema JSON che governa gli attributi personalizzati di un namespace, con versione e autore dell'ultima modifica. Senza namespace nel percorso si riferisce al namespace default. Richiede lo scope admin:read.
+// @Tags admin
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   namespace  path  string  true  "Namespace"
+// @Success 200 {object} models.UserAttributeSchema
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/user-attributes/schemas/{namespace} [get]
+// @Router /admin/user-attributes/schema [get]
+func GetUserAttributeSchema(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetUserAttributeSchema Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetUserAttributeSchema
+		span := tracer.StartSpan("GetUserAttributeSchema")
+		defer span.Finish()
+
+		schema, err := services.GetUserAttributeSchema(zipkin.NewContext(r.Context(), span), attributeNamespace(r))
+		if err != nil {
+			respondAttributeSchemaError(w, err, "Error retrieving attribute schema")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, schema)
+	}
+}
+
+// SetUserAttributeSchema sostituisce lo schema degli attributi personalizzati di un namespace del tenant
+// (default con /admin/user-attributes/schema).
+// @Summary Replace a user attribute schema
+// @Description Sostituisce lo schema JSON degli attributi di un namespace (un oggetto con le proprietà dichiarate, scalari o array di scalari) e allinea gli indici sugli attributi. Un attributo può essere dichiarato da un solo namespace e tutti i namespace insieme ne dichiarano al massimo 20. Gli utenti già salvati non vengono rivalidati. Richiede lo scope admin:write.
+// @Tags admin
+// @Accept  json,application/msgpack
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   namespace  path  string  true  "Namespace"
+// @Param   schema  body  models.UserAttributeSchemaRequest  true  "Attribute schema"
+// @Success 200 {object} models.UserAttributeSchema
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Router /admin/user-attributes/schemas/{namespace} [put]
+// @Router /admin/user-attributes/schema [put]
+func SetUserAttributeSchema(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("SetUserAttributeSchema Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione SetUserAttributeSchema
+		span := tracer.StartSpan("SetUserAttributeSchema")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.UserAttributeSchemaRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		schema, err := services.SetUserAttributeSchema(zipkin.NewContext(r.Context(), span), attributeNamespace(r), req)
+		if err != nil {
+			respondAttributeSchemaError(w, err, "Error saving attribute schema")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, schema)
+	}
+}
+
+// DeleteUserAttributeSchema elimina lo schema degli attributi personalizzati di un namespace del tenant.
+// @Summary Delete a user attribute schema
+// @Description Elimina lo schema JSON degli attributi di un namespace e gli indici dei suoi attributi. Gli attributi già salvati negli utenti restano ma non sono più accettati né filtrabili. Richiede lo scope admin:write.
+// @Tags admin
+// @Param   namespace  path  string  true  "Namespace"
+// @Success 204
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Router /admin/user-attributes/schemas/{namespace} [delete]
+func DeleteUserAttributeSchema(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("DeleteUserAttributeSchema Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione DeleteUserAttributeSchema
+		span := tracer.StartSpan("DeleteUserAttributeSchema")
+		defer span.Finish()
+
+		if err := services.DeleteUserAttributeSchema(zipkin.NewContext(r.Context(), span), attributeNamespace(r)); err != nil {
+			respondAttributeSchemaError(w, err, "Error deleting attribute schema")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// attributeNamespace restituisce il namespace del percorso, default con /admin/user-attributes/schema
+func attributeNamespace(r *http.Request) string {
+	if namespace, ok := mux.Vars(r)["namespace"]; ok {
+		return namespace
+	}
+	return constants.DEFAULT_ATTRIBUTE_NAMESPACE
+}
+
+func respondAttributeSchemaError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidAttributeSchema), errors.Is(err, services.ErrInvalidAttributeNamespace):
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrAttributeSchemaNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrAttributeSchemaConflict):
+		utils.RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		utils.WithContext().Errorf("%s: %v", message, err)
+		utils.RespondWithError(w, http.StatusInternalServerError, message)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"net/http"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// BatchCreateUsers crea più utenti con una singola richiesta.
+// @Summary Batch create users
+// @Description Crea più utenti con una bulk write, restituendo l'esito di ogni elemento
+// @Tags users
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   request  body  models.BatchUsersRequest  true  "Utenti da creare"
+// @Param   Content-Encoding  header  string  false  "gzip, deflate, zstd o br per un corpo compresso"
+// @Success 200 {object} models.BatchResult
+// @Failure 400 {object} utils.Response
+// @Failure 413 {object} utils.Response
+// @Router /users:batchCreate [post]
+func BatchCreateUsers(tracer *zipkin.Tracer) http.HandlerFunc {
+	return batchHandler(tracer, "BatchCreateUsers", "Error creating users", batchUsersSize, services.BatchCreateUsers)
+}
+
+// BatchUpdateUsers aggiorna più utenti con una singola richiesta.
+// @Summary Batch update users
+// @Description Aggiorna più utenti (identificati dal campo id) con una bulk write, restituendo l'esito di ogni elemento
+// @Tags users
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   request  body  models.BatchUsersRequest  true  "Utenti da aggiornare"
+// @Param   Content-Encoding  header  string  false  "gzip, deflate, zstd o br per un corpo compresso"
+// @Success 200 {object} models.BatchResult
+// @Failure 400 {object} utils.Response
+// @Failure 413 {object} utils.Response
+// @Router /users:batchUpdate [post]
+func BatchUpdateUsers(tracer *zipkin.Tracer) http.HandlerFunc {
+	return batchHandler(tracer, "BatchUpdateUsers", "Error updating users", batchUsersSize, services.BatchUpdateUsers)
+}
+
+// BatchDeleteUsers elimina più utenti con una singola richiesta.
+// @Summary Batch delete users
+// @Description Elimina più utenti con una bulk write, restituendo l'esito di ogni elemento
+// @Tags users
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   request  body  models.BatchDeleteRequest  true  "ID degli utenti da eliminare"
+// @Param   Content-Encoding  header  string  false  "gzip, deflate, zstd o br per un corpo compresso"
+// @Success 200 {object} models.BatchResult
+// @Failure 400 {object} utils.Response
+// @Failure 413 {object} utils.Response
+// @Router /users:batchDelete [post]
+func BatchDeleteUsers(tracer *zipkin.Tracer) http.HandlerFunc {
+	return batchHandler(tracer, "BatchDeleteUsers", "Error deleting users", batchDeleteSize, services.BatchDeleteUsers)
+}
+
+// batchHandler esegue il flusso comune degli handler batch: decodifica il corpo della richiesta, ne controlla la dimensione
+// con size, esegue l'operazione con run e risponde con l'esito di ogni elemento, o 500 con il messaggio failure
+func batchHandler[T any](tracer *zipkin.Tracer, name, failure string, size func(T) int, run func(context.Context, T) (*models.BatchResult, error)) http.HandlerFunc {
+	maxBatchSize := utils.EnvIntOrDefault("BATCH_MAX_SIZE", 1000)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("%s Handler with - correlationID: %s", name, correlationID)
+
+		// Crea uno span per tracciare l'operazione batch
+		span := tracer.StartSpan(name)
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req T
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+		if !checkBatchSize(w, size(req), maxBatchSize) {
+			return
+		}
+
+		result, err := run(zipkin.NewContext(r.Context(), span), req)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, failure)
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, result)
+	}
+}
+
+func batchUsersSize(req models.BatchUsersRequest) int {
+	return len(req.Items)
+}
+
+func batchDeleteSize(req models.BatchDeleteRequest) int {
+	return len(req.IDs)
+}
+
+// checkBatchSize risponde 400 se il batch è vuoto o supera la dimensione massima configurata
+func checkBatchSize(w http.ResponseWriter, size, maxBatchSize int) bool {
+	if size == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Batch is empty")
+		return false
+	}
+	if size > maxBatchSize {
+		utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Batch too large: max %d items", maxBatchSize))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+func TestBatchHandler(t *testing.T) {
+	t.Setenv("BATCH_MAX_SIZE", "2")
+	tracer, err := zipkin.NewTracer(reporter.NewNoopReporter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		runErr      error
+		status      int
+		ran         bool
+	}{
+		{"success", "application/json", `{"ids":["a","b"]}`, nil, http.StatusOK, true},
+		{"invalid payload", "application/json", `{"ids":`, nil, http.StatusBadRequest, false},
+		{"unsupported media type", "application/pdf", `{"ids":["a"]}`, nil, http.StatusUnsupportedMediaType, false},
+		{"empty batch", "application/json", `{"ids":[]}`, nil, http.StatusBadRequest, false},
+		{"batch too large", "application/json", `{"ids":["a","b","c"]}`, nil, http.StatusBadRequest, false},
+		{"service error", "application/json", `{"ids":["a"]}`, errors.New("boom"), http.StatusInternalServerError, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ran := false
+			handler := batchHandler(tracer, "TestBatch", "Error testing batch", batchDeleteSize,
+				func(ctx context.Context, req models.BatchDeleteRequest) (*models.BatchResult, error) {
+					ran = true
+					return &models.BatchResult{}, tc.runErr
+				})
+
+			r := httptest.NewRequest(http.MethodPost, "/users:batchDelete", strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tc.status {
+				t.Errorf("status = %d, want %d (body: %s)", w.Code, tc.status, w.Body.String())
+			}
+			if ran != tc.ran {
+				t.Errorf("service called = %t, want %t", ran, tc.ran)
+			}
+			if tc.runErr != nil && !strings.Contains(w.Body.String(), "Error testing batch") {
+				t.Errorf("body = %s, want the failure message", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBatchSizes(t *testing.T) {
+	if n := batchUsersSize(models.BatchUsersRequest{Items: make([]models.User, 3)}); n != 3 {
+		t.Errorf("batchUsersSize = %d, want 3", n)
+	}
+	if n := batchDeleteSize(models.BatchDeleteRequest{IDs: []string{"a"}}); n != 1 {
+		t.Errorf("batchDeleteSize = %d, want 1", n)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"myapp/internal/middleware"
+	"myapp/internal/stream"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// StreamUserEvents invia le modifiche agli utenti come Server-Sent Events.
+// @Summary Stream user changes
+// @Description Stream SSE degli eventi UserCreated, UserUpdated e UserDeleted; con l'header Last-Event-ID riprende dagli eventi ancora presenti nel buffer di replay, altrimenti invia un evento reset
+// @Tags users
+// @Produce  text/event-stream
+// @Param   userId  query  string  false  "Solo gli eventi di questo utente"
+// @Param   Last-Event-ID  header  string  false  "Ultimo id ricevuto, per riprendere lo stream"
+// @Success 200 {object} models.UserEvent
+// @Failure 400 {object} utils.Response
+// @Router /users/events [get]
+func StreamUserEvents(tracer *zipkin.Tracer) http.HandlerFunc {
+	heartbeat := utils.EnvDurationOrDefault("USER_EVENTS_HEARTBEAT", 15*time.Second)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("StreamUserEvents Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione StreamUserEvents
+		span := tracer.StartSpan("StreamUserEvents")
+		defer span.Finish()
+
+		var lastEventID uint64
+		resume := false
+		if value := r.Header.Get("Last-Event-ID"); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				utils.RespondWithError(w, http.StatusBadRequest, "Last-Event-ID must be a non-negative integer")
+				return
+			}
+			lastEventID, resume = parsed, true
+		}
+
+		broker := stream.GetBroker()
+		tenant, _ := tenancy.FromContext(r.Context())
+		subscriber, replay, complete := broker.Subscribe(tenant, r.URL.Query().Get("userId"), lastEventID, resume)
+		// Alla disconnessione del client il subscriber viene rimosso dal broker
+		defer broker.Unsubscribe(subscriber)
+
+		controller := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		// Disabilita il buffering dei reverse proxy (es. nginx)
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", 3000); err != nil {
+			return
+		}
+		if !complete {
+			// Alcuni eventi successivi a Last-Event-ID non sono più disponibili: il client deve ricaricare lo stato
+			if _, err := io.WriteString(w, "event: reset\ndata: {\"reason\":\"replay buffer exceeded\"}\n\n"); err != nil {
+				return
+			}
+		}
+		for _, message := range replay {
+			if err := writeUserEvent(w, message); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			log.Errorf("Streaming not supported: %v", err)
+			return
+		}
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				// I commenti SSE mantengono aperta la connessione attraverso proxy e load balancer
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case message, ok := <-subscriber.C:
+				if !ok {
+					// Subscriber rimosso perché troppo lento: il client si riconnetterà con Last-Event-ID
+					return
+				}
+				if err := writeUserEvent(w, message); err != nil {
+					return
+				}
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeUserEvent scrive un messaggio del broker nel formato SSE
+func writeUserEvent(w io.Writer, message stream.Message) error {
+	data, err := json.Marshal(message.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", message.ID, message.Event.Type, data)
+	return err
+}
@@ -1,12 +1,14 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"myapp/internal/middleware"
 	"myapp/internal/models"
 	"myapp/internal/services"
 	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/openzipkin/zipkin-go"
@@ -20,11 +22,14 @@ import (
 
 // GetUsers recupera tutti gli utenti e li restituisce come risposta JSON.
 // @Summary Get all users
-// @Description Recupera tutti gli utenti
+// @Description Recupera tutti gli utenti, eventualmente solo quelli negli stati indicati e con i valori indicati degli attributi dichiarati nello schema (es. ?attributes.team=core&attributes.team=ops)
 // @Tags users
-// @Accept  json
-// @Produce  json
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   status  query  string  false  "Stati separati da virgola (pending, active, suspended, deactivated)"
+// @Param   attributes.{name}  query  string  false  "Valore di un attributo; ripetuto, basta che corrisponda uno dei valori"
 // @Success 200 {array} models.User
+// @Failure 400 {object} utils.Response
 // @Router /users [get]
 func GetUsers(tracer *zipkin.Tracer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -40,8 +45,23 @@ func GetUsers(tracer *zipkin.Tracer) http.HandlerFunc {
 		// Crea un nuovo contesto con lo span corrente
 		ctx := zipkin.NewContext(r.Context(), span)
 
+		statuses, err := services.ParseUserStatuses(r.URL.Query().Get("status"))
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		attributes, err := services.ParseAttributeFilters(ctx, attributeQuery(r))
+		if errors.Is(err, services.ErrInvalidAttributeFilter) {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error retrieving users")
+			return
+		}
+
 		// Passa lo span e il contesto al servizio
-		users, err := services.GetAllUsers(ctx, tracer)
+		users, err := services.GetAllUsers(ctx, tracer, models.UserListFilter{Statuses: statuses, Attributes: attributes})
 		if err != nil {
 			utils.RespondWithError(w, http.StatusInternalServerError, "Error retrieving users")
 			return
@@ -50,21 +70,35 @@ func GetUsers(tracer *zipkin.Tracer) http.HandlerFunc {
 	}
 }
 
+// attributeQuery restituisce i filtri sugli attributi della richiesta: i parametri attributes.<nome>, con i loro valori
+func attributeQuery(r *http.Request) map[string][]string {
+	filters := make(map[string][]string)
+	for key, values := range r.URL.Query() {
+		if name, ok := strings.CutPrefix(key, constants.USER_ATTRIBUTES_QUERY_PREFIX); ok {
+			filters[name] = values
+		}
+	}
+	return filters
+}
+
 // CreateUser decodifica il JSON in ingresso dalla richiesta e crea un nuovo utente.
 // @Summary Create a new user
 // @Description Crea un nuovo utente
 // @Tags users
-// @Accept  json
-// @Produce  json
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
 // @Param   user  body  models.User  true  "User object"
+// @Param   Idempotency-Key  header  string  false  "Chiave per rendere idempotenti i retry"
 // @Success 201 {object} models.User
+// @Failure 400 {object} utils.Response
+// @Failure 409 {object} utils.Response
 // @Router /users [post]
 func CreateUser(tracer *zipkin.Tracer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := utils.WithContext()
 
 		correlationID := middleware.GetCorrelationID(r.Context())
-		log.Info("CreateUsers Handler with - correlationID: %s", correlationID)
+		log.Infof("CreateUsers Handler with - correlationID: %s", correlationID)
 
 		// Crea uno span per tracciare l'operazione CreateUser
 		span := tracer.StartSpan("CreateUser")
@@ -76,15 +110,23 @@ func CreateUser(tracer *zipkin.Tracer) http.HandlerFunc {
 		// Crea una variabile per memorizzare i dati dell'utente decodificati
 		var user models.User
 
-		// Tenta di decodificare il JSON nel corpo della richiesta nella variabile user
-		err := json.NewDecoder(r.Body).Decode(&user)
+		// Tenta di decodificare il corpo della richiesta (nel formato indicato da Content-Type) nella variabile user
+		err := utils.DecodeRequestBody(r, &user)
 		if err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			utils.RespondWithRequestBodyError(w, err)
 			return
 		}
 
 		// Crea un nuovo utente tramite il servizio
-		createdUser, err := services.CreateUser(user)
+		createdUser, err := services.CreateUser(zipkin.NewContext(r.Context(), span), user)
+		if errors.Is(err, services.ErrInvalidStatus) || errors.Is(err, services.ErrInvalidAttributes) {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrEmailInUse) {
+			utils.RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
 		if err != nil {
 			utils.RespondWithError(w, http.StatusInternalServerError, "Error creating user")
 			return
@@ -97,8 +139,8 @@ func CreateUser(tracer *zipkin.Tracer) http.HandlerFunc {
 // @Summary Get user by ID
 // @Description Recupera un utente per ID
 // @Tags users
-// @Accept  json
-// @Produce  json
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
 // @Param   id  path  string  true  "User ID"
 // @Success 200 {object} models.User
 // @Failure 404 {object} utils.Response
@@ -109,7 +151,7 @@ func GetUserByID(tracer *zipkin.Tracer) http.HandlerFunc {
 		log := utils.WithContext()
 
 		correlationID := middleware.GetCorrelationID(r.Context())
-		log.Info("GetUserByID Handler with - correlationID: %s", correlationID)
+		log.Infof("GetUserByID Handler with - correlationID: %s", correlationID)
 
 		// Crea uno span per tracciare l'operazione GetUserByID
 		span := tracer.StartSpan("GetUserByID")
@@ -122,7 +164,7 @@ func GetUserByID(tracer *zipkin.Tracer) http.HandlerFunc {
 		id := params["id"]
 
 		// Utilizza l'ID per recuperare l'utente corrispondente
-		user, err := services.GetUserByID(id)
+		user, err := services.GetUserByID(zipkin.NewContext(r.Context(), span), id)
 		if err != nil {
 			// Se l'utente non viene trovato, risponde con un errore 404 (Not Found)
 			utils.RespondWithError(w, http.StatusNotFound, "User not found")
@@ -138,8 +180,8 @@ func GetUserByID(tracer *zipkin.Tracer) http.HandlerFunc {
 // @Summary Delete a user by ID
 // @Description Elimina un utente per ID
 // @Tags users
-// @Accept  json
-// @Produce  json
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
 // @Param   id  path  string  true  "User ID"
 // @Success 204 "No Content"
 // @Failure 404 {object} utils.Response
@@ -150,7 +192,7 @@ func DeleteUserByID(tracer *zipkin.Tracer) http.HandlerFunc {
 		log := utils.WithContext()
 
 		correlationID := middleware.GetCorrelationID(r.Context())
-		log.Info("DeleteUserById Handler with - correlationID: %s", correlationID)
+		log.Infof("DeleteUserById Handler with - correlationID: %s", correlationID)
 		// Crea uno span per tracciare l'operazione DeleteUserByID
 		span := tracer.StartSpan("DeleteUserByID")
 		defer span.Finish()
@@ -159,7 +201,7 @@ func DeleteUserByID(tracer *zipkin.Tracer) http.HandlerFunc {
 		params := mux.Vars(r)
 
 		// Elimina l'utente tramite il servizio
-		err := services.DeleteUserByID(params["id"])
+		err := services.DeleteUserByID(zipkin.NewContext(r.Context(), span), params["id"])
 		if err != nil {
 			utils.RespondWithError(w, http.StatusNotFound, "Error deleting user")
 			return
@@ -172,13 +214,14 @@ func DeleteUserByID(tracer *zipkin.Tracer) http.HandlerFunc {
 // @Summary Update a user by ID
 // @Description Aggiorna un utente per ID
 // @Tags users
-// @Accept  json
-// @Produce  json
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
 // @Param   id  path  string  true  "User ID"
 // @Param   user  body  models.User  true  "User object"
 // @Success 200 {object} models.User
 // @Failure 404 {object} utils.Response
 // @Failure 400 {object} utils.Response
+// @Failure 409 {object} utils.Response
 // @Router /users/{id} [put]
 func UpdateUser(tracer *zipkin.Tracer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -186,7 +229,7 @@ func UpdateUser(tracer *zipkin.Tracer) http.HandlerFunc {
 		log := utils.WithContext()
 
 		correlationID := middleware.GetCorrelationID(r.Context())
-		log.Info("UpdateUser Handler with - correlationID: %s", correlationID)
+		log.Infof("UpdateUser Handler with - correlationID: %s", correlationID)
 		// Crea uno span per tracciare l'operazione UpdateUser
 		span := tracer.StartSpan("UpdateUser")
 		defer span.Finish()
@@ -197,10 +240,10 @@ func UpdateUser(tracer *zipkin.Tracer) http.HandlerFunc {
 		// Crea una variabile per memorizzare i dati dell'utente decodificati
 		var user models.User
 
-		// Tenta di decodificare il JSON nel corpo della richiesta nella variabile user
-		err := json.NewDecoder(r.Body).Decode(&user)
+		// Tenta di decodificare il corpo della richiesta (nel formato indicato da Content-Type) nella variabile user
+		err := utils.DecodeRequestBody(r, &user)
 		if err != nil {
-			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			utils.RespondWithRequestBodyError(w, err)
 			return
 		}
 
@@ -208,7 +251,15 @@ func UpdateUser(tracer *zipkin.Tracer) http.HandlerFunc {
 		params := mux.Vars(r)
 
 		// Aggiorna l'utente tramite il servizio
-		updatedUser, err := services.UpdateUser(params["id"], user)
+		updatedUser, err := services.UpdateUser(zipkin.NewContext(r.Context(), span), params["id"], user)
+		if errors.Is(err, services.ErrInvalidAttributes) {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrEmailInUse) {
+			utils.RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
 		if err != nil {
 			utils.RespondWithError(w, http.StatusNotFound, "Error updating user")
 			return
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"myapp/internal/middleware"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// SearchUsers cerca gli utenti per nome ed email e restituisce i risultati ordinati per rilevanza.
+// @Summary Search users
+// @Description Ricerca full-text su nome ed email, con fallback per prefisso se l'indice full-text non è disponibile
+// @Tags users
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   q  query  string  true  "Testo da cercare"
+// @Param   mode  query  string  false  "text (default) o prefix"
+// @Param   page  query  int  false  "Pagina (da 1)"
+// @Param   pageSize  query  int  false  "Risultati per pagina (max 100)"
+// @Success 200 {object} models.UserSearchResult
+// @Failure 400 {object} utils.Response
+// @Router /users/search [get]
+func SearchUsers(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("SearchUsers Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione SearchUsers
+		span := tracer.StartSpan("SearchUsers")
+		defer span.Finish()
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Query parameter q is required")
+			return
+		}
+
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = constants.SEARCH_MODE_TEXT
+		}
+		if mode != constants.SEARCH_MODE_TEXT && mode != constants.SEARCH_MODE_PREFIX {
+			utils.RespondWithError(w, http.StatusBadRequest, "mode must be one of: text, prefix")
+			return
+		}
+
+		page, pageSize, err := parsePagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := services.SearchUsers(zipkin.NewContext(r.Context(), span), query, mode, page, pageSize)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error searching users")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ChangeUserStatus applica un'azione del ciclo di vita a un utente.
+// @Summary Change user status
+// @Description Azioni ammesse: activate (pending → active), suspend (active → suspended), reactivate (suspended o deactivated → active), deactivate (pending, active o suspended → deactivated). La sospensione e la disattivazione revocano i refresh token dell'utente.
+// @Tags users
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "User ID"
+// @Param   action  path  string  true  "activate, suspend, reactivate o deactivate"
+// @Param   request  body  models.UserStatusRequest  false  "Motivo del cambio di stato"
+// @Success 200 {object} models.User
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 409 {object} utils.Response
+// @Router /users/{id}:{action} [post]
+func ChangeUserStatus(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("ChangeUserStatus Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione ChangeUserStatus
+		span := tracer.StartSpan("ChangeUserStatus")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		// Il corpo con il motivo è facoltativo
+		var req models.UserStatusRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil && !errors.Is(err, io.EOF) {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		params := mux.Vars(r)
+		user, err := services.ChangeUserStatus(zipkin.NewContext(r.Context(), span), params["id"], params["action"], req.Reason)
+		var transition *services.StatusTransitionError
+		switch {
+		case err == nil:
+			utils.RespondWithJSON(w, http.StatusOK, user)
+		case errors.Is(err, services.ErrInvalidStatusAction), errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+			utils.RespondWithError(w, http.StatusNotFound, "Not found")
+		case errors.Is(err, services.ErrInvalidStatusReason):
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		case errors.As(err, &transition):
+			utils.RespondWithError(w, http.StatusConflict, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error changing user status")
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// formatsByContentType associa i media type supportati ai formati di import/export
+var formatsByContentType = map[string]string{
+	constants.CONTENT_TYPE_CSV:    constants.FORMAT_CSV,
+	constants.CONTENT_TYPE_NDJSON: constants.FORMAT_NDJSON,
+	"application/jsonl":           constants.FORMAT_NDJSON,
+	constants.CONTENT_TYPE_JSON:   constants.FORMAT_JSON,
+}
+
+// contentTypesByFormat è il media type restituito per ogni formato di export
+var contentTypesByFormat = map[string]string{
+	constants.FORMAT_CSV:    constants.CONTENT_TYPE_CSV + "; charset=utf-8",
+	constants.FORMAT_NDJSON: constants.CONTENT_TYPE_NDJSON,
+	constants.FORMAT_JSON:   constants.CONTENT_TYPE_JSON,
+}
+
+// ExportUsers esporta tutti gli utenti in streaming nel formato scelto tramite l'header Accept.
+// @Summary Export users
+// @Description Esporta gli utenti in CSV, NDJSON o array JSON (default) in base all'header Accept
+// @Tags users
+// @Produce  json
+// @Produce  text/csv
+// @Produce  application/x-ndjson
+// @Success 200 {array} models.User
+// @Failure 406 {object} utils.Response
+// @Router /users/export [get]
+func ExportUsers(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("ExportUsers Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione ExportUsers
+		span := tracer.StartSpan("ExportUsers")
+		defer span.Finish()
+
+		format, ok := exportFormat(r.Header.Get("Accept"))
+		if !ok {
+			utils.RespondWithError(w, http.StatusNotAcceptable, "Supported formats: text/csv, application/x-ndjson, application/json")
+			return
+		}
+
+		w.Header().Set("Content-Type", contentTypesByFormat[format])
+		w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+		w.WriteHeader(http.StatusOK)
+
+		// Una volta iniziato lo streaming non è più possibile cambiare lo status: l'errore viene solo registrato
+		count, err := services.ExportUsers(zipkin.NewContext(r.Context(), span), w, format)
+		if err != nil {
+			log.Errorf("Export interrupted after %d users: %v", count, err)
+			return
+		}
+		log.Infof("Exported %d users", count)
+	}
+}
+
+// ImportUsers importa gli utenti dal corpo della richiesta nel formato indicato da Content-Type.
+// @Summary Import users
+// @Description Importa utenti da CSV, NDJSON o array JSON con modalità dry-run e politica di conflitto configurabile
+// @Tags users
+// @Accept  json
+// @Accept  text/csv
+// @Accept  application/x-ndjson
+// @Produce  json
+// @Param   dryRun  query  bool  false  "Valida senza scrivere"
+// @Param   onConflict  query  string  false  "skip (default), overwrite o fail"
+// @Param   Content-Encoding  header  string  false  "gzip, deflate, zstd o br per un corpo compresso"
+// @Success 200 {object} models.ImportReport
+// @Failure 400 {object} models.ImportReport
+// @Failure 409 {object} models.ImportReport
+// @Failure 500 {object} models.ImportReport
+// @Failure 413 {object} models.ImportReport
+// @Failure 415 {object} utils.Response
+// @Router /users/import [post]
+func ImportUsers(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("ImportUsers Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione ImportUsers
+		span := tracer.StartSpan("ImportUsers")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format, ok := formatsByContentType[mediaType]
+		if err != nil || !ok {
+			utils.RespondWithError(w, http.StatusUnsupportedMediaType, "Supported formats: text/csv, application/x-ndjson, application/json")
+			return
+		}
+
+		query := r.URL.Query()
+		opts := models.ImportOptions{Format: format, OnConflict: constants.CONFLICT_SKIP}
+		if value := query.Get("dryRun"); value != "" {
+			if opts.DryRun, err = strconv.ParseBool(value); err != nil {
+				utils.RespondWithError(w, http.StatusBadRequest, "dryRun must be a boolean")
+				return
+			}
+		}
+		if value := query.Get("onConflict"); value != "" {
+			if value != constants.CONFLICT_SKIP && value != constants.CONFLICT_OVERWRITE && value != constants.CONFLICT_FAIL {
+				utils.RespondWithError(w, http.StatusBadRequest, "onConflict must be one of: skip, overwrite, fail")
+				return
+			}
+			opts.OnConflict = value
+		}
+
+		report, err := services.ImportUsers(zipkin.NewContext(r.Context(), span), r.Body, opts)
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			log.Errorf("Import failed: %v", err)
+			utils.RespondWithJSON(w, http.StatusRequestEntityTooLarge, report)
+		case errors.Is(err, services.ErrImportAborted):
+			utils.RespondWithJSON(w, http.StatusConflict, report)
+		case errors.Is(err, services.ErrInvalidImport):
+			log.Errorf("Import failed: %v", err)
+			utils.RespondWithJSON(w, http.StatusBadRequest, report)
+		case err != nil:
+			log.Errorf("Import failed: %v", err)
+			utils.RespondWithJSON(w, http.StatusInternalServerError, report)
+		default:
+			utils.RespondWithJSON(w, http.StatusOK, report)
+		}
+	}
+}
+
+// exportFormat sceglie il primo formato supportato elencato nell'header Accept (JSON se assente o generico)
+func exportFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return constants.FORMAT_JSON, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return constants.FORMAT_JSON, true
+		}
+		if format, ok := formatsByContentType[mediaType]; ok {
+			return format, true
+		}
+	}
+	return "", false
+}
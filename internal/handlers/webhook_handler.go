@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"errors"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/services"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// CreateWebhook registra una sottoscrizione agli eventi sugli utenti.
+// @Summary Create a webhook subscription
+// @Description Registra un URL che riceverà gli eventi indicati (tutti se eventTypes è vuoto), firmati con HMAC-SHA256. Il secret è restituito solo in questa risposta.
+// @Tags webhooks
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   webhook  body  models.WebhookSubscriptionRequest  true  "Sottoscrizione"
+// @Success 201 {object} models.WebhookSubscription
+// @Failure 400 {object} utils.Response
+// @Router /webhooks [post]
+func CreateWebhook(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("CreateWebhook Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione CreateWebhook
+		span := tracer.StartSpan("CreateWebhook")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.WebhookSubscriptionRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		subscription, err := services.CreateWebhook(zipkin.NewContext(r.Context(), span), req)
+		if err != nil {
+			respondWebhookError(w, err, "Error creating webhook")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, subscription)
+	}
+}
+
+// GetWebhooks restituisce tutte le sottoscrizioni.
+// @Summary Get all webhook subscriptions
+// @Description Recupera tutte le sottoscrizioni (senza secret)
+// @Tags webhooks
+// @Produce  json,xml,application/msgpack,text/csv
+// @Success 200 {array} models.WebhookSubscription
+// @Router /webhooks [get]
+func GetWebhooks(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetWebhooks Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetWebhooks
+		span := tracer.StartSpan("GetWebhooks")
+		defer span.Finish()
+
+		subscriptions, err := services.GetWebhooks(zipkin.NewContext(r.Context(), span))
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error retrieving webhooks")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, subscriptions)
+	}
+}
+
+// GetWebhookByID restituisce una sottoscrizione.
+// @Summary Get a webhook subscription by ID
+// @Description Recupera una sottoscrizione (senza secret)
+// @Tags webhooks
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "Webhook ID"
+// @Success 200 {object} models.WebhookSubscription
+// @Failure 404 {object} utils.Response
+// @Router /webhooks/{id} [get]
+func GetWebhookByID(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetWebhookByID Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetWebhookByID
+		span := tracer.StartSpan("GetWebhookByID")
+		defer span.Finish()
+
+		subscription, err := services.GetWebhookByID(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"])
+		if err != nil {
+			respondWebhookError(w, err, "Error retrieving webhook")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, subscription)
+	}
+}
+
+// UpdateWebhook sostituisce una sottoscrizione.
+// @Summary Update a webhook subscription
+// @Description Aggiorna URL, tipi di evento, descrizione e stato; il secret viene ruotato solo se indicato
+// @Tags webhooks
+// @Accept  json,xml,application/msgpack,text/csv
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "Webhook ID"
+// @Param   webhook  body  models.WebhookSubscriptionRequest  true  "Sottoscrizione"
+// @Success 200 {object} models.WebhookSubscription
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /webhooks/{id} [put]
+func UpdateWebhook(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("UpdateWebhook Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione UpdateWebhook
+		span := tracer.StartSpan("UpdateWebhook")
+		defer span.Finish()
+
+		defer utils.CloseRequestBody(r.Body)
+
+		var req models.WebhookSubscriptionRequest
+		if err := utils.DecodeRequestBody(r, &req); err != nil {
+			utils.RespondWithRequestBodyError(w, err)
+			return
+		}
+
+		subscription, err := services.UpdateWebhook(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"], req)
+		if err != nil {
+			respondWebhookError(w, err, "Error updating webhook")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, subscription)
+	}
+}
+
+// DeleteWebhookByID elimina una sottoscrizione e il suo storico di consegne.
+// @Summary Delete a webhook subscription
+// @Description Elimina una sottoscrizione; le consegne non ancora eseguite vengono scartate
+// @Tags webhooks
+// @Param   id  path  string  true  "Webhook ID"
+// @Success 204 "No Content"
+// @Failure 404 {object} utils.Response
+// @Router /webhooks/{id} [delete]
+func DeleteWebhookByID(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("DeleteWebhookByID Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione DeleteWebhookByID
+		span := tracer.StartSpan("DeleteWebhookByID")
+		defer span.Finish()
+
+		if err := services.DeleteWebhookByID(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"]); err != nil {
+			respondWebhookError(w, err, "Error deleting webhook")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// GetWebhookDeliveries restituisce lo storico delle consegne di una sottoscrizione.
+// @Summary Get webhook deliveries
+// @Description Storico delle consegne con i tentativi eseguiti, dalla più recente; status=dead restituisce la dead-letter list
+// @Tags webhooks
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "Webhook ID"
+// @Param   status  query  string  false  "pending, processing, delivered o dead"
+// @Param   page  query  int  false  "Pagina (da 1)"
+// @Param   pageSize  query  int  false  "Consegne per pagina (max 100)"
+// @Success 200 {object} models.WebhookDeliveryPage
+// @Failure 400 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /webhooks/{id}/deliveries [get]
+func GetWebhookDeliveries(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("GetWebhookDeliveries Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione GetWebhookDeliveries
+		span := tracer.StartSpan("GetWebhookDeliveries")
+		defer span.Finish()
+
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "", constants.DELIVERY_PENDING, constants.DELIVERY_PROCESSING, constants.DELIVERY_DELIVERED, constants.DELIVERY_DEAD:
+		default:
+			utils.RespondWithError(w, http.StatusBadRequest, "status must be one of: pending, processing, delivered, dead")
+			return
+		}
+
+		page, pageSize, err := parsePagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := services.GetWebhookDeliveries(zipkin.NewContext(r.Context(), span), mux.Vars(r)["id"], status, page, pageSize)
+		if err != nil {
+			respondWebhookError(w, err, "Error retrieving webhook deliveries")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, result)
+	}
+}
+
+// RedeliverWebhookDelivery rimette in coda una consegna conclusa.
+// @Summary Redeliver a webhook delivery
+// @Description Rimette in coda una consegna dead o delivered con un nuovo ciclo di tentativi
+// @Tags webhooks
+// @Produce  json,xml,application/msgpack,text/csv
+// @Param   id  path  string  true  "Webhook ID"
+// @Param   deliveryId  path  string  true  "Delivery ID"
+// @Success 202 {object} models.WebhookDelivery
+// @Failure 404 {object} utils.Response
+// @Router /webhooks/{id}/deliveries/{deliveryId}/redeliver [post]
+func RedeliverWebhookDelivery(tracer *zipkin.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := utils.WithContext()
+
+		correlationID := middleware.GetCorrelationID(r.Context())
+		log.Infof("RedeliverWebhookDelivery Handler with - correlationID: %s", correlationID)
+
+		// Crea uno span per tracciare l'operazione RedeliverWebhookDelivery
+		span := tracer.StartSpan("RedeliverWebhookDelivery")
+		defer span.Finish()
+
+		params := mux.Vars(r)
+		delivery, err := services.RedeliverWebhookDelivery(zipkin.NewContext(r.Context(), span), params["id"], params["deliveryId"])
+		if err != nil {
+			respondWebhookError(w, err, "Error redelivering webhook delivery")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusAccepted, delivery)
+	}
+}
+
+// respondWebhookError traduce gli errori del servizio webhook nello status HTTP corrispondente
+func respondWebhookError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidWebhook):
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrWebhookNotFound), errors.Is(err, services.ErrDeliveryNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		utils.WithContext().Errorf("%s: %v", message, err)
+		utils.RespondWithError(w, http.StatusInternalServerError, message)
+	}
+}
@@ -0,0 +1,468 @@
+// Package jsonschema implementa il sottoinsieme di JSON Schema (draft 2020-12) usato per validare gli attributi
+// personalizzati degli utenti. Parole chiave supportate:
+//   - type (una sola stringa: object, array, string, number, integer, boolean), enum;
+//   - oggetti: properties, required, additionalProperties (solo booleano);
+//   - stringhe: minLength, maxLength, pattern (sintassi RE2), format (email, date, date-time, uri, uuid);
+//   - numeri: minimum, maximum, exclusiveMinimum, exclusiveMaximum (numerici);
+//   - array: items, minItems, maxItems, uniqueItems.
+//
+// Le annotazioni ($schema, $id, $comment, title, description, default, examples, deprecated) sono accettate e ignorate.
+// Le altre parole chiave ($ref, oneOf, ...) sono rifiutate da Parse, così uno schema non viene mai applicato solo in parte.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Tipi supportati dalla parola chiave type
+const (
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+)
+
+// SchemaError indica uno schema malformato o che usa parole chiave non supportate; Path è il percorso della proprietà
+type SchemaError struct {
+	Path    string
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	return location(e.Path) + ": " + e.Message
+}
+
+func schemaError(path, format string, args ...interface{}) error {
+	return &SchemaError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// Schema è uno schema compilato
+type Schema struct {
+	Type                 string
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *bool // nil: ammesse, come da specifica
+	Enum                 []interface{}
+
+	MinLength *int
+	MaxLength *int
+	Pattern   string
+	Format    string
+
+	Minimum          *float64
+	Maximum          *float64
+	ExclusiveMinimum *float64
+	ExclusiveMaximum *float64
+
+	Items       *Schema
+	MinItems    *int
+	MaxItems    *int
+	UniqueItems bool
+
+	pattern *regexp.Regexp
+}
+
+// definition è la forma JSON di uno schema; properties e items sono compilati ricorsivamente
+type definition struct {
+	Type                 string                     `json:"type"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties *bool                      `json:"additionalProperties"`
+	Enum                 []interface{}              `json:"enum"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Pattern              string                     `json:"pattern"`
+	Format               string                     `json:"format"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	ExclusiveMinimum     *float64                   `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64                   `json:"exclusiveMaximum"`
+	Items                json.RawMessage            `json:"items"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	UniqueItems          bool                       `json:"uniqueItems"`
+}
+
+// keywords sono le parole chiave ammesse: quelle applicate e le annotazioni, ignorate dalla validazione
+var keywords = map[string]struct{}{
+	"type": {}, "properties": {}, "required": {}, "additionalProperties": {}, "enum": {},
+	"minLength": {}, "maxLength": {}, "pattern": {}, "format": {},
+	"minimum": {}, "maximum": {}, "exclusiveMinimum": {}, "exclusiveMaximum": {},
+	"items": {}, "minItems": {}, "maxItems": {}, "uniqueItems": {},
+	"$schema": {}, "$id": {}, "$comment": {}, "title": {}, "description": {}, "default": {}, "examples": {}, "deprecated": {},
+}
+
+// formats verificano la parola chiave format sulle stringhe
+var formats = map[string]func(string) bool{
+	"email": func(s string) bool {
+		address, err := mail.ParseAddress(s)
+		return err == nil && address.Address == s
+	},
+	"date": func(s string) bool {
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	},
+	"date-time": func(s string) bool {
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	},
+	"uri": func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	},
+	"uuid": regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+}
+
+// Parse compila uno schema JSON; *SchemaError se è malformato o non supportato
+func Parse(data []byte) (*Schema, error) {
+	return parse(data, "")
+}
+
+func parse(data []byte, path string) (*Schema, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return nil, schemaError(path, "must be a JSON object")
+	}
+	for keyword := range fields {
+		if _, ok := keywords[keyword]; !ok {
+			return nil, schemaError(path, "keyword %q is not supported", keyword)
+		}
+	}
+	var def definition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, schemaError(path, "%v", err)
+	}
+
+	schema := &Schema{
+		Type:                 def.Type,
+		Required:             def.Required,
+		AdditionalProperties: def.AdditionalProperties,
+		Enum:                 def.Enum,
+		MinLength:            def.MinLength,
+		MaxLength:            def.MaxLength,
+		Pattern:              def.Pattern,
+		Format:               def.Format,
+		Minimum:              def.Minimum,
+		Maximum:              def.Maximum,
+		ExclusiveMinimum:     def.ExclusiveMinimum,
+		ExclusiveMaximum:     def.ExclusiveMaximum,
+		MinItems:             def.MinItems,
+		MaxItems:             def.MaxItems,
+		UniqueItems:          def.UniqueItems,
+	}
+	switch schema.Type {
+	case "", TypeObject, TypeArray, TypeString, TypeNumber, TypeInteger, TypeBoolean:
+	default:
+		return nil, schemaError(path, "type %q is not supported", schema.Type)
+	}
+	if schema.Pattern != "" {
+		pattern, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return nil, schemaError(path, "invalid pattern: %v", err)
+		}
+		schema.pattern = pattern
+	}
+	if _, ok := formats[schema.Format]; schema.Format != "" && !ok {
+		return nil, schemaError(path, "format %q is not supported", schema.Format)
+	}
+	for _, bound := range []*int{schema.MinLength, schema.MaxLength, schema.MinItems, schema.MaxItems} {
+		if bound != nil && *bound < 0 {
+			return nil, schemaError(path, "length and item bounds must not be negative")
+		}
+	}
+
+	if len(def.Properties) > 0 {
+		schema.Properties = make(map[string]*Schema, len(def.Properties))
+		for name, raw := range def.Properties {
+			property, err := parse(raw, join(path, name))
+			if err != nil {
+				return nil, err
+			}
+			schema.Properties[name] = property
+		}
+	}
+	if len(def.Items) > 0 {
+		items, err := parse(def.Items, path+"[]")
+		if err != nil {
+			return nil, err
+		}
+		schema.Items = items
+	}
+	return schema, nil
+}
+
+// FieldError è una violazione dello schema; Path è vuoto per il valore radice (es. "team", "tags[1]")
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError raccoglie tutte le violazioni di un valore
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = location(fieldErr.Path) + ": " + fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate verifica il valore, ottenuto dalla decodifica di un documento (mappe, slice, stringhe, numeri, booleani e nil);
+// restituisce un *ValidationError con tutte le violazioni, in ordine di percorso
+func (s *Schema) Validate(value interface{}) error {
+	var errs []FieldError
+	s.validate(value, "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: errs}
+}
+
+func (s *Schema) validate(value interface{}, path string, errs *[]FieldError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != "" && !HasType(value, s.Type) {
+		fail("must be of type %s", s.Type)
+		return
+	}
+	if len(s.Enum) > 0 && !s.inEnum(value) {
+		fail("must be one of the allowed values")
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match pattern %s", s.Pattern)
+		}
+		if s.Format != "" && !formats[s.Format](v) {
+			fail("must be a valid %s", s.Format)
+		}
+		return
+	case bool, nil:
+		return
+	}
+
+	if number, ok := ToFloat(value); ok {
+		if s.Minimum != nil && number < *s.Minimum {
+			fail("must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			fail("must be <= %v", *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && number <= *s.ExclusiveMinimum {
+			fail("must be > %v", *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && number >= *s.ExclusiveMaximum {
+			fail("must be < %v", *s.ExclusiveMaximum)
+		}
+		return
+	}
+
+	if items, ok := ToList(value); ok {
+		if s.MinItems != nil && len(items) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.UniqueItems && !unique(items) {
+			fail("must not contain duplicate items")
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+		return
+	}
+
+	if object, ok := toObject(value); ok {
+		for _, name := range s.Required {
+			if _, present := object[name]; !present {
+				*errs = append(*errs, FieldError{Path: join(path, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, declared := s.Properties[name]
+			switch {
+			case declared:
+				property.validate(object[name], join(path, name), errs)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				*errs = append(*errs, FieldError{Path: join(path, name), Message: "is not allowed"})
+			}
+		}
+	}
+}
+
+// HasType indica se il valore è del tipo JSON indicato; integer accetta anche i numeri senza parte decimale
+func HasType(value interface{}, typ string) bool {
+	switch typ {
+	case TypeString:
+		_, ok := value.(string)
+		return ok
+	case TypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case TypeNumber:
+		_, ok := ToFloat(value)
+		return ok
+	case TypeInteger:
+		number, ok := ToFloat(value)
+		return ok && number == math.Trunc(number) && !math.IsInf(number, 0)
+	case TypeArray:
+		_, ok := ToList(value)
+		return ok
+	case TypeObject:
+		_, ok := toObject(value)
+		return ok
+	}
+	return false
+}
+
+// ToFloat converte in float64 i valori numerici (tipi numerici di Go e json.Number)
+func ToFloat(value interface{}) (float64, bool) {
+	if number, ok := value.(json.Number); ok {
+		f, err := number.Float64()
+		return f, err == nil
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// ToList restituisce gli elementi di una slice (esclusi i []byte)
+func ToList(value interface{}) ([]interface{}, bool) {
+	if list, ok := value.([]interface{}); ok {
+		return list, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+// toObject restituisce i campi di una mappa con chiavi stringa
+func toObject(value interface{}) (map[string]interface{}, bool) {
+	if object, ok := value.(map[string]interface{}); ok {
+		return object, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	object := make(map[string]interface{}, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		object[iter.Key().String()] = iter.Value().Interface()
+	}
+	return object, true
+}
+
+func (s *Schema) inEnum(value interface{}) bool {
+	for _, allowed := range s.Enum {
+		if equal(value, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func unique(items []interface{}) bool {
+	for i := range items {
+		for j := i + 1; j < len(items); j++ {
+			if equal(items[i], items[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// equal confronta due valori JSON; i numeri sono uguali se hanno lo stesso valore, qualunque sia il tipo di Go
+func equal(a, b interface{}) bool {
+	if x, ok := ToFloat(a); ok {
+		y, ok := ToFloat(b)
+		return ok && x == y
+	}
+	if x, ok := ToList(a); ok {
+		y, ok := ToList(b)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if x, ok := toObject(a); ok {
+		y, ok := toObject(b)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, value := range x {
+			other, present := y[name]
+			if !present || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func location(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
@@ -0,0 +1,156 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const attributesSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"title": "Attributi utente",
+	"type": "object",
+	"required": ["team", "level"],
+	"additionalProperties": false,
+	"properties": {
+		"team": {"type": "string", "enum": ["billing", "platform"]},
+		"level": {"type": "integer", "minimum": 1, "maximum": 5},
+		"score": {"type": "number", "exclusiveMinimum": 0, "exclusiveMaximum": 1},
+		"nickname": {"type": "string", "minLength": 2, "maxLength": 4, "pattern": "^[a-z]+$"},
+		"email": {"type": "string", "format": "email"},
+		"birthday": {"type": "string", "format": "date"},
+		"tags": {"type": "array", "items": {"type": "string"}, "minItems": 1, "maxItems": 3, "uniqueItems": true},
+		"admin": {"type": "boolean"}
+	}
+}`
+
+// decode decodifica un documento JSON come i valori ricevuti dagli handler
+func decode(t *testing.T, document string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func mustParse(t *testing.T, schema string) *Schema {
+	t.Helper()
+	parsed, err := Parse([]byte(schema))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return parsed
+}
+
+func TestValidateValidDocument(t *testing.T) {
+	schema := mustParse(t, attributesSchema)
+	document := `{"team": "billing", "level": 3, "score": 0.5, "nickname": "ann", "email": "ann@example.com",
+		"birthday": "1990-04-01", "tags": ["a", "b"], "admin": false}`
+	if err := schema.Validate(decode(t, document)); err != nil {
+		t.Fatalf("valid document rejected: %v", err)
+	}
+	// Gli interi senza parte decimale sono integer anche se decodificati come float64 o ricevuti come tipi di Go
+	if err := schema.Validate(map[string]interface{}{"team": "platform", "level": int32(2), "tags": []string{"x"}}); err != nil {
+		t.Fatalf("Go values rejected: %v", err)
+	}
+}
+
+func TestValidateReportsEveryViolation(t *testing.T) {
+	schema := mustParse(t, attributesSchema)
+	document := `{"team": "sales", "level": 2.5, "score": 1, "nickname": "A", "email": "Ann <ann@example.com>",
+		"birthday": "01/04/1990", "tags": ["a", "a", 3, "d"], "admin": "yes", "extra": true}`
+
+	err := schema.Validate(decode(t, document))
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("err %v, want *ValidationError", err)
+	}
+	want := []FieldError{
+		{Path: "admin", Message: "must be of type boolean"},
+		{Path: "birthday", Message: "must be a valid date"},
+		{Path: "email", Message: "must be a valid email"},
+		{Path: "extra", Message: "is not allowed"},
+		{Path: "level", Message: "must be of type integer"},
+		{Path: "nickname", Message: "must be at least 2 characters"},
+		{Path: "nickname", Message: "must match pattern ^[a-z]+$"},
+		{Path: "score", Message: "must be < 1"},
+		{Path: "tags", Message: "must have at most 3 items"},
+		{Path: "tags", Message: "must not contain duplicate items"},
+		{Path: "tags[2]", Message: "must be of type string"},
+		{Path: "team", Message: "must be one of the allowed values"},
+	}
+	if !reflect.DeepEqual(validationErr.Errors, want) {
+		t.Errorf("errors:\n got %v\nwant %v", validationErr.Errors, want)
+	}
+}
+
+func TestValidateRequiredAndRoot(t *testing.T) {
+	schema := mustParse(t, attributesSchema)
+	err := schema.Validate(decode(t, `{"team": "billing"}`))
+	if err == nil || err.Error() != "level: is required" {
+		t.Errorf("missing property: err %v", err)
+	}
+	if err := schema.Validate(decode(t, `["team"]`)); err == nil || err.Error() != "(root): must be of type object" {
+		t.Errorf("wrong root type: err %v", err)
+	}
+}
+
+func TestValidateBounds(t *testing.T) {
+	cases := []struct {
+		schema string
+		value  string
+		valid  bool
+	}{
+		{`{"type": "integer", "minimum": 1}`, `1`, true},
+		{`{"type": "integer", "minimum": 1}`, `0`, false},
+		{`{"type": "number", "exclusiveMinimum": 1}`, `1`, false},
+		{`{"type": "number", "exclusiveMaximum": 1}`, `0.99`, true},
+		{`{"type": "string", "maxLength": 3}`, `"èèè"`, true},
+		{`{"type": "string", "maxLength": 3}`, `"abcd"`, false},
+		{`{"type": "string", "format": "date-time"}`, `"2024-05-01T10:00:00Z"`, true},
+		{`{"type": "string", "format": "date-time"}`, `"2024-05-01 10:00"`, false},
+		{`{"type": "string", "format": "uri"}`, `"https://example.com/a"`, true},
+		{`{"type": "string", "format": "uri"}`, `"example.com"`, false},
+		{`{"type": "string", "format": "uuid"}`, `"123e4567-e89b-12d3-a456-426614174000"`, true},
+		{`{"type": "string", "format": "uuid"}`, `"123e4567"`, false},
+		{`{"type": "array", "minItems": 1}`, `[]`, false},
+		{`{"type": "array", "uniqueItems": true}`, `[1, 1.0]`, false},
+		{`{"type": "array", "uniqueItems": true}`, `[{"a": 1}, {"a": 2}]`, true},
+		{`{"enum": [1, "one", null]}`, `1.0`, true},
+		{`{"enum": [1, "one", null]}`, `null`, true},
+		{`{"enum": [1, "one", null]}`, `"two"`, false},
+		{`{}`, `{"anything": [1, "a"]}`, true},
+	}
+	for _, tc := range cases {
+		err := mustParse(t, tc.schema).Validate(decode(t, tc.value))
+		if (err == nil) != tc.valid {
+			t.Errorf("%s with %s: err %v, want valid %v", tc.schema, tc.value, err, tc.valid)
+		}
+	}
+}
+
+func TestParseRejectsUnsupportedSchemas(t *testing.T) {
+	cases := map[string]string{
+		`[]`: "(root): must be a JSON object",
+		`{"type": "object", "properties": {"a": {"$ref": "#/defs/a"}}}`:   `a: keyword "$ref" is not supported`,
+		`{"oneOf": [{"type": "string"}]}`:                                 `(root): keyword "oneOf" is not supported`,
+		`{"type": ["string", "null"]}`:                                    "(root): json: cannot unmarshal array into Go struct field definition.type of type string",
+		`{"type": "date"}`:                                                `(root): type "date" is not supported`,
+		`{"type": "string", "pattern": "("}`:                              "(root): invalid pattern: error parsing regexp: missing closing ): `(`",
+		`{"type": "string", "format": "ipv4"}`:                            `(root): format "ipv4" is not supported`,
+		`{"type": "array", "items": {"type": "string", "minLength": -1}}`: "[]: length and item bounds must not be negative",
+	}
+	for schema, message := range cases {
+		_, err := Parse([]byte(schema))
+		var schemaErr *SchemaError
+		if !errors.As(err, &schemaErr) {
+			t.Errorf("%s: err %v, want *SchemaError", schema, err)
+			continue
+		}
+		if err.Error() != message {
+			t.Errorf("%s: message %q, want %q", schema, err.Error(), message)
+		}
+	}
+}
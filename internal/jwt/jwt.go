@@ -0,0 +1,111 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken indica un token malformato, con firma non valida o scaduto
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claim registrati usati dai token emessi dal servizio
+const (
+	ClaimSubject   = "sub"
+	ClaimID        = "jti"
+	ClaimIssuedAt  = "iat"
+	ClaimExpiresAt = "exp"
+	ClaimType      = "typ"   // tipo del token: TypeAccess o TypeRefresh
+	ClaimScope     = "scope" // scope separati da spazi, come in OAuth 2.0
+	ClaimEmail     = "email" // indirizzo a cui è stato inviato un token di verifica o di reset
+)
+
+// Tipi dei token emessi dal login e dei token inviati per email
+const (
+	TypeAccess            = "access"
+	TypeRefresh           = "refresh"
+	TypeEmailVerification = "email_verification"
+	TypePasswordReset     = "password_reset"
+)
+
+// Claims sono i claim del payload di un token
+type Claims map[string]interface{}
+
+// String restituisce il claim indicato se è una stringa, altrimenti una stringa vuota
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// header è l'header JOSE dei token; è supportato solo HS256
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Parse verifica la firma HS256 del token con secret e i claim temporali exp e nbf, e restituisce i claim
+func Parse(token string, secret []byte) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || len(secret) == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil || h.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(parts[0]+"."+parts[1], secret)) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return nil, ErrInvalidToken
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Sign firma i claim con HS256 e restituisce il token in formato compatto
+func Sign(claims Claims, secret []byte) (string, error) {
+	if len(secret) == 0 {
+		return "", errors.New("empty JWT secret")
+	}
+	h, err := json.Marshal(header{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign(signingInput, secret)), nil
+}
+
+// decodeSegment decodifica un segmento base64url del token come JSON
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// sign calcola la firma HMAC-SHA256 di header e payload
+func sign(signingInput string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"context"
+	"myapp/internal/utils"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogSender scrive le email nel log invece di inviarle: per lo sviluppo locale, i link dei token sono leggibili nel log
+type LogSender struct {
+	from string
+}
+
+// NewLogSender crea un sender che scrive le email nel log
+func NewLogSender(from string) *LogSender {
+	return &LogSender{from: from}
+}
+
+func (s *LogSender) Name() string {
+	return "log"
+}
+
+func (s *LogSender) Send(_ context.Context, msg Message) error {
+	if _, err := compose(s.from, msg, time.Now()); err != nil {
+		return err
+	}
+	utils.WithContext().WithField("to", msg.To).WithField("subject", msg.Subject).Infof("Email non inviata (MAIL_SENDER=log):\n%s", msg.Body)
+	return nil
+}
+
+// FileSender accoda le email, nel formato RFC 5322, a un file: per i test e per ispezionare i messaggi generati
+type FileSender struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+// NewFileSender crea un sender che accoda le email al file indicato
+func NewFileSender(path, from string) *FileSender {
+	return &FileSender{path: path, from: from}
+}
+
+func (s *FileSender) Name() string {
+	return "file"
+}
+
+func (s *FileSender) Send(_ context.Context, msg Message) error {
+	data, err := compose(s.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Le email sono separate da una riga vuota
+	if _, err := f.Write(append(data, '\r', '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
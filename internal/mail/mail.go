@@ -0,0 +1,90 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"myapp/internal/utils"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// ErrInvalidMessage indica un messaggio con destinatario non valido o header che contengono ritorni a capo
+var ErrInvalidMessage = errors.New("invalid mail message")
+
+// Message è un'email in testo semplice
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender invia le email dell'applicazione (verifica dell'indirizzo, reset della password).
+// Send deve restituire un errore se il messaggio non è stato accettato.
+type Sender interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSenderFromEnv crea il sender indicato da MAIL_SENDER: log (default, per lo sviluppo), file o smtp
+func NewSenderFromEnv() (Sender, error) {
+	from := utils.EnvOrDefault("MAIL_FROM", "myapp <no-reply@localhost>")
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid MAIL_FROM %q: %w", from, err)
+	}
+
+	switch name := utils.EnvOrDefault("MAIL_SENDER", "log"); name {
+	case "log":
+		return NewLogSender(from), nil
+	case "file":
+		return NewFileSender(utils.EnvOrDefault("MAIL_FILE_PATH", "mail.log"), from), nil
+	case "smtp":
+		host := utils.EnvOrDefault("MAIL_SMTP_HOST", "")
+		if host == "" {
+			return nil, errors.New("MAIL_SMTP_HOST is required by the smtp sender")
+		}
+		return &SMTPSender{
+			Host:     host,
+			Port:     utils.EnvIntOrDefault("MAIL_SMTP_PORT", 587),
+			Username: utils.EnvOrDefault("MAIL_SMTP_USERNAME", ""),
+			Password: utils.EnvOrDefault("MAIL_SMTP_PASSWORD", ""),
+			From:     from,
+			Timeout:  utils.EnvDurationOrDefault("MAIL_SMTP_TIMEOUT", 10*time.Second),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail sender: %s (log, file or smtp)", name)
+	}
+}
+
+// compose costruisce il messaggio RFC 5322 con corpo UTF-8 in quoted-printable
+func compose(from string, msg Message, date time.Time) ([]byte, error) {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return nil, ErrInvalidMessage
+	}
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	body := quotedprintable.NewWriter(&buf)
+	if _, err := body.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
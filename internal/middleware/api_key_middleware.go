@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"myapp/internal/apikey"
+	"myapp/internal/models"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const APIKeyKey = contextKey("apiKey")
+
+// APIKeyActorPrefix distingue nell'audit log gli attori autenticati con una API key
+const APIKeyActorPrefix = "apikey:"
+
+// AdminAPIKeyID è l'ID con cui è registrata la chiave di bootstrap ADMIN_API_KEY
+const AdminAPIKeyID = "admin"
+
+// minAdminAPIKeyLength è la lunghezza minima della chiave di bootstrap
+const minAdminAPIKeyLength = 32
+
+// APIKeyAuthenticator verifica le API key presentate dai client; è condiviso da APIKeyMiddleware e dal server gRPC
+type APIKeyAuthenticator struct {
+	adminKeyHash     []byte
+	lastUsedInterval time.Duration
+}
+
+// NewAPIKeyAuthenticatorFromEnv crea l'autenticatore leggendo ADMIN_API_KEY e API_KEY_LAST_USED_INTERVAL
+func NewAPIKeyAuthenticatorFromEnv() *APIKeyAuthenticator {
+	authenticator := &APIKeyAuthenticator{lastUsedInterval: utils.EnvDurationOrDefault("API_KEY_LAST_USED_INTERVAL", time.Minute)}
+	if adminKey := utils.EnvOrDefault("ADMIN_API_KEY", ""); adminKey != "" {
+		if len(adminKey) < minAdminAPIKeyLength {
+			utils.WithContext().WithField("function", "NewAPIKeyAuthenticatorFromEnv").Fatalf("ADMIN_API_KEY must be at least %d characters", minAdminAPIKeyLength)
+		}
+		sum := sha256.Sum256([]byte(adminKey))
+		authenticator.adminKeyHash = sum[:]
+	}
+	return authenticator
+}
+
+// Authenticate verifica la chiave presentata e restituisce il contesto con la API key e l'attore "apikey:<id>".
+// Gli errori di verifica sono apikey.ErrInvalidKey, ErrRevokedKey ed ErrExpiredKey; gli altri sono errori del database.
+// Il tenant della chiave è confrontato con quello della richiesta da TenantResolver.
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, presented string) (context.Context, error) {
+	var key *models.APIKey
+	// Gli hash hanno lunghezza fissa: il confronto non rivela la lunghezza della chiave di bootstrap
+	if sum := sha256.Sum256([]byte(presented)); a.adminKeyHash != nil && subtle.ConstantTimeCompare(sum[:], a.adminKeyHash) == 1 {
+		key = &models.APIKey{ID: AdminAPIKeyID, Name: AdminAPIKeyID, Scopes: apikey.Scopes}
+	} else {
+		authenticated, err := apikey.Authenticate(ctx, presented, a.lastUsedInterval)
+		if err != nil {
+			return nil, err
+		}
+		key = authenticated
+	}
+	ctx = context.WithValue(ctx, APIKeyKey, key)
+	return WithActor(ctx, APIKeyActorPrefix+key.ID), nil
+}
+
+// APIKeyMiddleware autentica le richieste con una API key, letta da "Authorization: ApiKey <chiave>" o da X-API-Key.
+// La chiave diventa l'attore "apikey:<id>", che prevale su X-Actor e sul certificato client, e l'identità
+// del client per il rate limiter; i suoi scope sono verificati da RequireScope.
+// ADMIN_API_KEY è una chiave di bootstrap con tutti gli scope, non salvata nel database, per creare le prime chiavi.
+// Chiavi non valide, revocate o scadute sono rifiutate con 401, chiavi di un altro tenant con 403 da TenantMiddleware;
+// le richieste senza chiave proseguono e sono RequireScope a decidere se ammetterle.
+func APIKeyMiddleware(next http.Handler) http.Handler {
+	log := utils.WithContext().WithField("function", "APIKeyMiddleware")
+	authenticator := NewAPIKeyAuthenticatorFromEnv()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := apiKeyFromRequest(r)
+		if presented == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, err := authenticator.Authenticate(r.Context(), presented)
+		switch {
+		case errors.Is(err, apikey.ErrInvalidKey), errors.Is(err, apikey.ErrRevokedKey), errors.Is(err, apikey.ErrExpiredKey):
+			respondUnauthorized(w, err.Error(), constants.API_KEY_SCHEME)
+			return
+		case err != nil:
+			log.Errorf("Error verifying API key: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error verifying API key")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetAPIKey recupera dal contesto la API key che ha autenticato la richiesta (nil se la richiesta non ne ha presentata una)
+func GetAPIKey(ctx context.Context) *models.APIKey {
+	key, _ := ctx.Value(APIKeyKey).(*models.APIKey)
+	return key
+}
+
+// apiKeyFromRequest restituisce la chiave indicata nell'header Authorization (schema ApiKey) o in X-API-Key
+func apiKeyFromRequest(r *http.Request) string {
+	return APIKeyCredentials(r.Header.Get("Authorization"), r.Header.Get(constants.API_KEY_HEADER))
+}
+
+// APIKeyCredentials restituisce la chiave indicata nel valore di Authorization (schema ApiKey, senza distinzione
+// tra maiuscole e minuscole) o, in sua assenza, in quello di X-API-Key
+func APIKeyCredentials(authorization, apiKeyHeader string) string {
+	if credentials, ok := AuthorizationCredentials(authorization, constants.API_KEY_SCHEME); ok {
+		return credentials
+	}
+	return strings.TrimSpace(apiKeyHeader)
+}
+
+// AuthorizationCredentials restituisce le credenziali di un valore dell'header Authorization se usa lo schema indicato,
+// confrontato senza distinzione tra maiuscole e minuscole come prevede RFC 9110
+func AuthorizationCredentials(authorization, scheme string) (string, bool) {
+	name, credentials, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(name, scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(credentials), true
+}
+
+// respondUnauthorized risponde 401 indicando gli schemi di autenticazione attesi
+func respondUnauthorized(w http.ResponseWriter, message string, schemes ...string) {
+	for _, scheme := range schemes {
+		w.Header().Add("WWW-Authenticate", scheme)
+	}
+	utils.RespondWithError(w, http.StatusUnauthorized, message)
+}
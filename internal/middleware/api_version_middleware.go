@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"fmt"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	deprecatedAPIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_deprecated_requests_total",
+		Help: "Richieste alle versioni deprecate dell'API per versione, rotta e client (API key o tipo di credenziali)",
+	}, []string{"version", "route", "client"})
+
+	// vendorMediaType riconosce il media type versionato application/vnd.myapp.v<N>+json
+	vendorMediaType = regexp.MustCompile(`^application/vnd\.myapp\.(v[0-9]+)\+json$`)
+)
+
+// APIVersionMiddleware serve le rotte montate sotto il prefisso di una versione (es. /v1).
+// Se l'header Accept chiede esplicitamente un'altra versione con il media type versionato risponde 406.
+func APIVersionMiddleware(version string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requested := requestedAPIVersion(r); requested != "" && requested != version {
+				utils.RespondWithError(w, http.StatusNotAcceptable, fmt.Sprintf("API version %s is not served under /%s", requested, version))
+				return
+			}
+			w.Header().Set(constants.API_VERSION_HEADER, version)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DeprecatedAPIMiddleware serve le rotte senza prefisso, mantenute come alias della versione current durante la migrazione.
+// Un client che chiede current con il media type versionato (Accept: application/vnd.myapp.v1+json) usa il versionamento
+// per media type e non è deprecato; gli altri ricevono gli header Deprecation (API_UNVERSIONED_DEPRECATED_AT),
+// Sunset (API_UNVERSIONED_SUNSET) e Link alla rotta versionata (e a API_DEPRECATION_DOC_URL), e vengono contati
+// in api_deprecated_requests_total per sapere chi deve ancora migrare.
+func DeprecatedAPIMiddleware(current, successorPrefix string) func(http.Handler) http.Handler {
+	log := utils.WithContext().WithField("function", "DeprecatedAPIMiddleware")
+
+	// RFC 9745: data della deprecazione come @<secondi Unix>; senza data "true" (draft precedenti)
+	deprecation := "true"
+	if value := utils.EnvOrDefault("API_UNVERSIONED_DEPRECATED_AT", ""); value != "" {
+		deprecatedAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Fatalf("Invalid API_UNVERSIONED_DEPRECATED_AT %q: %v", value, err)
+		}
+		deprecation = fmt.Sprintf("@%d", deprecatedAt.Unix())
+	}
+	sunset := ""
+	if value := utils.EnvOrDefault("API_UNVERSIONED_SUNSET", ""); value != "" {
+		sunsetAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Fatalf("Invalid API_UNVERSIONED_SUNSET %q: %v", value, err)
+		}
+		sunset = sunsetAt.UTC().Format(http.TimeFormat)
+	}
+	docURL := utils.EnvOrDefault("API_DEPRECATION_DOC_URL", "")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch requested := requestedAPIVersion(r); requested {
+			case current:
+				w.Header().Set(constants.API_VERSION_HEADER, current)
+				next.ServeHTTP(w, r)
+				return
+			case "":
+			default:
+				utils.RespondWithError(w, http.StatusNotAcceptable, fmt.Sprintf("API version %s is not available", requested))
+				return
+			}
+
+			w.Header().Set(constants.API_VERSION_HEADER, current)
+			w.Header().Set("Deprecation", deprecation)
+			if sunset != "" {
+				w.Header().Set("Sunset", sunset)
+			}
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successorPrefix+r.URL.RequestURI()))
+			if docURL != "" {
+				w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"; type="text/html"`, docURL))
+			}
+			deprecatedAPIRequests.WithLabelValues(constants.API_UNVERSIONED, routeTemplate(r), apiClient(r)).Inc()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestedAPIVersion restituisce la versione chiesta con il media type versionato nell'header Accept ("" se assente)
+func requestedAPIVersion(r *http.Request) string {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accepted, ";")
+		if match := vendorMediaType.FindStringSubmatch(strings.ToLower(strings.TrimSpace(mediaType))); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// routeTemplate restituisce il template della rotta (es. /users/{id}), che a differenza del path ha cardinalità limitata
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unknown"
+}
+
+// apiClient identifica il chiamante per la metrica delle versioni deprecate con un insieme limitato di valori:
+// l'identità della API key ("apikey:<id>"; le chiavi sono create solo dagli amministratori), altrimenti il tipo
+// di credenziali (user, cert) o anonymous. Attore, utente e User-Agent sono scelti dai client o illimitati,
+// e ogni valore diverso creerebbe una nuova serie.
+func apiClient(r *http.Request) string {
+	if key := GetAPIKey(r.Context()); key != nil {
+		return APIKeyActorPrefix + key.ID
+	}
+	if GetTokenSubject(r.Context()) != "" {
+		return strings.TrimSuffix(UserActorPrefix, ":")
+	}
+	if GetClientCertIdentity(r.Context()) != "" {
+		return strings.TrimSuffix(ClientCertActorPrefix, ":")
+	}
+	return AnonymousActor
+}
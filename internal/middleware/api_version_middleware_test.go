@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIClientHasBoundedValues(t *testing.T) {
+	cases := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"anonymous", context.Background(), AnonymousActor},
+		{"api key", context.WithValue(context.Background(), APIKeyKey, &models.APIKey{ID: "0123abcd"}), "apikey:0123abcd"},
+		{"user", context.WithValue(context.Background(), BearerTokenKey, &bearerToken{subject: "user-42"}), "user"},
+		{"certificate", context.WithValue(context.Background(), ClientCertKey, &clientCert{identity: "billing-service"}), "cert"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/users", nil).WithContext(WithActor(tc.ctx, "declared-by-client"))
+		req.Header.Set(constants.ACTOR_HEADER, "declared-by-client")
+		req.Header.Set("User-Agent", "random-agent-12345/1.0")
+		if got := apiClient(req); got != tc.want {
+			t.Errorf("%s: client %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	ActorKey    = contextKey("actor")
+	ClientIPKey = contextKey("clientIP")
+)
+
+// AnonymousActor è l'attore registrato quando la richiesta non indica chi la esegue
+const AnonymousActor = "anonymous"
+
+// AuditContextMiddleware aggiunge al contesto l'attore e l'IP del client, registrati nell'audit log delle modifiche.
+// L'attore è letto dall'header X-Actor; l'IP da X-Forwarded-For solo se TRUST_PROXY_HEADERS=true
+// (servizio dietro un proxy fidato), altrimenti dall'indirizzo della connessione.
+func AuditContextMiddleware(next http.Handler) http.Handler {
+	trustProxy := utils.EnvOrDefault("TRUST_PROXY_HEADERS", "false") == "true"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := strings.TrimSpace(r.Header.Get(constants.ACTOR_HEADER))
+		if actor == "" {
+			actor = AnonymousActor
+		}
+		ctx := WithActor(r.Context(), actor)
+		ctx = WithClientIP(ctx, clientIP(r, trustProxy))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// WithActor restituisce un contesto con l'attore indicato (es. utente autenticato, myctl)
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, ActorKey, actor)
+}
+
+// WithClientIP restituisce un contesto con l'IP del client indicato
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ClientIPKey, ip)
+}
+
+// GetActor recupera l'attore dal contesto (AnonymousActor se assente)
+func GetActor(ctx context.Context) string {
+	if actor, ok := ctx.Value(ActorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return AnonymousActor
+}
+
+// GetClientIP recupera l'IP del client dal contesto
+func GetClientIP(ctx context.Context) string {
+	if ip, ok := ctx.Value(ClientIPKey).(string); ok {
+		return ip
+	}
+	return ""
+}
+
+// clientIP restituisce l'IP del client; con trustProxy usa il primo indirizzo di X-Forwarded-For
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/jwt"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"strings"
+)
+
+const BearerTokenKey = contextKey("bearerToken")
+
+// UserActorPrefix distingue nell'audit log gli utenti autenticati con un access token
+const UserActorPrefix = "user:"
+
+// bearerToken è l'utente autenticato da un access token con i suoi scope e il suo tenant
+type bearerToken struct {
+	subject string
+	scopes  []string
+	tenant  string
+}
+
+var (
+	// ErrInvalidAccessToken indica un token malformato, con firma non valida o scaduto
+	ErrInvalidAccessToken = errors.New("invalid or expired access token")
+	// ErrNotAccessToken indica un token valido di un altro tipo (refresh, email)
+	ErrNotAccessToken = errors.New("token is not an access token")
+)
+
+// BearerAuthenticator verifica gli access token firmati con JWT_SECRET; è condiviso da BearerTokenMiddleware e dal server gRPC
+type BearerAuthenticator struct {
+	secret      []byte
+	tenantClaim string
+}
+
+// NewBearerAuthenticatorFromEnv crea l'autenticatore leggendo JWT_SECRET e TENANT_JWT_CLAIM; nil senza JWT_SECRET
+func NewBearerAuthenticatorFromEnv() *BearerAuthenticator {
+	secret := []byte(utils.EnvOrDefault("JWT_SECRET", ""))
+	if len(secret) == 0 {
+		return nil
+	}
+	return &BearerAuthenticator{secret: secret, tenantClaim: utils.EnvOrDefault("TENANT_JWT_CLAIM", "tenant")}
+}
+
+// Authenticate verifica l'access token e restituisce il contesto con l'utente, i suoi scope e l'attore "user:<id>".
+// Un token senza claim sub (es. usato solo per indicare il tenant) non autentica un utente: il contesto resta invariato.
+// Gli errori sono ErrInvalidAccessToken ed ErrNotAccessToken; il tenant del token è confrontato con quello
+// della richiesta da TenantResolver.
+func (a *BearerAuthenticator) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	claims, err := jwt.Parse(token, a.secret)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	subject := claims.String(jwt.ClaimSubject)
+	if subject == "" {
+		return ctx, nil
+	}
+	// I refresh token e i token inviati per email non autenticano le richieste
+	if typ := claims.String(jwt.ClaimType); typ != "" && typ != jwt.TypeAccess {
+		return nil, ErrNotAccessToken
+	}
+	ctx = context.WithValue(ctx, BearerTokenKey, &bearerToken{
+		subject: subject,
+		scopes:  strings.Fields(claims.String(jwt.ClaimScope)),
+		tenant:  strings.ToLower(claims.String(a.tenantClaim)),
+	})
+	return WithActor(ctx, UserActorPrefix+subject), nil
+}
+
+// BearerTokenMiddleware autentica le richieste con un access token emesso da POST /auth/login,
+// letto da "Authorization: Bearer <token>" e firmato con JWT_SECRET. L'utente diventa l'attore "user:<id>",
+// che prevale su X-Actor e sul certificato client, e l'identità del client per il rate limiter;
+// gli scope del claim scope sono verificati da RequireScope.
+// Token non validi, scaduti o di un altro tipo (refresh, email) sono rifiutati con 401, token di un altro tenant con 403 da TenantMiddleware.
+// I token senza claim sub (es. usati solo per indicare il tenant) non autenticano un utente.
+// Senza JWT_SECRET non fa nulla.
+func BearerTokenMiddleware(next http.Handler) http.Handler {
+	authenticator := NewBearerAuthenticatorFromEnv()
+	if authenticator == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := AuthorizationCredentials(r.Header.Get("Authorization"), constants.BEARER_SCHEME)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, err := authenticator.Authenticate(r.Context(), token)
+		switch {
+		case errors.Is(err, ErrInvalidAccessToken):
+			respondUnauthorized(w, "Invalid or expired access token", constants.BEARER_SCHEME)
+			return
+		case errors.Is(err, ErrNotAccessToken):
+			respondUnauthorized(w, "Token is not an access token", constants.BEARER_SCHEME)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetTokenSubject recupera dal contesto l'ID dell'utente autenticato con un access token ("" se la richiesta non ne ha presentato uno)
+func GetTokenSubject(ctx context.Context) string {
+	if token := getBearerToken(ctx); token != nil {
+		return token.subject
+	}
+	return ""
+}
+
+// getBearerToken recupera dal contesto l'access token che ha autenticato la richiesta
+func getBearerToken(ctx context.Context) *bearerToken {
+	token, _ := ctx.Value(BearerTokenKey).(*bearerToken)
+	return token
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"fmt"
+	"myapp/internal/utils"
+	"net/http"
+)
+
+// BodyLimitMiddleware limita il corpo delle richieste a maxBytes byte.
+// Le richieste che dichiarano un Content-Length maggiore sono rifiutate subito con 413; per le altre (es. chunked)
+// la lettura oltre il limite fallisce con *http.MaxBytesError, che gli handler traducono in 413
+// (vedi utils.RespondWithRequestBodyError). maxBytes <= 0 disabilita il limite.
+func BodyLimitMiddleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if maxBytes <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				utils.RespondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytes))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
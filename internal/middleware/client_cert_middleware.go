@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"myapp/internal/apikey"
+	"myapp/internal/tlsconfig"
+	"myapp/internal/utils"
+	"net/http"
+	"strings"
+)
+
+const ClientCertKey = contextKey("clientCert")
+
+// ClientCertActorPrefix distingue nell'audit log gli attori autenticati con un certificato da quelli dichiarati con X-Actor
+const ClientCertActorPrefix = "cert:"
+
+// clientCert è l'identità del certificato client con gli scope assegnati da TLS_CLIENT_SCOPES
+type clientCert struct {
+	identity string
+	scopes   []string
+	mapped   bool // l'identità compare in TLS_CLIENT_SCOPES: il certificato autentica la richiesta
+}
+
+// ErrClientCertIdentity indica un certificato client verificato che non contiene il campo di TLS_CLIENT_IDENTITY
+var ErrClientCertIdentity = errors.New("client certificate has no identity")
+
+// ClientCertAuthenticator ricava l'identità e gli scope di un certificato client verificato con il mutual TLS.
+// È condiviso da ClientCertMiddleware e dagli interceptor gRPC, che ricevono il certificato dal peer della connessione.
+type ClientCertAuthenticator struct {
+	source string
+	scopes map[string][]string
+}
+
+// NewClientCertAuthenticatorFromEnv crea l'autenticatore leggendo TLS_CLIENT_IDENTITY e TLS_CLIENT_SCOPES
+func NewClientCertAuthenticatorFromEnv() *ClientCertAuthenticator {
+	log := utils.WithContext().WithField("function", "NewClientCertAuthenticatorFromEnv")
+
+	source := utils.EnvOrDefault("TLS_CLIENT_IDENTITY", tlsconfig.IdentityCN)
+	if !tlsconfig.ValidIdentity(source) {
+		log.Fatalf("Invalid TLS_CLIENT_IDENTITY %q (cn, dn, email, uri or dns)", source)
+	}
+	certScopes, err := ParseClientCertScopes(utils.EnvOrDefault("TLS_CLIENT_SCOPES", ""))
+	if err != nil {
+		log.Fatalf("Invalid TLS_CLIENT_SCOPES: %v", err)
+	}
+	return &ClientCertAuthenticator{source: source, scopes: certScopes}
+}
+
+// Authenticate aggiunge al contesto l'identità del certificato client della connessione e l'attore "cert:<identità>".
+// state è lo stato della connessione TLS: senza TLS o senza certificato verificato il contesto resta invariato.
+func (c *ClientCertAuthenticator) Authenticate(ctx context.Context, state *tls.ConnectionState) (context.Context, error) {
+	// VerifiedChains è valorizzato solo se il certificato è stato verificato con le CA dei client
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return ctx, nil
+	}
+	identity := tlsconfig.Identity(state.VerifiedChains[0][0], c.source)
+	if identity == "" {
+		return nil, fmt.Errorf("%w: no %s", ErrClientCertIdentity, c.source)
+	}
+	scopes, mapped := c.scopes[identity]
+	ctx = context.WithValue(ctx, ClientCertKey, &clientCert{identity: identity, scopes: scopes, mapped: mapped})
+	return WithActor(ctx, ClientCertActorPrefix+identity), nil
+}
+
+// ClientCertMiddleware usa il certificato client verificato con il mutual TLS come identità della richiesta:
+// il campo indicato da TLS_CLIENT_IDENTITY (cn, dn, email, uri o dns; default cn) diventa l'attore "cert:<identità>",
+// che prevale sull'header X-Actor, ed è disponibile per le autorizzazioni con GetClientCertIdentity.
+// Le identità elencate in TLS_CLIENT_SCOPES autenticano la richiesta con gli scope indicati, verificati da RequireScope
+// come quelli delle API key; gli altri certificati identificano il chiamante ma non concedono scope.
+// Un certificato valido senza quel campo è rifiutato con 403. Senza TLS o senza certificato client non fa nulla.
+func ClientCertMiddleware(next http.Handler) http.Handler {
+	authenticator := NewClientCertAuthenticatorFromEnv()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := authenticator.Authenticate(r.Context(), r.TLS)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusForbidden, "Client certificate has no "+authenticator.source+" identity")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetClientCertIdentity recupera dal contesto l'identità del certificato client ("" se la richiesta non ne ha presentato uno)
+func GetClientCertIdentity(ctx context.Context) string {
+	if cert := getClientCert(ctx); cert != nil {
+		return cert.identity
+	}
+	return ""
+}
+
+// getClientCert recupera dal contesto il certificato client che ha identificato la richiesta
+func getClientCert(ctx context.Context) *clientCert {
+	cert, _ := ctx.Value(ClientCertKey).(*clientCert)
+	return cert
+}
+
+// ParseClientCertScopes legge la tabella TLS_CLIENT_SCOPES: voci "<identità>=<scope> <scope>..." separate da ";",
+// dove l'identità è il campo scelto con TLS_CLIENT_IDENTITY (es. "billing=users:read;ops.example.com=admin:read admin:write").
+// L'identità è separata dagli scope dall'ultimo "=", perché un DN ne contiene.
+func ParseClientCertScopes(value string) (map[string][]string, error) {
+	table := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		separator := strings.LastIndex(entry, "=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("entry %q must have the form <identity>=<scopes>", entry)
+		}
+		identity := strings.TrimSpace(entry[:separator])
+		scopes := strings.Fields(entry[separator+1:])
+		for _, scope := range scopes {
+			if !apikey.ValidScope(scope) {
+				return nil, fmt.Errorf("unknown scope %q for %q", scope, identity)
+			}
+		}
+		table[identity] = scopes
+	}
+	return table, nil
+}
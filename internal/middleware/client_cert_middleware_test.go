@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/apikey"
+	"slices"
+	"testing"
+)
+
+func TestParseClientCertScopes(t *testing.T) {
+	table, err := ParseClientCertScopes("billing=users:read; CN=ops,O=Example Corp=admin:read admin:write ;spiffe://example.org/sync=users:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"billing":                   {apikey.ScopeUsersRead},
+		"CN=ops,O=Example Corp":     {apikey.ScopeAdminRead, apikey.ScopeAdminWrite},
+		"spiffe://example.org/sync": {apikey.ScopeUsersWrite},
+	}
+	for identity, scopes := range want {
+		if !slices.Equal(table[identity], scopes) {
+			t.Errorf("%s: scopes %v, want %v", identity, table[identity], scopes)
+		}
+	}
+	for _, invalid := range []string{"billing", "=users:read", "billing=users:delete"} {
+		if _, err := ParseClientCertScopes(invalid); err == nil {
+			t.Errorf("%q accepted", invalid)
+		}
+	}
+}
+
+func TestCheckScopeWithClientCert(t *testing.T) {
+	mapped := context.WithValue(context.Background(), ClientCertKey, &clientCert{identity: "billing", scopes: []string{apikey.ScopeUsersRead}, mapped: true})
+	if err := CheckScope(mapped, apikey.ScopeUsersRead, true); err != nil {
+		t.Errorf("mapped certificate reading: %v", err)
+	}
+	if err := CheckScope(mapped, apikey.ScopeUsersWrite, true); !errors.Is(err, ErrMissingScope) {
+		t.Errorf("mapped certificate writing: err %v, want ErrMissingScope", err)
+	}
+
+	// Un certificato non elencato identifica il chiamante ma non lo autentica
+	unmapped := context.WithValue(context.Background(), ClientCertKey, &clientCert{identity: "unknown"})
+	if err := CheckScope(unmapped, apikey.ScopeUsersRead, true); !errors.Is(err, ErrAuthenticationRequired) {
+		t.Errorf("unmapped certificate: err %v, want ErrAuthenticationRequired", err)
+	}
+}
@@ -0,0 +1,346 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"myapp/internal/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codifiche di Content-Encoding supportate
+const (
+	encodingZstd    = "zstd"
+	encodingBrotli  = "br"
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// defaultCompressibleTypes sono i formati testuali (o comunque comprimibili) delle risposte del servizio
+const defaultCompressibleTypes = "application/json,application/xml,text/xml,text/csv,application/x-ndjson,application/msgpack,text/plain,text/html,text/css,application/javascript"
+
+// errUnsupportedEncoding indica un Content-Encoding della richiesta senza decoder
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// compressor è l'interfaccia comune agli encoder, riutilizzabili con Reset
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressorPools contiene un pool di encoder per codifica: gli encoder zstd e brotli allocano buffer di diversi MB
+var compressorPools = map[string]*sync.Pool{
+	encodingZstd: {New: func() interface{} {
+		encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return encoder
+	}},
+	encodingBrotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	encodingGzip: {New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}},
+	// In HTTP "deflate" è il formato zlib (RFC 9110), non il flusso deflate senza intestazione
+	encodingDeflate: {New: func() interface{} {
+		return zlib.NewWriter(nil)
+	}},
+}
+
+// compressionConfig è la configurazione della compressione delle risposte
+type compressionConfig struct {
+	encodings []string // codifiche abilitate in ordine di preferenza del server
+	minSize   int
+	types     []string
+}
+
+// CompressionMiddleware comprime le risposte con la codifica preferita dal client nell'header Accept-Encoding
+// (con i parametri q) tra quelle abilitate in COMPRESSION_ENCODINGS (default zstd, br, gzip, deflate, in ordine di preferenza).
+// Sono compresse solo le risposte con un Content-Type in COMPRESSION_CONTENT_TYPES e di almeno COMPRESSION_MIN_SIZE byte
+// (default 1024): le risposte più piccole non ne traggono vantaggio. Le risposte in streaming (Flush prima della soglia)
+// sono compresse se il tipo è ammesso, svuotando l'encoder a ogni Flush; quelle già codificate (es. /metrics) non sono toccate.
+func CompressionMiddleware(next http.Handler) http.Handler {
+	log := utils.WithContext().WithField("function", "CompressionMiddleware")
+
+	config := &compressionConfig{minSize: utils.EnvIntOrDefault("COMPRESSION_MIN_SIZE", 1024)}
+	for _, encoding := range strings.Split(utils.EnvOrDefault("COMPRESSION_ENCODINGS", "zstd,br,gzip,deflate"), ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding == "" {
+			continue
+		}
+		if _, ok := compressorPools[encoding]; !ok {
+			log.Fatalf("Unknown compression encoding %q (zstd, br, gzip or deflate)", encoding)
+		}
+		config.encodings = append(config.encodings, encoding)
+	}
+	for _, contentType := range strings.Split(utils.EnvOrDefault("COMPRESSION_CONTENT_TYPES", defaultCompressibleTypes), ",") {
+		if contentType = strings.ToLower(strings.TrimSpace(contentType)); contentType != "" {
+			config.types = append(config.types, contentType)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// La risposta dipende da Accept-Encoding anche quando non viene compressa
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := config.negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, config: config, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate sceglie la codifica con la qualità più alta nell'header Accept-Encoding, a parità quella preferita dal server;
+// "" se il client non ne accetta nessuna (identity)
+func (c *compressionConfig) negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	qualities := make(map[string]float64)
+	for _, element := range strings.Split(acceptEncoding, ",") {
+		token, params, _ := strings.Cut(element, ";")
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		qualities[strings.ToLower(strings.TrimSpace(token))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range c.encodings {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressible indica se il Content-Type è tra quelli da comprimere (sono ammessi anche prefissi come text/*)
+func (c *compressionConfig) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range c.types {
+		if mediaType == allowed || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter trattiene l'inizio della risposta finché non può decidere se comprimerla:
+// quando il corpo raggiunge la soglia, al primo Flush o alla fine della risposta
+type compressWriter struct {
+	http.ResponseWriter
+	config   *compressionConfig
+	encoding string
+
+	status     int
+	buf        []byte
+	decided    bool
+	compressor compressor
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	// Le risposte informative (es. 103 Early Hints) non hanno corpo
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.config.minSize {
+			return len(b), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush invia al client quanto scritto finora, comprimendo la risposta se il tipo è ammesso (streaming di lunghezza ignota)
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if cw.compressor != nil {
+		if err := cw.compressor.Flush(); err != nil {
+			return
+		}
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap consente a http.ResponseController di raggiungere il writer originale
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide sceglie se comprimere, scrive lo status e il contenuto trattenuto.
+// sizeReached indica che il corpo ha raggiunto la soglia o che la risposta è in streaming.
+func (cw *compressWriter) decide(sizeReached bool) error {
+	cw.decided = true
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := cw.Header()
+	if sizeReached && status != http.StatusNoContent && status != http.StatusNotModified &&
+		header.Get("Content-Encoding") == "" && cw.config.compressible(header.Get("Content-Type")) {
+		cw.compressor = compressorPools[cw.encoding].Get().(compressor)
+		cw.compressor.Reset(cw.ResponseWriter)
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+	}
+	cw.ResponseWriter.WriteHeader(status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close completa la risposta: le risposte rimaste sotto la soglia sono inviate senza compressione
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		// Nessuna scrittura e nessuno status: la risposta resta quella implicita del server
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return
+		}
+		_ = cw.decide(false)
+	}
+	if cw.compressor != nil {
+		if err := cw.compressor.Close(); err != nil {
+			utils.WithContext().Errorf("Error closing %s compressor: %v", cw.encoding, err)
+		}
+		cw.compressor.Reset(nil)
+		compressorPools[cw.encoding].Put(cw.compressor)
+		cw.compressor = nil
+	}
+}
+
+// DecompressionMiddleware decodifica i corpi delle richieste inviati compressi (Content-Encoding gzip, deflate, zstd o br).
+// Il corpo decompresso è limitato a REQUEST_MAX_DECOMPRESSED_SIZE byte (default 32 MiB) contro le zip bomb:
+// oltre il limite la lettura fallisce con *http.MaxBytesError e l'handler risponde 413.
+// Una codifica non supportata restituisce 415, un corpo che non è nel formato dichiarato 400.
+func DecompressionMiddleware(next http.Handler) http.Handler {
+	return DecompressionWithMinLimit(0)(next)
+}
+
+// DecompressionWithMinLimit è DecompressionMiddleware con un limite del corpo decompresso pari al maggiore tra
+// REQUEST_MAX_DECOMPRESSED_SIZE e minSize: una rotta che accetta corpi non compressi di minSize byte (es. l'import)
+// li accetta anche compressi.
+func DecompressionWithMinLimit(minSize int64) func(http.Handler) http.Handler {
+	maxSize := max(int64(utils.EnvIntOrDefault("REQUEST_MAX_DECOMPRESSED_SIZE", 32<<20)), minSize)
+
+	return func(next http.Handler) http.Handler {
+		return decompression(next, maxSize)
+	}
+}
+
+// decompression decodifica il corpo limitandone la dimensione decompressa a maxSize byte
+func decompression(next http.Handler, maxSize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == "identity" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		decoder, err := newDecompressor(encoding, r.Body, maxSize)
+		if err == errUnsupportedEncoding {
+			w.Header().Set("Accept-Encoding", "gzip, deflate, zstd, br")
+			utils.RespondWithError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding "+encoding)
+			return
+		}
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid "+encoding+" request body")
+			return
+		}
+
+		// Il corpo decodificato non ha più la lunghezza e la codifica dichiarate dal client
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		r.Body = http.MaxBytesReader(w, &decompressedBody{Reader: decoder, body: r.Body}, maxSize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newDecompressor restituisce il decoder del corpo; maxSize limita anche la finestra di decodifica di zstd
+func newDecompressor(encoding string, body io.Reader, maxSize int64) (io.Reader, error) {
+	switch encoding {
+	case encodingGzip, "x-gzip":
+		return gzip.NewReader(body)
+	case encodingDeflate:
+		return zlib.NewReader(body)
+	case encodingZstd:
+		decoder, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxSize)))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case encodingBrotli:
+		return brotli.NewReader(body), nil
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
+
+// decompressedBody chiude il decoder (se lo prevede) e il corpo originale
+type decompressedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (d *decompressedBody) Close() error {
+	if closer, ok := d.Reader.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	return d.body.Close()
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipBody(t *testing.T, size int) *bytes.Buffer {
+	t.Helper()
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write([]byte(strings.Repeat("a", size))); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &compressed
+}
+
+// readStatus legge il corpo decompresso: 413 se supera il limite, 200 altrimenti
+var readStatus = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var maxBytesErr *http.MaxBytesError
+	if _, err := io.ReadAll(r.Body); errors.As(err, &maxBytesErr) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}
+})
+
+func TestDecompressionWithMinLimitRaisesTheLimit(t *testing.T) {
+	t.Setenv("REQUEST_MAX_DECOMPRESSED_SIZE", "1024")
+
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+		want    int
+	}{
+		{"default limit", DecompressionMiddleware(readStatus), http.StatusRequestEntityTooLarge},
+		{"import limit", DecompressionWithMinLimit(4096)(readStatus), http.StatusOK},
+		{"lower minimum", DecompressionWithMinLimit(512)(readStatus), http.StatusRequestEntityTooLarge},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/users/import", gzipBody(t, 2048))
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		tc.handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
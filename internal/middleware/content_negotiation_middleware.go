@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"myapp/internal/codec"
+	"myapp/internal/utils"
+	"net/http"
+	"strings"
+)
+
+// ContentNegotiationMiddleware sceglie il formato della risposta dall'header Accept (JSON, XML, MessagePack o CSV,
+// con le preferenze indicate dai parametri q) e lo associa al writer, così utils.RespondWithJSON lo usa in ogni handler.
+// Risponde 406 se nessun formato supportato è accettato e 415 se il corpo della richiesta ha un Content-Type
+// che nessun codec sa decodificare, prima che l'handler esegua l'operazione.
+func ContentNegotiationMiddleware(next http.Handler) http.Handler {
+	supported := "Supported formats: " + strings.Join(codec.MediaTypes(), ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// La risposta dipende da Accept: le cache intermedie devono distinguere le varianti
+		w.Header().Add("Vary", "Accept")
+
+		encoder, err := codec.Negotiate(r.Header.Get("Accept"))
+		if err != nil {
+			utils.RespondWithError(w, http.StatusNotAcceptable, supported)
+			return
+		}
+		w = codec.NewResponseWriter(w, encoder)
+
+		if r.ContentLength != 0 {
+			if _, err := codec.ForContentType(r.Header.Get("Content-Type")); err != nil {
+				utils.RespondWithError(w, http.StatusUnsupportedMediaType, supported)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy è la configurazione CORS letta dall'ambiente
+type corsPolicy struct {
+	origins          []string // origini esatte, "*" o con un sottodominio jolly (https://*.example.com)
+	methods          []string
+	headers          map[string]bool
+	allowedHeaders   string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+// CORSMiddleware gestisce le richieste cross-origin dell'applicazione browser.
+// Le origini ammesse sono in CORS_ALLOWED_ORIGINS (vuoto = CORS disabilitato); metodi e header ammessi in
+// CORS_ALLOWED_METHODS e CORS_ALLOWED_HEADERS, gli header leggibili dal browser in CORS_EXPOSED_HEADERS.
+// Le preflight (OPTIONS con Access-Control-Request-Method) ricevono 204 se origine, metodo e header sono ammessi,
+// altrimenti 403. Va applicato fuori dal router: mux non esegue i middleware registrati con Use per le richieste
+// senza una rotta corrispondente, come le preflight.
+func CORSMiddleware(next http.Handler) http.Handler {
+	log := utils.WithContext().WithField("function", "CORSMiddleware")
+
+	policy := &corsPolicy{
+		origins:          splitList(utils.EnvOrDefault("CORS_ALLOWED_ORIGINS", "")),
+		methods:          splitList(utils.EnvOrDefault("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE")),
+		headers:          make(map[string]bool),
+		exposedHeaders:   utils.EnvOrDefault("CORS_EXPOSED_HEADERS", strings.Join([]string{"X-Correlation-ID", constants.API_VERSION_HEADER, constants.IDEMPOTENCY_REPLAYED_HEADER, "Deprecation", "Sunset", "Link", "Retry-After"}, ",")),
+		allowCredentials: utils.EnvOrDefault("CORS_ALLOW_CREDENTIALS", "false") == "true",
+		maxAge:           strconv.Itoa(int(utils.EnvDurationOrDefault("CORS_MAX_AGE", 10*time.Minute).Seconds())),
+	}
+	if len(policy.origins) == 0 {
+		return next
+	}
+	allowedHeaders := splitList(utils.EnvOrDefault("CORS_ALLOWED_HEADERS", strings.Join([]string{
+		"Accept", "Authorization", "Content-Type", "Content-Encoding", "X-Correlation-ID",
+		constants.IDEMPOTENCY_KEY_HEADER, constants.ACTOR_HEADER, constants.TENANT_HEADER, constants.API_KEY_HEADER,
+	}, ",")))
+	for _, header := range allowedHeaders {
+		policy.headers[strings.ToLower(header)] = true
+	}
+	policy.allowedHeaders = strings.Join(allowedHeaders, ", ")
+	// Con le credenziali il browser rifiuta "*": riflettere qualsiasi origine esporrebbe le sessioni a ogni sito
+	if policy.allowCredentials && policy.allowsAnyOrigin() {
+		log.Fatal("CORS_ALLOW_CREDENTIALS requires explicit CORS_ALLOWED_ORIGINS, not *")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// La risposta dipende dall'origine: le cache intermedie devono distinguerle
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !policy.allowsOrigin(origin) {
+			if preflight {
+				utils.RespondWithError(w, http.StatusForbidden, "CORS origin not allowed")
+				return
+			}
+			// Senza gli header CORS il browser non espone la risposta allo script
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !policy.allowsMethod(r.Header.Get("Access-Control-Request-Method")) || !policy.allowsHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+				utils.RespondWithError(w, http.StatusForbidden, "CORS request not allowed")
+				return
+			}
+			policy.writeOrigin(w, origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", policy.allowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", policy.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		policy.writeOrigin(w, origin)
+		if policy.exposedHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", policy.exposedHeaders)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeOrigin scrive l'origine ammessa: "*" se ammesse tutte senza credenziali, altrimenti quella della richiesta
+func (p *corsPolicy) writeOrigin(w http.ResponseWriter, origin string) {
+	if p.allowsAnyOrigin() && !p.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p *corsPolicy) allowsAnyOrigin() bool {
+	for _, allowed := range p.origins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsOrigin confronta l'origine con quelle ammesse; https://*.example.com ammette i sottodomini ma non example.com
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.origins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(allowed, "://*."); ok {
+			if rest, found := strings.CutPrefix(origin, scheme+"://"); found && strings.HasSuffix(rest, "."+host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) allowsMethod(method string) bool {
+	for _, allowed := range p.methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders verifica gli header elencati in Access-Control-Request-Headers
+func (p *corsPolicy) allowsHeaders(requested string) bool {
+	for _, header := range splitList(requested) {
+		if !p.headers[strings.ToLower(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// splitList divide una lista separata da virgole ignorando spazi ed elementi vuoti
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
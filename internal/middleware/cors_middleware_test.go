@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSAllowsOrigin(t *testing.T) {
+	policy := &corsPolicy{origins: []string{"https://app.example.com", "https://*.example.org", "HTTP://Localhost:3000"}}
+	cases := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://other.example.com", false},
+		{"http://localhost:3000", true},
+		// Il sottodominio jolly ammette i sottodomini a qualsiasi profondità ma non il dominio stesso
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"http://a.example.org", false},
+		{"https://a.example.org.evil.com", false},
+		{"https://evilexample.org", false},
+		{"null", false},
+	}
+	for _, tc := range cases {
+		if got := policy.allowsOrigin(tc.origin); got != tc.want {
+			t.Errorf("allowsOrigin(%q) = %t, want %t", tc.origin, got, tc.want)
+		}
+	}
+
+	wildcard := &corsPolicy{origins: []string{"*"}}
+	if !wildcard.allowsOrigin("https://anything.test") || !wildcard.allowsAnyOrigin() {
+		t.Error("* must allow any origin")
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	t.Setenv("CORS_ALLOWED_METHODS", "GET,POST")
+	t.Setenv("CORS_ALLOWED_HEADERS", "Content-Type,Authorization")
+	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name, method, origin, requestMethod, requestHeaders string
+		status                                              int
+		allowOrigin                                         string
+	}{
+		{"simple request", http.MethodGet, "https://app.example.com", "", "", http.StatusOK, "https://app.example.com"},
+		{"disallowed origin", http.MethodGet, "https://evil.test", "", "", http.StatusOK, ""},
+		{"no origin", http.MethodGet, "", "", "", http.StatusOK, ""},
+		{"preflight", http.MethodOptions, "https://app.example.com", "POST", "content-type, authorization", http.StatusNoContent, "https://app.example.com"},
+		{"preflight disallowed origin", http.MethodOptions, "https://evil.test", "POST", "", http.StatusForbidden, ""},
+		{"preflight disallowed method", http.MethodOptions, "https://app.example.com", "DELETE", "", http.StatusForbidden, ""},
+		{"preflight disallowed header", http.MethodOptions, "https://app.example.com", "POST", "X-Custom", http.StatusForbidden, ""},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(tc.method, "/users", nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if tc.requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", tc.requestMethod)
+		}
+		if tc.requestHeaders != "" {
+			r.Header.Set("Access-Control-Request-Headers", tc.requestHeaders)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: status = %d, want %d", tc.name, w.Code, tc.status)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.allowOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tc.name, got, tc.allowOrigin)
+		}
+		if w.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: Vary = %q, want Origin first", tc.name, w.Header().Get("Vary"))
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyMiddleware rende idempotenti le richieste che specificano l'header Idempotency-Key.
+// La prima richiesta con una chiave viene eseguita e la sua risposta salvata nello store; i retry con lo stesso
+// payload ricevono la risposta originale, mentre il riuso della chiave con un payload diverso restituisce 409.
+// Una richiesta ancora in corso con la stessa chiave restituisce 409 con Retry-After.
+// Le chiavi sono scelte dai client: sono salvate qualificate dall'identità del chiamante (ClientIdentity) e dal tenant,
+// così client diversi che usano la stessa chiave non vedono le risposte gli uni degli altri.
+func IdempotencyMiddleware(store repository.IdempotencyStore) func(http.Handler) http.Handler {
+	ttl := utils.EnvDurationOrDefault("IDEMPOTENCY_TTL", 24*time.Hour)
+	lockTimeout := utils.EnvDurationOrDefault("IDEMPOTENCY_LOCK_TIMEOUT", 30*time.Second)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(constants.IDEMPOTENCY_KEY_HEADER)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			log := utils.WithContext().WithField("idempotencyKey", key)
+
+			if len(key) > maxIdempotencyKeyLength {
+				utils.RespondWithError(w, http.StatusBadRequest, "Idempotency-Key troppo lunga")
+				return
+			}
+
+			// Legge il corpo per calcolarne l'impronta e lo ripristina per l'handler successivo
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				utils.RespondWithRequestBodyError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// owner distingue questa esecuzione da un retry che acquisisce la chiave dopo il lock timeout
+			owner, err := utils.GenerateUUID()
+			if err != nil {
+				utils.RespondWithError(w, http.StatusInternalServerError, "Error checking idempotency key")
+				return
+			}
+			fingerprint := requestFingerprint(r, body)
+			storedKey := ClientIdentity(r.Context()) + " " + key
+
+			now := time.Now()
+			existing, err := store.Acquire(r.Context(), models.IdempotencyRecord{
+				Key:         storedKey,
+				Fingerprint: fingerprint,
+				Owner:       owner,
+				Status:      constants.IDEMPOTENCY_IN_PROGRESS,
+				CreatedAt:   now,
+				LockedUntil: now.Add(lockTimeout),
+				ExpiresAt:   now.Add(ttl),
+			})
+			if err != nil {
+				utils.RespondWithError(w, http.StatusInternalServerError, "Error checking idempotency key")
+				return
+			}
+
+			if existing != nil {
+				switch {
+				case existing.Fingerprint != fingerprint:
+					utils.RespondWithError(w, http.StatusConflict, "Idempotency-Key già utilizzata con una richiesta differente")
+				case existing.Status == constants.IDEMPOTENCY_IN_PROGRESS:
+					w.Header().Set("Retry-After", "1")
+					utils.RespondWithError(w, http.StatusConflict, "Una richiesta con la stessa Idempotency-Key è ancora in corso")
+				default:
+					log.Info("Replaying stored response")
+					replayResponse(w, existing)
+				}
+				return
+			}
+
+			// Se l'handler va in panic la chiave viene rilasciata prima di propagare il panic
+			defer func() {
+				if rec := recover(); rec != nil {
+					_ = store.Release(r.Context(), storedKey, owner)
+					panic(rec)
+				}
+			}()
+
+			recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			// Gli errori del server non vengono memorizzati, così il client può ritentare
+			if recorder.statusCode >= http.StatusInternalServerError {
+				_ = store.Release(r.Context(), storedKey, owner)
+				return
+			}
+			err = store.Complete(r.Context(), storedKey, owner, recorder.statusCode, recorder.storedHeader(), recorder.body.Bytes())
+			switch {
+			case errors.Is(err, repository.ErrIdempotencyLockLost):
+				log.Warn("Idempotency key taken over after the lock timeout: response not stored")
+			case err != nil:
+				log.Errorf("Error storing idempotent response: %v", err)
+			}
+		})
+	}
+}
+
+// requestFingerprint calcola l'hash SHA-256 di metodo, rotta canonica e corpo della richiesta
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + canonicalRoute(r) + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// canonicalRoute restituisce il template della rotta senza il prefisso di versione, seguito dalle variabili del path
+// in ordine: /users e /v1/users sono la stessa rotta, mentre /users/a e /users/b restano distinte
+func canonicalRoute(r *http.Request) string {
+	route := r.URL.Path
+	if template := routeTemplate(r); template != "unknown" {
+		route = template
+	}
+	if rest, ok := strings.CutPrefix(route, constants.API_V1); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+		route = rest
+	}
+	vars := mux.Vars(r)
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		route += " " + name + "=" + vars[name]
+	}
+	return route
+}
+
+// replayResponse riscrive la risposta salvata aggiungendo l'header Idempotent-Replayed
+func replayResponse(w http.ResponseWriter, record *models.IdempotencyRecord) {
+	// Gli header salvati sostituiscono quelli già impostati dai middleware (es. Vary), che altrimenti sarebbero duplicati
+	for name, values := range record.Header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set(constants.IDEMPOTENCY_REPLAYED_HEADER, "true")
+	w.WriteHeader(record.StatusCode)
+	_, _ = w.Write(record.Body)
+}
+
+// responseRecorder scrive la risposta al client memorizzandone una copia
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	if !rr.wroteHeader {
+		rr.wroteHeader = true
+		rr.statusCode = statusCode
+		rr.header = rr.ResponseWriter.Header().Clone()
+	}
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if !rr.wroteHeader {
+		rr.WriteHeader(http.StatusOK)
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// storedHeader restituisce gli header da salvare, escluso il correlation ID che è specifico di ogni richiesta
+func (rr *responseRecorder) storedHeader() http.Header {
+	header := rr.header.Clone()
+	header.Del("X-Correlation-ID")
+	return header
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newIdempotentRouter registra POST /users e POST /v1/users, come il router dell'applicazione,
+// con un handler che risponde 201 e conta le esecuzioni
+func newIdempotentRouter(store repository.IdempotencyStore, calls *int32) http.Handler {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	})
+	idempotency := IdempotencyMiddleware(store)
+	r := mux.NewRouter()
+	r.Handle(constants.API_V1+constants.USERS, idempotency(handler)).Methods(http.MethodPost)
+	r.Handle(constants.USERS, idempotency(handler)).Methods(http.MethodPost)
+	return AuditContextMiddleware(r)
+}
+
+func idempotentRequest(path, key, body string, apiKeyID string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(constants.IDEMPOTENCY_KEY_HEADER, key)
+	if apiKeyID != "" {
+		req = req.WithContext(context.WithValue(req.Context(), APIKeyKey, &models.APIKey{ID: apiKeyID}))
+	}
+	return req
+}
+
+func TestIdempotencyReplaysStoredResponse(t *testing.T) {
+	var calls int32
+	router := newIdempotentRouter(repository.NewMemoryIdempotencyStore(), &calls)
+
+	first := httptest.NewRecorder()
+	router.ServeHTTP(first, idempotentRequest("/users", "key-1", `{"name":"Mario"}`, ""))
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request: status %d, want %d", first.Code, http.StatusCreated)
+	}
+
+	// Lo stesso payload sulla rotta versionata è la stessa richiesta: la risposta è riproposta senza eseguire l'handler
+	replay := httptest.NewRecorder()
+	router.ServeHTTP(replay, idempotentRequest("/v1/users", "key-1", `{"name":"Mario"}`, ""))
+	if replay.Code != http.StatusCreated {
+		t.Fatalf("replay: status %d, want %d", replay.Code, http.StatusCreated)
+	}
+	if replay.Body.String() != first.Body.String() {
+		t.Errorf("replay body %q, want %q", replay.Body.String(), first.Body.String())
+	}
+	if replay.Header().Get(constants.IDEMPOTENCY_REPLAYED_HEADER) != "true" {
+		t.Errorf("replay without %s header", constants.IDEMPOTENCY_REPLAYED_HEADER)
+	}
+	if calls != 1 {
+		t.Errorf("handler executed %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyConflictOnDifferentPayload(t *testing.T) {
+	var calls int32
+	router := newIdempotentRouter(repository.NewMemoryIdempotencyStore(), &calls)
+
+	router.ServeHTTP(httptest.NewRecorder(), idempotentRequest("/users", "key-1", `{"name":"Mario"}`, ""))
+
+	conflict := httptest.NewRecorder()
+	router.ServeHTTP(conflict, idempotentRequest("/users", "key-1", `{"name":"Luigi"}`, ""))
+	if conflict.Code != http.StatusConflict {
+		t.Fatalf("status %d, want %d", conflict.Code, http.StatusConflict)
+	}
+	if calls != 1 {
+		t.Errorf("handler executed %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyKeysAreScopedByCaller(t *testing.T) {
+	var calls int32
+	router := newIdempotentRouter(repository.NewMemoryIdempotencyStore(), &calls)
+
+	alice := httptest.NewRecorder()
+	router.ServeHTTP(alice, idempotentRequest("/users", "shared", `{"name":"Mario"}`, "alice"))
+	bob := httptest.NewRecorder()
+	router.ServeHTTP(bob, idempotentRequest("/users", "shared", `{"name":"Luigi"}`, "bob"))
+
+	if bob.Code != http.StatusCreated || bob.Header().Get(constants.IDEMPOTENCY_REPLAYED_HEADER) != "" {
+		t.Fatalf("second caller got status %d replayed=%q, want a fresh 201", bob.Code, bob.Header().Get(constants.IDEMPOTENCY_REPLAYED_HEADER))
+	}
+	if calls != 2 {
+		t.Errorf("handler executed %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyInProgressReturnsRetryAfter(t *testing.T) {
+	store := repository.NewMemoryIdempotencyStore()
+	now := time.Now()
+	_, err := store.Acquire(context.Background(), models.IdempotencyRecord{
+		Key:         "ip:192.0.2.1 key-1",
+		Fingerprint: "other",
+		Owner:       "first",
+		Status:      constants.IDEMPOTENCY_IN_PROGRESS,
+		LockedUntil: now.Add(time.Minute),
+		ExpiresAt:   now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	router := newIdempotentRouter(store, &calls)
+
+	// httptest.NewRequest usa 192.0.2.1 come indirizzo del client
+	req := idempotentRequest("/users", "key-1", `{}`, "")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if calls != 0 {
+		t.Errorf("handler executed %d times, want 0", calls)
+	}
+}
+
+func TestIdempotencyCompleteRequiresOwner(t *testing.T) {
+	store := repository.NewMemoryIdempotencyStore()
+	ctx := context.Background()
+	now := time.Now()
+	record := models.IdempotencyRecord{
+		Key:         "key-1",
+		Owner:       "first",
+		Status:      constants.IDEMPOTENCY_IN_PROGRESS,
+		LockedUntil: now.Add(-time.Second), // lock scaduto: un retry può acquisire la chiave
+		ExpiresAt:   now.Add(time.Hour),
+	}
+	if _, err := store.Acquire(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	record.Owner = "second"
+	record.LockedUntil = now.Add(time.Minute)
+	if existing, err := store.Acquire(ctx, record); err != nil || existing != nil {
+		t.Fatalf("takeover after lock timeout: existing=%v err=%v", existing, err)
+	}
+
+	// La prima esecuzione termina in ritardo: non deve sovrascrivere né rilasciare la chiave del retry
+	if err := store.Complete(ctx, "key-1", "first", http.StatusCreated, nil, []byte("stale")); !errors.Is(err, repository.ErrIdempotencyLockLost) {
+		t.Fatalf("Complete by previous owner: err=%v, want ErrIdempotencyLockLost", err)
+	}
+	if err := store.Release(ctx, "key-1", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Complete(ctx, "key-1", "second", http.StatusCreated, nil, []byte("fresh")); err != nil {
+		t.Fatalf("Complete by current owner: %v", err)
+	}
+	existing, err := store.Acquire(ctx, record)
+	if err != nil || existing == nil {
+		t.Fatalf("Acquire after completion: existing=%v err=%v", existing, err)
+	}
+	if string(existing.Body) != "fresh" {
+		t.Errorf("stored body %q, want %q", existing.Body, "fresh")
+	}
+}
@@ -1,24 +1,119 @@
 package middleware
 
 import (
+	"context"
 	"myapp/internal/utils"
 	"net/http"
+	"sync"
+	"time"
 
 	"golang.org/x/time/rate"
 )
 
+// Durata dopo cui il limiter di un client inattivo viene eliminato, e intervallo tra due pulizie
+const (
+	clientLimiterIdleTTL = 10 * time.Minute
+	clientLimiterSweep   = time.Minute
+)
+
+// clientLimiter è il limiter di un client con l'ultimo utilizzo
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ClientLimiters mantiene un limiter per ogni identità di client (vedi ClientIdentity)
+type ClientLimiters struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+// NewClientLimiters crea i limiter per client con il limite (richieste al secondo) e il burst indicati
+func NewClientLimiters(limit rate.Limit, burst int) *ClientLimiters {
+	return &ClientLimiters{limit: limit, burst: burst, clients: make(map[string]*clientLimiter)}
+}
+
+// Allow consuma una richiesta dal limiter del client, creandolo al primo utilizzo
+func (l *ClientLimiters) Allow(identity string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// I limiter dei client inattivi sono eliminati periodicamente, così la mappa non cresce con ogni IP visto
+	if now.Sub(l.lastSweep) >= clientLimiterSweep {
+		for id, client := range l.clients {
+			if now.Sub(client.lastSeen) >= clientLimiterIdleTTL {
+				delete(l.clients, id)
+			}
+		}
+		l.lastSweep = now
+	}
+	client, ok := l.clients[identity]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[identity] = client
+	}
+	client.lastSeen = now
+	return client.limiter.AllowN(now, 1)
+}
+
 // RateLimiterMiddleware limita il numero di richieste che un client può fare in un determinato periodo di tempo
 // Burst di 3: Significa che, oltre alle 5 richieste per secondo, il client può fare fino a 3 richieste in più in un colpo solo. Questo buffer di 3 richieste viene utilizzato per gestire picchi momentanei di traffico.
 // Quindi, in pratica, un client può fare 8 richieste in un secondo (5 + 3 burst),
 // ma se tutte e 8 le richieste vengono fatte immediatamente, il client dovrà attendere per fare ulteriori richieste fino al secondo successivo.
-// .
+// Ogni client ha il proprio limite, individuato da ClientIdentity: va quindi registrato dopo i middleware di autenticazione.
 func RateLimiterMiddleware(next http.Handler) http.Handler {
-	limiter := rate.NewLimiter(5, 3) // 5 richiesta al secondo con un burst di 3
+	limiters := NewClientLimiters(5, 3) // 5 richiesta al secondo con un burst di 3
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiters.Allow(ClientIdentity(r.Context())) {
+			utils.RespondWithError(w, http.StatusTooManyRequests, "Too Many Requests - slow down my friend")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewIPLimitersFromEnv crea i limiter per indirizzo IP dei tentativi di autenticazione: IP_RATE_LIMIT richieste
+// al secondo (default 20) con un burst di IP_RATE_LIMIT_BURST (default 40). Sono più larghi dei limiti per client
+// perché più client autenticati possono condividere un IP (es. dietro un NAT).
+func NewIPLimitersFromEnv() *ClientLimiters {
+	return NewClientLimiters(rate.Limit(utils.EnvIntOrDefault("IP_RATE_LIMIT", 20)), utils.EnvIntOrDefault("IP_RATE_LIMIT_BURST", 40))
+}
+
+// IPRateLimiterMiddleware limita le richieste per indirizzo IP del client (vedi AuditContextMiddleware) prima
+// dell'autenticazione: senza questo limite i tentativi con credenziali non valide (API key, token, login)
+// sarebbero rifiutati dai middleware di autenticazione prima di arrivare a RateLimiterMiddleware, e quindi illimitati.
+func IPRateLimiterMiddleware(next http.Handler) http.Handler {
+	limiters := NewIPLimitersFromEnv()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
+		if !limiters.Allow(IPIdentity(r.Context())) {
 			utils.RespondWithError(w, http.StatusTooManyRequests, "Too Many Requests - slow down my friend")
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// IPIdentity restituisce l'identità "ip:<indirizzo>" del client, usata quando non è autenticato
+func IPIdentity(ctx context.Context) string {
+	return "ip:" + GetClientIP(ctx)
+}
+
+// ClientIdentity restituisce l'identità del client usata dal rate limiter: la API key ("apikey:<id>"),
+// altrimenti l'utente dell'access token ("user:<id>"), altrimenti il certificato client ("cert:<identità>"), altrimenti l'IP ("ip:<indirizzo>").
+// X-Actor non è usato perché dichiarato liberamente dal client.
+func ClientIdentity(ctx context.Context) string {
+	if key := GetAPIKey(ctx); key != nil {
+		return APIKeyActorPrefix + key.ID
+	}
+	if subject := GetTokenSubject(ctx); subject != "" {
+		return UserActorPrefix + subject
+	}
+	if identity := GetClientCertIdentity(ctx); identity != "" {
+		return ClientCertActorPrefix + identity
+	}
+	return IPIdentity(ctx)
+}
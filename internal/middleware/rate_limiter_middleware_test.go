@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPRateLimiterLimitsFailedAuthentication(t *testing.T) {
+	t.Setenv("IP_RATE_LIMIT", "1")
+	t.Setenv("IP_RATE_LIMIT_BURST", "2")
+	handler := AuditContextMiddleware(IPRateLimiterMiddleware(APIKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+
+	codes := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("X-API-Key", "myapp_guessed")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	// Le chiavi non valide sono rifiutate con 401 finché il limite dell'IP non è esaurito
+	if codes[0] != http.StatusUnauthorized || codes[1] != http.StatusUnauthorized || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("status codes %v, want [401 401 429]", codes)
+	}
+
+	// Un altro IP ha il proprio limite
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("other IP: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/apikey"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// RequireScope verifica che le credenziali della richiesta (API key, access token o certificato client in TLS_CLIENT_SCOPES) abbiano lo scope readScope per GET e HEAD,
+// writeScope per gli altri metodi. Le richieste non autenticate sono rifiutate con 401 se authRequired, altrimenti proseguono
+// (rotte aperte finché AUTH_REQUIRED non è abilitato); credenziali senza lo scope sono rifiutate con 403.
+func RequireScope(readScope, writeScope string, authRequired bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope := writeScope
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = readScope
+			}
+			switch err := CheckScope(r.Context(), scope, authRequired); {
+			case errors.Is(err, ErrAuthenticationRequired):
+				respondUnauthorized(w, "Authentication required", constants.API_KEY_SCHEME, constants.BEARER_SCHEME)
+			case errors.Is(err, ErrMissingScope):
+				utils.RespondWithError(w, http.StatusForbidden, "Credentials lack scope "+scope)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+var (
+	// ErrAuthenticationRequired indica una richiesta senza credenziali quando l'autenticazione è obbligatoria
+	ErrAuthenticationRequired = errors.New("authentication required")
+	// ErrMissingScope indica credenziali che non hanno lo scope richiesto
+	ErrMissingScope = errors.New("credentials lack the required scope")
+)
+
+// CheckScope applica le regole di RequireScope a un'operazione che richiede lo scope indicato:
+// ErrAuthenticationRequired senza credenziali se authRequired, ErrMissingScope se le credenziali non hanno lo scope.
+// È usata anche dagli interceptor del server gRPC.
+func CheckScope(ctx context.Context, scope string, authRequired bool) error {
+	if _, authenticated := grantedScopes(ctx); !authenticated {
+		if authRequired {
+			return ErrAuthenticationRequired
+		}
+		return nil
+	}
+	if !HasScope(ctx, scope) {
+		return ErrMissingScope
+	}
+	return nil
+}
+
+// HasScope indica se la richiesta può eseguire un'operazione che richiede lo scope indicato:
+// sempre vero senza credenziali (l'accesso è già stato deciso da RequireScope), altrimenti secondo gli scope
+// delle credenziali (vedi grantedScopes). Serve agli handler che decidono lo scope dal contenuto della richiesta (es. mutation GraphQL).
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, authenticated := grantedScopes(ctx)
+	return !authenticated || apikey.Allows(scopes, scope)
+}
+
+// grantedScopes restituisce gli scope concessi alla richiesta: quelli della API key, che prevale, dell'access token
+// o del certificato client se la sua identità compare in TLS_CLIENT_SCOPES
+func grantedScopes(ctx context.Context) ([]string, bool) {
+	if key := GetAPIKey(ctx); key != nil {
+		return key.Scopes, true
+	}
+	if token := getBearerToken(ctx); token != nil {
+		return token.scopes, true
+	}
+	if cert := getClientCert(ctx); cert != nil && cert.mapped {
+		return cert.scopes, true
+	}
+	return nil, false
+}
+
+// ErrNotOwner indica un utente autenticato con un access token che accede a un altro utente senza lo scope di amministrazione
+var ErrNotOwner = errors.New("users can only access their own account")
+
+// CheckUserOwnership verifica che la richiesta possa accedere all'utente userID ("" per le operazioni che non riguardano
+// un singolo utente, come elenchi, ricerche, export, stream, creazioni, operazioni bulk e gruppi). Un utente autenticato
+// con un access token (senza API key, che prevale) accede solo a sé stesso, salvo che il token abbia lo scope adminScope
+// (admin:read per le letture, admin:write per le modifiche): un account non deve poter leggere gli altri né cambiarne
+// l'email e prenderne l'account con il reset della password.
+// Le API key e i certificati client sono credenziali di servizio e restano governati solo dagli scope.
+func CheckUserOwnership(ctx context.Context, userID, adminScope string) error {
+	if GetAPIKey(ctx) != nil {
+		return nil
+	}
+	token := getBearerToken(ctx)
+	if token == nil || (userID != "" && token.subject == userID) || apikey.Allows(token.scopes, adminScope) {
+		return nil
+	}
+	return ErrNotOwner
+}
+
+// OwnershipScope restituisce lo scope di amministrazione che CheckUserOwnership richiede per il metodo HTTP:
+// admin:read per GET e HEAD, admin:write per gli altri
+func OwnershipScope(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return apikey.ScopeAdminRead
+	}
+	return apikey.ScopeAdminWrite
+}
+
+// RequireUserOwnership applica CheckUserOwnership con l'utente indicato dalla variabile di rotta idVar
+// ("" se la rotta non riguarda un singolo utente) e lo scope di OwnershipScope. Va registrato dopo RequireScope.
+func RequireUserOwnership(idVar string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := ""
+			if idVar != "" {
+				userID = mux.Vars(r)[idVar]
+			}
+			scope := OwnershipScope(r.Method)
+			if err := CheckUserOwnership(r.Context(), userID, scope); err != nil {
+				utils.RespondWithError(w, http.StatusForbidden, "Users can only access their own account without scope "+scope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
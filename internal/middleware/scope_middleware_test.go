@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"myapp/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequireUserOwnership(t *testing.T) {
+	router := mux.NewRouter()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Handle("/users", RequireUserOwnership("")(ok))
+	router.Handle("/users/{id}", RequireUserOwnership("id")(ok))
+
+	user := func(scopes ...string) context.Context {
+		return context.WithValue(context.Background(), BearerTokenKey, &bearerToken{subject: "user-1", scopes: scopes})
+	}
+	cases := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		path   string
+		want   int
+	}{
+		{"user updates self", user("users:write"), http.MethodPut, "/users/user-1", http.StatusOK},
+		{"user updates another user", user("users:write"), http.MethodPut, "/users/user-2", http.StatusForbidden},
+		{"user deletes another user", user("users:write"), http.MethodDelete, "/users/user-2", http.StatusForbidden},
+		{"user creates a user", user("users:write"), http.MethodPost, "/users", http.StatusForbidden},
+		{"user reads self", user("users:read"), http.MethodGet, "/users/user-1", http.StatusOK},
+		{"user reads another user", user("users:read"), http.MethodGet, "/users/user-2", http.StatusForbidden},
+		{"user lists users", user("users:read"), http.MethodGet, "/users", http.StatusForbidden},
+		{"admin reader lists users", user("users:read", "admin:read"), http.MethodGet, "/users", http.StatusOK},
+		{"admin reader cannot create", user("users:write", "admin:read"), http.MethodPost, "/users", http.StatusForbidden},
+		{"admin writer reads another user", user("users:read", "admin:write"), http.MethodGet, "/users/user-2", http.StatusOK},
+		{"admin updates another user", user("users:write", "admin:write"), http.MethodPut, "/users/user-2", http.StatusOK},
+		{"admin creates a user", user("users:write", "admin:write"), http.MethodPost, "/users", http.StatusOK},
+		{"API key updates any user", context.WithValue(context.Background(), APIKeyKey, &models.APIKey{ID: "key-1", Scopes: []string{"users:write"}}), http.MethodPut, "/users/user-2", http.StatusOK},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil).WithContext(tc.ctx))
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
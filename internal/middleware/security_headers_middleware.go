@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"fmt"
+	"myapp/internal/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Content-Security-Policy di default: le risposte dell'API non caricano risorse, la Swagger UI usa i propri
+// script e fogli di stile (anche inline) serviti dallo stesso host
+const (
+	defaultAPIContentSecurityPolicy     = "default-src 'none'; frame-ancestors 'none'"
+	defaultSwaggerContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'"
+)
+
+// SecurityHeadersMiddleware aggiunge a tutte le risposte gli header di sicurezza standard:
+//   - X-Content-Type-Options: nosniff e X-Frame-Options: DENY;
+//   - Referrer-Policy (SECURITY_REFERRER_POLICY, default no-referrer);
+//   - Content-Security-Policy (SECURITY_CSP per l'API, SECURITY_SWAGGER_CSP per la Swagger UI);
+//   - Strict-Transport-Security sulle richieste HTTPS, anche terminate da un proxy (X-Forwarded-Proto),
+//     con durata HSTS_MAX_AGE (default 1 anno, 0 per disabilitarlo) e HSTS_INCLUDE_SUBDOMAINS.
+//
+// Va applicato fuori dal router, così copre anche le risposte 404 e 405.
+func SecurityHeadersMiddleware(next http.Handler) http.Handler {
+	referrerPolicy := utils.EnvOrDefault("SECURITY_REFERRER_POLICY", "no-referrer")
+	apiCSP := utils.EnvOrDefault("SECURITY_CSP", defaultAPIContentSecurityPolicy)
+	swaggerCSP := utils.EnvOrDefault("SECURITY_SWAGGER_CSP", defaultSwaggerContentSecurityPolicy)
+
+	hsts := ""
+	if maxAge := utils.EnvDurationOrDefault("HSTS_MAX_AGE", 365*24*time.Hour); maxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+		if utils.EnvOrDefault("HSTS_INCLUDE_SUBDOMAINS", "false") == "true" {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		if referrerPolicy != "" {
+			header.Set("Referrer-Policy", referrerPolicy)
+		}
+		csp := apiCSP
+		if r.URL.Path == "/swagger" || strings.HasPrefix(r.URL.Path, "/swagger/") {
+			csp = swaggerCSP
+		}
+		if csp != "" {
+			header.Set("Content-Security-Policy", csp)
+		}
+		// I browser ignorano HSTS ricevuto in HTTP: lo inviamo solo se la richiesta è arrivata in HTTPS
+		if hsts != "" && (r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")) {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/jwt"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrTenantMismatch indica sorgenti che indicano tenant diversi (es. header diverso dal tenant della API key)
+	ErrTenantMismatch = errors.New("tenant mismatch")
+	// ErrCredentialsTenantMismatch indica credenziali autenticate che non appartengono a nessun tenant
+	ErrCredentialsTenantMismatch = errors.New("credentials not valid for this tenant")
+)
+
+// tenantExemptPaths sono le rotte di infrastruttura che non appartengono a un tenant
+var tenantExemptPaths = []string{constants.HEALTH, "/metrics", "/swagger"}
+
+// TenantResolver ricava il tenant di una richiesta dalle sorgenti configurate con TENANT_SOURCES,
+// in qualsiasi combinazione di header (TENANT_HEADER), claim di un JWT HS256 firmato con JWT_SECRET
+// (TENANT_JWT_CLAIM) e sottodominio di TENANT_BASE_DOMAIN. Accetta solo i tenant registrati in TENANT_ALLOWLIST.
+type TenantResolver struct {
+	sources    map[string]bool
+	tenants    map[string]bool
+	header     string
+	claim      string
+	secret     []byte
+	baseDomain string
+}
+
+// NewTenantResolverFromEnv crea il resolver dalle variabili d'ambiente
+func NewTenantResolverFromEnv() *TenantResolver {
+	log := utils.WithContext().WithField("function", "NewTenantResolverFromEnv")
+
+	resolver := &TenantResolver{
+		sources:    make(map[string]bool),
+		tenants:    make(map[string]bool),
+		header:     utils.EnvOrDefault("TENANT_HEADER", constants.TENANT_HEADER),
+		claim:      utils.EnvOrDefault("TENANT_JWT_CLAIM", "tenant"),
+		secret:     []byte(utils.EnvOrDefault("JWT_SECRET", "")),
+		baseDomain: strings.ToLower(strings.Trim(utils.EnvOrDefault("TENANT_BASE_DOMAIN", ""), ".")),
+	}
+	for _, source := range strings.Split(utils.EnvOrDefault("TENANT_SOURCES", constants.TENANT_SOURCE_HEADER), ",") {
+		switch source = strings.TrimSpace(source); source {
+		case constants.TENANT_SOURCE_HEADER, constants.TENANT_SOURCE_JWT, constants.TENANT_SOURCE_SUBDOMAIN:
+			resolver.sources[source] = true
+		case "":
+		default:
+			log.Fatalf("Unknown tenant source %q (header, jwt or subdomain)", source)
+		}
+	}
+	if resolver.sources[constants.TENANT_SOURCE_JWT] && len(resolver.secret) == 0 {
+		log.Fatal("JWT_SECRET is required to read the tenant from a JWT claim")
+	}
+	if resolver.sources[constants.TENANT_SOURCE_SUBDOMAIN] && resolver.baseDomain == "" {
+		log.Fatal("TENANT_BASE_DOMAIN is required to read the tenant from the subdomain")
+	}
+	for _, tenant := range tenancy.Allowlist() {
+		resolver.tenants[tenant] = true
+	}
+	log.Infof("Tenant registrati: %s", strings.Join(tenancy.Allowlist(), ", "))
+	return resolver
+}
+
+// HeaderName restituisce il nome dell'header con il tenant
+func (t *TenantResolver) HeaderName() string {
+	return t.header
+}
+
+// Resolve restituisce il tenant della richiesta. Se il contesto contiene credenziali autenticate (API key o access token)
+// il tenant è quello delle credenziali, qualunque siano le sorgenti configurate: un header non basta a scegliere il tenant
+// di una richiesta autenticata. Le sorgenti (valore dell'header, header Authorization e host) devono essere concordi
+// tra loro e con le credenziali; un token presente ma non valido è un errore anche se le altre sorgenti indicano il tenant.
+func (t *TenantResolver) Resolve(ctx context.Context, header, authorization, host string) (string, error) {
+	candidates, err := credentialsTenants(ctx)
+	if err != nil {
+		return "", err
+	}
+	if t.sources[constants.TENANT_SOURCE_HEADER] {
+		if tenant := strings.TrimSpace(header); tenant != "" {
+			candidates = append(candidates, tenant)
+		}
+	}
+	if t.sources[constants.TENANT_SOURCE_JWT] {
+		if token, ok := AuthorizationCredentials(authorization, constants.BEARER_SCHEME); ok {
+			claims, err := jwt.Parse(token, t.secret)
+			if err != nil {
+				return "", err
+			}
+			if tenant := claims.String(t.claim); tenant != "" {
+				candidates = append(candidates, tenant)
+			}
+		}
+	}
+	if t.sources[constants.TENANT_SOURCE_SUBDOMAIN] {
+		if tenant := t.subdomain(host); tenant != "" {
+			candidates = append(candidates, tenant)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", tenancy.ErrTenantRequired
+	}
+	tenant := strings.ToLower(candidates[0])
+	for _, candidate := range candidates[1:] {
+		if strings.ToLower(candidate) != tenant {
+			return "", ErrTenantMismatch
+		}
+	}
+	if err := tenancy.Validate(tenant); err != nil {
+		return "", err
+	}
+	// Il tenant deve essere registrato prima di qualsiasi accesso ai suoi dati
+	if !t.tenants[tenant] {
+		return "", tenancy.ErrUnknownTenant
+	}
+	return tenant, nil
+}
+
+// credentialsTenants restituisce i tenant delle credenziali che hanno autenticato la richiesta: quello della API key
+// e il claim TENANT_JWT_CLAIM dell'access token. La chiave di bootstrap ADMIN_API_KEY non appartiene a un tenant
+// e può operare su quello indicato dalle sorgenti; credenziali di un utente o di una chiave senza tenant
+// non sono valide in un servizio multi-tenant (ErrCredentialsTenantMismatch).
+func credentialsTenants(ctx context.Context) ([]string, error) {
+	var tenants []string
+	if key := GetAPIKey(ctx); key != nil && key.ID != AdminAPIKeyID {
+		if key.TenantID == "" {
+			return nil, ErrCredentialsTenantMismatch
+		}
+		tenants = append(tenants, key.TenantID)
+	}
+	if token := getBearerToken(ctx); token != nil {
+		if token.tenant == "" {
+			return nil, ErrCredentialsTenantMismatch
+		}
+		tenants = append(tenants, token.tenant)
+	}
+	return tenants, nil
+}
+
+// subdomain restituisce l'etichetta che precede TENANT_BASE_DOMAIN nell'host (acme.api.example.com -> acme)
+func (t *TenantResolver) subdomain(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	label, ok := strings.CutSuffix(strings.ToLower(host), "."+t.baseDomain)
+	if !ok || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// TenantMiddleware aggiunge al contesto il tenant della richiesta (vedi TenantResolver).
+// Va registrato dopo i middleware di autenticazione: il tenant di una richiesta autenticata è quello delle sue credenziali.
+// Con TENANCY_MODE=off non fa nulla; altrimenti le richieste senza un tenant valido vengono rifiutate
+// prima di raggiungere gli handler, salvo le rotte di infrastruttura (health, metriche, Swagger).
+func TenantMiddleware(next http.Handler) http.Handler {
+	if !tenancy.Enabled() {
+		return next
+	}
+	resolver := NewTenantResolverFromEnv()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isTenantExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenant, err := resolver.Resolve(r.Context(), r.Header.Get(resolver.HeaderName()), r.Header.Get("Authorization"), r.Host)
+		if err != nil {
+			utils.RespondWithError(w, tenantErrorStatus(err), err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(tenancy.WithTenant(r.Context(), tenant)))
+	})
+}
+
+// tenantErrorStatus restituisce lo status HTTP per un errore di risoluzione del tenant
+func tenantErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, jwt.ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrTenantMismatch), errors.Is(err, ErrCredentialsTenantMismatch), errors.Is(err, tenancy.ErrUnknownTenant):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// isTenantExempt indica le rotte di infrastruttura che non richiedono un tenant
+func isTenantExempt(path string) bool {
+	for _, exempt := range tenantExemptPaths {
+		if path == exempt || strings.HasPrefix(path, exempt+"/") {
+			return true
+		}
+	}
+	return false
+}
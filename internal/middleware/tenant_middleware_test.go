@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/jwt"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils/constants"
+	"testing"
+)
+
+func newTestTenantResolver(sources ...string) *TenantResolver {
+	resolver := &TenantResolver{
+		sources: make(map[string]bool),
+		tenants: map[string]bool{"acme": true, "globex": true},
+		header:  constants.TENANT_HEADER,
+		claim:   "tenant",
+		secret:  []byte("tenant-test-secret"),
+	}
+	for _, source := range sources {
+		resolver.sources[source] = true
+	}
+	return resolver
+}
+
+func withTestAPIKey(id, tenant string) context.Context {
+	return context.WithValue(context.Background(), APIKeyKey, &models.APIKey{ID: id, TenantID: tenant})
+}
+
+func TestResolveUsesCredentialsTenant(t *testing.T) {
+	resolver := newTestTenantResolver(constants.TENANT_SOURCE_HEADER)
+
+	// Senza header il tenant è quello della API key
+	tenant, err := resolver.Resolve(withTestAPIKey("key-1", "acme"), "", "", "")
+	if err != nil || tenant != "acme" {
+		t.Fatalf("tenant %q err %v, want acme", tenant, err)
+	}
+	// L'header non può scegliere un tenant diverso da quello delle credenziali
+	if _, err := resolver.Resolve(withTestAPIKey("key-1", "acme"), "globex", "", ""); !errors.Is(err, ErrTenantMismatch) {
+		t.Errorf("header of another tenant: err %v, want ErrTenantMismatch", err)
+	}
+	// Un access token senza tenant non è valido in un servizio multi-tenant
+	ctx := context.WithValue(context.Background(), BearerTokenKey, &bearerToken{subject: "user-1"})
+	if _, err := resolver.Resolve(ctx, "acme", "", ""); !errors.Is(err, ErrCredentialsTenantMismatch) {
+		t.Errorf("token without tenant: err %v, want ErrCredentialsTenantMismatch", err)
+	}
+	// La chiave di bootstrap non appartiene a un tenant e opera su quello indicato
+	tenant, err = resolver.Resolve(withTestAPIKey(AdminAPIKeyID, ""), "globex", "", "")
+	if err != nil || tenant != "globex" {
+		t.Errorf("bootstrap key: tenant %q err %v, want globex", tenant, err)
+	}
+	// Un tenant non registrato è rifiutato anche con la chiave di bootstrap
+	if _, err := resolver.Resolve(withTestAPIKey(AdminAPIKeyID, ""), "initech", "", ""); !errors.Is(err, tenancy.ErrUnknownTenant) {
+		t.Errorf("unknown tenant: err %v, want ErrUnknownTenant", err)
+	}
+}
+
+func TestResolveBearerSchemeIsCaseInsensitive(t *testing.T) {
+	resolver := newTestTenantResolver(constants.TENANT_SOURCE_JWT)
+	token, err := jwt.Sign(jwt.Claims{"tenant": "acme"}, resolver.secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, authorization := range []string{"Bearer " + token, "bearer " + token, "BEARER  " + token} {
+		tenant, err := resolver.Resolve(context.Background(), "", authorization, "")
+		if err != nil || tenant != "acme" {
+			t.Errorf("%q: tenant %q err %v, want acme", authorization[:8], tenant, err)
+		}
+	}
+	if _, err := resolver.Resolve(context.Background(), "", "bearer not-a-token", ""); !errors.Is(err, jwt.ErrInvalidToken) {
+		t.Errorf("invalid token: err %v, want jwt.ErrInvalidToken", err)
+	}
+}
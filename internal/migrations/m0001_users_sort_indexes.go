@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"context"
+	"myapp/internal/utils/constants"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// usersSortIndexes crea gli indici usati dalla paginazione per chiave (valore ordinato, _id) di QueryUsers
+var usersSortIndexes = Migration{
+	Version:     1,
+	Description: "users sort indexes on name and email",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		// CreateMany non fallisce se gli indici esistono già con la stessa definizione
+		_, err := db.Collection(constants.USERSCOLLECTION).Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "name", Value: 1}, {Key: constants.DOCUMENT_ID, Value: 1}}, Options: options.Index().SetName("users_name_id")},
+			{Keys: bson.D{{Key: "email", Value: 1}, {Key: constants.DOCUMENT_ID, Value: 1}}, Options: options.Index().SetName("users_email_id")},
+		})
+		return err
+	},
+	Down: func(ctx context.Context, db *mongo.Database) error {
+		for _, name := range []string{"users_name_id", "users_email_id"} {
+			if err := dropIndex(ctx, db.Collection(constants.USERSCOLLECTION), name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"context"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils/constants"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// usersStatusBackfill imposta lo stato active sugli utenti creati prima dell'introduzione del ciclo di vita,
+// nella collezione principale e in quelle dei tenant (TENANCY_MODE collection o database).
+// Non è reversibile: dopo la migrazione non si distinguono gli utenti attivati da quelli già esistenti.
+var usersStatusBackfill = Migration{
+	Version:     2,
+	Description: "users status backfill",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		collections, err := userCollections(ctx, db)
+		if err != nil {
+			return err
+		}
+		// Il filtro sugli utenti senza stato rende il passo idempotente
+		filter := bson.M{"status": bson.M{"$exists": false}}
+		update := bson.M{constants.SET: bson.M{"status": constants.USER_STATUS_ACTIVE, "statusChangedAt": time.Now().UTC()}}
+		for _, collection := range collections {
+			if _, err := collection.UpdateMany(ctx, filter, update); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// userCollections restituisce la collezione degli utenti del database principale e quelle dei tenant
+func userCollections(ctx context.Context, db *mongo.Database) ([]*mongo.Collection, error) {
+	collections := []*mongo.Collection{db.Collection(constants.USERSCOLLECTION)}
+	switch tenancy.Mode() {
+	case tenancy.ModeCollection:
+		names, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": "^" + constants.USERSCOLLECTION + "_"}})
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if tenancy.Validate(strings.TrimPrefix(name, constants.USERSCOLLECTION+"_")) == nil {
+				collections = append(collections, db.Collection(name))
+			}
+		}
+	case tenancy.ModeDatabase:
+		names, err := db.Client().ListDatabaseNames(ctx, bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(db.Name()) + "_"}})
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if tenancy.Validate(strings.TrimPrefix(name, db.Name()+"_")) == nil {
+				collections = append(collections, db.Client().Database(name).Collection(constants.USERSCOLLECTION))
+			}
+		}
+	}
+	return collections, nil
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"context"
+	"myapp/internal/utils/constants"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// usersEmailLowercase porta in minuscolo le email salvate prima della normalizzazione (services.NormalizeEmail),
+// nella collezione principale e in quelle dei tenant: login e controlli sulle email usano l'indirizzo normalizzato.
+// Non è reversibile: la forma originale degli indirizzi non viene conservata.
+var usersEmailLowercase = Migration{
+	Version:     3,
+	Description: "users email lowercase",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		collections, err := userCollections(ctx, db)
+		if err != nil {
+			return err
+		}
+		// Il filtro sulle email con maiuscole rende il passo idempotente
+		filter := bson.M{"email": bson.M{"$regex": "[A-Z]"}}
+		update := mongo.Pipeline{{{Key: constants.SET, Value: bson.M{"email": bson.M{"$toLower": "$email"}}}}}
+		for _, collection := range collections {
+			if _, err := collection.UpdateMany(ctx, filter, update); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
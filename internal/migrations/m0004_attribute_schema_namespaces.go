@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"context"
+	"myapp/internal/utils/constants"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// attributeSchemaNamespaces sposta lo schema unico degli attributi di ogni tenant nel namespace default
+// (namespaces.default) e ne usa la versione come revisione del documento.
+// Non è reversibile: dopo la migrazione un tenant può avere più namespace, che lo schema unico non rappresenta.
+var attributeSchemaNamespaces = Migration{
+	Version:     4,
+	Description: "attribute schema namespaces",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		// Il filtro sui documenti con il campo definition di primo livello rende il passo idempotente
+		filter := bson.M{"definition": bson.M{"$exists": true}}
+		update := mongo.Pipeline{
+			{{Key: constants.SET, Value: bson.M{
+				"namespaces." + constants.DEFAULT_ATTRIBUTE_NAMESPACE: bson.M{
+					"definition": "$definition",
+					"version":    "$version",
+					"updatedAt":  "$updatedAt",
+					"updatedBy":  "$updatedBy",
+				},
+				"revision": "$version",
+			}}},
+			{{Key: "$unset", Value: bson.A{"definition", "version", "updatedAt", "updatedBy"}}},
+		}
+		_, err := db.Collection(constants.ATTRIBUTESCHEMASCOLLECTION).UpdateMany(ctx, filter, update)
+		return err
+	},
+}
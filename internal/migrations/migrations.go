@@ -0,0 +1,296 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"os"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Modalità di esecuzione delle migrazioni all'avvio (MIGRATIONS_MODE)
+const (
+	ModeAuto  = "auto"  // applica le migrazioni in attesa
+	ModeCheck = "check" // rifiuta l'avvio se ci sono migrazioni in attesa
+	ModeOff   = "off"   // non controlla le migrazioni
+)
+
+// indexNotFoundCode è il codice di errore MongoDB IndexNotFound
+const indexNotFoundCode = 27
+
+// ErrMigrationsPending indica migrazioni non applicate quando MIGRATIONS_MODE=check
+var ErrMigrationsPending = errors.New("schema migrations are pending")
+
+// Migration è un passo di evoluzione dello schema.
+// Up e Down ricevono il database e devono essere idempotenti: una migrazione interrotta
+// (processo terminato prima della registrazione in schema_migrations) viene rieseguita da capo.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error // nil se la migrazione non è reversibile
+}
+
+// registry contiene tutte le migrazioni conosciute, in ordine di versione.
+// Le nuove migrazioni si aggiungono in fondo, con una versione maggiore dell'ultima.
+var registry = []Migration{
+	usersSortIndexes,
+	usersStatusBackfill,
+	usersEmailLowercase,
+	attributeSchemaNamespaces,
+}
+
+func init() {
+	for i := 1; i < len(registry); i++ {
+		if registry[i].Version <= registry[i-1].Version {
+			panic(fmt.Sprintf("migration %d must have a version greater than %d", registry[i].Version, registry[i-1].Version))
+		}
+	}
+}
+
+// Latest restituisce la versione dell'ultima migrazione conosciuta
+func Latest() int {
+	if len(registry) == 0 {
+		return 0
+	}
+	return registry[len(registry)-1].Version
+}
+
+// Status restituisce lo stato di tutte le migrazioni conosciute e di quelle applicate da versioni più recenti del servizio
+func Status(ctx context.Context) ([]models.MigrationStatus, error) {
+	applied, err := appliedByVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]models.MigrationStatus, 0, len(registry))
+	for _, migration := range registry {
+		status := models.MigrationStatus{Version: migration.Version, Description: migration.Description}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied, status.AppliedAt = true, &record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range applied {
+		appliedAt := record.AppliedAt
+		statuses = append(statuses, models.MigrationStatus{Version: record.Version, Description: record.Description, Applied: true, AppliedAt: &appliedAt, Unknown: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applica in ordine le migrazioni non ancora applicate fino a target (incluso) e restituisce quelle applicate.
+// Con dryRun restituisce le migrazioni che verrebbero applicate senza eseguirle.
+func Up(ctx context.Context, target int, dryRun bool) ([]models.MigrationStatus, error) {
+	return run(ctx, func(applied map[int]models.AppliedMigration) []Migration {
+		return planUp(registry, applied, target)
+	}, dryRun, true)
+}
+
+// Down annulla in ordine inverso le migrazioni applicate con versione maggiore di target e restituisce quelle annullate.
+// Con dryRun restituisce le migrazioni che verrebbero annullate senza eseguirle.
+func Down(ctx context.Context, target int, dryRun bool) ([]models.MigrationStatus, error) {
+	return run(ctx, func(applied map[int]models.AppliedMigration) []Migration {
+		return planDown(registry, applied, target)
+	}, dryRun, false)
+}
+
+// planUp restituisce, in ordine di versione, le migrazioni non applicate con versione fino a target (incluso)
+func planUp(migrations []Migration, applied map[int]models.AppliedMigration, target int) []Migration {
+	var pending []Migration
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= target {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+// planDown restituisce, in ordine inverso di versione, le migrazioni applicate con versione maggiore di target
+func planDown(migrations []Migration, applied map[int]models.AppliedMigration, target int) []Migration {
+	var reverted []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[migrations[i].Version]; ok && migrations[i].Version > target {
+			reverted = append(reverted, migrations[i])
+		}
+	}
+	return reverted
+}
+
+// RunOnStartup applica o verifica le migrazioni secondo MIGRATIONS_MODE (auto, check o off)
+func RunOnStartup(ctx context.Context) error {
+	log := utils.WithContext().WithField("function", "RunOnStartup")
+
+	switch mode := utils.EnvOrDefault("MIGRATIONS_MODE", ModeAuto); mode {
+	case ModeOff:
+		return nil
+	case ModeAuto:
+		applied, err := Up(ctx, Latest(), false)
+		if err != nil {
+			return err
+		}
+		log.Infof("Applied %d schema migrations", len(applied))
+	case ModeCheck:
+		pending, err := Up(ctx, Latest(), true)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%w: %d migrations to apply, first is %d (%s)", ErrMigrationsPending, len(pending), pending[0].Version, pending[0].Description)
+		}
+	default:
+		return fmt.Errorf("invalid MIGRATIONS_MODE %q: must be one of auto, check, off", mode)
+	}
+
+	statuses, err := Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Unknown {
+			log.Warnf("Migration %d (%s) was applied by a newer version of the service", status.Version, status.Description)
+		}
+	}
+	return nil
+}
+
+// run applica (up) o annulla le migrazioni scelte da plan tenendo il lock; plan viene calcolato dopo aver preso il lock,
+// così un'istanza che ha atteso il lock non riesegue le migrazioni applicate da un'altra
+func run(ctx context.Context, plan func(applied map[int]models.AppliedMigration) []Migration, dryRun, up bool) ([]models.MigrationStatus, error) {
+	log := utils.WithContext().WithField("function", "migrations.run")
+
+	if dryRun {
+		applied, err := appliedByVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return toStatuses(plan(applied), applied), nil
+	}
+
+	owner, release, err := acquireLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := appliedByVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lockTTL := utils.EnvDurationOrDefault("MIGRATIONS_LOCK_TTL", 10*time.Minute)
+	done := []models.MigrationStatus{}
+	for _, migration := range plan(applied) {
+		fn := migration.Up
+		if !up {
+			fn = migration.Down
+		}
+		if fn == nil {
+			return done, fmt.Errorf("migration %d (%s) is not reversible", migration.Version, migration.Description)
+		}
+		// Rinnova il lock prima di ogni passo, così le migrazioni lunghe non lo perdono
+		if err := repository.AcquireMigrationLock(ctx, owner, lockTTL); err != nil {
+			return done, err
+		}
+
+		log.Infof("Running migration %d (%s)", migration.Version, migration.Description)
+		start := time.Now()
+		if err := fn(ctx, config.GetDatabase()); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		elapsed := time.Since(start)
+		status := models.MigrationStatus{Version: migration.Version, Description: migration.Description}
+		if up {
+			record := models.AppliedMigration{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now(), DurationMs: elapsed.Milliseconds()}
+			err = repository.InsertAppliedMigration(ctx, record)
+			status.Applied, status.AppliedAt = true, &record.AppliedAt
+		} else {
+			err = repository.DeleteAppliedMigration(ctx, migration.Version)
+		}
+		if err != nil {
+			return done, err
+		}
+		log.Infof("Migration %d completed in %s", migration.Version, elapsed)
+		done = append(done, status)
+	}
+	return done, nil
+}
+
+// acquireLock attende il lock delle migrazioni per al massimo MIGRATIONS_LOCK_WAIT e restituisce la funzione che lo rilascia
+func acquireLock(ctx context.Context) (string, func(), error) {
+	owner, err := utils.GenerateUUID()
+	if err != nil {
+		return "", nil, err
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		owner = hostname + "-" + owner
+	}
+	lockTTL := utils.EnvDurationOrDefault("MIGRATIONS_LOCK_TTL", 10*time.Minute)
+	wait := utils.EnvDurationOrDefault("MIGRATIONS_LOCK_WAIT", 2*time.Minute)
+
+	deadline := time.Now().Add(wait)
+	for {
+		err := repository.AcquireMigrationLock(ctx, owner, lockTTL)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, repository.ErrMigrationLocked) || time.Now().After(deadline) {
+			return "", nil, err
+		}
+		utils.WithContext().Info("Migrations locked by another instance, waiting..")
+		select {
+		case <-ctx.Done():
+			return "", nil, ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	release := func() {
+		// Il lock va rilasciato anche se il contesto del comando è scaduto
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := repository.ReleaseMigrationLock(releaseCtx, owner); err != nil {
+			utils.WithContext().Errorf("Error releasing migration lock: %v", err)
+		}
+	}
+	return owner, release, nil
+}
+
+func appliedByVersion(ctx context.Context) (map[int]models.AppliedMigration, error) {
+	applied, err := repository.GetAppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]models.AppliedMigration, len(applied))
+	for _, migration := range applied {
+		byVersion[migration.Version] = migration
+	}
+	return byVersion, nil
+}
+
+func toStatuses(migrations []Migration, applied map[int]models.AppliedMigration) []models.MigrationStatus {
+	statuses := make([]models.MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = models.MigrationStatus{Version: migration.Version, Description: migration.Description}
+		if record, ok := applied[migration.Version]; ok {
+			statuses[i].Applied, statuses[i].AppliedAt = true, &record.AppliedAt
+		}
+	}
+	return statuses
+}
+
+// dropIndex elimina un indice, ignorando l'errore se non esiste (i passi devono essere idempotenti)
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(indexNotFoundCode) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package migrations
+
+import (
+	"context"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func versions(migrations []Migration) []int {
+	result := []int{}
+	for _, migration := range migrations {
+		result = append(result, migration.Version)
+	}
+	return result
+}
+
+func appliedVersions(list ...int) map[int]models.AppliedMigration {
+	applied := make(map[int]models.AppliedMigration, len(list))
+	for _, version := range list {
+		applied[version] = models.AppliedMigration{Version: version}
+	}
+	return applied
+}
+
+func TestPlanUp(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}, {Version: 5}, {Version: 7}}
+	cases := []struct {
+		name    string
+		applied []int
+		target  int
+		want    []int
+	}{
+		{"empty database", nil, 7, []int{1, 2, 5, 7}},
+		{"up to target", nil, 5, []int{1, 2, 5}},
+		{"target between versions", nil, 4, []int{1, 2}},
+		{"skips applied", []int{1, 5}, 7, []int{2, 7}},
+		{"all applied", []int{1, 2, 5, 7}, 7, []int{}},
+		// Le migrazioni applicate da versioni più recenti del servizio non sono nel registro e non cambiano il piano
+		{"unknown applied", []int{1, 2, 9}, 7, []int{5, 7}},
+	}
+	for _, tc := range cases {
+		if got := versions(planUp(migrations, appliedVersions(tc.applied...), tc.target)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: planUp = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPlanDown(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}, {Version: 5}, {Version: 7}}
+	cases := []struct {
+		name    string
+		applied []int
+		target  int
+		want    []int
+	}{
+		{"reverse order", []int{1, 2, 5, 7}, 0, []int{7, 5, 2, 1}},
+		{"down to target", []int{1, 2, 5, 7}, 2, []int{7, 5}},
+		{"skips not applied", []int{1, 5}, 0, []int{5, 1}},
+		{"nothing above target", []int{1, 2}, 2, []int{}},
+		{"unknown applied", []int{1, 9}, 0, []int{1}},
+	}
+	for _, tc := range cases {
+		if got := versions(planDown(migrations, appliedVersions(tc.applied...), tc.target)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: planDown = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDryRunPlansFromAppliedMigrations(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	ns := "myapp." + constants.MIGRATIONSCOLLECTION
+	applied := []bson.D{
+		{{Key: "_id", Value: 1}, {Key: "description", Value: "first"}, {Key: "appliedAt", Value: time.Now()}},
+		{{Key: "_id", Value: 2}, {Key: "description", Value: "second"}, {Key: "appliedAt", Value: time.Now()}},
+	}
+
+	mt.Run("up", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, applied...))
+
+		pending, err := Up(context.Background(), Latest(), true)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		want := []int{}
+		for _, migration := range registry {
+			if migration.Version > 2 {
+				want = append(want, migration.Version)
+			}
+		}
+		got := []int{}
+		for _, status := range pending {
+			got = append(got, status.Version)
+			if status.Applied {
+				mt.Errorf("migration %d reported as applied", status.Version)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			mt.Errorf("pending = %v, want %v", got, want)
+		}
+		// Il dry-run legge solo le migrazioni applicate: nessun lock e nessuna scrittura
+		if started := mt.GetAllStartedEvents(); len(started) != 1 || started[0].CommandName != "find" {
+			mt.Errorf("commands = %d, want a single find", len(started))
+		}
+	})
+
+	mt.Run("down", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, applied...))
+
+		reverted, err := Down(context.Background(), 0, true)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if len(reverted) != 2 || reverted[0].Version != 2 || reverted[1].Version != 1 || !reverted[0].Applied {
+			mt.Errorf("reverted = %+v, want 2 and 1, applied", reverted)
+		}
+	})
+}
+
+func TestRegistryIsOrdered(t *testing.T) {
+	for i, migration := range registry {
+		if migration.Up == nil {
+			t.Errorf("migration %d has no Up step", migration.Version)
+		}
+		if i > 0 && migration.Version <= registry[i-1].Version {
+			t.Errorf("migration %d follows %d", migration.Version, registry[i-1].Version)
+		}
+	}
+	if Latest() != registry[len(registry)-1].Version {
+		t.Errorf("Latest = %d, want the last registered version", Latest())
+	}
+}
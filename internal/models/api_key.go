@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// APIKey è una chiave di accesso per i client che non possono ottenere un JWT (job, integrazioni).
+// Della chiave sono salvati solo il sale e l'hash: il valore in chiaro è restituito solo alla creazione.
+type APIKey struct {
+	ID         string     `json:"id" bson:"_id"`
+	Name       string     `json:"name" bson:"name"`
+	Key        string     `json:"key,omitempty" bson:"-"`
+	Salt       string     `json:"-" bson:"salt"`
+	Hash       string     `json:"-" bson:"hash"`
+	Scopes     []string   `json:"scopes" bson:"scopes"`
+	TenantID   string     `json:"-" bson:"tenantId,omitempty"` // la chiave è valida solo per il tenant in cui è stata creata
+	CreatedBy  string     `json:"createdBy" bson:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"` // nil = senza scadenza
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// APIKeyRequest è il corpo di creazione di una API key
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// AuditEntry è una voce dell'audit log: chi ha modificato un utente, quando, da dove e cosa è cambiato.
+// Le voci vengono solo inserite, mai modificate o eliminate (anche quando l'utente viene eliminato).
+type AuditEntry struct {
+	ID            string        `json:"id" bson:"_id"` // uguale all'ID dell'evento di dominio
+	UserID        string        `json:"userId" bson:"userId"`
+	Action        string        `json:"action" bson:"action"` // UserCreated, UserUpdated o UserDeleted
+	Actor         string        `json:"actor" bson:"actor"`
+	CorrelationID string        `json:"correlationId,omitempty" bson:"correlationId,omitempty"`
+	ClientIP      string        `json:"clientIp,omitempty" bson:"clientIp,omitempty"`
+	Timestamp     time.Time     `json:"timestamp" bson:"timestamp"`
+	Changes       []FieldChange `json:"changes" bson:"changes"`
+}
+
+// FieldChange è la modifica di un campo: Old è assente per i campi aggiunti, New per quelli rimossi
+type FieldChange struct {
+	Field string      `json:"field" bson:"field"`
+	Old   interface{} `json:"old,omitempty" bson:"old,omitempty"`
+	New   interface{} `json:"new,omitempty" bson:"new,omitempty"`
+}
+
+// AuditQuery filtra le voci dell'audit log; i campi vuoti non filtrano
+type AuditQuery struct {
+	UserID string
+	Actor  string
+	From   time.Time // incluso
+	To     time.Time // escluso
+}
+
+// AuditPage è una pagina di voci dell'audit log, dalla più recente
+type AuditPage struct {
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+	Total    int64        `json:"total"`
+	Entries  []AuditEntry `json:"entries"`
+}
@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// Credentials sono le credenziali di accesso di un utente, salvate separatamente dal documento dell'utente:
+// User è copiato negli eventi dell'outbox, nell'audit log e nelle consegne dei webhook, dove l'hash non deve finire.
+// Nessun handler le restituisce.
+type Credentials struct {
+	UserID            string     `bson:"_id"`
+	PasswordHash      string     `bson:"passwordHash"` // argon2id in formato PHC
+	PasswordChangedAt time.Time  `bson:"passwordChangedAt"`
+	FailedAttempts    int        `bson:"failedAttempts"`
+	LockedUntil       *time.Time `bson:"lockedUntil,omitempty"`
+	VerifiedEmail     string     `bson:"verifiedEmail,omitempty"` // indirizzo confermato: non più verificato se l'email dell'utente cambia
+	EmailVerifiedAt   *time.Time `bson:"emailVerifiedAt,omitempty"`
+}
+
+// RefreshToken è un refresh token emesso dal login. Ogni rinnovo lo sostituisce con uno nuovo della stessa famiglia;
+// il riuso di un token già sostituito indica un furto e revoca l'intera famiglia.
+type RefreshToken struct {
+	ID        string     `bson:"_id"` // claim jti
+	FamilyID  string     `bson:"familyId"`
+	UserID    string     `bson:"userId"`
+	TenantID  string     `bson:"tenantId,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt"`
+	ExpiresAt time.Time  `bson:"expiresAt"`
+	RotatedAt *time.Time `bson:"rotatedAt,omitempty"`
+	RevokedAt *time.Time `bson:"revokedAt,omitempty"`
+}
+
+// EmailToken registra un token di verifica dell'email o di reset della password inviato per email,
+// così che ciascun token si possa usare una sola volta
+type EmailToken struct {
+	ID        string     `bson:"_id"` // claim jti
+	UserID    string     `bson:"userId"`
+	Purpose   string     `bson:"purpose"` // tipo del token (jwt.TypeEmailVerification o jwt.TypePasswordReset)
+	TenantID  string     `bson:"tenantId,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt"`
+	ExpiresAt time.Time  `bson:"expiresAt"`
+	UsedAt    *time.Time `bson:"usedAt,omitempty"`
+}
+
+// LoginRequest è il corpo di POST /auth/login
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// RefreshRequest è il corpo di POST /auth/refresh e POST /auth/logout
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// TokenResponse contiene i token emessi dal login e dal rinnovo
+type TokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"` // sempre Bearer
+	ExpiresIn    int    `json:"expiresIn"` // durata dell'access token in secondi
+}
+
+// EmailRequest è il corpo di POST /auth/email-verification e POST /auth/password-reset
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// EmailTokenRequest è il corpo di POST /auth/email-verification/confirm
+type EmailTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// PasswordResetRequest è il corpo di POST /auth/password-reset/confirm
+type PasswordResetRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// PasswordRequest è il corpo di PUT /users/{id}/password.
+// CurrentPassword è richiesta quando l'utente cambia la propria password.
+type PasswordRequest struct {
+	CurrentPassword string `json:"currentPassword,omitempty"`
+	NewPassword     string `json:"newPassword"`
+}
@@ -0,0 +1,37 @@
+package models
+
+// BatchUsersRequest è il payload di :batchCreate e :batchUpdate.
+// Ordered (default true) interrompe l'elaborazione al primo errore, come le bulk write di MongoDB.
+type BatchUsersRequest struct {
+	Ordered *bool  `json:"ordered,omitempty"`
+	Items   []User `json:"items"`
+}
+
+// BatchDeleteRequest è il payload di :batchDelete
+type BatchDeleteRequest struct {
+	Ordered *bool    `json:"ordered,omitempty"`
+	IDs     []string `json:"ids"`
+}
+
+// BatchItemResult descrive l'esito di un singolo elemento del batch.
+// Status segue la semantica HTTP: 201/200/204 successo, 400 non valido, 404 non trovato,
+// 424 non eseguito perché un elemento precedente è fallito in modalità ordered.
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchResult raccoglie gli esiti di tutti gli elementi del batch
+type BatchResult struct {
+	Ordered   bool              `json:"ordered"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// IsOrdered restituisce la modalità richiesta, ordered se non specificata
+func IsOrdered(ordered *bool) bool {
+	return ordered == nil || *ordered
+}
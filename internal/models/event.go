@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// UserEvent è l'evento di dominio generato da ogni modifica di un utente.
+// Before è assente per UserCreated, After è assente per UserDeleted.
+type UserEvent struct {
+	ID            string    `json:"id" bson:"_id"`
+	Type          string    `json:"type" bson:"type"`
+	UserID        string    `json:"userId" bson:"userId"`
+	Before        *User     `json:"before,omitempty" bson:"before,omitempty"`
+	After         *User     `json:"after,omitempty" bson:"after,omitempty"`
+	CorrelationID string    `json:"correlationId,omitempty" bson:"correlationId,omitempty"`
+	TenantID      string    `json:"tenantId,omitempty" bson:"tenantId,omitempty"` // tenant dell'utente, vuoto con TENANCY_MODE=off
+	OccurredAt    time.Time `json:"occurredAt" bson:"occurredAt"`
+}
+
+// OutboxEntry è un evento salvato nell'outbox insieme allo stato della sua pubblicazione
+type OutboxEntry struct {
+	UserEvent     `bson:",inline"`
+	Status        string    `bson:"status"`
+	Attempts      int       `bson:"attempts"`
+	NextAttemptAt time.Time `bson:"nextAttemptAt"`
+	LockedUntil   time.Time `bson:"lockedUntil,omitempty"`
+	Owner         string    `bson:"owner,omitempty"` // relay che detiene il lease, diverso a ogni presa in carico
+	LastError     string    `bson:"lastError,omitempty"`
+	PublishedAt   time.Time `bson:"publishedAt,omitempty"`
+}
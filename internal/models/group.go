@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Group è un gruppo di utenti (es. un team)
+type Group struct {
+	ID          string    `json:"id" bson:"_id"`
+	Name        string    `json:"name" bson:"name"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// GroupRequest è il corpo di creazione e aggiornamento di un gruppo
+type GroupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// GroupMembership è l'appartenenza di un utente a un gruppo con il suo ruolo (owner, admin o member)
+type GroupMembership struct {
+	ID       string    `json:"-" bson:"_id"`
+	GroupID  string    `json:"groupId" bson:"groupId"`
+	UserID   string    `json:"userId" bson:"userId"`
+	Role     string    `json:"role" bson:"role"`
+	JoinedAt time.Time `json:"joinedAt" bson:"joinedAt"`
+}
+
+// GroupMemberRequest è il corpo dell'aggiunta di un membro (userId e ruolo) e del cambio di ruolo (solo ruolo)
+type GroupMemberRequest struct {
+	UserID string `json:"userId,omitempty"`
+	Role   string `json:"role,omitempty"` // default member
+}
+
+// GroupPage è una pagina dell'elenco dei gruppi
+type GroupPage struct {
+	Page     int     `json:"page"`
+	PageSize int     `json:"pageSize"`
+	Total    int64   `json:"total"`
+	Groups   []Group `json:"groups"`
+}
+
+// GroupMembershipPage è una pagina dei membri di un gruppo o dei gruppi di un utente
+type GroupMembershipPage struct {
+	Page        int               `json:"page"`
+	PageSize    int               `json:"pageSize"`
+	Total       int64             `json:"total"`
+	Memberships []GroupMembership `json:"memberships"`
+}
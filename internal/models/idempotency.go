@@ -0,0 +1,21 @@
+package models
+
+import (
+	"net/http"
+	"time"
+)
+
+// IdempotencyRecord rappresenta una richiesta eseguita con un header Idempotency-Key.
+// Contiene l'impronta (fingerprint) della richiesta originale e la risposta da riproporre in caso di retry.
+type IdempotencyRecord struct {
+	Key         string      `bson:"_id"`
+	Fingerprint string      `bson:"fingerprint"`
+	Owner       string      `bson:"owner"`  // identifica l'esecuzione che detiene il lock: solo lei può completare o rilasciare la chiave
+	Status      string      `bson:"status"` // in_progress finché la richiesta originale non termina, poi completed
+	StatusCode  int         `bson:"statusCode,omitempty"`
+	Header      http.Header `bson:"header,omitempty"`
+	Body        []byte      `bson:"body,omitempty"`
+	CreatedAt   time.Time   `bson:"createdAt"`
+	LockedUntil time.Time   `bson:"lockedUntil"` // oltre questa data un record in_progress è considerato abbandonato
+	ExpiresAt   time.Time   `bson:"expiresAt"`   // usato dall'indice TTL di MongoDB
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// AppliedMigration è una migrazione registrata nella collezione schema_migrations
+type AppliedMigration struct {
+	Version     int       `json:"version" bson:"_id"`
+	Description string    `json:"description" bson:"description"`
+	AppliedAt   time.Time `json:"appliedAt" bson:"appliedAt"`
+	DurationMs  int64     `json:"durationMs" bson:"durationMs"`
+}
+
+// MigrationStatus descrive lo stato di una migrazione: applicata, in attesa o sconosciuta al binario in esecuzione
+type MigrationStatus struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	Applied     bool       `json:"applied"`
+	AppliedAt   *time.Time `json:"appliedAt,omitempty"`
+	Unknown     bool       `json:"unknown,omitempty"` // applicata da una versione più recente del servizio
+}
@@ -0,0 +1,19 @@
+package models
+
+// UserSearchHit è un utente restituito dalla ricerca con il relativo punteggio di rilevanza
+type UserSearchHit struct {
+	User          User              `json:"user"`
+	Score         float64           `json:"score"`
+	MatchedFields []string          `json:"matchedFields"`
+	Highlights    map[string]string `json:"highlights,omitempty"` // valore del campo con i termini trovati racchiusi in <em></em>
+}
+
+// UserSearchResult è una pagina di risultati della ricerca utenti
+type UserSearchResult struct {
+	Query    string          `json:"query"`
+	Mode     string          `json:"mode"`
+	Page     int             `json:"page"`
+	PageSize int             `json:"pageSize"`
+	Total    int64           `json:"total"`
+	Hits     []UserSearchHit `json:"hits"`
+}
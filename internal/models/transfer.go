@@ -0,0 +1,32 @@
+package models
+
+// ImportOptions controlla il comportamento dell'import degli utenti
+type ImportOptions struct {
+	Format     string // csv, ndjson o json
+	DryRun     bool   // valida e calcola i conflitti senza scrivere
+	OnConflict string // skip, overwrite o fail quando l'ID importato esiste già
+}
+
+// ImportError descrive un record scartato durante l'import
+type ImportError struct {
+	Record int    `json:"record"` // posizione del record nel file (1-based, esclusa l'intestazione CSV)
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ImportReport riassume l'esito di un import
+type ImportReport struct {
+	Format          string        `json:"format"`
+	DryRun          bool          `json:"dryRun"`
+	OnConflict      string        `json:"onConflict"`
+	Total           int           `json:"total"`
+	Valid           int           `json:"valid"`
+	Invalid         int           `json:"invalid"`
+	Inserted        int           `json:"inserted"`
+	Updated         int           `json:"updated"`
+	Skipped         int           `json:"skipped"`
+	Failed          int           `json:"failed"`
+	Aborted         bool          `json:"aborted"`
+	Errors          []ImportError `json:"errors"`
+	ErrorsTruncated bool          `json:"errorsTruncated,omitempty"`
+}
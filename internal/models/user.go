@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // User Combinazione di json e bson
 // Utilizzando entrambe le annotazioni, puoi garantire che la stessa struttura User possa
 // essere utilizzata senza problemi sia per la comunicazione API in formato JSON che per la memorizzazione e il recupero dei dati in MongoDB in formato BSON.
@@ -7,4 +9,17 @@ type User struct {
 	ID    string `json:"id" bson:"_id,omitempty"` // _id,omitempty" specifica che il campo ID è mappato al campo _id in MongoDB e che deve essere omesso se vuoto (omitempty).
 	Name  string `json:"name" bson:"name"`
 	Email string `json:"email" bson:"email"`
+	// Stato del ciclo di vita (pending, active, suspended, deactivated): impostato alla creazione e modificato solo
+	// dalle azioni di stato (POST /users/{id}:<azione>). omitempty evita che gli aggiornamenti, che lo ignorano, lo azzerino.
+	Status          string     `json:"status,omitempty" bson:"status,omitempty"`
+	StatusReason    string     `json:"statusReason,omitempty" bson:"statusReason,omitempty"`
+	StatusChangedAt *time.Time `json:"statusChangedAt,omitempty" bson:"statusChangedAt,omitempty"`
+	// Attributi personalizzati, validati con lo schema del tenant (PUT /admin/user-attributes/schema).
+	// In un aggiornamento, se assenti restano invariati; se presenti sostituiscono tutti quelli salvati ({} li rimuove).
+	Attributes map[string]interface{} `json:"attributes,omitempty" bson:"attributes,omitempty"`
+}
+
+// UserStatusRequest è il corpo facoltativo delle azioni di stato
+type UserStatusRequest struct {
+	Reason string `json:"reason,omitempty"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// UserAttributeSchema è lo schema JSON che governa gli attributi personalizzati degli utenti di un tenant
+// dichiarati da un namespace (ad esempio un team di prodotto); default è il namespace di /admin/user-attributes/schema
+type UserAttributeSchema struct {
+	Namespace string          `json:"namespace"`
+	Schema    json.RawMessage `json:"schema"`
+	Version   int64           `json:"version"` // incrementata a ogni modifica del namespace
+	UpdatedAt time.Time       `json:"updatedAt"`
+	UpdatedBy string          `json:"updatedBy,omitempty"`
+}
+
+// UserAttributeSchemaRequest è il corpo della sostituzione dello schema degli attributi
+type UserAttributeSchemaRequest struct {
+	Schema json.RawMessage `json:"schema"`
+}
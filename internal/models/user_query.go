@@ -0,0 +1,31 @@
+package models
+
+// UserQuery descrive una pagina di utenti filtrata e ordinata, con paginazione a cursore
+type UserQuery struct {
+	NameContains  string
+	EmailContains string
+	Statuses      []string // se valorizzato, solo gli utenti in uno di questi stati
+	SortField     string   // id, name o email
+	Descending    bool
+	After         string // cursore dell'ultimo utente della pagina precedente
+	Limit         int
+}
+
+// UserPageItem è un utente di una pagina con il cursore che lo identifica nell'ordinamento richiesto
+type UserPageItem struct {
+	Cursor string
+	User   User
+}
+
+// UserPage è il risultato di una UserQuery
+type UserPage struct {
+	Items       []UserPageItem
+	HasNextPage bool
+	TotalCount  int64
+}
+
+// UserListFilter filtra l'elenco completo degli utenti (GET /users)
+type UserListFilter struct {
+	Statuses   []string                 // se valorizzato, solo gli utenti in uno di questi stati
+	Attributes map[string][]interface{} // attributo -> valori ammessi, già convertiti nel tipo dichiarato dallo schema
+}
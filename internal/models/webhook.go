@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// WebhookSubscription è la registrazione di un sistema esterno che riceve gli eventi sugli utenti.
+// Il secret firma le consegne ed è restituito solo alla creazione (o quando viene ruotato).
+type WebhookSubscription struct {
+	ID          string    `json:"id" bson:"_id"`
+	URL         string    `json:"url" bson:"url"`
+	EventTypes  []string  `json:"eventTypes" bson:"eventTypes"` // vuoto = tutti gli eventi
+	Secret      string    `json:"secret,omitempty" bson:"secret"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	Active      bool      `json:"active" bson:"active"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// WebhookSubscriptionRequest è il corpo di creazione e aggiornamento di una sottoscrizione.
+// Se Secret è vuoto in creazione viene generato; in aggiornamento il secret esistente viene mantenuto.
+type WebhookSubscriptionRequest struct {
+	URL         string   `json:"url"`
+	EventTypes  []string `json:"eventTypes"`
+	Secret      string   `json:"secret,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Active      *bool    `json:"active,omitempty"` // default true
+}
+
+// WebhookDelivery è la consegna di un evento a una sottoscrizione, con lo storico dei tentativi
+type WebhookDelivery struct {
+	ID             string           `json:"id" bson:"_id"`
+	SubscriptionID string           `json:"subscriptionId" bson:"subscriptionId"`
+	EventID        string           `json:"eventId" bson:"eventId"`
+	EventType      string           `json:"eventType" bson:"eventType"`
+	Event          UserEvent        `json:"event" bson:"event"`
+	Status         string           `json:"status" bson:"status"` // pending, processing, delivered, dead
+	AttemptCount   int              `json:"attemptCount" bson:"attemptCount"`
+	Attempts       []WebhookAttempt `json:"attempts,omitempty" bson:"attempts,omitempty"`
+	NextAttemptAt  time.Time        `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LockedUntil    time.Time        `json:"-" bson:"lockedUntil,omitempty"`
+	LastError      string           `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	TenantID       string           `json:"-" bson:"tenantId,omitempty"` // usato dal dispatcher per leggere la sottoscrizione nel tenant giusto
+	CreatedAt      time.Time        `json:"createdAt" bson:"createdAt"`
+	DeliveredAt    *time.Time       `json:"deliveredAt,omitempty" bson:"deliveredAt,omitempty"`
+}
+
+// WebhookAttempt è l'esito di un singolo tentativo di consegna
+type WebhookAttempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMs int64     `json:"durationMs" bson:"durationMs"`
+}
+
+// WebhookDeliveryPage è una pagina dello storico delle consegne di una sottoscrizione
+type WebhookDeliveryPage struct {
+	Page       int               `json:"page"`
+	PageSize   int               `json:"pageSize"`
+	Total      int64             `json:"total"`
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
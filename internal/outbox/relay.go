@@ -0,0 +1,108 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"time"
+)
+
+// Relay legge gli eventi pendenti dall'outbox e li pubblica sul sink.
+// Più repliche possono eseguire il relay in parallelo: ogni evento viene preso in carico con un lease,
+// e l'esito viene registrato solo se il lease non è nel frattempo scaduto e passato a un altro relay.
+// Un evento viene segnato come pubblicato solo dopo la consegna (at-least-once) e, in caso di errore,
+// ritentato con backoff esponenziale fino a MaxAttempts, dopodiché resta in stato failed.
+type Relay struct {
+	Sink         Sink
+	PollInterval time.Duration
+	BatchSize    int
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+// NewRelayFromEnv crea il relay leggendo la configurazione dalle variabili d'ambiente
+func NewRelayFromEnv(sink Sink) *Relay {
+	return &Relay{
+		Sink:         sink,
+		PollInterval: utils.EnvDurationOrDefault("OUTBOX_POLL_INTERVAL", time.Second),
+		BatchSize:    utils.EnvIntOrDefault("OUTBOX_BATCH_SIZE", 100),
+		Lease:        utils.EnvDurationOrDefault("OUTBOX_LEASE", 30*time.Second),
+		MaxAttempts:  utils.EnvIntOrDefault("OUTBOX_MAX_ATTEMPTS", 10),
+		BaseBackoff:  utils.EnvDurationOrDefault("OUTBOX_BASE_BACKOFF", time.Second),
+		MaxBackoff:   utils.EnvDurationOrDefault("OUTBOX_MAX_BACKOFF", 5*time.Minute),
+	}
+}
+
+// Run esegue il relay finché il contesto non viene cancellato
+func (r *Relay) Run(ctx context.Context) {
+	log := utils.WithContext().WithField("package", "outbox")
+	log.Infof("Outbox relay started (sink: %s)", r.Sink.Name())
+
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.drain(ctx)
+		select {
+		case <-ctx.Done():
+			log.Info("Outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain pubblica fino a BatchSize eventi pendenti
+func (r *Relay) drain(ctx context.Context) {
+	log := utils.WithContext().WithField("package", "outbox")
+
+	for i := 0; i < r.BatchSize && ctx.Err() == nil; i++ {
+		// Ogni presa in carico ha un owner diverso: se il lease scade e l'evento viene ripreso,
+		// anche da questo stesso relay, l'esito del tentativo precedente non viene più registrato
+		owner, err := utils.GenerateUUID()
+		if err != nil {
+			return
+		}
+		entry, err := repository.ClaimOutboxEntry(ctx, r.Lease, owner)
+		if err != nil || entry == nil {
+			return
+		}
+
+		publishCtx, cancel := context.WithTimeout(ctx, r.Lease)
+		err = r.Sink.Publish(publishCtx, entry.UserEvent)
+		cancel()
+
+		if err == nil {
+			if err := repository.MarkOutboxPublished(ctx, entry.ID, owner); err != nil {
+				logMarkError(entry.ID, "published", err)
+			}
+			continue
+		}
+
+		attempts := entry.Attempts + 1
+		dead := attempts >= r.MaxAttempts
+		if dead {
+			log.Errorf("Event %s failed %d times, giving up: %v", entry.ID, attempts, err)
+		} else {
+			log.Warnf("Error publishing event %s (attempt %d): %v", entry.ID, attempts, err)
+		}
+		next := time.Now().Add(utils.ExponentialBackoff(r.BaseBackoff, r.MaxBackoff, attempts))
+		if err := repository.MarkOutboxRetry(ctx, entry.ID, owner, attempts, next, err.Error(), dead); err != nil {
+			logMarkError(entry.ID, "retry", err)
+		}
+	}
+}
+
+// logMarkError registra il fallimento della registrazione dell'esito (published o retry) di un evento
+func logMarkError(id, outcome string, err error) {
+	log := utils.WithContext().WithField("package", "outbox")
+	if errors.Is(err, repository.ErrOutboxLeaseLost) {
+		// Il lease è scaduto durante la pubblicazione: l'evento è di un altro relay, che lo pubblicherà di nuovo (at-least-once)
+		log.Warnf("Lease of event %s expired before recording the %s outcome", id, outcome)
+		return
+	}
+	log.Errorf("Error recording the %s outcome of event %s: %v", outcome, id, err)
+}
@@ -0,0 +1,126 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// stubSink registra gli eventi pubblicati e restituisce err
+type stubSink struct {
+	published []models.UserEvent
+	err       error
+}
+
+func (s *stubSink) Name() string { return "stub" }
+
+func (s *stubSink) Publish(_ context.Context, event models.UserEvent) error {
+	s.published = append(s.published, event)
+	return s.err
+}
+
+// started restituisce i comandi inviati al database
+func started(mt *mtest.T) []*event.CommandStartedEvent {
+	var events []*event.CommandStartedEvent
+	for e := mt.GetStartedEvent(); e != nil; e = mt.GetStartedEvent() {
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestRelayRecordsTheOutcomeOnlyUnderItsLease(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	claimed := bson.D{{Key: "_id", Value: "event-1"}, {Key: "status", Value: constants.OUTBOX_PROCESSING}, {Key: "attempts", Value: 2}}
+	relay := func(sink Sink) *Relay {
+		return &Relay{Sink: sink, BatchSize: 1, Lease: time.Minute, MaxAttempts: 10, BaseBackoff: time.Second, MaxBackoff: time.Minute}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		sinkErr    error
+		wantStatus string
+	}{
+		{"published", nil, constants.OUTBOX_PUBLISHED},
+		{"retry", errors.New("sink down"), constants.OUTBOX_PENDING},
+	} {
+		mt.Run(tc.name, func(mt *mtest.T) {
+			config.SetDatabase(mt.Client, mt.DB)
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "value", Value: claimed}),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			)
+			sink := &stubSink{err: tc.sinkErr}
+			relay(sink).drain(context.Background())
+
+			if len(sink.published) != 1 || sink.published[0].ID != "event-1" {
+				mt.Fatalf("published %+v", sink.published)
+			}
+			commands := started(mt)
+			if len(commands) != 2 {
+				mt.Fatalf("%d commands, want the claim and the outcome", len(commands))
+			}
+			owner := commands[0].Command.Lookup("update", "$set", "owner").StringValue()
+			if owner == "" {
+				mt.Fatal("the claim does not record the lease owner")
+			}
+			update := commands[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+			if got := update.Lookup("q", "owner").StringValue(); got != owner {
+				mt.Errorf("outcome recorded for owner %q, want the claiming owner %q", got, owner)
+			}
+			if got := update.Lookup("q", "status").StringValue(); got != constants.OUTBOX_PROCESSING {
+				mt.Errorf("outcome recorded for status %q, want only entries still processing", got)
+			}
+			if got := update.Lookup("u", "$set", "status").StringValue(); got != tc.wantStatus {
+				mt.Errorf("status %q, want %q", got, tc.wantStatus)
+			}
+		})
+	}
+
+	mt.Run("expired lease", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: claimed}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+		)
+		r := relay(&stubSink{})
+		r.BatchSize = 2
+		r.drain(context.Background())
+
+		// L'esito perso non interrompe il relay, che passa all'evento successivo
+		if commands := started(mt); len(commands) != 3 {
+			mt.Errorf("%d commands, want the relay to keep claiming after a lost lease", len(commands))
+		}
+	})
+
+	mt.Run("every claim has its own owner", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: claimed}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: claimed}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+		r := relay(&stubSink{})
+		r.BatchSize = 2
+		r.drain(context.Background())
+
+		commands := started(mt)
+		if len(commands) != 4 {
+			mt.Fatalf("%d commands, want two claims and two outcomes", len(commands))
+		}
+		first := commands[0].Command.Lookup("update", "$set", "owner").StringValue()
+		second := commands[2].Command.Lookup("update", "$set", "owner").StringValue()
+		if first == second {
+			mt.Errorf("both claims used owner %q", first)
+		}
+	})
+}
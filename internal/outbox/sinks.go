@@ -0,0 +1,186 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"myapp/internal/models"
+	"myapp/internal/utils"
+	"myapp/internal/webhooks"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink è la destinazione su cui il relay pubblica gli eventi dell'outbox.
+// Publish deve restituire un errore se l'evento non è stato consegnato, così il relay lo ritenta:
+// la consegna è at-least-once, quindi i consumatori devono deduplicare per ID evento.
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, event models.UserEvent) error
+}
+
+// NewSinkFromEnv crea i sink elencati in OUTBOX_SINKS (separati da virgola): stdout, file, webhook, webhooks
+func NewSinkFromEnv() (Sink, error) {
+	var sinks []Sink
+	for _, name := range strings.Split(utils.EnvOrDefault("OUTBOX_SINKS", "stdout,webhooks"), ",") {
+		switch strings.TrimSpace(name) {
+		case "stdout":
+			sinks = append(sinks, NewWriterSink("stdout", os.Stdout))
+		case "file":
+			sinks = append(sinks, NewFileSink(utils.EnvOrDefault("OUTBOX_FILE_PATH", "outbox.ndjson")))
+		case "webhook":
+			url := os.Getenv("OUTBOX_WEBHOOK_URL")
+			if url == "" {
+				return nil, errors.New("OUTBOX_WEBHOOK_URL is required by the webhook sink")
+			}
+			sinks = append(sinks, NewWebhookSink(url, utils.EnvDurationOrDefault("OUTBOX_WEBHOOK_TIMEOUT", 10*time.Second)))
+		case "webhooks":
+			// Consegna alle sottoscrizioni registrate tramite /webhooks
+			sinks = append(sinks, webhooks.NewFanoutSink())
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown outbox sink: %s", name)
+		}
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return MultiSink(sinks), nil
+}
+
+// WriterSink scrive ogni evento come una riga JSON su un io.Writer
+type WriterSink struct {
+	name string
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewWriterSink crea un sink che scrive su w
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{name: name, w: w}
+}
+
+func (s *WriterSink) Name() string {
+	return s.name
+}
+
+func (s *WriterSink) Publish(_ context.Context, event models.UserEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// FileSink accoda gli eventi in formato NDJSON a un file
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSink crea un sink che scrive sul file indicato, creandolo se non esiste
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+func (s *FileSink) Publish(_ context.Context, event models.UserEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+	// Sync garantisce che l'evento sia su disco prima di segnarlo come pubblicato
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WebhookSink invia ogni evento con una POST JSON all'URL configurato; una risposta non 2xx è un errore
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink crea un sink HTTP con il timeout indicato
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Publish(ctx context.Context, event models.UserEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", event.ID)
+	req.Header.Set("X-Event-Type", event.Type)
+	if event.CorrelationID != "" {
+		req.Header.Set("X-Correlation-ID", event.CorrelationID)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// MultiSink pubblica su tutti i sink; se uno fallisce l'evento viene ritentato su tutti
+type MultiSink []Sink
+
+func (m MultiSink) Name() string {
+	names := make([]string, len(m))
+	for i, sink := range m {
+		names[i] = sink.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (m MultiSink) Publish(ctx context.Context, event models.UserEvent) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Publish(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,80 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Parametri di argon2id per i nuovi hash (raccomandazione OWASP: 19 MiB, 2 iterazioni, 1 thread).
+// Gli hash salvati riportano i propri parametri: cambiarli non invalida le password esistenti,
+// che vengono ricalcolate al login successivo (vedi Verify).
+const (
+	memoryKiB   = 19 * 1024
+	iterations  = 2
+	parallelism = 1
+	saltLength  = 16
+	keyLength   = 32
+)
+
+// ErrMalformedHash indica un hash salvato che non è nel formato PHC di argon2id
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// Hash calcola l'hash argon2id della password con un sale casuale, nel formato PHC
+// ($argon2id$v=19$m=19456,t=2,p=1$<sale>$<hash>)
+func Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, iterations, memoryKiB, parallelism, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, memoryKiB, iterations, parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify confronta in tempo costante la password con l'hash salvato.
+// rehash indica che la password è corretta ma l'hash usa parametri diversi da quelli attuali e va ricalcolato.
+func Verify(password, encoded string) (match, rehash bool, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false, ErrMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrMalformedHash
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return false, false, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrMalformedHash
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, false, ErrMalformedHash
+	}
+
+	key := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(key, expected) != 1 {
+		return false, false, nil
+	}
+	rehash = memory != memoryKiB || time != iterations || threads != parallelism || len(expected) != keyLength
+	return true, rehash, nil
+}
+
+// dummyHash è l'hash usato da Waste
+var dummyHash, _ = Hash("dummy password")
+
+// Waste esegue una verifica su un hash fittizio, così il login di un utente inesistente richiede
+// lo stesso tempo di quello con una password errata e non rivela quali email sono registrate
+func Waste(password string) {
+	_, _, _ = Verify(password, dummyHash)
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InsertAPIKey salva una nuova API key nel tenant del contesto
+func InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	scope, err := sharedScope(ctx, constants.APIKEYSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	document, err := scope.document(key)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.InsertOne(ctx, document)
+	if err != nil {
+		utils.WithContext().WithField("function", "InsertAPIKey").Errorf("Error inserting API key: %v", err)
+	}
+	return err
+}
+
+// GetAPIKeys restituisce le API key del tenant del contesto, anche revocate e scadute, in ordine di creazione
+func GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	scope, err := sharedScope(ctx, constants.APIKEYSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := scope.collection.Find(ctx, scope.filter(bson.M{}),
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		utils.WithContext().WithField("function", "GetAPIKeys").Errorf("Error finding API keys: %v", err)
+		return nil, err
+	}
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByID restituisce una API key del tenant del contesto; mongo.ErrNoDocuments se non esiste
+func GetAPIKeyByID(ctx context.Context, id string) (*models.APIKey, error) {
+	scope, err := sharedScope(ctx, constants.APIKEYSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	var key models.APIKey
+	err = scope.collection.FindOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: id})).Decode(&key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RevokeAPIKey registra la revoca di una API key del tenant del contesto; mongo.ErrNoDocuments se non esiste
+func RevokeAPIKey(ctx context.Context, id string, revokedAt time.Time) error {
+	scope, err := sharedScope(ctx, constants.APIKEYSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	result, err := scope.collection.UpdateOne(ctx,
+		scope.filter(bson.M{constants.DOCUMENT_ID: id}),
+		bson.M{constants.SET: bson.M{"revokedAt": revokedAt}})
+	if err != nil {
+		utils.WithContext().WithField("function", "RevokeAPIKey").Errorf("Error revoking API key: %v", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// FindAPIKeyForAuth restituisce una API key di qualsiasi tenant per verificarla durante l'autenticazione,
+// che avviene prima di sapere a quale tenant appartiene; mongo.ErrNoDocuments se non esiste
+func FindAPIKeyForAuth(ctx context.Context, id string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := config.GetDatabase().Collection(constants.APIKEYSCOLLECTION).
+		FindOne(ctx, bson.M{constants.DOCUMENT_ID: id}).Decode(&key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// TouchAPIKey aggiorna l'ultimo utilizzo di una API key
+func TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := config.GetDatabase().Collection(constants.APIKEYSCOLLECTION).UpdateOne(ctx,
+		bson.M{constants.DOCUMENT_ID: id},
+		bson.M{constants.SET: bson.M{"lastUsedAt": usedAt}})
+	return err
+}
+
+// ensureAPIKeyIndexes crea l'indice per elencare le API key di un tenant; le ricerche per ID usano _id
+func ensureAPIKeyIndexes(ctx context.Context, db *mongo.Database) error {
+	if !tenancy.Enabled() {
+		return nil
+	}
+	_, err := db.Collection(constants.APIKEYSCOLLECTION).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: tenancy.Field, Value: 1}, {Key: "createdAt", Value: 1}},
+		Options: options.Index().SetName("tenantId_createdAt"),
+	})
+	return err
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// L'audit log è append-only: il repository espone solo inserimento e lettura.
+
+// InsertAuditEntries salva le voci dell'audit log.
+// Va chiamata con il contesto della transazione che esegue la modifica, così voce e modifica sono atomiche.
+func InsertAuditEntries(ctx context.Context, entries []models.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	scope, err := userDataScope(ctx, constants.AUDITCOLLECTION)
+	if err != nil {
+		return err
+	}
+	documents := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		documents[i] = entry
+	}
+	if documents, err = scope.documents(documents); err != nil {
+		return err
+	}
+	_, err = scope.collection.InsertMany(ctx, documents)
+	if err != nil {
+		utils.WithContext().WithField("function", "InsertAuditEntries").Errorf("Error inserting audit entries: %v", err)
+	}
+	return err
+}
+
+// FindAuditEntries restituisce le voci che soddisfano la query, dalla più recente, e il loro numero totale
+func FindAuditEntries(ctx context.Context, query models.AuditQuery, skip, limit int64) ([]models.AuditEntry, int64, error) {
+	scope, err := userDataScope(ctx, constants.AUDITCOLLECTION)
+	if err != nil {
+		return nil, 0, err
+	}
+	collection := scope.collection
+
+	filter := scope.filter(bson.M{})
+	if query.UserID != "" {
+		filter["userId"] = query.UserID
+	}
+	if query.Actor != "" {
+		filter["actor"] = query.Actor
+	}
+	timestamp := bson.M{}
+	if !query.From.IsZero() {
+		timestamp["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		timestamp["$lt"] = query.To
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: constants.DOCUMENT_ID, Value: -1}}).SetSkip(skip).SetLimit(limit))
+	if err != nil {
+		utils.WithContext().WithField("function", "FindAuditEntries").Errorf("Error finding audit entries: %v", err)
+		return nil, 0, err
+	}
+	entries := []models.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// ensureAuditIndexes crea gli indici per lo storico di un utente e per le ricerche per attore e periodo,
+// preceduti da tenantId con shared (TENANCY_MODE=shared)
+func ensureAuditIndexes(ctx context.Context, collection *mongo.Collection, shared bool) error {
+	keys := []bson.D{
+		{{Key: "userId", Value: 1}, {Key: "timestamp", Value: -1}},
+		{{Key: "actor", Value: 1}, {Key: "timestamp", Value: -1}},
+		{{Key: "timestamp", Value: -1}},
+	}
+	indexes := make([]mongo.IndexModel, len(keys))
+	for i, key := range keys {
+		if shared {
+			key = append(bson.D{{Key: tenancy.Field, Value: 1}}, key...)
+		}
+		indexes[i] = mongo.IndexModel{Keys: key}
+	}
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	return err
+}
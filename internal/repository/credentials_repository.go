@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"myapp/internal/models"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Le credenziali sono dati degli utenti: con la tenancy sono isolate come la collezione users (vedi userDataScope)
+
+// FindCredentials restituisce le credenziali degli utenti indicati che hanno una password
+// (un utente può avere solo l'email verificata, vedi SetEmailVerified)
+func FindCredentials(ctx context.Context, userIDs []string) ([]models.Credentials, error) {
+	scope, err := userDataScope(ctx, constants.CREDENTIALSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := scope.collection.Find(ctx, scope.filter(bson.M{
+		constants.DOCUMENT_ID: bson.M{"$in": userIDs},
+		"passwordHash":        bson.M{"$exists": true},
+	}))
+	if err != nil {
+		utils.WithContext().WithField("function", "FindCredentials").Errorf("Error finding credentials: %v", err)
+		return nil, err
+	}
+	credentials := []models.Credentials{}
+	if err := cursor.All(ctx, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// SetPasswordHash salva l'hash della password di un utente, creando le credenziali se non esistono,
+// e azzera i tentativi falliti e il blocco
+func SetPasswordHash(ctx context.Context, userID, hash string, changedAt time.Time) error {
+	scope, err := userDataScope(ctx, constants.CREDENTIALSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	update := bson.M{
+		constants.SET: bson.M{"passwordHash": hash, "passwordChangedAt": changedAt, "failedAttempts": 0},
+		"$unset":      bson.M{"lockedUntil": ""},
+	}
+	// Con l'upsert il tenantId del filtro è copiato nel nuovo documento
+	_, err = scope.collection.UpdateOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: userID}), update,
+		options.Update().SetUpsert(true))
+	if err != nil {
+		utils.WithContext().WithField("function", "SetPasswordHash").Errorf("Error saving password hash: %v", err)
+	}
+	return err
+}
+
+// SetEmailVerified registra la verifica dell'indirizzo email di un utente, creando le credenziali se non esistono
+func SetEmailVerified(ctx context.Context, userID, email string, verifiedAt time.Time) error {
+	scope, err := userDataScope(ctx, constants.CREDENTIALSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.UpdateOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: userID}),
+		bson.M{constants.SET: bson.M{"verifiedEmail": email, "emailVerifiedAt": verifiedAt}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		utils.WithContext().WithField("function", "SetEmailVerified").Errorf("Error saving email verification: %v", err)
+	}
+	return err
+}
+
+// RecordLoginFailure incrementa i tentativi falliti di un utente; raggiunti maxAttempts blocca il login fino a
+// lockedUntil e riparte da zero. Restituisce le credenziali aggiornate.
+func RecordLoginFailure(ctx context.Context, userID string, maxAttempts int, lockedUntil time.Time) (*models.Credentials, error) {
+	scope, err := userDataScope(ctx, constants.CREDENTIALSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	// Pipeline di aggiornamento: incremento e blocco sono decisi atomicamente anche con tentativi concorrenti
+	attempts := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failedAttempts", 0}}, 1}}
+	locked := bson.M{"$gte": bson.A{attempts, maxAttempts}}
+	update := mongo.Pipeline{{{Key: constants.SET, Value: bson.M{
+		"failedAttempts": bson.M{"$cond": bson.A{locked, 0, attempts}},
+		"lockedUntil":    bson.M{"$cond": bson.A{locked, lockedUntil, "$lockedUntil"}},
+	}}}}
+
+	var credentials models.Credentials
+	err = scope.collection.FindOneAndUpdate(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: userID}), update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&credentials)
+	if err != nil {
+		utils.WithContext().WithField("function", "RecordLoginFailure").Errorf("Error recording login failure: %v", err)
+		return nil, err
+	}
+	return &credentials, nil
+}
+
+// ResetLoginFailures azzera i tentativi falliti dopo un login riuscito
+func ResetLoginFailures(ctx context.Context, userID string) error {
+	scope, err := userDataScope(ctx, constants.CREDENTIALSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.UpdateOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: userID}),
+		bson.M{constants.SET: bson.M{"failedAttempts": 0}, "$unset": bson.M{"lockedUntil": ""}})
+	return err
+}
+
+// DeleteCredentials elimina le credenziali degli utenti indicati (utenti eliminati)
+func DeleteCredentials(ctx context.Context, userIDs []string) error {
+	scope, err := userDataScope(ctx, constants.CREDENTIALSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.DeleteMany(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: bson.M{"$in": userIDs}}))
+	if err != nil {
+		utils.WithContext().WithField("function", "DeleteCredentials").Errorf("Error deleting credentials: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InsertEmailToken salva un token inviato per email nel tenant del contesto
+func InsertEmailToken(ctx context.Context, token models.EmailToken) error {
+	scope, err := sharedScope(ctx, constants.EMAILTOKENSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	document, err := scope.document(token)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.InsertOne(ctx, document)
+	if err != nil {
+		utils.WithContext().WithField("function", "InsertEmailToken").Errorf("Error inserting email token: %v", err)
+	}
+	return err
+}
+
+// ConsumeEmailToken segna come usato un token ancora valido e lo restituisce; la condizione sul documento
+// garantisce che con richieste concorrenti il token sia usato una sola volta.
+// mongo.ErrNoDocuments se il token non esiste, ha un altro scopo, è scaduto o è già stato usato.
+func ConsumeEmailToken(ctx context.Context, id, purpose string, usedAt time.Time) (*models.EmailToken, error) {
+	scope, err := sharedScope(ctx, constants.EMAILTOKENSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	filter := scope.filter(bson.M{
+		constants.DOCUMENT_ID: id,
+		"purpose":             purpose,
+		"usedAt":              bson.M{"$exists": false},
+		"expiresAt":           bson.M{"$gt": usedAt},
+	})
+	var token models.EmailToken
+	err = scope.collection.FindOneAndUpdate(ctx, filter, bson.M{constants.SET: bson.M{"usedAt": usedAt}}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// InvalidateEmailTokens segna come usati i token ancora validi di un utente per lo scopo indicato
+// (un nuovo token sostituisce i precedenti; un reset riuscito invalida gli altri link)
+func InvalidateEmailTokens(ctx context.Context, userID, purpose string, at time.Time) error {
+	scope, err := sharedScope(ctx, constants.EMAILTOKENSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	filter := scope.filter(bson.M{"userId": userID, "purpose": purpose, "usedAt": bson.M{"$exists": false}})
+	_, err = scope.collection.UpdateMany(ctx, filter, bson.M{constants.SET: bson.M{"usedAt": at}})
+	if err != nil {
+		utils.WithContext().WithField("function", "InvalidateEmailTokens").Errorf("Error invalidating email tokens: %v", err)
+	}
+	return err
+}
+
+// EmailTokenIssuedSince indica se all'utente è stato inviato un token per lo scopo indicato dopo since
+func EmailTokenIssuedSince(ctx context.Context, userID, purpose string, since time.Time) (bool, error) {
+	scope, err := sharedScope(ctx, constants.EMAILTOKENSCOLLECTION)
+	if err != nil {
+		return false, err
+	}
+	count, err := scope.collection.CountDocuments(ctx,
+		scope.filter(bson.M{"userId": userID, "purpose": purpose, "createdAt": bson.M{"$gt": since}}),
+		options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// DeleteUserEmailTokens elimina i token degli utenti indicati (utenti eliminati)
+func DeleteUserEmailTokens(ctx context.Context, userIDs []string) error {
+	scope, err := sharedScope(ctx, constants.EMAILTOKENSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.DeleteMany(ctx, scope.filter(bson.M{"userId": bson.M{"$in": userIDs}}))
+	return err
+}
+
+// ensureEmailTokenIndexes crea l'indice per trovare i token di un utente e il TTL che elimina i token scaduti
+func ensureEmailTokenIndexes(ctx context.Context, db *mongo.Database) error {
+	keys := bson.D{}
+	if tenancy.Enabled() {
+		keys = append(keys, bson.E{Key: tenancy.Field, Value: 1})
+	}
+	keys = append(keys, bson.E{Key: "userId", Value: 1}, bson.E{Key: "purpose", Value: 1}, bson.E{Key: "createdAt", Value: -1})
+	_, err := db.Collection(constants.EMAILTOKENSCOLLECTION).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    keys,
+			Options: options.Index().SetName("userId_purpose_createdAt"),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
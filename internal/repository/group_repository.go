@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// I gruppi e le appartenenze sono dati degli utenti: con la tenancy sono isolati come la collezione users (vedi userDataScope)
+
+// InsertGroup salva un nuovo gruppo; errore di chiave duplicata se esiste già un gruppo con lo stesso nome
+func InsertGroup(ctx context.Context, group models.Group) error {
+	scope, err := userDataScope(ctx, constants.GROUPSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	document, err := scope.document(group)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.InsertOne(ctx, document)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		utils.WithContext().WithField("function", "InsertGroup").Errorf("Error inserting group: %v", err)
+	}
+	return err
+}
+
+// GetGroups restituisce una pagina dei gruppi in ordine di nome e il numero totale di gruppi
+func GetGroups(ctx context.Context, skip, limit int64) ([]models.Group, int64, error) {
+	scope, err := userDataScope(ctx, constants.GROUPSCOLLECTION)
+	if err != nil {
+		return nil, 0, err
+	}
+	filter := scope.filter(bson.M{})
+	total, err := scope.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := scope.collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetSkip(skip).SetLimit(limit))
+	if err != nil {
+		utils.WithContext().WithField("function", "GetGroups").Errorf("Error finding groups: %v", err)
+		return nil, 0, err
+	}
+	groups := []models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+// GetGroupByID restituisce un gruppo; mongo.ErrNoDocuments se non esiste
+func GetGroupByID(ctx context.Context, id string) (*models.Group, error) {
+	scope, err := userDataScope(ctx, constants.GROUPSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	var group models.Group
+	err = scope.collection.FindOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: id})).Decode(&group)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// TouchGroup incrementa il campo membershipVersion del gruppo; mongo.ErrNoDocuments se non esiste.
+// In una transazione è una scrittura sul documento: un'eliminazione concorrente del gruppo va in conflitto
+// invece di passare inosservata come accadrebbe con una semplice lettura.
+func TouchGroup(ctx context.Context, id string) error {
+	scope, err := userDataScope(ctx, constants.GROUPSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	result, err := scope.collection.UpdateOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: id}),
+		bson.M{"$inc": bson.M{"membershipVersion": 1}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// ReplaceGroup sostituisce un gruppo esistente; mongo.ErrNoDocuments se non esiste,
+// errore di chiave duplicata se il nuovo nome è già usato da un altro gruppo
+func ReplaceGroup(ctx context.Context, group models.Group) error {
+	scope, err := userDataScope(ctx, constants.GROUPSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	document, err := scope.document(group)
+	if err != nil {
+		return err
+	}
+	result, err := scope.collection.ReplaceOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: group.ID}), document)
+	if err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			utils.WithContext().WithField("function", "ReplaceGroup").Errorf("Error replacing group: %v", err)
+		}
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteGroupByID elimina un gruppo e le sue appartenenze; mongo.ErrNoDocuments se non esiste.
+// Va eseguita in una transazione, così i membri non restano associati a un gruppo eliminato.
+func DeleteGroupByID(ctx context.Context, id string) error {
+	groups, err := userDataScope(ctx, constants.GROUPSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	members, err := userDataScope(ctx, constants.GROUPMEMBERSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	result, err := groups.collection.DeleteOne(ctx, groups.filter(bson.M{constants.DOCUMENT_ID: id}))
+	if err != nil {
+		utils.WithContext().WithField("function", "DeleteGroupByID").Errorf("Error deleting group: %v", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = members.collection.DeleteMany(ctx, members.filter(bson.M{"groupId": id}))
+	return err
+}
+
+// InsertGroupMembership aggiunge un utente a un gruppo; errore di chiave duplicata se ne è già membro
+func InsertGroupMembership(ctx context.Context, membership models.GroupMembership) error {
+	scope, err := userDataScope(ctx, constants.GROUPMEMBERSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	document, err := scope.document(membership)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.InsertOne(ctx, document)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		utils.WithContext().WithField("function", "InsertGroupMembership").Errorf("Error inserting group membership: %v", err)
+	}
+	return err
+}
+
+// SetGroupMemberRole cambia il ruolo di un membro e restituisce l'appartenenza aggiornata;
+// mongo.ErrNoDocuments se l'utente non è membro del gruppo
+func SetGroupMemberRole(ctx context.Context, groupID, userID, role string) (*models.GroupMembership, error) {
+	scope, err := userDataScope(ctx, constants.GROUPMEMBERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	var membership models.GroupMembership
+	err = scope.collection.FindOneAndUpdate(ctx, scope.filter(bson.M{"groupId": groupID, "userId": userID}),
+		bson.M{constants.SET: bson.M{"role": role}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&membership)
+	if err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+// DeleteGroupMembership rimuove un utente da un gruppo; mongo.ErrNoDocuments se non ne è membro
+func DeleteGroupMembership(ctx context.Context, groupID, userID string) error {
+	scope, err := userDataScope(ctx, constants.GROUPMEMBERSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	result, err := scope.collection.DeleteOne(ctx, scope.filter(bson.M{"groupId": groupID, "userId": userID}))
+	if err != nil {
+		utils.WithContext().WithField("function", "DeleteGroupMembership").Errorf("Error deleting group membership: %v", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetGroupMembers restituisce una pagina dei membri di un gruppo in ordine di ID utente, eventualmente solo con il ruolo indicato
+func GetGroupMembers(ctx context.Context, groupID, role string, skip, limit int64) ([]models.GroupMembership, int64, error) {
+	filter := bson.M{"groupId": groupID}
+	if role != "" {
+		filter["role"] = role
+	}
+	return findGroupMemberships(ctx, filter, bson.D{{Key: "userId", Value: 1}}, skip, limit)
+}
+
+// GetUserGroupMemberships restituisce una pagina delle appartenenze di un utente in ordine di ID del gruppo
+func GetUserGroupMemberships(ctx context.Context, userID string, skip, limit int64) ([]models.GroupMembership, int64, error) {
+	return findGroupMemberships(ctx, bson.M{"userId": userID}, bson.D{{Key: "groupId", Value: 1}}, skip, limit)
+}
+
+func findGroupMemberships(ctx context.Context, filter bson.M, sort bson.D, skip, limit int64) ([]models.GroupMembership, int64, error) {
+	scope, err := userDataScope(ctx, constants.GROUPMEMBERSCOLLECTION)
+	if err != nil {
+		return nil, 0, err
+	}
+	filter = scope.filter(filter)
+	total, err := scope.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := scope.collection.Find(ctx, filter, options.Find().SetSort(sort).SetSkip(skip).SetLimit(limit))
+	if err != nil {
+		utils.WithContext().WithField("function", "findGroupMemberships").Errorf("Error finding group memberships: %v", err)
+		return nil, 0, err
+	}
+	memberships := []models.GroupMembership{}
+	if err := cursor.All(ctx, &memberships); err != nil {
+		return nil, 0, err
+	}
+	return memberships, total, nil
+}
+
+// DeleteUserGroupMemberships elimina le appartenenze degli utenti indicati (utenti eliminati)
+func DeleteUserGroupMemberships(ctx context.Context, userIDs []string) error {
+	scope, err := userDataScope(ctx, constants.GROUPMEMBERSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.DeleteMany(ctx, scope.filter(bson.M{"userId": bson.M{"$in": userIDs}}))
+	return err
+}
+
+// ensureGroupIndexes crea l'indice univoco sul nome dei gruppi e quelli delle appartenenze, per gruppo e per utente;
+// con shared gli indici sono preceduti da tenantId (i nomi sono univoci in ogni tenant)
+func ensureGroupIndexes(ctx context.Context, groups, members *mongo.Collection, shared bool) error {
+	withTenant := func(keys ...bson.E) bson.D {
+		if shared {
+			return append(bson.D{{Key: tenancy.Field, Value: 1}}, keys...)
+		}
+		return keys
+	}
+	_, err := groups.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    withTenant(bson.E{Key: "name", Value: 1}),
+		Options: options.Index().SetName("name_unique").SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = members.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    withTenant(bson.E{Key: "groupId", Value: 1}, bson.E{Key: "userId", Value: 1}),
+			Options: options.Index().SetName("groupId_userId").SetUnique(true),
+		},
+		{
+			Keys:    withTenant(bson.E{Key: "userId", Value: 1}, bson.E{Key: "groupId", Value: 1}),
+			Options: options.Index().SetName("userId_groupId"),
+		},
+	})
+	return err
+}
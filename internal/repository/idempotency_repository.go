@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdempotencyStore memorizza le chiavi di idempotenza e le risposte associate.
+// Acquire restituisce nil se la chiave è stata acquisita dal chiamante, altrimenti il record già esistente.
+// Complete e Release agiscono solo se la chiave è ancora in corso e detenuta da owner (record.Owner di Acquire):
+// un'esecuzione che ha superato il lock timeout non sovrascrive la chiave acquisita nel frattempo da un retry.
+type IdempotencyStore interface {
+	Acquire(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	Complete(ctx context.Context, key, owner string, statusCode int, header http.Header, body []byte) error
+	Release(ctx context.Context, key, owner string) error
+}
+
+// ErrIdempotencyLockLost indica che la chiave non è più detenuta dall'esecuzione che prova a completarla
+var ErrIdempotencyLockLost = errors.New("idempotency key no longer owned by this request")
+
+// NewIdempotencyStore crea lo store indicato da kind ("mongo" o "memory")
+func NewIdempotencyStore(kind string) IdempotencyStore {
+	if kind == "memory" {
+		return NewMemoryIdempotencyStore()
+	}
+	return NewMongoIdempotencyStore(config.GetDatabase())
+}
+
+// MongoIdempotencyStore salva le chiavi su MongoDB; la scadenza è gestita dall'indice TTL su expiresAt
+type MongoIdempotencyStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoIdempotencyStore crea uno store basato sulla collezione idempotency_keys
+func NewMongoIdempotencyStore(db *mongo.Database) *MongoIdempotencyStore {
+	return &MongoIdempotencyStore{collection: db.Collection(constants.IDEMPOTENCYCOLLECTION)}
+}
+
+// Acquire inserisce il record in stato in_progress sfruttando l'unicità di _id per gestire le richieste concorrenti
+func (s *MongoIdempotencyStore) Acquire(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	key, err := tenantKey(ctx, record.Key)
+	if err != nil {
+		return nil, err
+	}
+	record.Key = key
+	return s.acquire(ctx, record)
+}
+
+// acquire esegue Acquire con la chiave già qualificata dal tenant
+func (s *MongoIdempotencyStore) acquire(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	log := utils.WithContext().WithField("function", "IdempotencyAcquire")
+
+	_, err := s.collection.InsertOne(ctx, record)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		log.Errorf("Error acquiring idempotency key: %v", err)
+		return nil, err
+	}
+
+	// La chiave esiste già: se è scaduta (il TTL monitor di MongoDB gira ogni 60s) o abbandonata la si sostituisce
+	now := time.Now()
+	filter := bson.M{
+		constants.DOCUMENT_ID: record.Key,
+		"$or": bson.A{
+			bson.M{"expiresAt": bson.M{"$lte": now}},
+			bson.M{"status": constants.IDEMPOTENCY_IN_PROGRESS, "lockedUntil": bson.M{"$lte": now}},
+		},
+	}
+	replaced, err := s.collection.ReplaceOne(ctx, filter, record)
+	if err != nil {
+		log.Errorf("Error replacing expired idempotency key: %v", err)
+		return nil, err
+	}
+	if replaced.MatchedCount == 1 {
+		return nil, nil
+	}
+
+	var existing models.IdempotencyRecord
+	err = s.collection.FindOne(ctx, bson.M{constants.DOCUMENT_ID: record.Key}).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Il record è stato rilasciato nel frattempo: si riprova l'acquisizione
+		return s.acquire(ctx, record)
+	}
+	if err != nil {
+		log.Errorf("Error loading idempotency key: %v", err)
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// Complete salva la risposta e marca la chiave come completata; ErrIdempotencyLockLost se owner non la detiene più
+func (s *MongoIdempotencyStore) Complete(ctx context.Context, key, owner string, statusCode int, header http.Header, body []byte) error {
+	key, err := tenantKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{constants.DOCUMENT_ID: key, "owner": owner, "status": constants.IDEMPOTENCY_IN_PROGRESS}
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{constants.SET: bson.M{
+		"status":     constants.IDEMPOTENCY_COMPLETED,
+		"statusCode": statusCode,
+		"header":     header,
+		"body":       body,
+	}})
+	if err != nil {
+		utils.WithContext().WithField("function", "IdempotencyComplete").Errorf("Error completing idempotency key: %v", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdempotencyLockLost
+	}
+	return nil
+}
+
+// Release elimina una chiave in corso detenuta da owner, permettendo al client di ritentare la richiesta
+func (s *MongoIdempotencyStore) Release(ctx context.Context, key, owner string) error {
+	key, err := tenantKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	_, err = s.collection.DeleteOne(ctx, bson.M{constants.DOCUMENT_ID: key, "owner": owner, "status": constants.IDEMPOTENCY_IN_PROGRESS})
+	if err != nil {
+		utils.WithContext().WithField("function", "IdempotencyRelease").Errorf("Error releasing idempotency key: %v", err)
+	}
+	return err
+}
+
+// ensureIdempotencyIndexes crea l'indice TTL che rimuove le chiavi scadute
+func ensureIdempotencyIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(constants.IDEMPOTENCYCOLLECTION).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// tenantKey qualifica la chiave con il tenant del contesto: le chiavi sono scelte dai client,
+// che in tenant diversi possono usare la stessa
+func tenantKey(ctx context.Context, key string) (string, error) {
+	tenant, err := tenancy.Require(ctx)
+	if err != nil {
+		return "", err
+	}
+	return tenancy.Key(tenant, key), nil
+}
+
+// MemoryIdempotencyStore mantiene le chiavi in memoria, utile per i test e per le esecuzioni locali
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]models.IdempotencyRecord
+}
+
+// NewMemoryIdempotencyStore crea uno store in memoria vuoto
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]models.IdempotencyRecord)}
+}
+
+// Acquire registra la chiave se assente, scaduta o abbandonata
+func (s *MemoryIdempotencyStore) Acquire(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	key, err := tenantKey(ctx, record.Key)
+	if err != nil {
+		return nil, err
+	}
+	record.Key = key
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	existing, ok := s.records[record.Key]
+	if ok && now.Before(existing.ExpiresAt) &&
+		(existing.Status == constants.IDEMPOTENCY_COMPLETED || now.Before(existing.LockedUntil)) {
+		return &existing, nil
+	}
+	s.records[record.Key] = record
+	return nil, nil
+}
+
+// Complete salva la risposta e marca la chiave come completata; ErrIdempotencyLockLost se owner non la detiene più
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key, owner string, statusCode int, header http.Header, body []byte) error {
+	key, err := tenantKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok || record.Owner != owner || record.Status != constants.IDEMPOTENCY_IN_PROGRESS {
+		return ErrIdempotencyLockLost
+	}
+	record.Status = constants.IDEMPOTENCY_COMPLETED
+	record.StatusCode = statusCode
+	record.Header = header
+	record.Body = body
+	s.records[key] = record
+	return nil
+}
+
+// Release elimina una chiave in corso detenuta da owner
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key, owner string) error {
+	key, err := tenantKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[key]; ok && record.Owner == owner && record.Status == constants.IDEMPOTENCY_IN_PROGRESS {
+		delete(s.records, key)
+	}
+	return nil
+}
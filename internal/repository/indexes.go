@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"myapp/internal/config"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+)
+
+// EnsureIndexes crea (se non presenti) gli indici necessari alle collezioni dell'applicazione
+func EnsureIndexes(ctx context.Context) error {
+	log := utils.WithContext().WithField("function", "EnsureIndexes")
+	db := config.GetDatabase()
+	// Con TENANCY_MODE=shared gli indici dei dati degli utenti sono preceduti da tenantId;
+	// nelle modalità collection e database quelli delle collezioni di ogni tenant sono creati al primo utilizzo
+	shared := tenancy.Mode() == tenancy.ModeShared
+
+	users := db.Collection(constants.USERSCOLLECTION)
+	if err := ensureUserIndexes(ctx, users, shared); err != nil {
+		log.Errorf("Error creating user indexes: %v", err)
+		return err
+	}
+	if shared {
+		if err := ensureUserSortIndexes(ctx, users, true); err != nil {
+			log.Errorf("Error creating user sort indexes: %v", err)
+			return err
+		}
+	}
+	if err := ensureGroupIndexes(ctx, db.Collection(constants.GROUPSCOLLECTION), db.Collection(constants.GROUPMEMBERSCOLLECTION), shared); err != nil {
+		log.Errorf("Error creating group indexes: %v", err)
+		return err
+	}
+	if err := ensureIdempotencyIndexes(ctx, db); err != nil {
+		log.Errorf("Error creating idempotency indexes: %v", err)
+		return err
+	}
+	if err := ensureOutboxIndexes(ctx, db); err != nil {
+		log.Errorf("Error creating outbox indexes: %v", err)
+		return err
+	}
+	if err := ensureWebhookIndexes(ctx, db); err != nil {
+		log.Errorf("Error creating webhook indexes: %v", err)
+		return err
+	}
+	if err := ensureAuditIndexes(ctx, db.Collection(constants.AUDITCOLLECTION), shared); err != nil {
+		log.Errorf("Error creating audit indexes: %v", err)
+		return err
+	}
+	if err := ensureAPIKeyIndexes(ctx, db); err != nil {
+		log.Errorf("Error creating API key indexes: %v", err)
+		return err
+	}
+	if err := ensureRefreshTokenIndexes(ctx, db); err != nil {
+		log.Errorf("Error creating refresh token indexes: %v", err)
+		return err
+	}
+	if err := ensureEmailTokenIndexes(ctx, db); err != nil {
+		log.Errorf("Error creating email token indexes: %v", err)
+		return err
+	}
+	log.Info("Indexes ensured")
+	return nil
+}
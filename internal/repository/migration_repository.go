@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrationLockID è l'_id dell'unico documento di lock delle migrazioni
+const migrationLockID = "migrations"
+
+// ErrMigrationLocked indica che un'altra istanza sta eseguendo le migrazioni
+var ErrMigrationLocked = errors.New("migrations are locked by another instance")
+
+// GetAppliedMigrations restituisce le migrazioni applicate, ordinate per versione
+func GetAppliedMigrations(ctx context.Context) ([]models.AppliedMigration, error) {
+	cursor, err := config.GetDatabase().Collection(constants.MIGRATIONSCOLLECTION).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.M{constants.DOCUMENT_ID: 1}))
+	if err != nil {
+		utils.WithContext().WithField("function", "GetAppliedMigrations").Errorf("Error finding applied migrations: %v", err)
+		return nil, err
+	}
+	applied := []models.AppliedMigration{}
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// InsertAppliedMigration registra una migrazione come applicata
+func InsertAppliedMigration(ctx context.Context, migration models.AppliedMigration) error {
+	_, err := config.GetDatabase().Collection(constants.MIGRATIONSCOLLECTION).InsertOne(ctx, migration)
+	return err
+}
+
+// DeleteAppliedMigration rimuove la registrazione di una migrazione annullata
+func DeleteAppliedMigration(ctx context.Context, version int) error {
+	_, err := config.GetDatabase().Collection(constants.MIGRATIONSCOLLECTION).DeleteOne(ctx, bson.M{constants.DOCUMENT_ID: version})
+	return err
+}
+
+// AcquireMigrationLock prende (o rinnova, se owner lo possiede già) il lock delle migrazioni per la durata indicata.
+// Un lock scaduto, ad esempio per un'istanza terminata durante una migrazione, può essere preso da un altro owner.
+// Restituisce ErrMigrationLocked se il lock è di un'altra istanza.
+func AcquireMigrationLock(ctx context.Context, owner string, ttl time.Duration) error {
+	now := time.Now()
+	filter := bson.M{
+		constants.DOCUMENT_ID: migrationLockID,
+		"$or":                 bson.A{bson.M{"owner": owner}, bson.M{"lockedUntil": bson.M{"$lte": now}}},
+	}
+	update := bson.M{constants.SET: bson.M{"owner": owner, "lockedUntil": now.Add(ttl), "acquiredAt": now}}
+
+	_, err := config.GetDatabase().Collection(constants.MIGRATIONLOCKSCOLLECTION).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	// Se il lock è di un altro owner il filtro non trova il documento e l'upsert viola l'_id esistente
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrMigrationLocked
+	}
+	if err != nil {
+		utils.WithContext().WithField("function", "AcquireMigrationLock").Errorf("Error acquiring migration lock: %v", err)
+	}
+	return err
+}
+
+// ReleaseMigrationLock rilascia il lock se è ancora di owner
+func ReleaseMigrationLock(ctx context.Context, owner string) error {
+	_, err := config.GetDatabase().Collection(constants.MIGRATIONLOCKSCOLLECTION).DeleteOne(ctx,
+		bson.M{constants.DOCUMENT_ID: migrationLockID, "owner": owner})
+	return err
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InsertOutboxEvents salva gli eventi nell'outbox in stato pending.
+// Va chiamata con il contesto della transazione che esegue la modifica, così evento e modifica sono atomici.
+func InsertOutboxEvents(ctx context.Context, events []models.UserEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	documents := make([]interface{}, len(events))
+	for i, event := range events {
+		documents[i] = models.OutboxEntry{
+			UserEvent:     event,
+			Status:        constants.OUTBOX_PENDING,
+			NextAttemptAt: event.OccurredAt,
+		}
+	}
+	_, err := config.GetDatabase().Collection(constants.OUTBOXCOLLECTION).InsertMany(ctx, documents)
+	if err != nil {
+		utils.WithContext().WithField("function", "InsertOutboxEvents").Errorf("Error inserting outbox events: %v", err)
+	}
+	return err
+}
+
+// ErrOutboxLeaseLost indica che il lease dell'evento è scaduto ed è stato preso in carico da un altro relay
+var ErrOutboxLeaseLost = errors.New("outbox entry lease lost")
+
+// ClaimOutboxEntry prende in carico il prossimo evento da pubblicare per owner, bloccandolo per la durata del lease.
+// Un evento in processing con lease scaduto (relay terminato durante la pubblicazione) viene ripreso.
+// Restituisce nil se non ci sono eventi da pubblicare.
+func ClaimOutboxEntry(ctx context.Context, lease time.Duration, owner string) (*models.OutboxEntry, error) {
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": constants.OUTBOX_PENDING, "nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{"status": constants.OUTBOX_PROCESSING, "lockedUntil": bson.M{"$lte": now}},
+	}}
+	update := bson.M{constants.SET: bson.M{"status": constants.OUTBOX_PROCESSING, "lockedUntil": now.Add(lease), "owner": owner}}
+
+	var entry models.OutboxEntry
+	err := config.GetDatabase().Collection(constants.OUTBOXCOLLECTION).FindOneAndUpdate(
+		ctx, filter, update,
+		options.FindOneAndUpdate().SetSort(bson.M{"occurredAt": 1}).SetReturnDocument(options.After),
+	).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		utils.WithContext().WithField("function", "ClaimOutboxEntry").Errorf("Error claiming outbox entry: %v", err)
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// MarkOutboxPublished segna l'evento come pubblicato se owner ne detiene ancora il lease; ErrOutboxLeaseLost altrimenti
+func MarkOutboxPublished(ctx context.Context, id, owner string) error {
+	return updateLeasedOutboxEntry(ctx, id, owner, bson.M{"status": constants.OUTBOX_PUBLISHED, "publishedAt": time.Now()})
+}
+
+// MarkOutboxRetry registra un tentativo fallito se owner detiene ancora il lease dell'evento (ErrOutboxLeaseLost altrimenti);
+// se dead è true l'evento non verrà più ritentato
+func MarkOutboxRetry(ctx context.Context, id, owner string, attempts int, nextAttemptAt time.Time, lastError string, dead bool) error {
+	status := constants.OUTBOX_PENDING
+	if dead {
+		status = constants.OUTBOX_FAILED
+	}
+	return updateLeasedOutboxEntry(ctx, id, owner, bson.M{
+		"status":        status,
+		"attempts":      attempts,
+		"nextAttemptAt": nextAttemptAt,
+		"lastError":     lastError,
+	})
+}
+
+// updateLeasedOutboxEntry aggiorna un evento in processing solo se il lease è ancora di owner: un relay il cui lease
+// è scaduto non deve sovrascrivere l'esito del relay che ha ripreso l'evento
+func updateLeasedOutboxEntry(ctx context.Context, id, owner string, set bson.M) error {
+	filter := bson.M{constants.DOCUMENT_ID: id, "status": constants.OUTBOX_PROCESSING, "owner": owner}
+	result, err := config.GetDatabase().Collection(constants.OUTBOXCOLLECTION).UpdateOne(ctx, filter,
+		bson.M{constants.SET: set, "$unset": bson.M{"owner": "", "lockedUntil": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrOutboxLeaseLost
+	}
+	return nil
+}
+
+// WatchOutboxEvents segue con un change stream gli eventi inseriti nell'outbox da tutte le repliche, a partire
+// da resumeAfter (dal momento dell'apertura se nil), e chiama handle per ognuno in ordine di commit.
+// Termina alla cancellazione del contesto o al primo errore e restituisce il resume token dell'ultimo evento
+// gestito, da passare alla chiamata successiva per riprendere senza perdere eventi.
+func WatchOutboxEvents(ctx context.Context, resumeAfter bson.Raw, handle func(models.UserEvent)) (bson.Raw, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	opts := options.ChangeStream()
+	if resumeAfter != nil {
+		opts.SetResumeAfter(resumeAfter)
+	}
+	changes, err := config.GetDatabase().Collection(constants.OUTBOXCOLLECTION).Watch(ctx, pipeline, opts)
+	if err != nil {
+		return resumeAfter, err
+	}
+	defer changes.Close(context.WithoutCancel(ctx))
+
+	token := resumeAfter
+	for changes.Next(ctx) {
+		var change struct {
+			FullDocument models.OutboxEntry `bson:"fullDocument"`
+		}
+		if err := changes.Decode(&change); err != nil {
+			return token, err
+		}
+		handle(change.FullDocument.UserEvent)
+		token = changes.ResumeToken()
+	}
+	return token, changes.Err()
+}
+
+// ensureOutboxIndexes crea gli indici per la ricerca degli eventi da pubblicare e il TTL di quelli pubblicati
+func ensureOutboxIndexes(ctx context.Context, db *mongo.Database) error {
+	retention := utils.EnvDurationOrDefault("OUTBOX_RETENTION", 7*24*time.Hour)
+	_, err := db.Collection(constants.OUTBOXCOLLECTION).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}},
+			Options: options.Index().SetName("status_nextAttemptAt"),
+		},
+		{
+			// ClaimOutboxEntry ordina gli eventi da prendere in carico per occurredAt
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "occurredAt", Value: 1}},
+			Options: options.Index().SetName("status_occurredAt"),
+		},
+		{
+			Keys:    bson.D{{Key: "publishedAt", Value: 1}},
+			Options: options.Index().SetName("publishedAt_ttl").SetExpireAfterSeconds(int32(retention.Seconds())),
+		},
+	})
+	return err
+}
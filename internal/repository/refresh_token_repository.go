@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InsertRefreshToken salva un refresh token emesso nel tenant del contesto
+func InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	scope, err := sharedScope(ctx, constants.REFRESHTOKENSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	document, err := scope.document(token)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.InsertOne(ctx, document)
+	if err != nil {
+		utils.WithContext().WithField("function", "InsertRefreshToken").Errorf("Error inserting refresh token: %v", err)
+	}
+	return err
+}
+
+// RotateRefreshToken segna come sostituito un refresh token ancora valido e lo restituisce.
+// La condizione sul documento rende il rinnovo atomico: con due richieste concorrenti solo una ottiene il token.
+// mongo.ErrNoDocuments se il token non esiste, è scaduto, revocato o già sostituito.
+func RotateRefreshToken(ctx context.Context, id string, rotatedAt time.Time) (*models.RefreshToken, error) {
+	scope, err := sharedScope(ctx, constants.REFRESHTOKENSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	filter := scope.filter(bson.M{
+		constants.DOCUMENT_ID: id,
+		"rotatedAt":           bson.M{"$exists": false},
+		"revokedAt":           bson.M{"$exists": false},
+		"expiresAt":           bson.M{"$gt": rotatedAt},
+	})
+	var token models.RefreshToken
+	err = scope.collection.FindOneAndUpdate(ctx, filter, bson.M{constants.SET: bson.M{"rotatedAt": rotatedAt}}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetRefreshToken restituisce un refresh token del tenant del contesto; mongo.ErrNoDocuments se non esiste
+func GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	scope, err := sharedScope(ctx, constants.REFRESHTOKENSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	var token models.RefreshToken
+	err = scope.collection.FindOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: id})).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeRefreshTokenFamily revoca tutti i token di una famiglia (logout o riuso di un token già sostituito)
+func RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	return revokeRefreshTokens(ctx, bson.M{"familyId": familyID}, revokedAt)
+}
+
+// RevokeUserRefreshTokens revoca tutti i token di un utente (cambio della password)
+func RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error {
+	return revokeRefreshTokens(ctx, bson.M{"userId": userID}, revokedAt)
+}
+
+// revokeRefreshTokens revoca i token non ancora revocati che corrispondono al filtro
+func revokeRefreshTokens(ctx context.Context, filter bson.M, revokedAt time.Time) error {
+	scope, err := sharedScope(ctx, constants.REFRESHTOKENSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	filter["revokedAt"] = bson.M{"$exists": false}
+	_, err = scope.collection.UpdateMany(ctx, scope.filter(filter), bson.M{constants.SET: bson.M{"revokedAt": revokedAt}})
+	if err != nil {
+		utils.WithContext().WithField("function", "revokeRefreshTokens").Errorf("Error revoking refresh tokens: %v", err)
+	}
+	return err
+}
+
+// DeleteUserRefreshTokens elimina i token degli utenti indicati (utenti eliminati)
+func DeleteUserRefreshTokens(ctx context.Context, userIDs []string) error {
+	scope, err := sharedScope(ctx, constants.REFRESHTOKENSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.DeleteMany(ctx, scope.filter(bson.M{"userId": bson.M{"$in": userIDs}}))
+	return err
+}
+
+// ensureRefreshTokenIndexes crea gli indici per revocare i token per famiglia e per utente
+// e il TTL che elimina i token scaduti
+func ensureRefreshTokenIndexes(ctx context.Context, db *mongo.Database) error {
+	prefix := bson.D{}
+	if tenancy.Enabled() {
+		prefix = bson.D{{Key: tenancy.Field, Value: 1}}
+	}
+	_, err := db.Collection(constants.REFRESHTOKENSCOLLECTION).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    append(append(bson.D{}, prefix...), bson.E{Key: "familyId", Value: 1}),
+			Options: options.Index().SetName("familyId"),
+		},
+		{
+			Keys:    append(append(bson.D{}, prefix...), bson.E{Key: "userId", Value: 1}),
+			Options: options.Index().SetName("userId"),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
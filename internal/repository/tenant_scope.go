@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"myapp/internal/config"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Tutte le query sui dati dei tenant passano da uno scope, che applica l'isolamento configurato con TENANCY_MODE:
+//   - shared: collezioni condivise, il campo tenantId è aggiunto a ogni filtro e a ogni documento scritto;
+//   - collection: i dati degli utenti (users, user_audit) sono in collezioni per tenant (users_acme);
+//   - database: i dati degli utenti sono in un database per tenant (myapp_acme).
+//
+// Le collezioni operative (webhook, consegne, outbox, chiavi di idempotenza) restano nel database principale
+// e con la tenancy abilitata sono sempre separate dal campo tenantId, così relay e dispatcher possono
+// elaborarle senza conoscere l'elenco dei tenant.
+
+// scope è una collezione vista dal tenant del contesto
+type scope struct {
+	collection *mongo.Collection
+	tenant     string // vuoto con TENANCY_MODE=off
+	byField    bool   // true se i documenti dei tenant sono separati dal campo tenantId
+}
+
+// preparedNamespaces contiene i tenant di cui sono già stati creati gli indici (modalità collection e database)
+var preparedNamespaces sync.Map
+
+// userDataScope restituisce lo scope di una collezione dei dati degli utenti (users o user_audit),
+// isolata secondo TENANCY_MODE; tenancy.ErrTenantRequired se la tenancy è abilitata e il contesto non ha un tenant
+func userDataScope(ctx context.Context, name string) (*scope, error) {
+	tenant, err := tenancy.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch tenancy.Mode() {
+	case tenancy.ModeCollection, tenancy.ModeDatabase:
+		// Collezioni, database e indici si creano solo per i tenant registrati (il middleware li verifica già,
+		// ma i dati degli utenti sono raggiunti anche da myctl e dai processi in background)
+		if err := tenancy.Allowed(tenant); err != nil {
+			return nil, err
+		}
+		db := tenantDatabase(tenant)
+		prepareNamespace(tenant, db)
+		return &scope{collection: db.Collection(tenantCollectionName(name, tenant)), tenant: tenant}, nil
+	default:
+		return &scope{collection: config.GetDatabase().Collection(name), tenant: tenant, byField: tenant != ""}, nil
+	}
+}
+
+// sharedScope restituisce lo scope di una collezione operativa del database principale
+func sharedScope(ctx context.Context, name string) (*scope, error) {
+	tenant, err := tenancy.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &scope{collection: config.GetDatabase().Collection(name), tenant: tenant, byField: tenant != ""}, nil
+}
+
+// filter aggiunge al filtro la condizione sul tenant
+func (s *scope) filter(filter bson.M) bson.M {
+	if s.byField {
+		filter[tenancy.Field] = s.tenant
+	}
+	return filter
+}
+
+// document restituisce il documento da scrivere con il campo tenantId
+func (s *scope) document(document interface{}) (interface{}, error) {
+	if !s.byField {
+		return document, nil
+	}
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.D
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	// Il tenant del contesto prevale su quello eventualmente già presente nel documento
+	for i := range fields {
+		if fields[i].Key == tenancy.Field {
+			fields[i].Value = s.tenant
+			return fields, nil
+		}
+	}
+	return append(fields, bson.E{Key: tenancy.Field, Value: s.tenant}), nil
+}
+
+// documents applica document a tutti i documenti
+func (s *scope) documents(documents []interface{}) ([]interface{}, error) {
+	for i, document := range documents {
+		scoped, err := s.document(document)
+		if err != nil {
+			return nil, err
+		}
+		documents[i] = scoped
+	}
+	return documents, nil
+}
+
+// tenantDatabase restituisce il database dei dati del tenant: quello principale in modalità collection,
+// <MONGO_DATABASE>_<tenant> in modalità database
+func tenantDatabase(tenant string) *mongo.Database {
+	db := config.GetDatabase()
+	if tenancy.Mode() == tenancy.ModeDatabase {
+		return config.GetMongoClient().Database(db.Name() + "_" + tenant)
+	}
+	return db
+}
+
+// tenantCollectionName restituisce il nome della collezione del tenant (users_acme in modalità collection)
+func tenantCollectionName(name, tenant string) string {
+	if tenancy.Mode() == tenancy.ModeCollection {
+		return name + "_" + tenant
+	}
+	return name
+}
+
+// prepareNamespace crea gli indici delle collezioni di un tenant al primo utilizzo.
+// Usa un contesto proprio: il chiamante può essere in una transazione, dove gli indici non si possono creare.
+// In caso di errore gli indici verranno ricreati alla richiesta successiva.
+func prepareNamespace(tenant string, db *mongo.Database) {
+	if _, ok := preparedNamespaces.Load(tenant); ok {
+		return
+	}
+	log := utils.WithContext().WithField("function", "prepareNamespace").WithField("tenant", tenant)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	users := db.Collection(tenantCollectionName(constants.USERSCOLLECTION, tenant))
+	if err := ensureUserIndexes(ctx, users, false); err != nil {
+		log.Errorf("Error creating user indexes: %v", err)
+		return
+	}
+	if err := ensureUserSortIndexes(ctx, users, false); err != nil {
+		log.Errorf("Error creating user sort indexes: %v", err)
+		return
+	}
+	if err := ensureAuditIndexes(ctx, db.Collection(tenantCollectionName(constants.AUDITCOLLECTION, tenant)), false); err != nil {
+		log.Errorf("Error creating audit indexes: %v", err)
+		return
+	}
+	groups := db.Collection(tenantCollectionName(constants.GROUPSCOLLECTION, tenant))
+	members := db.Collection(tenantCollectionName(constants.GROUPMEMBERSCOLLECTION, tenant))
+	if err := ensureGroupIndexes(ctx, groups, members, false); err != nil {
+		log.Errorf("Error creating group indexes: %v", err)
+		return
+	}
+	preparedNamespaces.Store(tenant, struct{}{})
+	log.Info("Tenant indexes ensured")
+}
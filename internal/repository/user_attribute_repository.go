@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"myapp/internal/models"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lo schema degli attributi è una configurazione del tenant: è salvato nel database principale (vedi sharedScope),
+// un documento per tenant con _id uguale al tenant (defaultAttributeSchemaID con TENANCY_MODE=off).
+// Il documento contiene gli schemi di tutti i namespace del tenant (namespaces.<nome>) e una revisione
+// incrementata a ogni modifica, usata per il controllo di concorrenza ottimistico di SaveUserAttributeSchema
+// e DeleteUserAttributeSchema: i vincoli tra namespace (attributi non condivisi, limite complessivo) sono
+// verificati sull'intero documento, quindi due modifiche concorrenti non possono violarli.
+const defaultAttributeSchemaID = "default"
+
+// attributeIndexPrefix è il prefisso dei nomi degli indici sugli attributi (attributes_team)
+const attributeIndexPrefix = constants.USER_ATTRIBUTES_FIELD + "_"
+
+// attributeWildcardIndex è il nome dell'indice wildcard sugli attributi usato con TENANCY_MODE=shared;
+// contiene $, che non può comparire nel nome di un attributo, quindi non è confuso con gli indici attributes_<nome>
+const attributeWildcardIndex = constants.USER_ATTRIBUTES_FIELD + ".$**_1"
+
+// ErrAttributeSchemaChanged indica che lo schema del tenant è stato modificato dopo la lettura (revisione diversa)
+var ErrAttributeSchemaChanged = errors.New("attribute schema changed concurrently")
+
+// attributeSchemaDocument è il documento salvato: ogni schema è conservato come testo JSON,
+// perché parole chiave come $schema non sono nomi di campo utilizzabili in MongoDB
+type attributeSchemaDocument struct {
+	ID         string                             `bson:"_id"`
+	Revision   int64                              `bson:"revision"`
+	Namespaces map[string]attributeNamespaceEntry `bson:"namespaces,omitempty"`
+
+	// Campi dello schema unico delle versioni precedenti, spostati nel namespace default dalla migrazione 4
+	Definition string    `bson:"definition,omitempty"`
+	Version    int64     `bson:"version,omitempty"`
+	UpdatedAt  time.Time `bson:"updatedAt,omitempty"`
+	UpdatedBy  string    `bson:"updatedBy,omitempty"`
+}
+
+// attributeNamespaceEntry è lo schema di un namespace
+type attributeNamespaceEntry struct {
+	Definition string    `bson:"definition"`
+	Version    int64     `bson:"version"`
+	UpdatedAt  time.Time `bson:"updatedAt"`
+	UpdatedBy  string    `bson:"updatedBy,omitempty"`
+}
+
+// models restituisce gli schemi dei namespace in ordine di nome; uno schema nel formato precedente
+// alla migrazione 4 è restituito come namespace default
+func (d attributeSchemaDocument) models() []models.UserAttributeSchema {
+	namespaces := d.Namespaces
+	if d.Definition != "" && namespaces[constants.DEFAULT_ATTRIBUTE_NAMESPACE].Definition == "" {
+		namespaces = make(map[string]attributeNamespaceEntry, len(d.Namespaces)+1)
+		for name, entry := range d.Namespaces {
+			namespaces[name] = entry
+		}
+		namespaces[constants.DEFAULT_ATTRIBUTE_NAMESPACE] = attributeNamespaceEntry{
+			Definition: d.Definition, Version: d.Version, UpdatedAt: d.UpdatedAt, UpdatedBy: d.UpdatedBy,
+		}
+	}
+	schemas := make([]models.UserAttributeSchema, 0, len(namespaces))
+	for name, entry := range namespaces {
+		schemas = append(schemas, models.UserAttributeSchema{
+			Namespace: name,
+			Schema:    json.RawMessage(entry.Definition),
+			Version:   entry.Version,
+			UpdatedAt: entry.UpdatedAt,
+			UpdatedBy: entry.UpdatedBy,
+		})
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Namespace < schemas[j].Namespace })
+	return schemas
+}
+
+// attributeSchemaID restituisce l'_id del documento degli schemi del tenant dello scope
+func attributeSchemaID(s *scope) string {
+	if s.tenant == "" {
+		return defaultAttributeSchemaID
+	}
+	return s.tenant
+}
+
+// GetUserAttributeSchemas restituisce gli schemi di tutti i namespace del tenant, in ordine di nome,
+// e la revisione del documento (0 e nessuno schema se il tenant non ne ha mai definiti)
+func GetUserAttributeSchemas(ctx context.Context) ([]models.UserAttributeSchema, int64, error) {
+	scope, err := sharedScope(ctx, constants.ATTRIBUTESCHEMASCOLLECTION)
+	if err != nil {
+		return nil, 0, err
+	}
+	var document attributeSchemaDocument
+	err = scope.collection.FindOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: attributeSchemaID(scope)})).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return document.models(), document.Revision, nil
+}
+
+// SaveUserAttributeSchema crea o sostituisce lo schema di un namespace incrementandone la versione, se il documento
+// del tenant è ancora alla revisione letta; ErrAttributeSchemaChanged altrimenti. Restituisce lo schema salvato.
+func SaveUserAttributeSchema(ctx context.Context, namespace string, definition json.RawMessage, updatedBy string, updatedAt time.Time, revision int64) (*models.UserAttributeSchema, error) {
+	path := "namespaces." + namespace
+	update := bson.M{
+		constants.SET: bson.M{path + ".definition": string(definition), path + ".updatedAt": updatedAt, path + ".updatedBy": updatedBy},
+		"$inc":        bson.M{path + ".version": 1, "revision": 1},
+	}
+	document, err := updateAttributeSchemas(ctx, update, revision)
+	if err != nil {
+		return nil, err
+	}
+	for _, schema := range document.models() {
+		if schema.Namespace == namespace {
+			return &schema, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// DeleteUserAttributeSchema elimina lo schema di un namespace, se il documento del tenant è ancora
+// alla revisione letta; ErrAttributeSchemaChanged altrimenti
+func DeleteUserAttributeSchema(ctx context.Context, namespace string, revision int64) error {
+	update := bson.M{"$unset": bson.M{"namespaces." + namespace: ""}, "$inc": bson.M{"revision": 1}}
+	_, err := updateAttributeSchemas(ctx, update, revision)
+	return err
+}
+
+// updateAttributeSchemas applica la modifica al documento degli schemi del tenant alla revisione indicata
+// (creandolo con la revisione 0) e restituisce il documento aggiornato
+func updateAttributeSchemas(ctx context.Context, update bson.M, revision int64) (*attributeSchemaDocument, error) {
+	scope, err := sharedScope(ctx, constants.ATTRIBUTESCHEMASCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	// Un documento precedente alla migrazione 4 non ha la revisione: vale 0
+	filter := bson.M{constants.DOCUMENT_ID: attributeSchemaID(scope), "revision": revision}
+	if revision == 0 {
+		filter["revision"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	var document attributeSchemaDocument
+	err = scope.collection.FindOneAndUpdate(ctx, scope.filter(filter), update,
+		options.FindOneAndUpdate().SetUpsert(revision == 0).SetReturnDocument(options.After)).Decode(&document)
+	// Con una revisione diversa il documento non corrisponde: senza upsert non è trovato,
+	// con l'upsert l'inserimento collide con l'_id esistente
+	if errors.Is(err, mongo.ErrNoDocuments) || mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAttributeSchemaChanged
+	}
+	if err != nil {
+		utils.WithContext().WithField("function", "updateAttributeSchemas").Errorf("Error saving attribute schema: %v", err)
+		return nil, err
+	}
+	return &document, nil
+}
+
+// EnsureUserAttributeIndexes allinea gli indici sugli attributi della collezione degli utenti del tenant:
+// crea un indice per ogni attributo indicato (attributes_<nome>) ed elimina quelli degli attributi non più dichiarati.
+// Con TENANCY_MODE=shared la collezione è condivisa e gli indici per attributo dei tenant la esaurirebbero
+// (MongoDB ammette 64 indici per collezione): un solo indice wildcard su attributes.$** serve tutti i tenant
+// e gli indici per attributo creati dalle versioni precedenti vengono eliminati.
+func EnsureUserAttributeIndexes(ctx context.Context, names []string) error {
+	log := utils.WithContext().WithField("function", "EnsureUserAttributeIndexes")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return err
+	}
+
+	declared := make(map[string]bool, len(names))
+	indexModels := make([]mongo.IndexModel, 0, len(names))
+	if scope.byField {
+		indexModels = append(indexModels, mongo.IndexModel{
+			Keys:    bson.D{{Key: constants.USER_ATTRIBUTES_FIELD + ".$**", Value: 1}},
+			Options: options.Index().SetName(attributeWildcardIndex),
+		})
+	} else {
+		for _, name := range names {
+			declared[attributeIndexPrefix+name] = true
+			indexModels = append(indexModels, mongo.IndexModel{
+				Keys:    bson.D{{Key: constants.USER_ATTRIBUTES_FIELD + "." + name, Value: 1}},
+				Options: options.Index().SetName(attributeIndexPrefix + name),
+			})
+		}
+	}
+	if len(indexModels) > 0 {
+		if _, err := scope.collection.Indexes().CreateMany(ctx, indexModels); err != nil {
+			log.Errorf("Error creating attribute indexes: %v", err)
+			return err
+		}
+	}
+
+	cursor, err := scope.collection.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var existing []struct {
+		Name string `bson:"name"`
+	}
+	if err := cursor.All(ctx, &existing); err != nil {
+		return err
+	}
+	for _, index := range existing {
+		if strings.HasPrefix(index.Name, attributeIndexPrefix) && !declared[index.Name] {
+			if _, err := scope.collection.Indexes().DropOne(ctx, index.Name); err != nil {
+				log.Errorf("Error dropping attribute index %s: %v", index.Name, err)
+				return err
+			}
+			log.Infof("Indice %s eliminato", index.Name)
+		}
+	}
+	return nil
+}
+
+// attributeFilter aggiunge al filtro le condizioni sugli attributi: per ogni attributo almeno uno dei valori
+// (con un attributo array basta che uno degli elementi corrisponda)
+func attributeFilter(filter bson.M, attributes map[string][]interface{}) bson.M {
+	for name, values := range attributes {
+		filter[constants.USER_ATTRIBUTES_FIELD+"."+name] = bson.M{"$in": values}
+	}
+	return filter
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/models"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BulkCreateUsers inserisce gli utenti con una bulk write.
+// Gli ObjectID vengono generati prima dell'inserimento (o presi dal campo ID, se valorizzato) per poterli
+// restituire per ogni elemento.
+// La mappa restituita contiene gli errori per indice; gli elementi non presenti sono stati scritti,
+// salvo in modalità ordered dove quelli successivi al primo errore non vengono eseguiti.
+func BulkCreateUsers(ctx context.Context, users []models.User, ordered bool) ([]string, map[int]error, error) {
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]string, len(users))
+	writeModels := make([]mongo.WriteModel, len(users))
+	for i, user := range users {
+		objectID := primitive.NewObjectID()
+		if user.ID != "" {
+			var err error
+			if objectID, err = primitive.ObjectIDFromHex(user.ID); err != nil {
+				return nil, nil, err
+			}
+		}
+		document, err := userDocument(user, objectID)
+		if err != nil {
+			return nil, nil, err
+		}
+		scoped, err := scope.document(document)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids[i] = objectID.Hex()
+		writeModels[i] = mongo.NewInsertOneModel().SetDocument(scoped)
+	}
+
+	failures, err := bulkWriteUsers(ctx, scope, writeModels, ordered)
+	return ids, failures, err
+}
+
+// BulkUpdateUsers aggiorna gli utenti (identificati dal campo ID) con una bulk write
+func BulkUpdateUsers(ctx context.Context, users []models.User, ordered bool) (map[int]error, error) {
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+
+	writeModels := make([]mongo.WriteModel, len(users))
+	for i, user := range users {
+		objectID, err := primitive.ObjectIDFromHex(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		// L'ID è già nel filtro e non deve finire nel $set
+		user.ID = ""
+		writeModels[i] = mongo.NewUpdateOneModel().
+			SetFilter(scope.filter(bson.M{constants.DOCUMENT_ID: objectID})).
+			SetUpdate(userUpdate(user))
+	}
+	return bulkWriteUsers(ctx, scope, writeModels, ordered)
+}
+
+// BulkDeleteUsers elimina gli utenti indicati con una bulk write
+func BulkDeleteUsers(ctx context.Context, ids []string, ordered bool) (map[int]error, error) {
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+
+	writeModels := make([]mongo.WriteModel, len(ids))
+	for i, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		writeModels[i] = mongo.NewDeleteOneModel().SetFilter(scope.filter(bson.M{constants.DOCUMENT_ID: objectID}))
+	}
+	return bulkWriteUsers(ctx, scope, writeModels, ordered)
+}
+
+// FindExistingUserIDs restituisce l'insieme degli ID (esadecimali) presenti nella collezione
+func FindExistingUserIDs(ctx context.Context, ids []string) (map[string]bool, error) {
+	log := utils.WithContext().WithField("function", "FindExistingUserIDs")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+
+	objectIDs := toObjectIDs(ids)
+	cursor, err := scope.collection.Find(
+		ctx,
+		scope.filter(bson.M{constants.DOCUMENT_ID: bson.M{"$in": objectIDs}}),
+		options.Find().SetProjection(bson.M{constants.DOCUMENT_ID: 1}),
+	)
+	if err != nil {
+		log.Errorf("Error finding existing users: %v", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	existing := make(map[string]bool, len(objectIDs))
+	for cursor.Next(ctx) {
+		var document struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&document); err != nil {
+			return nil, err
+		}
+		existing[document.ID.Hex()] = true
+	}
+	return existing, cursor.Err()
+}
+
+// FindUsersByIDs restituisce gli utenti indicati indicizzati per ID; gli ID inesistenti o non validi sono ignorati
+func FindUsersByIDs(ctx context.Context, ids []string) (map[string]models.User, error) {
+	log := utils.WithContext().WithField("function", "FindUsersByIDs")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := scope.collection.Find(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: bson.M{"$in": toObjectIDs(ids)}}))
+	if err != nil {
+		log.Errorf("Error finding users: %v", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := make(map[string]models.User, len(ids))
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		users[user.ID] = user
+	}
+	return users, cursor.Err()
+}
+
+// toObjectIDs converte gli ID esadecimali validi in ObjectID
+func toObjectIDs(ids []string) []primitive.ObjectID {
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objectIDs = append(objectIDs, objectID)
+		}
+	}
+	return objectIDs
+}
+
+// bulkWriteUsers esegue la bulk write e converte gli eventuali errori di scrittura in una mappa indice -> errore
+func bulkWriteUsers(ctx context.Context, scope *scope, writeModels []mongo.WriteModel, ordered bool) (map[int]error, error) {
+	log := utils.WithContext().WithField("function", "bulkWriteUsers")
+	failures := make(map[int]error)
+	if len(writeModels) == 0 {
+		return failures, nil
+	}
+
+	_, err := scope.collection.BulkWrite(ctx, writeModels, options.BulkWrite().SetOrdered(ordered))
+	if err == nil {
+		return failures, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		log.Errorf("Error executing bulk write: %v", err)
+		return nil, err
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		failures[writeErr.Index] = writeErr
+	}
+	return failures, nil
+}
+
+// userDocument converte l'utente in un documento BSON con l'ObjectID indicato
+func userDocument(user models.User, objectID primitive.ObjectID) (bson.M, error) {
+	user.ID = ""
+	raw, err := bson.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	var document bson.M
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	document[constants.DOCUMENT_ID] = objectID
+	return document, nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"myapp/internal/models"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserKey è la posizione di un utente nell'ordinamento di una UserQuery: il valore del campo ordinato e l'ID,
+// che rende l'ordine totale anche con valori duplicati
+type UserKey struct {
+	Value string
+	ID    string
+}
+
+// FindUsersPage restituisce al massimo limit utenti che soddisfano i filtri, nell'ordine richiesto e successivi ad after,
+// insieme al numero totale di utenti che soddisfano i filtri.
+// La paginazione è per chiave (valore ordinato, _id), quindi stabile anche se gli utenti vengono modificati tra una pagina e l'altra.
+func FindUsersPage(ctx context.Context, query models.UserQuery, after *UserKey, limit int64) ([]models.User, int64, error) {
+	log := utils.WithContext().WithField("function", "FindUsersPage")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, 0, err
+	}
+	collection := scope.collection
+
+	filter := scope.filter(bson.M{})
+	if query.NameContains != "" {
+		filter["name"] = caseInsensitiveRegex(regexp.QuoteMeta(query.NameContains))
+	}
+	if query.EmailContains != "" {
+		filter["email"] = caseInsensitiveRegex(regexp.QuoteMeta(query.EmailContains))
+	}
+	if len(query.Statuses) > 0 {
+		filter["status"] = statusFilter(query.Statuses)
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Errorf("Error counting users: %v", err)
+		return nil, 0, err
+	}
+
+	direction, comparison := 1, "$gt"
+	if query.Descending {
+		direction, comparison = -1, "$lt"
+	}
+	sort := bson.D{{Key: constants.DOCUMENT_ID, Value: direction}}
+	if query.SortField != constants.SORT_FIELD_ID {
+		sort = append(bson.D{{Key: query.SortField, Value: direction}}, sort...)
+	}
+
+	pageFilter := filter
+	if after != nil {
+		objectID, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		var keyset bson.M
+		if query.SortField == constants.SORT_FIELD_ID {
+			keyset = bson.M{constants.DOCUMENT_ID: bson.M{comparison: objectID}}
+		} else {
+			keyset = bson.M{"$or": bson.A{
+				bson.M{query.SortField: bson.M{comparison: after.Value}},
+				bson.M{query.SortField: after.Value, constants.DOCUMENT_ID: bson.M{comparison: objectID}},
+			}}
+		}
+		pageFilter = bson.M{"$and": bson.A{filter, keyset}}
+	}
+
+	cursor, err := collection.Find(ctx, pageFilter, options.Find().SetSort(sort).SetLimit(limit))
+	if err != nil {
+		log.Errorf("Error finding users: %v", err)
+		return nil, 0, err
+	}
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		log.Errorf("Error decoding users: %v", err)
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// statusFilter restituisce la condizione sugli stati indicati.
+// Gli utenti senza stato, creati prima della migrazione 2 che lo valorizza, sono attivi.
+func statusFilter(statuses []string) bson.M {
+	values := bson.A{}
+	for _, status := range statuses {
+		values = append(values, status)
+		if status == constants.USER_STATUS_ACTIVE {
+			values = append(values, nil)
+		}
+	}
+	return bson.M{"$in": values}
+}
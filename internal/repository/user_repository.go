@@ -2,19 +2,21 @@ package repository
 
 import (
 	"context"
-	"myapp/internal/config"
+	"errors"
 	"myapp/internal/models"
 	"myapp/internal/utils"
 	"myapp/internal/utils/constants"
+	"time"
 
 	"github.com/openzipkin/zipkin-go"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// GetUsers retrieves all users from the MongoDB collection
-func GetUsers(ctx context.Context, tracer *zipkin.Tracer) ([]models.User, error) {
+// GetUsers retrieves all users from the MongoDB collection that match the filter (statuses and attribute values)
+func GetUsers(ctx context.Context, tracer *zipkin.Tracer, listFilter models.UserListFilter) ([]models.User, error) {
 	log := utils.WithContext().WithField("function", "GetUsers_Repo")
 
 	// Recupera lo span dal contesto
@@ -26,8 +28,17 @@ func GetUsers(ctx context.Context, tracer *zipkin.Tracer) ([]models.User, error)
 	// Crea un nuovo contesto con lo span figlio
 	childCtx := zipkin.NewContext(ctx, childSpan)
 
+	scope, err := userDataScope(childCtx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := attributeFilter(scope.filter(bson.M{}), listFilter.Attributes)
+	if len(listFilter.Statuses) > 0 {
+		filter["status"] = statusFilter(listFilter.Statuses)
+	}
 	var users []models.User
-	cursor, err := config.GetDatabase().Collection(constants.USERSCOLLECTION).Find(childCtx, bson.M{})
+	cursor, err := scope.collection.Find(childCtx, filter)
 	if err != nil {
 		log.Errorf("Error finding users: %v", err)
 		return nil, err
@@ -40,13 +51,84 @@ func GetUsers(ctx context.Context, tracer *zipkin.Tracer) ([]models.User, error)
 	return users, nil
 }
 
+// StreamUsers scorre la collezione con un cursore invocando fn per ogni utente, senza caricare tutti i documenti in memoria
+func StreamUsers(ctx context.Context, fn func(models.User) error) error {
+	log := utils.WithContext().WithField("function", "StreamUsers")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := scope.collection.Find(
+		ctx,
+		scope.filter(bson.M{}),
+		options.Find().SetBatchSize(500).SetSort(bson.M{constants.DOCUMENT_ID: 1}),
+	)
+	if err != nil {
+		log.Errorf("Error finding users: %v", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			log.Errorf("Error decoding user: %v", err)
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// ListUsersAfter restituisce al massimo limit utenti ordinati per ID, successivi ad afterID (dall'inizio se vuoto).
+// La paginazione per chiave resta efficiente anche sulle pagine lontane, a differenza di skip.
+func ListUsersAfter(ctx context.Context, afterID string, limit int64) ([]models.User, error) {
+	log := utils.WithContext().WithField("function", "ListUsersAfter")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := scope.filter(bson.M{})
+	if afterID != "" {
+		objectID, err := primitive.ObjectIDFromHex(afterID)
+		if err != nil {
+			return nil, err
+		}
+		filter[constants.DOCUMENT_ID] = bson.M{"$gt": objectID}
+	}
+
+	cursor, err := scope.collection.Find(ctx, filter,
+		options.Find().SetSort(bson.M{constants.DOCUMENT_ID: 1}).SetLimit(limit))
+	if err != nil {
+		log.Errorf("Error finding users: %v", err)
+		return nil, err
+	}
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		log.Errorf("Error decoding users: %v", err)
+		return nil, err
+	}
+	return users, nil
+}
+
 // CreateUser inserisce un nuovo utente nella collezione MongoDB
-func CreateUser(user models.User) (*mongo.InsertOneResult, error) {
+func CreateUser(ctx context.Context, user models.User) (*mongo.InsertOneResult, error) {
 	log := utils.WithContext().WithField("function", "CreateUser")
-	db := config.GetDatabase()
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	document, err := scope.document(user)
+	if err != nil {
+		return nil, err
+	}
 
 	// Esegue l'operazione di inserimento e restituisce il risultato dell'inserimento e un eventuale errore
-	result, err := db.Collection(constants.USERSCOLLECTION).InsertOne(context.TODO(), user)
+	result, err := scope.collection.InsertOne(ctx, document)
 	if err != nil {
 		log.Errorf("Error creating user: %v", err)
 	}
@@ -54,9 +136,12 @@ func CreateUser(user models.User) (*mongo.InsertOneResult, error) {
 }
 
 // GetUserByID recupera un utente per ID dalla collezione MongoDB
-func GetUserByID(id string) (*models.User, error) {
+func GetUserByID(ctx context.Context, id string) (*models.User, error) {
 	log := utils.WithContext().WithField("function", "GetUserByID")
-	db := config.GetDatabase()
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
 
 	// Converte l'ID esadecimale (stringa) in un ObjectID di MongoDB
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -69,7 +154,7 @@ func GetUserByID(id string) (*models.User, error) {
 	var user models.User
 
 	// Esegue la query per trovare il documento con l'ObjectID specificato
-	err = db.Collection(constants.USERSCOLLECTION).FindOne(context.TODO(), bson.M{constants.DOCUMENT_ID: objectID}).Decode(&user)
+	err = scope.collection.FindOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: objectID})).Decode(&user)
 	if err != nil {
 		log.Errorf("Error finding user by ID: %v", err)
 		return nil, err
@@ -79,40 +164,164 @@ func GetUserByID(id string) (*models.User, error) {
 	return &user, nil
 }
 
-// DeleteUserByID deletes a user by ID from the MongoDB collection
-func DeleteUserByID(id string) error {
+// TouchUser incrementa il campo membershipVersion dell'utente; mongo.ErrNoDocuments se non esiste.
+// Come TouchGroup, serve a far entrare il documento nel write set di una transazione che altrimenti lo leggerebbe soltanto.
+func TouchUser(ctx context.Context, id string) error {
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	result, err := scope.collection.UpdateOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: objectID}),
+		bson.M{"$inc": bson.M{"membershipVersion": 1}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteUserByID deletes a user by ID from the MongoDB collection and returns the deleted document.
+// It returns mongo.ErrNoDocuments if the user does not exist.
+func DeleteUserByID(ctx context.Context, id string) (*models.User, error) {
 	log := utils.WithContext().WithField("function", "DeleteUserByID")
-	db := config.GetDatabase()
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
 
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Errorf("Error converting ID: %v", err)
-		return err
+		return nil, err
 	}
-	_, err = db.Collection(constants.USERSCOLLECTION).DeleteOne(context.TODO(), bson.M{constants.DOCUMENT_ID: objectID})
+
+	var deleted models.User
+	err = scope.collection.FindOneAndDelete(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: objectID})).Decode(&deleted)
 	if err != nil {
-		log.Errorf("Error deleting user by ID: %v", err)
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Errorf("Error deleting user by ID: %v", err)
+		}
+		return nil, err
 	}
-	return err
+	return &deleted, nil
 }
 
-// UpdateUser updates a user by ID in the MongoDB collection
-func UpdateUser(id string, user models.User) error {
+// UpdateUser updates a user by ID in the MongoDB collection and returns the updated document.
+// It returns mongo.ErrNoDocuments if the user does not exist.
+func UpdateUser(ctx context.Context, id string, user models.User) (*models.User, error) {
 	log := utils.WithContext().WithField("function", "UpdateUser")
-	db := config.GetDatabase()
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
 
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Errorf("Error converting ID: %v", err)
-		return err
+		return nil, err
 	}
-	_, err = db.Collection(constants.USERSCOLLECTION).UpdateOne(
-		context.TODO(),
-		bson.M{constants.DOCUMENT_ID: objectID},
-		bson.M{constants.SET: user},
-	)
+
+	var updated models.User
+	err = scope.collection.FindOneAndUpdate(
+		ctx,
+		scope.filter(bson.M{constants.DOCUMENT_ID: objectID}),
+		userUpdate(user),
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
 	if err != nil {
 		log.Errorf("Error updating user by ID: %v", err)
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// userUpdate restituisce l'aggiornamento di un utente: $set dei campi valorizzati e, se la richiesta contiene
+// una mappa di attributi vuota (che omitempty escluderebbe dal $set), la rimozione di tutti gli attributi
+func userUpdate(user models.User) bson.M {
+	update := bson.M{constants.SET: user}
+	if user.Attributes != nil && len(user.Attributes) == 0 {
+		update["$unset"] = bson.M{constants.USER_ATTRIBUTES_FIELD: ""}
+	}
+	return update
+}
+
+// SetUserStatus imposta lo stato di un utente con motivo e data del cambio e restituisce l'utente aggiornato.
+// La transizione è verificata dal chiamante; mongo.ErrNoDocuments se l'utente non esiste.
+func SetUserStatus(ctx context.Context, id, status, reason string, changedAt time.Time) (*models.User, error) {
+	log := utils.WithContext().WithField("function", "SetUserStatus")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{constants.SET: bson.M{"status": status, "statusChangedAt": changedAt}}
+	if reason != "" {
+		update[constants.SET].(bson.M)["statusReason"] = reason
+	} else {
+		// Il motivo di un cambio precedente non deve restare associato al nuovo stato
+		update["$unset"] = bson.M{"statusReason": ""}
+	}
+	var updated models.User
+	err = scope.collection.FindOneAndUpdate(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: objectID}), update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err != nil {
+		log.Errorf("Error updating user status: %v", err)
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// FindUsersByEmails restituisce ID ed email degli utenti con uno degli indirizzi indicati
+func FindUsersByEmails(ctx context.Context, emails []string) ([]models.User, error) {
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := scope.collection.Find(ctx, scope.filter(bson.M{"email": bson.M{"$in": emails}}),
+		options.Find().SetProjection(bson.M{constants.DOCUMENT_ID: 1, "email": 1}))
+	if err != nil {
+		utils.WithContext().WithField("function", "FindUsersByEmails").Errorf("Error finding users by email: %v", err)
+		return nil, err
+	}
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// FindUserIDsByEmail restituisce gli ID degli utenti con l'indirizzo email indicato (al massimo limit).
+// L'email non è univoca: chi la usa per il login deve gestire più risultati.
+func FindUserIDsByEmail(ctx context.Context, email string, limit int64) ([]string, error) {
+	log := utils.WithContext().WithField("function", "FindUserIDsByEmail")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := scope.collection.Find(ctx, scope.filter(bson.M{"email": email}),
+		options.Find().SetProjection(bson.M{constants.DOCUMENT_ID: 1}).SetLimit(limit))
+	if err != nil {
+		log.Errorf("Error finding users by email: %v", err)
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
 	}
-	return err
+	return ids, nil
 }
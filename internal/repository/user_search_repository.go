@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrTextIndexNotFound indica che la collezione non ha l'indice full-text richiesto da $text
+var ErrTextIndexNotFound = errors.New("text index not found")
+
+// Codici di errore MongoDB: IndexNotFound (restituito anche da $text in assenza di indice full-text) e NamespaceNotFound
+const (
+	indexNotFoundCode     = 27
+	namespaceNotFoundCode = 26
+)
+
+// ScoredUser è un utente con il punteggio calcolato da MongoDB
+type ScoredUser struct {
+	models.User `bson:",inline"`
+	Score       float64 `bson:"score"`
+}
+
+// SearchUsersText esegue una ricerca full-text su nome ed email ordinando per rilevanza
+func SearchUsersText(ctx context.Context, query string, skip, limit int64) ([]ScoredUser, int64, error) {
+	log := utils.WithContext().WithField("function", "SearchUsersText")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, 0, err
+	}
+	collection := scope.collection
+
+	filter := scope.filter(bson.M{"$text": bson.M{"$search": query}})
+	score := bson.M{"score": bson.M{"$meta": "textScore"}}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, textSearchError(err)
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetProjection(score).
+		SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: constants.DOCUMENT_ID, Value: 1}}).
+		SetSkip(skip).
+		SetLimit(limit))
+	if err != nil {
+		return nil, 0, textSearchError(err)
+	}
+
+	var users []ScoredUser
+	if err := cursor.All(ctx, &users); err != nil {
+		log.Errorf("Error decoding search results: %v", err)
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// SearchUsersPrefix cerca gli utenti in cui ogni termine è l'inizio di una parola del nome o dell'email.
+// Non richiede indici ed è usata come fallback quando l'indice full-text non è disponibile.
+func SearchUsersPrefix(ctx context.Context, terms []string, skip, limit int64) ([]models.User, int64, error) {
+	log := utils.WithContext().WithField("function", "SearchUsersPrefix")
+	scope, err := userDataScope(ctx, constants.USERSCOLLECTION)
+	if err != nil {
+		return nil, 0, err
+	}
+	collection := scope.collection
+
+	conditions := bson.A{}
+	for _, term := range terms {
+		pattern := caseInsensitiveRegex(`(^|[\s.@_-])` + regexp.QuoteMeta(term))
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"name": pattern},
+			bson.M{"email": pattern},
+		}})
+	}
+	filter := bson.M{}
+	if len(conditions) > 0 {
+		filter = bson.M{"$and": conditions}
+	}
+	filter = scope.filter(filter)
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Errorf("Error counting search results: %v", err)
+		return nil, 0, err
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "name", Value: 1}, {Key: constants.DOCUMENT_ID, Value: 1}}).
+		SetSkip(skip).
+		SetLimit(limit))
+	if err != nil {
+		log.Errorf("Error finding search results: %v", err)
+		return nil, 0, err
+	}
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		log.Errorf("Error decoding search results: %v", err)
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// ensureUserIndexes crea l'indice full-text su nome ed email usato dalla ricerca.
+// Con shared (TENANCY_MODE=shared) l'indice è preceduto da tenantId e sostituisce quello senza tenant:
+// una collezione può avere un solo indice full-text.
+func ensureUserIndexes(ctx context.Context, collection *mongo.Collection, shared bool) error {
+	if !shared {
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "name", Value: "text"}, {Key: "email", Value: "text"}},
+			Options: options.Index().SetName("users_text").SetWeights(bson.M{"name": 2, "email": 1}),
+		})
+		return err
+	}
+	if err := dropIndexIfExists(ctx, collection, "users_text"); err != nil {
+		return err
+	}
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: tenancy.Field, Value: 1}, {Key: "name", Value: "text"}, {Key: "email", Value: "text"}},
+		Options: options.Index().SetName("users_tenant_text").SetWeights(bson.M{"name": 2, "email": 1}),
+	})
+	return err
+}
+
+// ensureUserSortIndexes crea gli indici della paginazione per chiave di FindUsersPage nelle collezioni dei tenant
+// (nella collezione condivisa senza tenancy li crea la migrazione 1), preceduti da tenantId con shared
+func ensureUserSortIndexes(ctx context.Context, collection *mongo.Collection, shared bool) error {
+	indexes := make([]mongo.IndexModel, 0, 2)
+	for _, field := range []string{"name", "email"} {
+		keys := bson.D{{Key: field, Value: 1}, {Key: constants.DOCUMENT_ID, Value: 1}}
+		name := "users_" + field + "_id"
+		if shared {
+			keys = append(bson.D{{Key: tenancy.Field, Value: 1}}, keys...)
+			name = "users_tenant_" + field + "_id"
+		}
+		indexes = append(indexes, mongo.IndexModel{Keys: keys, Options: options.Index().SetName(name)})
+	}
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+// dropIndexIfExists elimina un indice ignorando l'assenza dell'indice o della collezione
+func dropIndexIfExists(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && (serverErr.HasErrorCode(indexNotFoundCode) || serverErr.HasErrorCode(namespaceNotFoundCode)) {
+		return nil
+	}
+	return err
+}
+
+// textSearchError converte l'errore di indice mancante in ErrTextIndexNotFound
+func textSearchError(err error) error {
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(indexNotFoundCode) {
+		return ErrTextIndexNotFound
+	}
+	utils.WithContext().WithField("function", "SearchUsersText").Errorf("Error executing text search: %v", err)
+	return err
+}
+
+func caseInsensitiveRegex(pattern string) bson.M {
+	return bson.M{"$regex": pattern, "$options": "i"}
+}
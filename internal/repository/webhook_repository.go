@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxStoredAttempts è il numero di tentativi conservati nello storico di ogni consegna
+const maxStoredAttempts = 20
+
+// InsertWebhook salva una nuova sottoscrizione
+func InsertWebhook(ctx context.Context, subscription models.WebhookSubscription) error {
+	scope, err := sharedScope(ctx, constants.WEBHOOKSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	document, err := scope.document(subscription)
+	if err != nil {
+		return err
+	}
+	_, err = scope.collection.InsertOne(ctx, document)
+	if err != nil {
+		utils.WithContext().WithField("function", "InsertWebhook").Errorf("Error inserting webhook: %v", err)
+	}
+	return err
+}
+
+// GetWebhooks restituisce tutte le sottoscrizioni in ordine di creazione
+func GetWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	scope, err := sharedScope(ctx, constants.WEBHOOKSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := scope.collection.Find(ctx, scope.filter(bson.M{}),
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		utils.WithContext().WithField("function", "GetWebhooks").Errorf("Error finding webhooks: %v", err)
+		return nil, err
+	}
+	subscriptions := []models.WebhookSubscription{}
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// GetWebhookByID restituisce una sottoscrizione; mongo.ErrNoDocuments se non esiste
+func GetWebhookByID(ctx context.Context, id string) (*models.WebhookSubscription, error) {
+	scope, err := sharedScope(ctx, constants.WEBHOOKSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	var subscription models.WebhookSubscription
+	err = scope.collection.FindOne(ctx, scope.filter(bson.M{constants.DOCUMENT_ID: id})).Decode(&subscription)
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// ReplaceWebhook sostituisce una sottoscrizione esistente; mongo.ErrNoDocuments se non esiste
+func ReplaceWebhook(ctx context.Context, subscription models.WebhookSubscription) error {
+	scope, err := sharedScope(ctx, constants.WEBHOOKSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	document, err := scope.document(subscription)
+	if err != nil {
+		return err
+	}
+	result, err := scope.collection.ReplaceOne(ctx,
+		scope.filter(bson.M{constants.DOCUMENT_ID: subscription.ID}), document)
+	if err != nil {
+		utils.WithContext().WithField("function", "ReplaceWebhook").Errorf("Error replacing webhook: %v", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteWebhookByID elimina una sottoscrizione e il suo storico di consegne; mongo.ErrNoDocuments se non esiste
+func DeleteWebhookByID(ctx context.Context, id string) error {
+	subscriptions, err := sharedScope(ctx, constants.WEBHOOKSCOLLECTION)
+	if err != nil {
+		return err
+	}
+	deliveries, err := sharedScope(ctx, constants.DELIVERIESCOLLECTION)
+	if err != nil {
+		return err
+	}
+	result, err := subscriptions.collection.DeleteOne(ctx, subscriptions.filter(bson.M{constants.DOCUMENT_ID: id}))
+	if err != nil {
+		utils.WithContext().WithField("function", "DeleteWebhookByID").Errorf("Error deleting webhook: %v", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = deliveries.collection.DeleteMany(ctx, deliveries.filter(bson.M{"subscriptionId": id}))
+	return err
+}
+
+// FindWebhooksForEvent restituisce le sottoscrizioni attive interessate al tipo di evento
+// (quelle che lo elencano o che non filtrano per tipo) del tenant del contesto
+func FindWebhooksForEvent(ctx context.Context, eventType string) ([]models.WebhookSubscription, error) {
+	scope, err := sharedScope(ctx, constants.WEBHOOKSCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	filter := scope.filter(bson.M{
+		"active": true,
+		"$or": bson.A{
+			bson.M{"eventTypes": eventType},
+			bson.M{"eventTypes": bson.M{"$size": 0}},
+		},
+	})
+	cursor, err := scope.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var subscriptions []models.WebhookSubscription
+	err = cursor.All(ctx, &subscriptions)
+	return subscriptions, err
+}
+
+// InsertWebhookDeliveries accoda le consegne di un evento.
+// Le consegne già presenti per la stessa coppia sottoscrizione/evento (evento ripubblicato dall'outbox) vengono ignorate:
+// l'ID è derivato dalla coppia e l'indice unico subscriptionId_eventId copre anche consegne con ID diversi.
+func InsertWebhookDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	scope, err := sharedScope(ctx, constants.DELIVERIESCOLLECTION)
+	if err != nil {
+		return err
+	}
+	documents := make([]interface{}, len(deliveries))
+	for i, delivery := range deliveries {
+		documents[i] = delivery
+	}
+	if documents, err = scope.documents(documents); err != nil {
+		return err
+	}
+	_, err = scope.collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	if err != nil && !onlyDuplicateKeyErrors(err) {
+		utils.WithContext().WithField("function", "InsertWebhookDeliveries").Errorf("Error inserting webhook deliveries: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ClaimWebhookDelivery prende in carico la prossima consegna da eseguire, di qualsiasi tenant, bloccandola per la durata del lease.
+// Restituisce nil se non ci sono consegne da eseguire.
+func ClaimWebhookDelivery(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": constants.DELIVERY_PENDING, "nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{"status": constants.DELIVERY_PROCESSING, "lockedUntil": bson.M{"$lte": now}},
+	}}
+	update := bson.M{constants.SET: bson.M{"status": constants.DELIVERY_PROCESSING, "lockedUntil": now.Add(lease)}}
+
+	var delivery models.WebhookDelivery
+	err := config.GetDatabase().Collection(constants.DELIVERIESCOLLECTION).FindOneAndUpdate(
+		ctx, filter, update,
+		options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptAt": 1}).SetReturnDocument(options.After),
+	).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		utils.WithContext().WithField("function", "ClaimWebhookDelivery").Errorf("Error claiming webhook delivery: %v", err)
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// RecordWebhookAttempt registra l'esito di un tentativo e il nuovo stato della consegna
+func RecordWebhookAttempt(ctx context.Context, delivery models.WebhookDelivery, attempt models.WebhookAttempt) error {
+	set := bson.M{
+		"status":        delivery.Status,
+		"attemptCount":  delivery.AttemptCount,
+		"nextAttemptAt": delivery.NextAttemptAt,
+		"lastError":     delivery.LastError,
+	}
+	if delivery.DeliveredAt != nil {
+		set["deliveredAt"] = delivery.DeliveredAt
+	}
+	_, err := config.GetDatabase().Collection(constants.DELIVERIESCOLLECTION).UpdateOne(ctx,
+		bson.M{constants.DOCUMENT_ID: delivery.ID},
+		bson.M{
+			constants.SET: set,
+			"$push":       bson.M{"attempts": bson.M{"$each": bson.A{attempt}, "$slice": -maxStoredAttempts}},
+		},
+	)
+	return err
+}
+
+// GetWebhookDeliveries restituisce una pagina dello storico delle consegne di una sottoscrizione, dalla più recente.
+// Se status non è vuoto vengono restituite solo le consegne in quello stato (es. dead per la dead-letter list).
+func GetWebhookDeliveries(ctx context.Context, subscriptionID, status string, skip, limit int64) ([]models.WebhookDelivery, int64, error) {
+	scope, err := sharedScope(ctx, constants.DELIVERIESCOLLECTION)
+	if err != nil {
+		return nil, 0, err
+	}
+	collection := scope.collection
+	filter := scope.filter(bson.M{"subscriptionId": subscriptionID})
+	if status != "" {
+		filter["status"] = status
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: constants.DOCUMENT_ID, Value: 1}}).SetSkip(skip).SetLimit(limit))
+	if err != nil {
+		utils.WithContext().WithField("function", "GetWebhookDeliveries").Errorf("Error finding webhook deliveries: %v", err)
+		return nil, 0, err
+	}
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// RequeueWebhookDelivery rimette in coda una consegna conclusa (dead o delivered) con un nuovo ciclo di tentativi.
+// Restituisce mongo.ErrNoDocuments se la consegna non esiste o è ancora in corso.
+func RequeueWebhookDelivery(ctx context.Context, subscriptionID, deliveryID string) (*models.WebhookDelivery, error) {
+	scope, err := sharedScope(ctx, constants.DELIVERIESCOLLECTION)
+	if err != nil {
+		return nil, err
+	}
+	filter := scope.filter(bson.M{
+		constants.DOCUMENT_ID: deliveryID,
+		"subscriptionId":      subscriptionID,
+		"status":              bson.M{"$in": bson.A{constants.DELIVERY_DEAD, constants.DELIVERY_DELIVERED}},
+	})
+	update := bson.M{constants.SET: bson.M{
+		"status":        constants.DELIVERY_PENDING,
+		"attemptCount":  0,
+		"nextAttemptAt": time.Now(),
+	}}
+
+	var delivery models.WebhookDelivery
+	err = scope.collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&delivery)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ensureWebhookIndexes crea gli indici delle consegne: unicità per evento, coda dei tentativi, storico e TTL.
+// Con la multi-tenancy abilitata crea anche l'indice delle sottoscrizioni di ogni tenant.
+func ensureWebhookIndexes(ctx context.Context, db *mongo.Database) error {
+	if tenancy.Enabled() {
+		_, err := db.Collection(constants.WEBHOOKSCOLLECTION).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: tenancy.Field, Value: 1}, {Key: "createdAt", Value: 1}},
+			Options: options.Index().SetName("tenantId_createdAt"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	retention := utils.EnvDurationOrDefault("WEBHOOK_DELIVERY_RETENTION", 30*24*time.Hour)
+	_, err := db.Collection(constants.DELIVERIESCOLLECTION).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "subscriptionId", Value: 1}, {Key: "eventId", Value: 1}},
+			Options: options.Index().SetName("subscriptionId_eventId").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}},
+			Options: options.Index().SetName("status_nextAttemptAt"),
+		},
+		{
+			Keys:    bson.D{{Key: "subscriptionId", Value: 1}, {Key: "createdAt", Value: -1}},
+			Options: options.Index().SetName("subscriptionId_createdAt"),
+		},
+		{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetName("createdAt_ttl").SetExpireAfterSeconds(int32(retention.Seconds())),
+		},
+	})
+	return err
+}
+
+// onlyDuplicateKeyErrors indica se l'errore di una scrittura non ordinata contiene solo violazioni di chiave duplicata
+func onlyDuplicateKeyErrors(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return mongo.IsDuplicateKeyError(err)
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,8 +1,10 @@
 package router
 
 import (
+	"myapp/internal/apikey"
 	"myapp/internal/handlers"
 	"myapp/internal/middleware"
+	"myapp/internal/repository"
 	"myapp/internal/utils"
 	"myapp/internal/utils/constants"
 	"net/http"
@@ -14,7 +16,7 @@ import (
 )
 
 // SetupRouter configura le rotte HTTP per l'applicazione.
-func SetupRouter(tracer *zipkin.Tracer) *mux.Router {
+func SetupRouter(tracer *zipkin.Tracer) http.Handler {
 	// Crea un nuovo router
 	r := mux.NewRouter()
 
@@ -22,19 +24,37 @@ func SetupRouter(tracer *zipkin.Tracer) *mux.Router {
 	zipkinMw := zipkinMiddleware.NewServerMiddleware(tracer, zipkinMiddleware.TagResponseSize(true))
 	r.Use(zipkinMw)
 
+	// Comprime le risposte secondo Accept-Encoding; registrato per primo così copre anche le risposte di errore degli altri middleware
+	r.Use(middleware.CompressionMiddleware)
+
 	// Applica middleware globali
 
-	r.Use(middleware.RateLimiterMiddleware)
 	r.Use(middleware.CorrelationIDMiddleware)
+	r.Use(middleware.AuditContextMiddleware)
+	// Limite per IP prima dell'autenticazione, così anche i tentativi con credenziali non valide sono limitati
+	r.Use(middleware.IPRateLimiterMiddleware)
+	// Con il mutual TLS l'identità del certificato client sostituisce l'attore dichiarato con X-Actor
+	r.Use(middleware.ClientCertMiddleware)
+	// Autentica gli access token degli utenti e le API key; gli scope sono verificati per gruppo di rotte
+	r.Use(middleware.BearerTokenMiddleware)
+	r.Use(middleware.APIKeyMiddleware)
+	// Risolve il tenant della richiesta (TENANCY_MODE) prima di qualsiasi accesso ai dati: dopo l'autenticazione,
+	// perché il tenant di una richiesta autenticata è quello delle credenziali e non quello scelto con un header
+	r.Use(middleware.TenantMiddleware)
+	// Il limite è per client (API key, utente, certificato o IP), quindi segue i middleware che lo identificano
+	r.Use(middleware.RateLimiterMiddleware)
 	r.Use(middleware.ErrorHandlerMiddleware)
 
-	// Definizione rotta per gli utenti
-	userRoutes := r.PathPrefix(constants.USERS).Subrouter()
-	userRoutes.HandleFunc(constants.BLANK, handlers.GetUsers(tracer)).Methods(constants.HTTPGet)
-	userRoutes.HandleFunc(constants.BLANK, handlers.CreateUser(tracer)).Methods(constants.HTTPPost)
-	userRoutes.HandleFunc(constants.ID, handlers.GetUserByID(tracer)).Methods(constants.HTTPGet)
-	userRoutes.HandleFunc(constants.ID, handlers.DeleteUserByID(tracer)).Methods(constants.HTTPDelete)
-	userRoutes.HandleFunc(constants.ID, handlers.UpdateUser(tracer)).Methods(constants.HTTPPut)
+	// Store delle chiavi di idempotenza per la creazione degli utenti (mongo o memory)
+	idempotency := middleware.IdempotencyMiddleware(repository.NewIdempotencyStore(utils.EnvOrDefault("IDEMPOTENCY_STORE", "mongo")))
+
+	// Rotte dell'API versionate sotto /v1
+	v1 := r.PathPrefix(constants.API_V1).Subrouter()
+	v1.Use(middleware.APIVersionMiddleware(constants.API_VERSION_V1))
+	registerAPIRoutes(v1, tracer, idempotency)
+
+	// Aggiunge una rotta per lo stato del servizio (usata da myctl health e dai probe)
+	r.HandleFunc(constants.HEALTH, handlers.Health()).Methods(constants.HTTPGet)
 
 	// Aggiunge una rotta per le metriche di Prometheus
 	r.Handle("/metrics", handlers.MetricsHandler())
@@ -42,10 +62,124 @@ func SetupRouter(tracer *zipkin.Tracer) *mux.Router {
 	// Aggiunge una rotta per la documentazione Swagger
 	r.PathPrefix("/swagger").Handler(httpSwagger.WrapHandler)
 
+	// Rotte senza prefisso: alias deprecati di /v1, mantenuti finché i client non hanno migrato.
+	// Il subrouter non ha condizioni, quindi è registrato dopo le rotte di infrastruttura.
+	unversioned := r.NewRoute().Subrouter()
+	unversioned.Use(middleware.DeprecatedAPIMiddleware(constants.API_VERSION_V1, constants.API_V1))
+	registerAPIRoutes(unversioned, tracer, idempotency)
+
 	// Aggiunge un handler per gestire le rotte non trovate (404)
 	r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
 
-	return r
+	// CORS e header di sicurezza avvolgono il router: mux non esegue i middleware registrati con Use
+	// per le richieste senza una rotta corrispondente, come le preflight OPTIONS e le risposte 404 e 405
+	return middleware.SecurityHeadersMiddleware(middleware.CORSMiddleware(r))
+}
+
+// registerAPIRoutes registra le rotte dell'API sul router di una versione
+func registerAPIRoutes(api *mux.Router, tracer *zipkin.Tracer, idempotency func(http.Handler) http.Handler) {
+	// Dimensione massima dei corpi delle richieste: l'import accetta file più grandi delle altre rotte
+	bodyLimit := middleware.BodyLimitMiddleware(int64(utils.EnvIntOrDefault("MAX_REQUEST_BODY_SIZE", 1<<20)))
+	importMaxBodySize := int64(utils.EnvIntOrDefault("IMPORT_MAX_BODY_SIZE", 64<<20))
+	importBodyLimit := middleware.BodyLimitMiddleware(importMaxBodySize)
+
+	// Scope richiesti alle API key e agli access token: con AUTH_REQUIRED=true le rotte rifiutano anche le richieste non autenticate;
+	// le rotte di amministrazione e il cambio della password richiedono sempre credenziali
+	authRequired := utils.EnvOrDefault("AUTH_REQUIRED", "false") == "true"
+	usersScope := middleware.RequireScope(apikey.ScopeUsersRead, apikey.ScopeUsersWrite, authRequired)
+	webhooksScope := middleware.RequireScope(apikey.ScopeWebhooksRead, apikey.ScopeWebhooksWrite, authRequired)
+	adminScope := middleware.RequireScope(apikey.ScopeAdminRead, apikey.ScopeAdminWrite, true)
+	passwordScope := middleware.RequireScope(apikey.ScopeUsersWrite, apikey.ScopeUsersWrite, true)
+	// Con un access token un utente legge e modifica solo il proprio account; elenchi, ricerche, export, stream e gruppi
+	// riguardano gli altri utenti e richiedono admin:read per le letture e admin:write per le modifiche
+	ownUser := middleware.RequireUserOwnership("id")
+	adminOnly := middleware.RequireUserOwnership("")
+
+	// Rotte che scelgono da sole il formato: export e import (CSV, NDJSON, array JSON), stream SSE e GraphQL (JSON per specifica).
+	// Le rotte statiche devono precedere /{id}, che altrimenti le intercetterebbe
+	streamRoutes := api.PathPrefix(constants.USERS).Subrouter()
+	streamRoutes.Use(usersScope, adminOnly, importBodyLimit)
+	streamRoutes.HandleFunc(constants.EXPORT, handlers.ExportUsers(tracer)).Methods(constants.HTTPGet)
+	streamRoutes.Handle(constants.IMPORT, middleware.DecompressionWithMinLimit(importMaxBodySize)(handlers.ImportUsers(tracer))).Methods(constants.HTTPPost)
+	streamRoutes.HandleFunc(constants.EVENTS, handlers.StreamUserEvents(tracer)).Methods(constants.HTTPGet)
+
+	// Endpoint GraphQL: query in GET o POST, mutation solo in POST (lo scope users:write delle mutation è verificato dall'handler)
+	graphqlScope := middleware.RequireScope(apikey.ScopeUsersRead, apikey.ScopeUsersRead, authRequired)
+	api.Handle(constants.GRAPHQL, graphqlScope(bodyLimit(handlers.GraphQL(tracer)))).Methods(constants.HTTPGet, constants.HTTPPost)
+
+	// Tutte le altre rotte rispondono nel formato negoziato con l'header Accept (JSON, XML, MessagePack o CSV)
+	negotiated := api.NewRoute().Subrouter()
+	negotiated.Use(bodyLimit, middleware.ContentNegotiationMiddleware)
+
+	// Definizione rotta per gli utenti
+	userRoutes := negotiated.PathPrefix(constants.USERS).Subrouter()
+	userRoutes.Use(usersScope, ownUser)
+	userRoutes.HandleFunc(constants.BLANK, handlers.GetUsers(tracer)).Methods(constants.HTTPGet)
+	userRoutes.Handle(constants.BLANK, idempotency(handlers.CreateUser(tracer))).Methods(constants.HTTPPost)
+	userRoutes.HandleFunc(constants.SEARCH, handlers.SearchUsers(tracer)).Methods(constants.HTTPGet)
+	userRoutes.HandleFunc(constants.ID, handlers.GetUserByID(tracer)).Methods(constants.HTTPGet)
+	userRoutes.HandleFunc(constants.ID, handlers.DeleteUserByID(tracer)).Methods(constants.HTTPDelete)
+	userRoutes.HandleFunc(constants.ID, handlers.UpdateUser(tracer)).Methods(constants.HTTPPut)
+	userRoutes.HandleFunc(constants.HISTORY, handlers.GetUserHistory(tracer)).Methods(constants.HTTPGet)
+	// Lo stato (sospensione, riattivazione, ...) non è modificabile dall'utente stesso
+	userRoutes.Handle(constants.STATUS_ACTION, adminOnly(handlers.ChangeUserStatus(tracer))).Methods(constants.HTTPPost)
+	userRoutes.HandleFunc(constants.USER_GROUPS, handlers.GetUserGroups(tracer)).Methods(constants.HTTPGet)
+
+	// Definizione rotte per i gruppi di utenti e i loro membri: stessa catena di middleware e stessi scope degli utenti;
+	// con un access token richiedono admin:read o admin:write
+	groupRoutes := negotiated.PathPrefix(constants.GROUPS).Subrouter()
+	groupRoutes.Use(usersScope, adminOnly)
+	groupRoutes.HandleFunc(constants.BLANK, handlers.GetGroups(tracer)).Methods(constants.HTTPGet)
+	groupRoutes.HandleFunc(constants.BLANK, handlers.CreateGroup(tracer)).Methods(constants.HTTPPost)
+	groupRoutes.HandleFunc(constants.ID, handlers.GetGroupByID(tracer)).Methods(constants.HTTPGet)
+	groupRoutes.HandleFunc(constants.ID, handlers.UpdateGroup(tracer)).Methods(constants.HTTPPut)
+	groupRoutes.HandleFunc(constants.ID, handlers.DeleteGroupByID(tracer)).Methods(constants.HTTPDelete)
+	groupRoutes.HandleFunc(constants.MEMBERS, handlers.GetGroupMembers(tracer)).Methods(constants.HTTPGet)
+	groupRoutes.HandleFunc(constants.MEMBERS, handlers.AddGroupMember(tracer)).Methods(constants.HTTPPost)
+	groupRoutes.HandleFunc(constants.MEMBER, handlers.UpdateGroupMember(tracer)).Methods(constants.HTTPPut)
+	groupRoutes.HandleFunc(constants.MEMBER, handlers.RemoveGroupMember(tracer)).Methods(constants.HTTPDelete)
+
+	// Rotte bulk (corpi eventualmente compressi, vedi DecompressionMiddleware): mux non accetta path di subrouter che non iniziano con "/", quindi sono registrate direttamente sul router della versione
+	negotiated.Handle(constants.USERS+constants.BATCH_CREATE, usersScope(adminOnly(middleware.DecompressionMiddleware(handlers.BatchCreateUsers(tracer))))).Methods(constants.HTTPPost)
+	negotiated.Handle(constants.USERS+constants.BATCH_UPDATE, usersScope(adminOnly(middleware.DecompressionMiddleware(handlers.BatchUpdateUsers(tracer))))).Methods(constants.HTTPPost)
+	negotiated.Handle(constants.USERS+constants.BATCH_DELETE, usersScope(adminOnly(middleware.DecompressionMiddleware(handlers.BatchDeleteUsers(tracer))))).Methods(constants.HTTPPost)
+	negotiated.Handle(constants.USERS+constants.PASSWORD, passwordScope(handlers.SetUserPassword(tracer))).Methods(constants.HTTPPut)
+
+	// Rotte di autenticazione degli utenti: aperte, il login è protetto dal rate limiter e dal blocco dopo i tentativi falliti
+	authRoutes := negotiated.PathPrefix(constants.AUTH).Subrouter()
+	authRoutes.HandleFunc(constants.LOGIN, handlers.Login(tracer)).Methods(constants.HTTPPost)
+	authRoutes.HandleFunc(constants.REFRESH, handlers.RefreshToken(tracer)).Methods(constants.HTTPPost)
+	authRoutes.HandleFunc(constants.LOGOUT, handlers.Logout(tracer)).Methods(constants.HTTPPost)
+	authRoutes.HandleFunc(constants.EMAIL_VERIFICATION, handlers.RequestEmailVerification(tracer)).Methods(constants.HTTPPost)
+	authRoutes.HandleFunc(constants.EMAIL_VERIFICATION_CONFIRM, handlers.ConfirmEmailVerification(tracer)).Methods(constants.HTTPPost)
+	authRoutes.HandleFunc(constants.PASSWORD_RESET, handlers.RequestPasswordReset(tracer)).Methods(constants.HTTPPost)
+	authRoutes.HandleFunc(constants.PASSWORD_RESET_CONFIRM, handlers.ResetPassword(tracer)).Methods(constants.HTTPPost)
+
+	// Definizione rotte per le sottoscrizioni webhook e il loro storico di consegne
+	webhookRoutes := negotiated.PathPrefix(constants.WEBHOOKS).Subrouter()
+	webhookRoutes.Use(webhooksScope)
+	webhookRoutes.HandleFunc(constants.BLANK, handlers.GetWebhooks(tracer)).Methods(constants.HTTPGet)
+	webhookRoutes.HandleFunc(constants.BLANK, handlers.CreateWebhook(tracer)).Methods(constants.HTTPPost)
+	webhookRoutes.HandleFunc(constants.ID, handlers.GetWebhookByID(tracer)).Methods(constants.HTTPGet)
+	webhookRoutes.HandleFunc(constants.ID, handlers.UpdateWebhook(tracer)).Methods(constants.HTTPPut)
+	webhookRoutes.HandleFunc(constants.ID, handlers.DeleteWebhookByID(tracer)).Methods(constants.HTTPDelete)
+	webhookRoutes.HandleFunc(constants.DELIVERIES, handlers.GetWebhookDeliveries(tracer)).Methods(constants.HTTPGet)
+	webhookRoutes.HandleFunc(constants.DELIVERY_REDELIVER, handlers.RedeliverWebhookDelivery(tracer)).Methods(constants.HTTPPost)
+
+	// Definizione rotte di amministrazione
+	adminRoutes := negotiated.PathPrefix(constants.ADMIN).Subrouter()
+	adminRoutes.Use(adminScope)
+	adminRoutes.HandleFunc(constants.AUDIT, handlers.QueryAuditLog(tracer)).Methods(constants.HTTPGet)
+	adminRoutes.HandleFunc(constants.API_KEYS, handlers.GetAPIKeys(tracer)).Methods(constants.HTTPGet)
+	adminRoutes.HandleFunc(constants.API_KEYS, handlers.CreateAPIKey(tracer)).Methods(constants.HTTPPost)
+	adminRoutes.HandleFunc(constants.API_KEYS+constants.ID, handlers.GetAPIKeyByID(tracer)).Methods(constants.HTTPGet)
+	adminRoutes.HandleFunc(constants.API_KEYS+constants.ID, handlers.RevokeAPIKey(tracer)).Methods(constants.HTTPDelete)
+	adminRoutes.HandleFunc(constants.USER_ATTRIBUTES_SCHEMA, handlers.GetUserAttributeSchema(tracer)).Methods(constants.HTTPGet)
+	adminRoutes.HandleFunc(constants.USER_ATTRIBUTES_SCHEMA, handlers.SetUserAttributeSchema(tracer)).Methods(constants.HTTPPut)
+	adminRoutes.HandleFunc(constants.USER_ATTRIBUTES_SCHEMAS, handlers.ListUserAttributeSchemas(tracer)).Methods(constants.HTTPGet)
+	adminRoutes.HandleFunc(constants.USER_ATTRIBUTES_NAMESPACE, handlers.GetUserAttributeSchema(tracer)).Methods(constants.HTTPGet)
+	adminRoutes.HandleFunc(constants.USER_ATTRIBUTES_NAMESPACE, handlers.SetUserAttributeSchema(tracer)).Methods(constants.HTTPPut)
+	adminRoutes.HandleFunc(constants.USER_ATTRIBUTES_NAMESPACE, handlers.DeleteUserAttributeSchema(tracer)).Methods(constants.HTTPDelete)
 }
 
 // notFoundHandler gestisce gli errori 404 per le rotte non definite.
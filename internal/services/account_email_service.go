@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/internal/jwt"
+	"myapp/internal/mail"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// emailDeliveryTimeout limita la ricerca dell'utente, il salvataggio del token e l'invio dell'email
+const emailDeliveryTimeout = 30 * time.Second
+
+var (
+	// ErrEmailRequired indica una richiesta di verifica o di reset senza indirizzo email
+	ErrEmailRequired = errors.New("email is required")
+	// ErrInvalidEmailToken indica un token di verifica o di reset non valido, scaduto o già usato
+	ErrInvalidEmailToken = errors.New("invalid or expired token")
+)
+
+// emailSettings è la configurazione dei token inviati per email letta dall'ambiente
+type emailSettings struct {
+	sender          mail.Sender
+	verificationTTL time.Duration
+	resetTTL        time.Duration
+	resendInterval  time.Duration
+	verificationURL *url.URL
+	resetURL        *url.URL
+}
+
+var (
+	emailConfig     *emailSettings
+	emailConfigOnce sync.Once
+)
+
+// getEmailSettings legge la configurazione alla prima richiesta: il sender (MAIL_SENDER), la durata dei token
+// (EMAIL_VERIFICATION_TOKEN_TTL, PASSWORD_RESET_TOKEN_TTL), l'intervallo minimo tra due invii allo stesso utente
+// (EMAIL_TOKEN_RESEND_INTERVAL) e le pagine del frontend a cui puntano i link (EMAIL_VERIFICATION_URL, PASSWORD_RESET_URL)
+func getEmailSettings() *emailSettings {
+	emailConfigOnce.Do(func() {
+		log := utils.WithContext().WithField("function", "getEmailSettings")
+		sender, err := mail.NewSenderFromEnv()
+		if err != nil {
+			log.Fatalf("Error configuring mail sender: %v", err)
+		}
+		emailConfig = &emailSettings{
+			sender:          sender,
+			verificationTTL: utils.EnvDurationOrDefault("EMAIL_VERIFICATION_TOKEN_TTL", 24*time.Hour),
+			resetTTL:        utils.EnvDurationOrDefault("PASSWORD_RESET_TOKEN_TTL", time.Hour),
+			resendInterval:  utils.EnvDurationOrDefault("EMAIL_TOKEN_RESEND_INTERVAL", time.Minute),
+			verificationURL: absoluteURLFromEnv("EMAIL_VERIFICATION_URL", "http://localhost:8080/verify-email"),
+			resetURL:        absoluteURLFromEnv("PASSWORD_RESET_URL", "http://localhost:8080/reset-password"),
+		}
+		log.Infof("Mail sender: %s", sender.Name())
+	})
+	return emailConfig
+}
+
+// absoluteURLFromEnv legge dalla variabile indicata l'URL assoluto di una pagina del frontend
+func absoluteURLFromEnv(name, fallback string) *url.URL {
+	parsed, err := url.Parse(utils.EnvOrDefault(name, fallback))
+	if err != nil || !parsed.IsAbs() {
+		utils.WithContext().WithField("function", "absoluteURLFromEnv").Fatalf("%s must be an absolute URL", name)
+	}
+	return parsed
+}
+
+// RequestEmailVerification invia all'indirizzo indicato un link per confermarlo.
+// La risposta non dipende dall'esistenza dell'utente: ricerca e invio avvengono in background
+// e gli errori sono solo registrati nel log.
+func RequestEmailVerification(ctx context.Context, email string) error {
+	return requestEmailToken(ctx, jwt.TypeEmailVerification, email)
+}
+
+// RequestPasswordReset invia all'indirizzo indicato un link per impostare una nuova password,
+// con le stesse garanzie di RequestEmailVerification
+func RequestPasswordReset(ctx context.Context, email string) error {
+	return requestEmailToken(ctx, jwt.TypePasswordReset, email)
+}
+
+// ConfirmEmailVerification consuma un token di verifica e registra l'indirizzo come verificato.
+// Il token vale solo per l'indirizzo a cui è stato inviato: se nel frattempo l'email dell'utente è cambiata è rifiutato.
+// Un utente in stato pending diventa attivo.
+func ConfirmEmailVerification(ctx context.Context, token string) error {
+	claims, user, err := emailTokenUser(ctx, jwt.TypeEmailVerification, token)
+	if err != nil {
+		return err
+	}
+	if err := consumeEmailToken(ctx, claims); err != nil {
+		return err
+	}
+	if err := repository.SetEmailVerified(ctx, user.ID, user.Email, time.Now().UTC()); err != nil {
+		return err
+	}
+	utils.WithContext().Infof("Email dell'utente %s verificata", user.ID)
+	if user.Status == constants.USER_STATUS_PENDING {
+		var transition *StatusTransitionError
+		_, err := ChangeUserStatus(ctx, user.ID, constants.USER_ACTION_ACTIVATE, "email verified")
+		// Lo stato può essere cambiato nel frattempo da un amministratore: la verifica resta valida
+		if err != nil && !errors.As(err, &transition) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetPassword consuma un token di reset e imposta la nuova password; come SetPassword revoca le sessioni dell'utente.
+// Come quello di verifica, il token vale solo per l'indirizzo a cui è stato inviato.
+// Gli altri link di reset ancora validi sono invalidati.
+func ResetPassword(ctx context.Context, req models.PasswordResetRequest) error {
+	// Password e conflitti sull'email sono verificati prima di consumare il token, così un errore non brucia il link
+	if err := validatePassword(req.NewPassword, getAuthSettings().minPasswordLength); err != nil {
+		return err
+	}
+	claims, user, err := emailTokenUser(ctx, jwt.TypePasswordReset, req.Token)
+	if err != nil {
+		return err
+	}
+	if _, err := ownCredentials(ctx, user); err != nil {
+		return err
+	}
+	if err := consumeEmailToken(ctx, claims); err != nil {
+		return err
+	}
+	if err := storePassword(ctx, user.ID, req.NewPassword); err != nil {
+		return err
+	}
+	return repository.InvalidateEmailTokens(ctx, user.ID, jwt.TypePasswordReset, time.Now().UTC())
+}
+
+// requestEmailToken verifica la richiesta e avvia in background l'invio del token
+func requestEmailToken(ctx context.Context, purpose, email string) error {
+	auth := getAuthSettings()
+	if len(auth.secret) == 0 {
+		return ErrAuthDisabled
+	}
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return ErrEmailRequired
+	}
+	if _, err := tenancy.Require(ctx); err != nil {
+		return err
+	}
+	settings := getEmailSettings()
+
+	// Il contesto della richiesta è annullato alla risposta: l'invio usa un contesto proprio con gli stessi valori (tenant, log)
+	deliveryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), emailDeliveryTimeout)
+	go func() {
+		defer cancel()
+		if err := deliverEmailToken(deliveryCtx, auth, settings, purpose, email); err != nil {
+			utils.WithContext().WithField("function", "deliverEmailToken").Errorf("Error sending %s email: %v", purpose, err)
+		}
+	}()
+	return nil
+}
+
+// deliverEmailToken emette un token per l'utente con l'email indicata e glielo invia. Non fa nulla se l'utente
+// non esiste o se ha già ricevuto un token entro EMAIL_TOKEN_RESEND_INTERVAL; il nuovo token invalida i precedenti.
+func deliverEmailToken(ctx context.Context, auth *authSettings, settings *emailSettings, purpose, email string) error {
+	log := utils.WithContext()
+	userID, err := userForEmail(ctx, email)
+	if err != nil || userID == "" {
+		return err
+	}
+	now := time.Now().UTC()
+	recent, err := repository.EmailTokenIssuedSince(ctx, userID, purpose, now.Add(-settings.resendInterval))
+	if err != nil {
+		return err
+	}
+	if recent {
+		log.Infof("Token %s per l'utente %s già inviato negli ultimi %s", purpose, userID, settings.resendInterval)
+		return nil
+	}
+	if err := repository.InvalidateEmailTokens(ctx, userID, purpose, now); err != nil {
+		return err
+	}
+
+	ttl, link := settings.verificationTTL, *settings.verificationURL
+	if purpose == jwt.TypePasswordReset {
+		ttl, link = settings.resetTTL, *settings.resetURL
+	}
+	tokenID, err := utils.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	// Il token è legato all'indirizzo a cui è inviato: se l'email dell'utente cambia non è più valido
+	claims := jwt.Claims{
+		jwt.ClaimSubject:   userID,
+		jwt.ClaimType:      purpose,
+		jwt.ClaimID:        tokenID,
+		jwt.ClaimEmail:     NormalizeEmail(email),
+		jwt.ClaimIssuedAt:  now.Unix(),
+		jwt.ClaimExpiresAt: now.Add(ttl).Unix(),
+	}
+	tenant, err := tenancy.Require(ctx)
+	if err != nil {
+		return err
+	}
+	if tenant != "" {
+		claims[auth.tenantClaim] = tenant
+	}
+	token, err := jwt.Sign(claims, auth.secret)
+	if err != nil {
+		return err
+	}
+	err = repository.InsertEmailToken(ctx, models.EmailToken{
+		ID:        tokenID,
+		UserID:    userID,
+		Purpose:   purpose,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	if err := settings.sender.Send(ctx, emailTokenMessage(purpose, email, link.String(), ttl)); err != nil {
+		return err
+	}
+	log.Infof("Token %s inviato all'utente %s", purpose, userID)
+	return nil
+}
+
+// userForEmail restituisce l'utente a cui inviare il token: l'unico con quell'email o, se l'email è condivisa,
+// l'unico che ha una password (lo stesso che può eseguire il login). Vuoto se non è individuabile.
+func userForEmail(ctx context.Context, email string) (string, error) {
+	ids, err := repository.FindUserIDsByEmail(ctx, NormalizeEmail(email), maxEmailMatches)
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	if len(ids) == 1 {
+		return ids[0], nil
+	}
+	credentials, err := repository.FindCredentials(ctx, ids)
+	if err != nil {
+		return "", err
+	}
+	if len(credentials) != 1 {
+		utils.WithContext().Warnf("Nessun token inviato: %d utenti con la stessa email", len(ids))
+		return "", nil
+	}
+	return credentials[0].UserID, nil
+}
+
+// emailTokenUser verifica firma, scadenza, tipo e tenant del token senza consumarlo e restituisce l'utente a cui
+// è stato inviato, se la sua email è ancora quella del token
+func emailTokenUser(ctx context.Context, purpose, token string) (jwt.Claims, *models.User, error) {
+	auth := getAuthSettings()
+	if len(auth.secret) == 0 {
+		return nil, nil, ErrAuthDisabled
+	}
+	claims, err := jwt.Parse(strings.TrimSpace(token), auth.secret)
+	if err != nil || claims.String(jwt.ClaimType) != purpose {
+		return nil, nil, ErrInvalidEmailToken
+	}
+	tenant, err := tenancy.Require(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims.String(jwt.ClaimID) == "" || claims.String(auth.tenantClaim) != tenant {
+		return nil, nil, ErrInvalidEmailToken
+	}
+
+	user, err := getUserForAuth(ctx, claims.String(jwt.ClaimSubject))
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil, ErrInvalidEmailToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if email := claims.String(jwt.ClaimEmail); email == "" || NormalizeEmail(user.Email) != email {
+		return nil, nil, ErrInvalidEmailToken
+	}
+	return claims, user, nil
+}
+
+// consumeEmailToken segna come usato il token verificato da emailTokenUser; un token già usato è rifiutato
+func consumeEmailToken(ctx context.Context, claims jwt.Claims) error {
+	stored, err := repository.ConsumeEmailToken(ctx, claims.String(jwt.ClaimID), claims.String(jwt.ClaimType), time.Now().UTC())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrInvalidEmailToken
+	}
+	if err != nil {
+		return err
+	}
+	if stored.UserID != claims.String(jwt.ClaimSubject) {
+		return ErrInvalidEmailToken
+	}
+	return nil
+}
+
+// emailTokenMessage compone l'email con il link del token
+func emailTokenMessage(purpose, to, link string, ttl time.Duration) mail.Message {
+	if purpose == jwt.TypePasswordReset {
+		return mail.Message{
+			To:      to,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Open the following link to choose a new password:\n\n%s\n\n"+
+				"The link can be used once and expires in %s. If you did not request a password reset, ignore this email: your password will not change.\n",
+				link, ttl),
+		}
+	}
+	return mail.Message{
+		To:      to,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Open the following link to confirm your email address:\n\n%s\n\n"+
+			"The link can be used once and expires in %s. If you did not create an account, ignore this email.\n",
+			link, ttl),
+	}
+}
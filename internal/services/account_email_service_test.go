@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/config"
+	"myapp/internal/jwt"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// emailToken firma un token inviato per email all'utente con l'ID indicato; email vuota omette il claim
+func emailToken(t *testing.T, purpose, userID, email string) string {
+	t.Helper()
+	claims := jwt.Claims{
+		jwt.ClaimSubject:   userID,
+		jwt.ClaimType:      purpose,
+		jwt.ClaimID:        "token-1",
+		jwt.ClaimExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	if email != "" {
+		claims[jwt.ClaimEmail] = email
+	}
+	token, err := jwt.Sign(claims, testAuthSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestResetPassword(t *testing.T) {
+	useTestAuthSettings()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	userID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+	usersNamespace := "myapp." + constants.USERSCOLLECTION
+	storedUser := bson.D{{Key: "_id", Value: userID}, {Key: "name", Value: "Ada"}, {Key: "email", Value: "ada@example.com"}}
+
+	mt.Run("consumes the token bound to the current email", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch, storedUser),
+			mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch, bson.D{{Key: "_id", Value: userID}}),
+			mtest.CreateCursorResponse(0, "myapp."+constants.CREDENTIALSCOLLECTION, mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "_id", Value: "token-1"}, {Key: "userId", Value: userID.Hex()}}}),
+			mtest.CreateSuccessResponse(), // password
+			mtest.CreateSuccessResponse(), // revoca delle sessioni
+			mtest.CreateSuccessResponse(), // invalidazione degli altri link
+		)
+
+		err := ResetPassword(context.Background(), models.PasswordResetRequest{
+			Token:       emailToken(mt.T, jwt.TypePasswordReset, userID.Hex(), "ada@example.com"),
+			NewPassword: "correct horse battery",
+		})
+		if err != nil {
+			mt.Fatalf("reset: %v", err)
+		}
+		names := commands(mt)
+		if len(names) != 7 || names[3] != "findAndModify" {
+			mt.Errorf("commands %v, want the token consumed after the checks", names)
+		}
+	})
+
+	mt.Run("token sent to a previous email is rejected without consuming it", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch, storedUser))
+
+		err := ResetPassword(context.Background(), models.PasswordResetRequest{
+			Token:       emailToken(mt.T, jwt.TypePasswordReset, userID.Hex(), "old@example.com"),
+			NewPassword: "correct horse battery",
+		})
+		if !errors.Is(err, ErrInvalidEmailToken) {
+			mt.Fatalf("err %v, want ErrInvalidEmailToken", err)
+		}
+		if names := commands(mt); len(names) != 1 {
+			mt.Errorf("commands %v, want only the user lookup", names)
+		}
+	})
+
+	mt.Run("token without email is rejected", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch, storedUser))
+
+		err := ResetPassword(context.Background(), models.PasswordResetRequest{
+			Token:       emailToken(mt.T, jwt.TypePasswordReset, userID.Hex(), ""),
+			NewPassword: "correct horse battery",
+		})
+		if !errors.Is(err, ErrInvalidEmailToken) {
+			mt.Fatalf("err %v, want ErrInvalidEmailToken", err)
+		}
+	})
+
+	mt.Run("email held by another login keeps the token", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch, storedUser),
+			mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch, bson.D{{Key: "_id", Value: userID}}, bson.D{{Key: "_id", Value: otherID}}),
+			mtest.CreateCursorResponse(0, "myapp."+constants.CREDENTIALSCOLLECTION, mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: otherID.Hex()}, {Key: "passwordHash", Value: "hash"}}),
+		)
+
+		err := ResetPassword(context.Background(), models.PasswordResetRequest{
+			Token:       emailToken(mt.T, jwt.TypePasswordReset, userID.Hex(), "ada@example.com"),
+			NewPassword: "correct horse battery",
+		})
+		if !errors.Is(err, ErrEmailInUse) {
+			mt.Fatalf("err %v, want ErrEmailInUse", err)
+		}
+		for _, name := range commands(mt) {
+			if name == "findAndModify" {
+				mt.Errorf("the token was consumed")
+			}
+		}
+	})
+
+	mt.Run("verification tokens are not reset tokens", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		err := ResetPassword(context.Background(), models.PasswordResetRequest{
+			Token:       emailToken(mt.T, jwt.TypeEmailVerification, userID.Hex(), "ada@example.com"),
+			NewPassword: "correct horse battery",
+		})
+		if !errors.Is(err, ErrInvalidEmailToken) {
+			mt.Fatalf("err %v, want ErrInvalidEmailToken", err)
+		}
+		if names := commands(mt); len(names) != 0 {
+			mt.Errorf("commands %v, want none", names)
+		}
+	})
+}
+
+func TestConfirmEmailVerificationRejectsChangedEmail(t *testing.T) {
+	useTestAuthSettings()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	userID := primitive.NewObjectID()
+
+	mt.Run("changed email", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "myapp."+constants.USERSCOLLECTION, mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: userID}, {Key: "name", Value: "Ada"}, {Key: "email", Value: "new@example.com"}}))
+
+		err := ConfirmEmailVerification(context.Background(), emailToken(mt.T, jwt.TypeEmailVerification, userID.Hex(), "ada@example.com"))
+		if !errors.Is(err, ErrInvalidEmailToken) {
+			mt.Fatalf("err %v, want ErrInvalidEmailToken", err)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/internal/apikey"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxAPIKeyNameLength è la lunghezza massima del nome di una API key
+const maxAPIKeyNameLength = 100
+
+var (
+	// ErrInvalidAPIKey indica una richiesta di creazione non valida (nome, scope o scadenza)
+	ErrInvalidAPIKey = errors.New("invalid API key request")
+	// ErrAPIKeyNotFound indica che la API key non esiste
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// CreateAPIKey crea una API key nel tenant del contesto. Il valore in chiaro è restituito solo qui:
+// nel database restano solo sale e hash.
+func CreateAPIKey(ctx context.Context, req models.APIKeyRequest) (*models.APIKey, error) {
+	log := utils.WithContext()
+
+	key, err := apiKeyFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var secret string
+	if key.ID, secret, key.Key, err = apikey.Generate(); err != nil {
+		return nil, err
+	}
+	if key.Salt, key.Hash, err = apikey.HashSecret(secret); err != nil {
+		return nil, err
+	}
+	key.CreatedBy = middleware.GetActor(ctx)
+	key.CreatedAt = time.Now().UTC()
+
+	if err := repository.InsertAPIKey(ctx, key); err != nil {
+		return nil, err
+	}
+	log.Infof("API key %s (%s) creata da %s con scope %v", key.ID, key.Name, key.CreatedBy, key.Scopes)
+	return &key, nil
+}
+
+// GetAPIKeys restituisce le API key del tenant, anche revocate e scadute
+func GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return repository.GetAPIKeys(ctx)
+}
+
+// GetAPIKeyByID restituisce una API key
+func GetAPIKeyByID(ctx context.Context, id string) (*models.APIKey, error) {
+	key, err := repository.GetAPIKeyByID(ctx, id)
+	if err != nil {
+		return nil, apiKeyError(err)
+	}
+	return key, nil
+}
+
+// RevokeAPIKey revoca una API key: le richieste successive con quella chiave sono rifiutate.
+// La chiave resta nell'elenco con la data di revoca; revocarla di nuovo non la modifica.
+func RevokeAPIKey(ctx context.Context, id string) (*models.APIKey, error) {
+	key, err := repository.GetAPIKeyByID(ctx, id)
+	if err != nil {
+		return nil, apiKeyError(err)
+	}
+	if key.RevokedAt != nil {
+		return key, nil
+	}
+	revokedAt := time.Now().UTC()
+	if err := repository.RevokeAPIKey(ctx, id, revokedAt); err != nil {
+		return nil, apiKeyError(err)
+	}
+	key.RevokedAt = &revokedAt
+	utils.WithContext().Infof("API key %s (%s) revocata da %s", id, key.Name, middleware.GetActor(ctx))
+	return key, nil
+}
+
+// apiKeyFromRequest valida la richiesta e la converte in una API key (senza ID, chiave e date di creazione)
+func apiKeyFromRequest(req models.APIKeyRequest) (models.APIKey, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		return models.APIKey{}, fmt.Errorf("%w: name is required (max %d characters)", ErrInvalidAPIKey, maxAPIKeyNameLength)
+	}
+
+	if len(req.Scopes) == 0 {
+		return models.APIKey{}, fmt.Errorf("%w: at least one scope is required (%s)", ErrInvalidAPIKey, strings.Join(apikey.Scopes, ", "))
+	}
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !apikey.ValidScope(scope) {
+			return models.APIKey{}, fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKey, scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			return models.APIKey{}, fmt.Errorf("%w: expiresAt must be in the future", ErrInvalidAPIKey)
+		}
+		utc := req.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+	return models.APIKey{Name: name, Scopes: scopes, ExpiresAt: expiresAt}, nil
+}
+
+// apiKeyError converte l'assenza del documento in ErrAPIKeyNotFound
+func apiKeyError(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"reflect"
+	"sort"
+)
+
+// GetUserHistory restituisce lo storico delle modifiche di un utente, dalla più recente.
+// Lo storico resta disponibile anche dopo l'eliminazione dell'utente.
+func GetUserHistory(ctx context.Context, id string, page, pageSize int) (*models.AuditPage, error) {
+	return QueryAuditLog(ctx, models.AuditQuery{UserID: id}, page, pageSize)
+}
+
+// QueryAuditLog restituisce le voci dell'audit log filtrate per utente, attore e periodo
+func QueryAuditLog(ctx context.Context, query models.AuditQuery, page, pageSize int) (*models.AuditPage, error) {
+	entries, total, err := repository.FindAuditEntries(ctx, query, int64((page-1)*pageSize), int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+	return &models.AuditPage{Page: page, PageSize: pageSize, Total: total, Entries: entries}, nil
+}
+
+// newAuditEntries crea le voci dell'audit log per gli eventi, con attore e IP del client presi dal contesto della richiesta
+func newAuditEntries(ctx context.Context, events []models.UserEvent) ([]models.AuditEntry, error) {
+	entries := make([]models.AuditEntry, len(events))
+	for i, event := range events {
+		changes, err := diffUsers(event.Before, event.After)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = models.AuditEntry{
+			ID:            event.ID,
+			UserID:        event.UserID,
+			Action:        event.Type,
+			Actor:         middleware.GetActor(ctx),
+			CorrelationID: event.CorrelationID,
+			ClientIP:      middleware.GetClientIP(ctx),
+			Timestamp:     event.OccurredAt,
+			Changes:       changes,
+		}
+	}
+	return entries, nil
+}
+
+// diffUsers confronta i campi JSON dei due snapshot (nil per creazione e cancellazione) e restituisce quelli cambiati,
+// in ordine alfabetico. Il confronto è generico, quindi include automaticamente i campi aggiunti in futuro a models.User.
+func diffUsers(before, after *models.User) ([]models.FieldChange, error) {
+	oldFields, err := userFields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := userFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(oldFields)+len(newFields))
+	for name := range oldFields {
+		names[name] = struct{}{}
+	}
+	for name := range newFields {
+		names[name] = struct{}{}
+	}
+	// L'ID identifica la voce, non è una modifica
+	delete(names, "id")
+
+	changes := []models.FieldChange{}
+	for name := range names {
+		oldValue, newValue := oldFields[name], newFields[name]
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, models.FieldChange{Field: name, Old: oldValue, New: newValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// userFields converte un utente nella mappa dei suoi campi JSON (vuota per nil)
+func userFields(user *models.User) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if user == nil {
+		return fields, nil
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
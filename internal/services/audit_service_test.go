@@ -0,0 +1,42 @@
+package services
+
+import (
+	"myapp/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestDiffUsers(t *testing.T) {
+	ada := &models.User{ID: "6650f1a2b3c4d5e6f7a8b9c0", Name: "Ada", Email: "ada@example.com"}
+	renamed := &models.User{ID: "6650f1a2b3c4d5e6f7a8b9c0", Name: "Ada Lovelace", Email: "ada@example.com"}
+
+	cases := []struct {
+		name          string
+		before, after *models.User
+		want          []models.FieldChange
+	}{
+		{"create", nil, ada, []models.FieldChange{
+			{Field: "email", Old: nil, New: "ada@example.com"},
+			{Field: "name", Old: nil, New: "Ada"},
+		}},
+		{"delete", ada, nil, []models.FieldChange{
+			{Field: "email", Old: "ada@example.com", New: nil},
+			{Field: "name", Old: "Ada", New: nil},
+		}},
+		{"update", ada, renamed, []models.FieldChange{
+			{Field: "name", Old: "Ada", New: "Ada Lovelace"},
+		}},
+		{"unchanged", ada, ada, []models.FieldChange{}},
+		// L'ID identifica la voce e non è mai riportato come modifica
+		{"id ignored", ada, &models.User{Name: "Ada", Email: "ada@example.com"}, []models.FieldChange{}},
+	}
+	for _, tc := range cases {
+		changes, err := diffUsers(tc.before, tc.after)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(changes, tc.want) {
+			t.Errorf("%s: changes = %+v, want %+v", tc.name, changes, tc.want)
+		}
+	}
+}
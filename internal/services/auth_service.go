@@ -0,0 +1,521 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/internal/apikey"
+	"myapp/internal/jwt"
+	"myapp/internal/models"
+	"myapp/internal/password"
+	"myapp/internal/repository"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxPasswordLength limita la lunghezza delle password: argon2 elabora tutto l'input
+const maxPasswordLength = 128
+
+// maxEmailMatches è il numero massimo di utenti con la stessa email esaminati dal login
+const maxEmailMatches = 10
+
+var (
+	// ErrAuthDisabled indica che il login non è configurato (JWT_SECRET non impostato)
+	ErrAuthDisabled = errors.New("authentication is not configured")
+	// ErrInvalidCredentials indica email o password errate, senza distinguere i due casi
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrInvalidRefreshToken indica un refresh token non valido, scaduto, revocato o già utilizzato
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrInvalidPassword indica una nuova password che non rispetta i requisiti
+	ErrInvalidPassword = errors.New("invalid password")
+	// ErrWrongPassword indica una password attuale errata nel cambio della propria password
+	ErrWrongPassword = errors.New("current password is incorrect")
+	// ErrEmailInUse indica un'email già usata per il login da un altro utente
+	ErrEmailInUse = errors.New("another user with the same email already has a password")
+	// ErrEmailNotVerified indica un login con password corretta ma email non ancora verificata (AUTH_REQUIRE_VERIFIED_EMAIL)
+	ErrEmailNotVerified = errors.New("email address not verified")
+	// ErrAccountInactive indica un login o un rinnovo della sessione di un utente non attivo (pending, suspended o deactivated)
+	ErrAccountInactive = errors.New("account is not active")
+	// ErrUserNotFound indica che l'utente non esiste
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// AccountLockedError indica un login bloccato dopo troppi tentativi falliti
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return "account temporarily locked after too many failed login attempts"
+}
+
+// authSettings è la configurazione del login letta dall'ambiente
+type authSettings struct {
+	secret            []byte
+	tenantClaim       string
+	accessTTL         time.Duration
+	refreshTTL        time.Duration
+	scopes            []string
+	maxAttempts       int
+	lockout           time.Duration
+	minPasswordLength int
+	requireVerified   bool
+}
+
+var (
+	authConfig     *authSettings
+	authConfigOnce sync.Once
+)
+
+// getAuthSettings legge la configurazione del login alla prima richiesta:
+// JWT_SECRET firma i token (lo stesso segreto con cui TenantMiddleware legge il claim del tenant),
+// AUTH_ACCESS_TOKEN_TTL e AUTH_REFRESH_TOKEN_TTL ne stabiliscono la durata, AUTH_TOKEN_SCOPES gli scope concessi agli utenti (default solo users:read),
+// LOGIN_MAX_ATTEMPTS e LOGIN_LOCKOUT_DURATION il blocco dopo i tentativi falliti,
+// AUTH_REQUIRE_VERIFIED_EMAIL se il login richiede un'email verificata.
+func getAuthSettings() *authSettings {
+	authConfigOnce.Do(func() {
+		log := utils.WithContext().WithField("function", "getAuthSettings")
+		authConfig = &authSettings{
+			secret:            []byte(utils.EnvOrDefault("JWT_SECRET", "")),
+			tenantClaim:       utils.EnvOrDefault("TENANT_JWT_CLAIM", "tenant"),
+			accessTTL:         utils.EnvDurationOrDefault("AUTH_ACCESS_TOKEN_TTL", 15*time.Minute),
+			refreshTTL:        utils.EnvDurationOrDefault("AUTH_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+			maxAttempts:       utils.EnvIntOrDefault("LOGIN_MAX_ATTEMPTS", 5),
+			lockout:           utils.EnvDurationOrDefault("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			minPasswordLength: utils.EnvIntOrDefault("PASSWORD_MIN_LENGTH", 12),
+			requireVerified:   utils.EnvOrDefault("AUTH_REQUIRE_VERIFIED_EMAIL", "false") == "true",
+		}
+		for _, scope := range strings.Split(utils.EnvOrDefault("AUTH_TOKEN_SCOPES", apikey.ScopeUsersRead), ",") {
+			if scope = strings.TrimSpace(scope); scope == "" {
+				continue
+			}
+			if !apikey.ValidScope(scope) {
+				log.Fatalf("Invalid scope %q in AUTH_TOKEN_SCOPES", scope)
+			}
+			authConfig.scopes = append(authConfig.scopes, scope)
+		}
+		if authConfig.maxAttempts < 1 {
+			log.Fatal("LOGIN_MAX_ATTEMPTS must be at least 1")
+		}
+	})
+	return authConfig
+}
+
+// Login verifica email e password e restituisce un access token e un refresh token.
+// Dopo LOGIN_MAX_ATTEMPTS tentativi falliti consecutivi il login dell'utente è bloccato per LOGIN_LOCKOUT_DURATION
+// (AccountLockedError); email inesistenti e password errate restituiscono lo stesso errore nello stesso tempo.
+// Solo gli utenti attivi possono accedere (ErrAccountInactive).
+func Login(ctx context.Context, req models.LoginRequest) (*models.TokenResponse, error) {
+	log := utils.WithContext()
+	settings := getAuthSettings()
+	if len(settings.secret) == 0 {
+		return nil, ErrAuthDisabled
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" || req.Password == "" || len(req.Password) > maxPasswordLength {
+		return nil, ErrInvalidCredentials
+	}
+
+	credentials, err := credentialsForEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if credentials == nil {
+		password.Waste(req.Password)
+		return nil, ErrInvalidCredentials
+	}
+
+	now := time.Now().UTC()
+	if credentials.LockedUntil != nil && now.Before(*credentials.LockedUntil) {
+		return nil, &AccountLockedError{Until: *credentials.LockedUntil}
+	}
+	match, rehash, err := password.Verify(req.Password, credentials.PasswordHash)
+	if err != nil {
+		log.Errorf("Hash della password non valido per l'utente %s: %v", credentials.UserID, err)
+		return nil, err
+	}
+	if !match {
+		updated, err := repository.RecordLoginFailure(ctx, credentials.UserID, settings.maxAttempts, now.Add(settings.lockout))
+		if err != nil {
+			return nil, err
+		}
+		if updated.LockedUntil != nil && updated.LockedUntil.After(now) {
+			log.Warnf("Login dell'utente %s bloccato fino a %s dopo %d tentativi falliti", credentials.UserID, updated.LockedUntil.Format(time.RFC3339), settings.maxAttempts)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if rehash {
+		// Parametri di argon2 cambiati: l'hash è ricalcolato ora che la password è disponibile
+		hash, err := password.Hash(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		if err := repository.SetPasswordHash(ctx, credentials.UserID, hash, credentials.PasswordChangedAt); err != nil {
+			return nil, err
+		}
+	} else if credentials.FailedAttempts > 0 || credentials.LockedUntil != nil {
+		if err := repository.ResetLoginFailures(ctx, credentials.UserID); err != nil {
+			return nil, err
+		}
+	}
+	// Verificato solo dopo la password: chi non la conosce non scopre lo stato dell'email
+	if settings.requireVerified && credentials.VerifiedEmail != email {
+		return nil, ErrEmailNotVerified
+	}
+	if err := requireActiveUser(ctx, credentials.UserID); err != nil {
+		return nil, err
+	}
+
+	familyID, err := utils.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Login dell'utente %s", credentials.UserID)
+	return issueTokens(ctx, settings, credentials.UserID, familyID, now)
+}
+
+// RefreshTokens sostituisce un refresh token con una nuova coppia di token della stessa famiglia.
+// Ogni refresh token si può usare una sola volta: il riuso di un token già sostituito (es. rubato) revoca l'intera famiglia,
+// così né il client legittimo né chi l'ha sottratto possono continuare a rinnovare la sessione.
+func RefreshTokens(ctx context.Context, refreshToken string) (*models.TokenResponse, error) {
+	settings := getAuthSettings()
+	if len(settings.secret) == 0 {
+		return nil, ErrAuthDisabled
+	}
+	id, userID, err := parseRefreshToken(ctx, settings, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	stored, err := repository.RotateRefreshToken(ctx, id, now)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		previous, err := repository.GetRefreshToken(ctx, id)
+		if err == nil && previous.RotatedAt != nil && previous.RevokedAt == nil {
+			utils.WithContext().Warnf("Riuso del refresh token %s dell'utente %s: revoco la famiglia %s", id, previous.UserID, previous.FamilyID)
+			if err := repository.RevokeRefreshTokenFamily(ctx, previous.FamilyID, now); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.UserID != userID {
+		return nil, ErrInvalidRefreshToken
+	}
+	// La sospensione revoca i refresh token, ma un token può essere ruotato mentre lo stato cambia
+	if err := requireActiveUser(ctx, stored.UserID); err != nil {
+		if errors.Is(err, ErrAccountInactive) {
+			if err := repository.RevokeRefreshTokenFamily(ctx, stored.FamilyID, now); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+	return issueTokens(ctx, settings, stored.UserID, stored.FamilyID, now)
+}
+
+// Logout revoca la famiglia del refresh token, cioè la sessione aperta dal login. Un token già revocato non è un errore.
+func Logout(ctx context.Context, refreshToken string) error {
+	settings := getAuthSettings()
+	if len(settings.secret) == 0 {
+		return ErrAuthDisabled
+	}
+	id, _, err := parseRefreshToken(ctx, settings, refreshToken)
+	if err != nil {
+		return err
+	}
+	stored, err := repository.GetRefreshToken(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return repository.RevokeRefreshTokenFamily(ctx, stored.FamilyID, time.Now().UTC())
+}
+
+// SetPassword imposta la password di un utente e revoca i suoi refresh token.
+// Con requireCurrent (l'utente cambia la propria password) la password attuale deve essere corretta.
+func SetPassword(ctx context.Context, userID string, req models.PasswordRequest, requireCurrent bool) error {
+	settings := getAuthSettings()
+	if err := validatePassword(req.NewPassword, settings.minPasswordLength); err != nil {
+		return err
+	}
+	user, err := getUserForAuth(ctx, userID)
+	if err != nil {
+		return err
+	}
+	current, err := ownCredentials(ctx, user)
+	if err != nil {
+		return err
+	}
+	if requireCurrent {
+		if current == nil || len(req.CurrentPassword) > maxPasswordLength {
+			return ErrWrongPassword
+		}
+		match, _, err := password.Verify(req.CurrentPassword, current.PasswordHash)
+		if err != nil {
+			return err
+		}
+		if !match {
+			return ErrWrongPassword
+		}
+	}
+	return storePassword(ctx, userID, req.NewPassword)
+}
+
+// getUserForAuth restituisce l'utente indicato, ErrUserNotFound se non esiste
+func getUserForAuth(ctx context.Context, userID string) (*models.User, error) {
+	user, err := repository.GetUserByID(ctx, userID)
+	if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// requireActiveUser restituisce ErrAccountInactive se l'utente non è attivo; ErrUserNotFound se non esiste
+func requireActiveUser(ctx context.Context, userID string) error {
+	user, err := getUserForAuth(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if userStatus(user) != constants.USER_STATUS_ACTIVE {
+		return ErrAccountInactive
+	}
+	return nil
+}
+
+// ownCredentials restituisce le credenziali dell'utente (nil se non ha ancora una password).
+// Il login avviene per email: ErrEmailInUse se un altro utente con la stessa email ha già una password.
+func ownCredentials(ctx context.Context, user *models.User) (*models.Credentials, error) {
+	ids, err := repository.FindUserIDsByEmail(ctx, user.Email, maxEmailMatches)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := repository.FindCredentials(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	var current *models.Credentials
+	for i := range existing {
+		if existing[i].UserID != user.ID {
+			return nil, ErrEmailInUse
+		}
+		current = &existing[i]
+	}
+	return current, nil
+}
+
+// NormalizeEmail restituisce l'indirizzo in minuscolo: gli indirizzi sono confrontati senza distinguere
+// maiuscole e minuscole, sia nel login sia nei controlli di EmailConflicts
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EmailConflicts restituisce, per posizione, ErrEmailInUse per gli utenti da scrivere la cui email appartiene
+// a un altro utente con una password (o a un altro utente dello stesso gruppo di scritture che ne ha una).
+// Il login avviene per email e credentialsForEmail richiede un solo utente con password per indirizzo:
+// senza questo controllo chiunque possa scrivere un utente potrebbe impedire il login di un altro copiandone l'email.
+// Gli utenti devono avere l'email già normalizzata (NormalizeEmail); l'ID è vuoto per quelli da creare.
+func EmailConflicts(ctx context.Context, users []models.User) (map[int]error, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+	emails := make([]string, 0, len(users))
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		if user.Email != "" {
+			emails = append(emails, user.Email)
+		}
+		if user.ID != "" {
+			ids = append(ids, user.ID)
+		}
+	}
+	existing, err := repository.FindUsersByEmails(ctx, emails)
+	if err != nil {
+		return nil, err
+	}
+	emailOf := make(map[string]string, len(existing))
+	for _, user := range existing {
+		emailOf[user.ID] = user.Email
+		ids = append(ids, user.ID)
+	}
+	credentials, err := repository.FindCredentials(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	hasPassword := make(map[string]bool, len(credentials))
+	for _, credential := range credentials {
+		hasPassword[credential.UserID] = true
+	}
+
+	// holders associa a ogni email gli utenti con password che la usano: quelli salvati e, in ordine, quelli scritti
+	holders := make(map[string]map[string]bool)
+	addHolder := func(email, userID string) {
+		if holders[email] == nil {
+			holders[email] = make(map[string]bool)
+		}
+		holders[email][userID] = true
+	}
+	for userID, email := range emailOf {
+		if hasPassword[userID] {
+			addHolder(email, userID)
+		}
+	}
+	conflicts := make(map[int]error)
+	for i, user := range users {
+		// Un aggiornamento parziale senza email non cambia l'indirizzo
+		if user.Email == "" {
+			continue
+		}
+		for holder := range holders[user.Email] {
+			if holder != user.ID {
+				conflicts[i] = ErrEmailInUse
+			}
+		}
+		if conflicts[i] == nil && user.ID != "" && hasPassword[user.ID] {
+			addHolder(user.Email, user.ID)
+		}
+	}
+	return conflicts, nil
+}
+
+// checkEmailConflict restituisce ErrEmailInUse se l'email dell'utente appartiene a un altro utente con una password
+func checkEmailConflict(ctx context.Context, user models.User) error {
+	conflicts, err := EmailConflicts(ctx, []models.User{user})
+	if err != nil {
+		return err
+	}
+	return conflicts[0]
+}
+
+// storePassword salva l'hash della nuova password e revoca i refresh token dell'utente
+func storePassword(ctx context.Context, userID, newPassword string) error {
+	hash, err := password.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if err := repository.SetPasswordHash(ctx, userID, hash, now); err != nil {
+		return err
+	}
+	// Le sessioni aperte con la password precedente non devono sopravvivere al cambio
+	if err := repository.RevokeUserRefreshTokens(ctx, userID, now); err != nil {
+		return err
+	}
+	utils.WithContext().Infof("Password dell'utente %s impostata", userID)
+	return nil
+}
+
+// credentialsForEmail restituisce le credenziali dell'utente con l'email indicata, nil se nessuno
+// (o più di un utente, situazione che SetPassword impedisce) ha una password
+func credentialsForEmail(ctx context.Context, email string) (*models.Credentials, error) {
+	ids, err := repository.FindUserIDsByEmail(ctx, NormalizeEmail(email), maxEmailMatches)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	credentials, err := repository.FindCredentials(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) > 1 {
+		utils.WithContext().Warnf("Login rifiutato: %d utenti con la stessa email hanno una password", len(credentials))
+	}
+	if len(credentials) != 1 {
+		return nil, nil
+	}
+	return &credentials[0], nil
+}
+
+// issueTokens firma un access token e un refresh token per l'utente e salva il refresh token nella famiglia indicata
+func issueTokens(ctx context.Context, settings *authSettings, userID, familyID string, now time.Time) (*models.TokenResponse, error) {
+	tenant, err := tenancy.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tokenID, err := utils.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	access := jwt.Claims{
+		jwt.ClaimSubject:   userID,
+		jwt.ClaimType:      jwt.TypeAccess,
+		jwt.ClaimScope:     strings.Join(settings.scopes, " "),
+		jwt.ClaimIssuedAt:  now.Unix(),
+		jwt.ClaimExpiresAt: now.Add(settings.accessTTL).Unix(),
+	}
+	refresh := jwt.Claims{
+		jwt.ClaimSubject:   userID,
+		jwt.ClaimType:      jwt.TypeRefresh,
+		jwt.ClaimID:        tokenID,
+		jwt.ClaimIssuedAt:  now.Unix(),
+		jwt.ClaimExpiresAt: now.Add(settings.refreshTTL).Unix(),
+	}
+	if tenant != "" {
+		access[settings.tenantClaim] = tenant
+		refresh[settings.tenantClaim] = tenant
+	}
+	accessToken, err := jwt.Sign(access, settings.secret)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := jwt.Sign(refresh, settings.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repository.InsertRefreshToken(ctx, models.RefreshToken{
+		ID:        tokenID,
+		FamilyID:  familyID,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(settings.refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(settings.accessTTL.Seconds()),
+	}, nil
+}
+
+// parseRefreshToken verifica firma, scadenza, tipo e tenant di un refresh token e ne restituisce ID e utente
+func parseRefreshToken(ctx context.Context, settings *authSettings, token string) (id, userID string, err error) {
+	claims, err := jwt.Parse(strings.TrimSpace(token), settings.secret)
+	if err != nil || claims.String(jwt.ClaimType) != jwt.TypeRefresh {
+		return "", "", ErrInvalidRefreshToken
+	}
+	tenant, err := tenancy.Require(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	id, userID = claims.String(jwt.ClaimID), claims.String(jwt.ClaimSubject)
+	if id == "" || userID == "" || claims.String(settings.tenantClaim) != tenant {
+		return "", "", ErrInvalidRefreshToken
+	}
+	return id, userID, nil
+}
+
+// validatePassword applica i requisiti minimi delle password: lunghezza tra PASSWORD_MIN_LENGTH e maxPasswordLength
+func validatePassword(value string, minLength int) error {
+	length := len([]rune(value))
+	if length < minLength || len(value) > maxPasswordLength {
+		return fmt.Errorf("%w: must be between %d and %d characters", ErrInvalidPassword, minLength, maxPasswordLength)
+	}
+	return nil
+}
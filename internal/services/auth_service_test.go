@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/config"
+	"myapp/internal/jwt"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var testAuthSecret = []byte("auth-service-test-secret")
+
+// useTestAuthSettings sostituisce la configurazione letta da getAuthSettings
+func useTestAuthSettings() {
+	authConfigOnce.Do(func() {})
+	authConfig = &authSettings{
+		secret:      testAuthSecret,
+		tenantClaim: "tenant",
+		accessTTL:   15 * time.Minute,
+		refreshTTL:  time.Hour,
+		scopes:      []string{"users:read"},
+		maxAttempts: 5,
+	}
+}
+
+// refreshToken firma un refresh token dell'utente con l'ID indicato
+func refreshToken(t *testing.T, id, userID string) string {
+	t.Helper()
+	token, err := jwt.Sign(jwt.Claims{
+		jwt.ClaimSubject:   userID,
+		jwt.ClaimType:      jwt.TypeRefresh,
+		jwt.ClaimID:        id,
+		jwt.ClaimExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}, testAuthSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// storedRefreshToken è il documento di un refresh token nella collezione refresh_tokens
+func storedRefreshToken(id, userID string, rotatedAt, revokedAt *time.Time) bson.D {
+	document := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "familyId", Value: "family-1"},
+		{Key: "userId", Value: userID},
+		{Key: "expiresAt", Value: time.Now().Add(time.Hour)},
+	}
+	if rotatedAt != nil {
+		document = append(document, bson.E{Key: "rotatedAt", Value: *rotatedAt})
+	}
+	if revokedAt != nil {
+		document = append(document, bson.E{Key: "revokedAt", Value: *revokedAt})
+	}
+	return document
+}
+
+// commands restituisce i nomi dei comandi inviati al database simulato
+func commands(mt *mtest.T) []string {
+	var names []string
+	for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+		names = append(names, event.CommandName)
+	}
+	return names
+}
+
+func TestRefreshTokens(t *testing.T) {
+	useTestAuthSettings()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	namespace := "myapp." + constants.REFRESHTOKENSCOLLECTION
+	userID := primitive.NewObjectID()
+
+	mt.Run("rotates the token within its family", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(
+			// findAndModify segna il token come sostituito e lo restituisce
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: storedRefreshToken("token-1", userID.Hex(), nil, nil)}),
+			mtest.CreateCursorResponse(0, "myapp."+constants.USERSCOLLECTION, mtest.FirstBatch, bson.D{{Key: "_id", Value: userID}}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		tokens, err := RefreshTokens(context.Background(), refreshToken(mt.T, "token-1", userID.Hex()))
+		if err != nil {
+			mt.Fatalf("refresh: %v", err)
+		}
+		claims, err := jwt.Parse(tokens.RefreshToken, testAuthSecret)
+		if err != nil || claims.String(jwt.ClaimType) != jwt.TypeRefresh || claims.String(jwt.ClaimSubject) != userID.Hex() {
+			mt.Fatalf("new refresh token claims %v, err %v", claims, err)
+		}
+		if id := claims.String(jwt.ClaimID); id == "" || id == "token-1" {
+			mt.Errorf("new refresh token ID %q, want a new ID", id)
+		}
+
+		mt.GetStartedEvent() // findAndModify
+		mt.GetStartedEvent() // find dell'utente
+		insert := mt.GetStartedEvent()
+		if insert == nil || insert.CommandName != "insert" {
+			mt.Fatalf("the new refresh token was not stored")
+		}
+		stored := insert.Command.Lookup("documents").Array().Index(0).Value().Document()
+		if family := stored.Lookup("familyId").StringValue(); family != "family-1" {
+			mt.Errorf("new refresh token family %q, want family-1", family)
+		}
+	})
+
+	mt.Run("reuse of a rotated token revokes the family", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		rotatedAt := time.Now().Add(-time.Minute)
+		mt.AddMockResponses(
+			// findAndModify non trova un token ancora valido
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch, storedRefreshToken("token-1", userID.Hex(), &rotatedAt, nil)),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}),
+		)
+
+		if _, err := RefreshTokens(context.Background(), refreshToken(mt.T, "token-1", userID.Hex())); !errors.Is(err, ErrInvalidRefreshToken) {
+			mt.Fatalf("reused token: err %v, want ErrInvalidRefreshToken", err)
+		}
+		mt.GetStartedEvent() // findAndModify
+		mt.GetStartedEvent() // find del token
+		update := mt.GetStartedEvent()
+		if update == nil || update.CommandName != "update" {
+			mt.Fatalf("the family was not revoked")
+		}
+		filter := update.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q").Document()
+		if family := filter.Lookup("familyId").StringValue(); family != "family-1" {
+			mt.Errorf("revoked family %q, want family-1", family)
+		}
+	})
+
+	mt.Run("revoked token is rejected without further revocations", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		rotatedAt, revokedAt := time.Now().Add(-time.Hour), time.Now().Add(-time.Minute)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch, storedRefreshToken("token-1", userID.Hex(), &rotatedAt, &revokedAt)),
+		)
+
+		if _, err := RefreshTokens(context.Background(), refreshToken(mt.T, "token-1", userID.Hex())); !errors.Is(err, ErrInvalidRefreshToken) {
+			mt.Fatalf("revoked token: err %v, want ErrInvalidRefreshToken", err)
+		}
+		if names := commands(mt); len(names) != 2 {
+			mt.Errorf("commands %v, want only findAndModify and find", names)
+		}
+	})
+
+	mt.Run("access tokens are not refresh tokens", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		access, err := jwt.Sign(jwt.Claims{
+			jwt.ClaimSubject:   userID.Hex(),
+			jwt.ClaimType:      jwt.TypeAccess,
+			jwt.ClaimID:        "token-1",
+			jwt.ClaimExpiresAt: time.Now().Add(time.Hour).Unix(),
+		}, testAuthSecret)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if _, err := RefreshTokens(context.Background(), access); !errors.Is(err, ErrInvalidRefreshToken) {
+			mt.Fatalf("access token: err %v, want ErrInvalidRefreshToken", err)
+		}
+		if names := commands(mt); len(names) != 0 {
+			mt.Errorf("commands %v, want none", names)
+		}
+	})
+}
+
+func TestEmailConflicts(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	owner, other, third := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+	// mockEmailLookup simula la ricerca degli utenti per email e quella delle loro credenziali con password
+	mockEmailLookup := func(mt *mtest.T, users []bson.D, withPassword ...primitive.ObjectID) {
+		credentials := make([]bson.D, 0, len(withPassword))
+		for _, id := range withPassword {
+			credentials = append(credentials, bson.D{{Key: "_id", Value: id.Hex()}, {Key: "passwordHash", Value: "hash"}})
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "myapp."+constants.USERSCOLLECTION, mtest.FirstBatch, users...),
+			mtest.CreateCursorResponse(0, "myapp."+constants.CREDENTIALSCOLLECTION, mtest.FirstBatch, credentials...),
+		)
+	}
+	stored := func(id primitive.ObjectID, email string) bson.D {
+		return bson.D{{Key: "_id", Value: id}, {Key: "email", Value: email}}
+	}
+
+	mt.Run("email of another login is rejected", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mockEmailLookup(mt, []bson.D{stored(owner, "ada@example.com")}, owner)
+
+		conflicts, err := EmailConflicts(context.Background(), []models.User{{Email: "ada@example.com"}})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if !errors.Is(conflicts[0], ErrEmailInUse) {
+			mt.Errorf("conflict %v, want ErrEmailInUse", conflicts[0])
+		}
+	})
+
+	mt.Run("owner keeps its email and users without password do not block it", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mockEmailLookup(mt, []bson.D{stored(owner, "ada@example.com"), stored(other, "ada@example.com")}, owner)
+
+		conflicts, err := EmailConflicts(context.Background(), []models.User{{ID: owner.Hex(), Email: "ada@example.com"}})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if len(conflicts) != 0 {
+			mt.Errorf("conflicts %v, want none", conflicts)
+		}
+	})
+
+	mt.Run("a login moved within the batch blocks the following items", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mockEmailLookup(mt, nil, other)
+
+		conflicts, err := EmailConflicts(context.Background(), []models.User{
+			{ID: other.Hex(), Email: "new@example.com"},
+			{ID: third.Hex(), Email: "new@example.com"},
+			{ID: third.Hex()},
+		})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if conflicts[0] != nil || !errors.Is(conflicts[1], ErrEmailInUse) || conflicts[2] != nil {
+			mt.Errorf("conflicts %v, want only the second item", conflicts)
+		}
+	})
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	if got := NormalizeEmail(" Ada.Lovelace@Example.COM "); got != "ada.lovelace@example.com" {
+		t.Errorf("NormalizeEmail = %q", got)
+	}
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Lunghezze massime di nome e descrizione di un gruppo
+const (
+	maxGroupNameLength        = 100
+	maxGroupDescriptionLength = 1000
+)
+
+var (
+	// ErrInvalidGroup indica un gruppo non valido (nome o descrizione)
+	ErrInvalidGroup = errors.New("invalid group")
+	// ErrGroupNotFound indica che il gruppo non esiste
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrGroupNameInUse indica un nome già usato da un altro gruppo
+	ErrGroupNameInUse = errors.New("a group with the same name already exists")
+	// ErrInvalidGroupRole indica un ruolo sconosciuto
+	ErrInvalidGroupRole = errors.New("role must be one of: owner, admin, member")
+	// ErrGroupMemberRequired indica un'aggiunta di un membro senza userId
+	ErrGroupMemberRequired = errors.New("userId is required")
+	// ErrAlreadyGroupMember indica un utente già membro del gruppo
+	ErrAlreadyGroupMember = errors.New("user is already a member of the group")
+	// ErrGroupMemberNotFound indica un utente che non è membro del gruppo
+	ErrGroupMemberNotFound = errors.New("user is not a member of the group")
+)
+
+// CreateGroup crea un nuovo gruppo
+func CreateGroup(ctx context.Context, req models.GroupRequest) (*models.Group, error) {
+	group, err := groupFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if group.ID, err = utils.GenerateUUID(); err != nil {
+		return nil, err
+	}
+	group.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	group.UpdatedAt = group.CreatedAt
+
+	if err := repository.InsertGroup(ctx, group); err != nil {
+		return nil, groupError(err)
+	}
+	utils.WithContext().Infof("Gruppo %s creato: %s", group.ID, group.Name)
+	return &group, nil
+}
+
+// GetGroups restituisce una pagina dei gruppi in ordine di nome
+func GetGroups(ctx context.Context, page, pageSize int) (*models.GroupPage, error) {
+	groups, total, err := repository.GetGroups(ctx, int64((page-1)*pageSize), int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+	return &models.GroupPage{Page: page, PageSize: pageSize, Total: total, Groups: groups}, nil
+}
+
+// GetGroupByID restituisce un gruppo
+func GetGroupByID(ctx context.Context, id string) (*models.Group, error) {
+	group, err := repository.GetGroupByID(ctx, id)
+	if err != nil {
+		return nil, groupError(err)
+	}
+	return group, nil
+}
+
+// UpdateGroup sostituisce nome e descrizione di un gruppo
+func UpdateGroup(ctx context.Context, id string, req models.GroupRequest) (*models.Group, error) {
+	existing, err := repository.GetGroupByID(ctx, id)
+	if err != nil {
+		return nil, groupError(err)
+	}
+	group, err := groupFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	group.ID = existing.ID
+	group.CreatedAt = existing.CreatedAt
+	group.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	if err := repository.ReplaceGroup(ctx, group); err != nil {
+		return nil, groupError(err)
+	}
+	utils.WithContext().Infof("Gruppo %s aggiornato", id)
+	return &group, nil
+}
+
+// DeleteGroupByID elimina un gruppo e, nella stessa transazione, le appartenenze dei suoi membri
+func DeleteGroupByID(ctx context.Context, id string) error {
+	utils.WithContext().Infof("Cancello gruppo con Id: %s", id)
+	err := config.WithTransaction(ctx, func(txCtx context.Context) error {
+		return repository.DeleteGroupByID(txCtx, id)
+	})
+	return groupError(err)
+}
+
+// AddGroupMember aggiunge un utente esistente a un gruppo con il ruolo indicato (member se vuoto).
+// Errori: ErrGroupMemberRequired, ErrInvalidGroupRole, ErrGroupNotFound, ErrUserNotFound, ErrAlreadyGroupMember.
+func AddGroupMember(ctx context.Context, groupID string, req models.GroupMemberRequest) (*models.GroupMembership, error) {
+	userID := strings.TrimSpace(req.UserID)
+	if userID == "" {
+		return nil, ErrGroupMemberRequired
+	}
+	role, err := groupRole(req.Role)
+	if err != nil {
+		return nil, err
+	}
+	membership := models.GroupMembership{
+		GroupID:  groupID,
+		UserID:   userID,
+		Role:     role,
+		JoinedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if membership.ID, err = utils.GenerateUUID(); err != nil {
+		return nil, err
+	}
+	// Le verifiche di esistenza e l'inserimento avvengono nella stessa transazione, come l'eliminazione del gruppo
+	// e delle sue appartenenze. Le verifiche scrivono sui documenti (TouchGroup/TouchUser) invece di leggerli:
+	// con la sola lettura l'isolamento snapshot ammette il write skew, cioè un gruppo o un utente eliminati
+	// da una transazione concorrente lascerebbero un'appartenenza orfana. Con la scrittura le due transazioni
+	// vanno in conflitto e quella ripetuta da WithTransaction trova il documento mancante.
+	err = config.WithTransaction(ctx, func(txCtx context.Context) error {
+		if err := repository.TouchGroup(txCtx, groupID); err != nil {
+			return groupError(err)
+		}
+		err := repository.TouchUser(txCtx, userID)
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return repository.InsertGroupMembership(txCtx, membership)
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAlreadyGroupMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	utils.WithContext().Infof("Utente %s aggiunto al gruppo %s come %s", membership.UserID, groupID, role)
+	return &membership, nil
+}
+
+// SetGroupMemberRole cambia il ruolo di un membro del gruppo
+func SetGroupMemberRole(ctx context.Context, groupID, userID, role string) (*models.GroupMembership, error) {
+	role, err := groupRole(role)
+	if err != nil {
+		return nil, err
+	}
+	membership, err := repository.SetGroupMemberRole(ctx, groupID, userID, role)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrGroupMemberNotFound
+	}
+	return membership, err
+}
+
+// RemoveGroupMember rimuove un utente da un gruppo
+func RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	err := repository.DeleteGroupMembership(ctx, groupID, userID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrGroupMemberNotFound
+	}
+	return err
+}
+
+// GetGroupMembers restituisce una pagina dei membri di un gruppo, eventualmente solo con il ruolo indicato
+func GetGroupMembers(ctx context.Context, groupID, role string, page, pageSize int) (*models.GroupMembershipPage, error) {
+	if role != "" && !isGroupRole(role) {
+		return nil, ErrInvalidGroupRole
+	}
+	if _, err := repository.GetGroupByID(ctx, groupID); err != nil {
+		return nil, groupError(err)
+	}
+	memberships, total, err := repository.GetGroupMembers(ctx, groupID, role, int64((page-1)*pageSize), int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+	return &models.GroupMembershipPage{Page: page, PageSize: pageSize, Total: total, Memberships: memberships}, nil
+}
+
+// GetUserGroups restituisce una pagina dei gruppi di cui l'utente è membro, con il suo ruolo in ciascuno;
+// ErrUserNotFound se l'utente non esiste
+func GetUserGroups(ctx context.Context, userID string, page, pageSize int) (*models.GroupMembershipPage, error) {
+	if _, err := getUserForAuth(ctx, userID); err != nil {
+		return nil, err
+	}
+	memberships, total, err := repository.GetUserGroupMemberships(ctx, userID, int64((page-1)*pageSize), int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+	return &models.GroupMembershipPage{Page: page, PageSize: pageSize, Total: total, Memberships: memberships}, nil
+}
+
+// groupFromRequest valida la richiesta e la converte in un gruppo (senza ID e date)
+func groupFromRequest(req models.GroupRequest) (models.Group, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxGroupNameLength {
+		return models.Group{}, fmt.Errorf("%w: name is required and must be at most %d characters", ErrInvalidGroup, maxGroupNameLength)
+	}
+	description := strings.TrimSpace(req.Description)
+	if utf8.RuneCountInString(description) > maxGroupDescriptionLength {
+		return models.Group{}, fmt.Errorf("%w: description must be at most %d characters", ErrInvalidGroup, maxGroupDescriptionLength)
+	}
+	return models.Group{Name: name, Description: description}, nil
+}
+
+// groupRole restituisce il ruolo richiesto, member se vuoto
+func groupRole(role string) (string, error) {
+	if role == "" {
+		return constants.GROUP_ROLE_MEMBER, nil
+	}
+	if !isGroupRole(role) {
+		return "", ErrInvalidGroupRole
+	}
+	return role, nil
+}
+
+func isGroupRole(role string) bool {
+	return role == constants.GROUP_ROLE_OWNER || role == constants.GROUP_ROLE_ADMIN || role == constants.GROUP_ROLE_MEMBER
+}
+
+// groupError converte l'assenza del documento in ErrGroupNotFound e la chiave duplicata in ErrGroupNameInUse
+func groupError(err error) error {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrGroupNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return ErrGroupNameInUse
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAddGroupMemberWritesGroupAndUser(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	userID := primitive.NewObjectID().Hex()
+	matched := func(n int) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+	}
+
+	mt.Run("both documents join the transaction write set", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(matched(1), matched(1), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		membership, err := AddGroupMember(context.Background(), "group-1", models.GroupMemberRequest{UserID: userID})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if membership.UserID != userID || membership.GroupID != "group-1" {
+			mt.Errorf("membership %+v", membership)
+		}
+		var started []*event.CommandStartedEvent
+		for e := mt.GetStartedEvent(); e != nil; e = mt.GetStartedEvent() {
+			started = append(started, e)
+		}
+		if len(started) < 3 || started[0].CommandName != "update" || started[1].CommandName != "update" || started[2].CommandName != "insert" {
+			mt.Fatalf("%d commands, want group and user updates before the insert", len(started))
+		}
+		for _, e := range started[:2] {
+			if _, err := e.Command.LookupErr("txnNumber"); err != nil {
+				mt.Errorf("update on %v outside the transaction", e.Command.Lookup("update"))
+			}
+			update := e.Command.Lookup("updates").Array().Index(0).Value().Document()
+			if _, err := update.LookupErr("u", "$inc", "membershipVersion"); err != nil {
+				mt.Errorf("update does not bump membershipVersion: %v", update)
+			}
+		}
+	})
+
+	mt.Run("missing group", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(matched(0), mtest.CreateSuccessResponse())
+
+		_, err := AddGroupMember(context.Background(), "group-1", models.GroupMemberRequest{UserID: userID})
+		if !errors.Is(err, ErrGroupNotFound) {
+			mt.Fatalf("err %v, want ErrGroupNotFound", err)
+		}
+	})
+
+	mt.Run("missing user", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(matched(1), matched(0), mtest.CreateSuccessResponse())
+
+		_, err := AddGroupMember(context.Background(), "group-1", models.GroupMemberRequest{UserID: userID})
+		if !errors.Is(err, ErrUserNotFound) {
+			mt.Fatalf("err %v, want ErrUserNotFound", err)
+		}
+		for _, name := range commands(mt) {
+			if name == "insert" {
+				mt.Errorf("membership inserted for a missing user")
+			}
+		}
+	})
+
+	mt.Run("malformed user ID", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(matched(1), mtest.CreateSuccessResponse())
+
+		_, err := AddGroupMember(context.Background(), "group-1", models.GroupMemberRequest{UserID: "not-an-id"})
+		if !errors.Is(err, ErrUserNotFound) {
+			mt.Fatalf("err %v, want ErrUserNotFound", err)
+		}
+	})
+}
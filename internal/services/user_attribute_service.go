@@ -0,0 +1,385 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"myapp/internal/jsonschema"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxUserAttributes è il numero massimo di attributi dichiarati da tutti i namespace di un tenant: con TENANCY_MODE
+// collection o database ognuno ha un indice e MongoDB ammette al massimo 64 indici per collezione
+const maxUserAttributes = 20
+
+// maxAttributeSchemaAttempts è il numero di tentativi di una modifica degli schemi in conflitto con una concorrente
+const maxAttributeSchemaAttempts = 3
+
+// attributeNamePattern limita i nomi degli attributi a identificatori usabili come campi e nei parametri di query
+var attributeNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}$`)
+
+// attributeNamespacePattern limita i nomi dei namespace, usati nel percorso e come campi del documento degli schemi
+var attributeNamespacePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+var (
+	// ErrInvalidAttributeSchema indica uno schema degli attributi malformato o non supportato
+	ErrInvalidAttributeSchema = errors.New("invalid attribute schema")
+	// ErrInvalidAttributeNamespace indica un nome di namespace non valido
+	ErrInvalidAttributeNamespace = errors.New("invalid attribute namespace")
+	// ErrAttributeSchemaNotFound indica che il namespace non ha ancora uno schema degli attributi
+	ErrAttributeSchemaNotFound = errors.New("attribute schema not defined")
+	// ErrAttributeSchemaConflict indica modifiche concorrenti degli schemi che hanno esaurito i tentativi
+	ErrAttributeSchemaConflict = errors.New("attribute schemas changed concurrently, retry")
+	// ErrInvalidAttributes indica attributi non conformi allo schema, o attributi senza uno schema
+	ErrInvalidAttributes = errors.New("invalid attributes")
+	// ErrInvalidAttributeFilter indica un filtro su un attributo non dichiarato o con un valore del tipo sbagliato
+	ErrInvalidAttributeFilter = errors.New("invalid attribute filter")
+)
+
+// ListUserAttributeSchemas restituisce gli schemi degli attributi di tutti i namespace del tenant, in ordine di nome
+func ListUserAttributeSchemas(ctx context.Context) ([]models.UserAttributeSchema, error) {
+	schemas, _, err := repository.GetUserAttributeSchemas(ctx)
+	if schemas == nil && err == nil {
+		schemas = []models.UserAttributeSchema{}
+	}
+	return schemas, err
+}
+
+// GetUserAttributeSchema restituisce lo schema degli attributi di un namespace del tenant
+func GetUserAttributeSchema(ctx context.Context, namespace string) (*models.UserAttributeSchema, error) {
+	if !attributeNamespacePattern.MatchString(namespace) {
+		return nil, ErrAttributeSchemaNotFound
+	}
+	schemas, _, err := repository.GetUserAttributeSchemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, schema := range schemas {
+		if schema.Namespace == namespace {
+			return &schema, nil
+		}
+	}
+	return nil, ErrAttributeSchemaNotFound
+}
+
+// SetUserAttributeSchema valida e sostituisce lo schema degli attributi di un namespace del tenant, poi allinea
+// gli indici sugli attributi dichiarati. Un attributo appartiene a un solo namespace e tutti i namespace insieme
+// dichiarano al massimo maxUserAttributes attributi. Gli utenti già salvati non vengono rivalidati:
+// lo schema si applica alle scritture successive.
+func SetUserAttributeSchema(ctx context.Context, namespace string, req models.UserAttributeSchemaRequest) (*models.UserAttributeSchema, error) {
+	log := utils.WithContext()
+	if !attributeNamespacePattern.MatchString(namespace) {
+		return nil, fmt.Errorf("%w: %q must start with a lowercase letter and contain at most 32 lowercase letters, digits, '_' or '-'", ErrInvalidAttributeNamespace, namespace)
+	}
+	schema, err := compileAttributeSchema(req.Schema)
+	if err != nil {
+		return nil, err
+	}
+	var definition bytes.Buffer
+	if err := json.Compact(&definition, req.Schema); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAttributeSchema, err)
+	}
+
+	var saved *models.UserAttributeSchema
+	names, err := updateAttributeSchemas(ctx, namespace, func(others map[string]*jsonschema.Schema, _ bool, revision int64) error {
+		for name := range schema.Properties {
+			for owner, other := range others {
+				if _, declared := other.Properties[name]; declared {
+					return fmt.Errorf("%w: attribute %q is already declared by namespace %q", ErrInvalidAttributeSchema, name, owner)
+				}
+			}
+		}
+		others[namespace] = schema
+		if total := countAttributes(others); total > maxUserAttributes {
+			return fmt.Errorf("%w: the namespaces would declare %d attributes, at most %d are allowed", ErrInvalidAttributeSchema, total, maxUserAttributes)
+		}
+		saved, err = repository.SaveUserAttributeSchema(ctx, namespace, definition.Bytes(), middleware.GetActor(ctx), time.Now().UTC().Truncate(time.Millisecond), revision)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Schema degli attributi del namespace %s aggiornato alla versione %d da %s: %d attributi del tenant", namespace, saved.Version, saved.UpdatedBy, len(names))
+	return saved, nil
+}
+
+// DeleteUserAttributeSchema elimina lo schema degli attributi di un namespace e gli indici dei suoi attributi.
+// Gli attributi già salvati negli utenti restano, ma non sono più accettati nelle scritture né filtrabili.
+func DeleteUserAttributeSchema(ctx context.Context, namespace string) error {
+	if !attributeNamespacePattern.MatchString(namespace) {
+		return ErrAttributeSchemaNotFound
+	}
+	names, err := updateAttributeSchemas(ctx, namespace, func(_ map[string]*jsonschema.Schema, found bool, revision int64) error {
+		if !found {
+			return ErrAttributeSchemaNotFound
+		}
+		return repository.DeleteUserAttributeSchema(ctx, namespace, revision)
+	})
+	if err != nil {
+		return err
+	}
+	utils.WithContext().Infof("Schema degli attributi del namespace %s eliminato: %d attributi del tenant", namespace, len(names))
+	return nil
+}
+
+// updateAttributeSchemas legge gli schemi del tenant e chiama apply con quelli compilati degli altri namespace
+// (apply vi aggiunge lo schema che salva), l'esistenza del namespace e la revisione letta; se apply fallisce
+// per una modifica concorrente riprova da capo. Dopo la modifica allinea gli indici agli attributi del tenant,
+// che restituisce in ordine di nome.
+func updateAttributeSchemas(ctx context.Context, namespace string, apply func(others map[string]*jsonschema.Schema, found bool, revision int64) error) ([]string, error) {
+	for attempt := 1; ; attempt++ {
+		saved, revision, err := repository.GetUserAttributeSchemas(ctx)
+		if err != nil {
+			return nil, err
+		}
+		others := make(map[string]*jsonschema.Schema, len(saved))
+		found := false
+		for _, schema := range saved {
+			if schema.Namespace == namespace {
+				found = true
+				continue
+			}
+			if others[schema.Namespace], err = compileAttributeSchema(schema.Schema); err != nil {
+				return nil, err
+			}
+		}
+		err = apply(others, found, revision)
+		if errors.Is(err, repository.ErrAttributeSchemaChanged) {
+			if attempt < maxAttributeSchemaAttempts {
+				continue
+			}
+			return nil, ErrAttributeSchemaConflict
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, maxUserAttributes)
+		for _, schema := range others {
+			for name := range schema.Properties {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		// Se l'allineamento degli indici fallisce gli schemi restano salvati: la richiesta può essere ripetuta
+		return names, repository.EnsureUserAttributeIndexes(ctx, names)
+	}
+}
+
+// countAttributes restituisce il numero di attributi dichiarati dagli schemi
+func countAttributes(schemas map[string]*jsonschema.Schema) int {
+	total := 0
+	for _, schema := range schemas {
+		total += len(schema.Properties)
+	}
+	return total
+}
+
+// compileAttributeSchema compila lo schema e verifica le regole degli attributi: un oggetto di primo livello
+// con al massimo maxUserAttributes proprietà dichiarate, ognuna scalare o array di scalari, e nessun attributo non dichiarato
+func compileAttributeSchema(definition json.RawMessage) (*jsonschema.Schema, error) {
+	if len(definition) == 0 {
+		return nil, fmt.Errorf("%w: schema is required", ErrInvalidAttributeSchema)
+	}
+	schema, err := jsonschema.Parse(definition)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAttributeSchema, err)
+	}
+	if schema.Type != jsonschema.TypeObject {
+		return nil, fmt.Errorf("%w: type must be object", ErrInvalidAttributeSchema)
+	}
+	if schema.AdditionalProperties != nil && *schema.AdditionalProperties {
+		return nil, fmt.Errorf("%w: additionalProperties must be false, every attribute must be declared", ErrInvalidAttributeSchema)
+	}
+	// Gli attributi non dichiarati non sarebbero né filtrabili né indicizzati: sono sempre rifiutati
+	closed := false
+	schema.AdditionalProperties = &closed
+
+	if len(schema.Properties) == 0 || len(schema.Properties) > maxUserAttributes {
+		return nil, fmt.Errorf("%w: between 1 and %d properties must be declared", ErrInvalidAttributeSchema, maxUserAttributes)
+	}
+	for name, property := range schema.Properties {
+		if !attributeNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%w: property name %q must start with a letter and contain only letters, digits and underscores", ErrInvalidAttributeSchema, name)
+		}
+		scalar := property
+		if property.Type == jsonschema.TypeArray {
+			if property.Items == nil {
+				return nil, fmt.Errorf("%w: array property %q must declare items", ErrInvalidAttributeSchema, name)
+			}
+			scalar = property.Items
+		}
+		if !isScalarAttributeType(scalar.Type) {
+			return nil, fmt.Errorf("%w: property %q must be a string, number, integer, boolean or an array of them", ErrInvalidAttributeSchema, name)
+		}
+	}
+	for _, name := range schema.Required {
+		if _, declared := schema.Properties[name]; !declared {
+			return nil, fmt.Errorf("%w: required property %q is not declared", ErrInvalidAttributeSchema, name)
+		}
+	}
+	return schema, nil
+}
+
+func isScalarAttributeType(typ string) bool {
+	return typ == jsonschema.TypeString || typ == jsonschema.TypeNumber || typ == jsonschema.TypeInteger || typ == jsonschema.TypeBoolean
+}
+
+// loadAttributeSchema restituisce lo schema compilato del tenant, che unisce gli attributi di tutti i namespace;
+// nil se il tenant non ha schemi
+func loadAttributeSchema(ctx context.Context) (*jsonschema.Schema, error) {
+	saved, _, err := repository.GetUserAttributeSchemas(ctx)
+	if err != nil || len(saved) == 0 {
+		return nil, err
+	}
+	closed := false
+	merged := &jsonschema.Schema{Type: jsonschema.TypeObject, Properties: map[string]*jsonschema.Schema{}, AdditionalProperties: &closed}
+	for _, namespace := range saved {
+		schema, err := compileAttributeSchema(namespace.Schema)
+		if err != nil {
+			return nil, err
+		}
+		for name, property := range schema.Properties {
+			merged.Properties[name] = property
+		}
+		merged.Required = append(merged.Required, schema.Required...)
+	}
+	return merged, nil
+}
+
+// validateUserAttributes verifica gli attributi dell'utente con lo schema del tenant (nil se non definito)
+// e li normalizza nel tipo dichiarato. In una creazione anche l'assenza di attributi è verificata (proprietà required);
+// in un aggiornamento gli attributi assenti restano invariati e non sono verificati.
+func validateUserAttributes(schema *jsonschema.Schema, user *models.User, creating bool) error {
+	if schema == nil {
+		if len(user.Attributes) > 0 {
+			return fmt.Errorf("%w: no attribute schema is defined", ErrInvalidAttributes)
+		}
+		return nil
+	}
+	if user.Attributes == nil && !creating {
+		return nil
+	}
+
+	attributes := make(map[string]interface{}, len(user.Attributes))
+	for name, value := range user.Attributes {
+		attributes[name] = coerceAttribute(schema.Properties[name], value)
+	}
+	if err := schema.Validate(attributes); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidAttributes, err)
+	}
+	if user.Attributes == nil {
+		return nil
+	}
+	for name, value := range attributes {
+		attributes[name] = normalizeAttribute(schema.Properties[name], value)
+	}
+	user.Attributes = attributes
+	return nil
+}
+
+// coerceAttribute converte nel tipo dichiarato i valori testuali, come quelli letti da XML e CSV che non distinguono
+// numeri e booleani dalle stringhe; un testo singolo per un attributo array diventa un array di un elemento
+func coerceAttribute(property *jsonschema.Schema, value interface{}) interface{} {
+	if property == nil {
+		return value
+	}
+	if property.Type == jsonschema.TypeArray {
+		items, ok := jsonschema.ToList(value)
+		if !ok {
+			if _, isText := value.(string); !isText {
+				return value
+			}
+			items = []interface{}{value}
+		}
+		coerced := make([]interface{}, len(items))
+		for i, item := range items {
+			coerced[i] = coerceAttribute(property.Items, item)
+		}
+		return coerced
+	}
+	if text, ok := value.(string); ok {
+		if converted, err := parseAttributeValue(property.Type, text); err == nil {
+			return converted
+		}
+	}
+	return value
+}
+
+// normalizeAttribute restituisce il valore, già valido, nel tipo salvato: int64 per integer e float64 per number
+func normalizeAttribute(property *jsonschema.Schema, value interface{}) interface{} {
+	switch property.Type {
+	case jsonschema.TypeInteger:
+		number, _ := jsonschema.ToFloat(value)
+		return int64(number)
+	case jsonschema.TypeNumber:
+		number, _ := jsonschema.ToFloat(value)
+		return number
+	case jsonschema.TypeArray:
+		// coerceAttribute ha già convertito gli array in []interface{}
+		items := value.([]interface{})
+		normalized := make([]interface{}, len(items))
+		for i, item := range items {
+			normalized[i] = normalizeAttribute(property.Items, item)
+		}
+		return normalized
+	}
+	return value
+}
+
+// parseAttributeValue converte un testo nel tipo scalare indicato
+func parseAttributeValue(typ, text string) (interface{}, error) {
+	switch typ {
+	case jsonschema.TypeInteger:
+		return strconv.ParseInt(text, 10, 64)
+	case jsonschema.TypeNumber:
+		return strconv.ParseFloat(text, 64)
+	case jsonschema.TypeBoolean:
+		return strconv.ParseBool(text)
+	}
+	return text, nil
+}
+
+// ParseAttributeFilters converte i filtri sugli attributi (nome -> valori testuali dei parametri di query)
+// nel tipo dichiarato dallo schema; ErrInvalidAttributeFilter se l'attributo non è dichiarato o il valore non è convertibile
+func ParseAttributeFilters(ctx context.Context, filters map[string][]string) (map[string][]interface{}, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	schema, err := loadAttributeSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return nil, fmt.Errorf("%w: no attribute schema is defined", ErrInvalidAttributeFilter)
+	}
+
+	parsed := make(map[string][]interface{}, len(filters))
+	for name, texts := range filters {
+		property, declared := schema.Properties[name]
+		if !declared {
+			return nil, fmt.Errorf("%w: attribute %q is not declared", ErrInvalidAttributeFilter, name)
+		}
+		// Con un attributo array il filtro confronta i singoli elementi
+		if property.Type == jsonschema.TypeArray {
+			property = property.Items
+		}
+		for _, text := range texts {
+			value, err := parseAttributeValue(property.Type, text)
+			if err != nil {
+				return nil, fmt.Errorf("%w: attribute %q must be of type %s", ErrInvalidAttributeFilter, name, property.Type)
+			}
+			parsed[name] = append(parsed[name], value)
+		}
+	}
+	return parsed, nil
+}
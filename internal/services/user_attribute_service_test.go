@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// attributeSchemas restituisce il documento degli schemi del tenant con i namespace indicati (nome -> definizione)
+func attributeSchemas(revision int64, namespaces map[string]string) bson.D {
+	entries := bson.D{}
+	for name, definition := range namespaces {
+		entries = append(entries, bson.E{Key: name, Value: bson.D{
+			{Key: "definition", Value: definition},
+			{Key: "version", Value: int64(1)},
+			{Key: "updatedAt", Value: time.Now()},
+		}})
+	}
+	return bson.D{{Key: "_id", Value: "default"}, {Key: "revision", Value: revision}, {Key: "namespaces", Value: entries}}
+}
+
+// objectSchema restituisce uno schema con le proprietà stringa indicate
+func objectSchema(required []string, names ...string) string {
+	properties := make([]string, len(names))
+	for i, name := range names {
+		properties[i] = strconv.Quote(name) + `:{"type":"string"}`
+	}
+	requiredJSON, _ := json.Marshal(required)
+	return `{"type":"object","required":` + string(requiredJSON) + `,"properties":{` + strings.Join(properties, ",") + `}}`
+}
+
+func TestSetUserAttributeSchema(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	namespace := "myapp." + constants.ATTRIBUTESCHEMASCOLLECTION
+	billing := objectSchema(nil, "plan", "currency")
+
+	mt.Run("saves the namespace next to the others and indexes all their attributes", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		saved := attributeSchemas(2, map[string]string{"billing": billing, "crm": objectSchema(nil, "segment")})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch, attributeSchemas(1, map[string]string{"billing": billing})),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: saved}),
+			mtest.CreateSuccessResponse(), // createIndexes
+			mtest.CreateCursorResponse(0, "myapp."+constants.USERSCOLLECTION+".$cmd.listIndexes", mtest.FirstBatch,
+				bson.D{{Key: "name", Value: "attributes_team"}}),
+			mtest.CreateSuccessResponse(), // dropIndexes
+		)
+
+		schema, err := SetUserAttributeSchema(context.Background(), "crm", models.UserAttributeSchemaRequest{Schema: json.RawMessage(objectSchema(nil, "segment"))})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if schema.Namespace != "crm" {
+			mt.Errorf("saved namespace %q", schema.Namespace)
+		}
+		mt.GetStartedEvent() // find
+		save := mt.GetStartedEvent()
+		if revision := save.Command.Lookup("query", "revision"); revision.Int64() != 1 {
+			mt.Errorf("saved at revision %v, want the one read", revision)
+		}
+		create := mt.GetStartedEvent()
+		var indexes []string
+		values, _ := create.Command.Lookup("indexes").Array().Values()
+		for _, index := range values {
+			indexes = append(indexes, index.Document().Lookup("name").StringValue())
+		}
+		if strings.Join(indexes, ",") != "attributes_currency,attributes_plan,attributes_segment" {
+			mt.Errorf("indexes %v, want the attributes of every namespace", indexes)
+		}
+		mt.GetStartedEvent() // listIndexes
+		if drop := mt.GetStartedEvent(); drop == nil || drop.Command.Lookup("index").StringValue() != "attributes_team" {
+			mt.Errorf("the index of an attribute no longer declared was not dropped")
+		}
+	})
+
+	mt.Run("an attribute belongs to a single namespace", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch, attributeSchemas(1, map[string]string{"billing": billing})))
+
+		_, err := SetUserAttributeSchema(context.Background(), "crm", models.UserAttributeSchemaRequest{Schema: json.RawMessage(objectSchema(nil, "plan"))})
+		if !errors.Is(err, ErrInvalidAttributeSchema) || !strings.Contains(err.Error(), `"billing"`) {
+			mt.Fatalf("err %v, want the attribute of billing rejected", err)
+		}
+		if names := commands(mt); len(names) != 1 {
+			mt.Errorf("commands %v, want only the read", names)
+		}
+	})
+
+	mt.Run("the namespaces share the attribute limit", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		names := make([]string, maxUserAttributes-1)
+		for i := range names {
+			names[i] = "a" + strconv.Itoa(i)
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch,
+			attributeSchemas(1, map[string]string{"billing": objectSchema(nil, names...)})))
+
+		_, err := SetUserAttributeSchema(context.Background(), "crm", models.UserAttributeSchemaRequest{Schema: json.RawMessage(objectSchema(nil, "segment", "region"))})
+		if !errors.Is(err, ErrInvalidAttributeSchema) {
+			mt.Fatalf("err %v, want ErrInvalidAttributeSchema", err)
+		}
+	})
+
+	mt.Run("concurrent changes are retried, then reported", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		for i := 0; i < maxAttributeSchemaAttempts; i++ {
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch, attributeSchemas(int64(i+1), map[string]string{"billing": billing})),
+				mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			)
+		}
+
+		_, err := SetUserAttributeSchema(context.Background(), "crm", models.UserAttributeSchemaRequest{Schema: json.RawMessage(objectSchema(nil, "segment"))})
+		if !errors.Is(err, ErrAttributeSchemaConflict) {
+			mt.Fatalf("err %v, want ErrAttributeSchemaConflict", err)
+		}
+		if names := commands(mt); len(names) != 2*maxAttributeSchemaAttempts {
+			mt.Errorf("commands %v, want %d read and save attempts", names, maxAttributeSchemaAttempts)
+		}
+	})
+
+	mt.Run("invalid namespace", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		_, err := SetUserAttributeSchema(context.Background(), "Billing!", models.UserAttributeSchemaRequest{Schema: json.RawMessage(billing)})
+		if !errors.Is(err, ErrInvalidAttributeNamespace) {
+			mt.Fatalf("err %v, want ErrInvalidAttributeNamespace", err)
+		}
+	})
+}
+
+func TestDeleteUserAttributeSchemaRequiresTheNamespace(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("missing namespace", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "myapp."+constants.ATTRIBUTESCHEMASCOLLECTION, mtest.FirstBatch,
+			attributeSchemas(1, map[string]string{"billing": objectSchema(nil, "plan")})))
+
+		if err := DeleteUserAttributeSchema(context.Background(), "crm"); !errors.Is(err, ErrAttributeSchemaNotFound) {
+			mt.Fatalf("err %v, want ErrAttributeSchemaNotFound", err)
+		}
+	})
+}
+
+func TestLoadAttributeSchemaMergesNamespaces(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("merge", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "myapp."+constants.ATTRIBUTESCHEMASCOLLECTION, mtest.FirstBatch,
+			attributeSchemas(2, map[string]string{"billing": objectSchema([]string{"plan"}, "plan"), "crm": objectSchema([]string{"segment"}, "segment")})))
+
+		schema, err := loadAttributeSchema(context.Background())
+		if err != nil {
+			mt.Fatal(err)
+		}
+		user := models.User{Attributes: map[string]interface{}{"plan": "pro", "segment": "smb"}}
+		if err := validateUserAttributes(schema, &user, true); err != nil {
+			mt.Errorf("attributes of both namespaces: %v", err)
+		}
+		user = models.User{Attributes: map[string]interface{}{"plan": "pro"}}
+		if err := validateUserAttributes(schema, &user, true); !errors.Is(err, ErrInvalidAttributes) {
+			mt.Errorf("missing attribute required by crm: err %v, want ErrInvalidAttributes", err)
+		}
+		user = models.User{Attributes: map[string]interface{}{"plan": "pro", "segment": "smb", "team": "core"}}
+		if err := validateUserAttributes(schema, &user, true); !errors.Is(err, ErrInvalidAttributes) {
+			mt.Errorf("undeclared attribute: err %v, want ErrInvalidAttributes", err)
+		}
+	})
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errNotExecuted = errors.New("not executed: a previous item failed in ordered mode")
+	// errBulkRetry annulla la transazione di una bulk write con errori per elemento
+	errBulkRetry = errors.New("bulk write has item failures")
+)
+
+// BatchCreateUsers valida e inserisce gli utenti con una singola bulk write
+func BatchCreateUsers(ctx context.Context, req models.BatchUsersRequest) (*models.BatchResult, error) {
+	log := utils.WithContext()
+	ordered := models.IsOrdered(req.Ordered)
+	log.Infof("Batch create di %d utenti (ordered: %t)", len(req.Items), ordered)
+
+	// Lo schema degli attributi è letto una volta per tutto il batch
+	schema, err := loadAttributeSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := newBatchResults(len(req.Items))
+	var candidates []int
+	var users []models.User
+	for i, user := range req.Items {
+		user.Email = NormalizeEmail(user.Email)
+		err := ValidateUser(user)
+		if err == nil {
+			err = ValidateInitialStatus(user.Status)
+		}
+		if err == nil {
+			err = validateUserAttributes(schema, &user, true)
+		}
+		if err != nil {
+			results[i] = itemError(i, http.StatusBadRequest, err)
+			if ordered {
+				break
+			}
+			continue
+		}
+		// L'ID viene sempre generato dal database e la data del cambio di stato dal server
+		user.ID = ""
+		user.StatusChangedAt = nil
+		candidates = append(candidates, i)
+		users = append(users, user)
+	}
+	candidates, users, err = rejectEmailConflicts(ctx, results, candidates, users, ordered)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, failures, err := bulkCreateWithEvents(ctx, users, ordered)
+	if err != nil {
+		log.Errorf("Errore durante la batch create: %v", err)
+		return nil, err
+	}
+	applyBulkResults(results, candidates, failures, ordered, func(k int) models.BatchItemResult {
+		return models.BatchItemResult{Status: http.StatusCreated, ID: ids[k]}
+	})
+	return summarizeBatch(ordered, results), nil
+}
+
+// BatchUpdateUsers valida e aggiorna gli utenti indicati dal campo id di ogni elemento
+func BatchUpdateUsers(ctx context.Context, req models.BatchUsersRequest) (*models.BatchResult, error) {
+	log := utils.WithContext()
+	ordered := models.IsOrdered(req.Ordered)
+	log.Infof("Batch update di %d utenti (ordered: %t)", len(req.Items), ordered)
+
+	ids := make([]string, len(req.Items))
+	for i, user := range req.Items {
+		ids[i] = user.ID
+	}
+	existing, err := repository.FindExistingUserIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := loadAttributeSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := newBatchResults(len(req.Items))
+	var candidates []int
+	var users []models.User
+	for i, user := range req.Items {
+		user.Email = NormalizeEmail(user.Email)
+		if failure := checkBatchID(i, user.ID, existing); failure != nil {
+			results[i] = *failure
+		} else if err := ValidateUser(user); err != nil {
+			results[i] = itemError(i, http.StatusBadRequest, err)
+		} else if err := validateUserAttributes(schema, &user, false); err != nil {
+			results[i] = itemError(i, http.StatusBadRequest, err)
+		} else {
+			candidates = append(candidates, i)
+			users = append(users, user)
+			continue
+		}
+		if ordered {
+			break
+		}
+	}
+	candidates, users, err = rejectEmailConflicts(ctx, results, candidates, users, ordered)
+	if err != nil {
+		return nil, err
+	}
+
+	failures, err := bulkUpdateWithEvents(ctx, users, ordered)
+	if err != nil {
+		log.Errorf("Errore durante la batch update: %v", err)
+		return nil, err
+	}
+	applyBulkResults(results, candidates, failures, ordered, func(k int) models.BatchItemResult {
+		return models.BatchItemResult{Status: http.StatusOK, ID: users[k].ID}
+	})
+	return summarizeBatch(ordered, results), nil
+}
+
+// BatchDeleteUsers elimina gli utenti indicati
+func BatchDeleteUsers(ctx context.Context, req models.BatchDeleteRequest) (*models.BatchResult, error) {
+	log := utils.WithContext()
+	ordered := models.IsOrdered(req.Ordered)
+	log.Infof("Batch delete di %d utenti (ordered: %t)", len(req.IDs), ordered)
+
+	existing, err := repository.FindExistingUserIDs(ctx, req.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := newBatchResults(len(req.IDs))
+	var candidates []int
+	var ids []string
+	for i, id := range req.IDs {
+		if failure := checkBatchID(i, id, existing); failure != nil {
+			results[i] = *failure
+			if ordered {
+				break
+			}
+			continue
+		}
+		candidates = append(candidates, i)
+		ids = append(ids, id)
+	}
+
+	failures, err := bulkDeleteWithEvents(ctx, ids, ordered)
+	if err != nil {
+		log.Errorf("Errore durante la batch delete: %v", err)
+		return nil, err
+	}
+	applyBulkResults(results, candidates, failures, ordered, func(k int) models.BatchItemResult {
+		return models.BatchItemResult{Status: http.StatusNoContent, ID: ids[k]}
+	})
+	return summarizeBatch(ordered, results), nil
+}
+
+// bulkCreateWithEvents inserisce gli utenti salvando un evento UserCreated per ognuno.
+// Gli utenti senza ID ricevono un nuovo ObjectID; restituisce gli ID e gli errori per indice.
+func bulkCreateWithEvents(ctx context.Context, users []models.User, ordered bool) ([]string, map[int]error, error) {
+	ids := make([]string, len(users))
+	now := time.Now()
+	for i := range users {
+		if users[i].ID == "" {
+			users[i].ID = primitive.NewObjectID().Hex()
+		}
+		ids[i] = users[i].ID
+		initUserStatus(&users[i], now)
+	}
+
+	failures, err := writeBulkWithEvents(ctx, len(users), ordered, func(txCtx context.Context, positions []int) (map[int]error, []models.UserEvent, error) {
+		subset := pick(users, positions)
+		_, failures, err := repository.BulkCreateUsers(txCtx, subset, ordered)
+		if err != nil || len(failures) > 0 {
+			return failures, nil, err
+		}
+		events := make([]models.UserEvent, 0, len(subset))
+		for i := range subset {
+			event, err := newUserEvent(txCtx, nil, &subset[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			events = append(events, event)
+		}
+		return nil, events, nil
+	})
+	return ids, failures, err
+}
+
+// bulkUpdateWithEvents aggiorna gli utenti salvando un evento UserUpdated con gli snapshot prima e dopo
+func bulkUpdateWithEvents(ctx context.Context, users []models.User, ordered bool) (map[int]error, error) {
+	for i := range users {
+		clearUserStatus(&users[i])
+	}
+	return writeBulkWithEvents(ctx, len(users), ordered, func(txCtx context.Context, positions []int) (map[int]error, []models.UserEvent, error) {
+		subset := pick(users, positions)
+		ids := make([]string, len(subset))
+		for i, user := range subset {
+			ids[i] = user.ID
+		}
+
+		before, err := repository.FindUsersByIDs(txCtx, ids)
+		if err != nil {
+			return nil, nil, err
+		}
+		failures, err := repository.BulkUpdateUsers(txCtx, subset, ordered)
+		if err != nil || len(failures) > 0 {
+			return failures, nil, err
+		}
+		after, err := repository.FindUsersByIDs(txCtx, ids)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return changeEvents(txCtx, ids, before, after)
+	})
+}
+
+// bulkDeleteWithEvents elimina gli utenti salvando un evento UserDeleted per quelli esistenti
+func bulkDeleteWithEvents(ctx context.Context, ids []string, ordered bool) (map[int]error, error) {
+	return writeBulkWithEvents(ctx, len(ids), ordered, func(txCtx context.Context, positions []int) (map[int]error, []models.UserEvent, error) {
+		subset := pick(ids, positions)
+		before, err := repository.FindUsersByIDs(txCtx, subset)
+		if err != nil {
+			return nil, nil, err
+		}
+		failures, err := repository.BulkDeleteUsers(txCtx, subset, ordered)
+		if err != nil || len(failures) > 0 {
+			return failures, nil, err
+		}
+		if err := deleteUserDependents(txCtx, subset); err != nil {
+			return nil, nil, err
+		}
+		return changeEvents(txCtx, subset, before, nil)
+	})
+}
+
+// changeEvents crea gli eventi per gli ID indicati a partire dagli snapshot prima e dopo la modifica
+func changeEvents(ctx context.Context, ids []string, before, after map[string]models.User) (map[int]error, []models.UserEvent, error) {
+	events := make([]models.UserEvent, 0, len(ids))
+	for _, id := range ids {
+		previous, existed := before[id]
+		if !existed {
+			continue
+		}
+		var current *models.User
+		if user, exists := after[id]; exists {
+			current = &user
+		}
+		event, err := newUserEvent(ctx, &previous, current)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, event)
+	}
+	return nil, events, nil
+}
+
+// writeBulkWithEvents esegue in una transazione la bulk write e il salvataggio dei relativi eventi nell'outbox.
+// Un errore di scrittura annulla l'intera transazione: gli elementi falliti vengono quindi esclusi e la
+// transazione viene ripetuta con i restanti (in modalità ordered solo con quelli precedenti al primo errore),
+// così gli eventi corrispondono sempre alle scritture effettive.
+// write riceve le posizioni degli elementi da scrivere e restituisce gli errori indicizzati su quelle posizioni,
+// oppure gli eventi da salvare se tutte le scritture sono riuscite.
+func writeBulkWithEvents(ctx context.Context, n int, ordered bool, write func(txCtx context.Context, positions []int) (map[int]error, []models.UserEvent, error)) (map[int]error, error) {
+	failures := make(map[int]error)
+	pending := make([]int, n)
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for len(pending) > 0 {
+		var writeFailures map[int]error
+		err := runUserTransaction(ctx, func(txCtx context.Context) error {
+			itemFailures, events, err := write(txCtx, pending)
+			if err != nil {
+				return err
+			}
+			if len(itemFailures) > 0 {
+				writeFailures = itemFailures
+				return errBulkRetry
+			}
+			return recordUserEvents(txCtx, events...)
+		})
+		if err == nil {
+			return failures, nil
+		}
+		if !errors.Is(err, errBulkRetry) {
+			return nil, err
+		}
+
+		remaining := make([]int, 0, len(pending))
+		for k, position := range pending {
+			if writeErr, failed := writeFailures[k]; failed {
+				failures[position] = writeErr
+				if ordered {
+					break
+				}
+				continue
+			}
+			remaining = append(remaining, position)
+		}
+		pending = remaining
+	}
+	return failures, nil
+}
+
+// pick restituisce gli elementi di items alle posizioni indicate
+func pick[T any](items []T, positions []int) []T {
+	picked := make([]T, len(positions))
+	for i, position := range positions {
+		picked[i] = items[position]
+	}
+	return picked
+}
+
+// newBatchResults inizializza gli esiti come "non eseguiti"; vengono sovrascritti man mano che gli elementi sono elaborati
+func newBatchResults(n int) []models.BatchItemResult {
+	results := make([]models.BatchItemResult, n)
+	for i := range results {
+		results[i] = itemError(i, http.StatusFailedDependency, errNotExecuted)
+	}
+	return results
+}
+
+// rejectEmailConflicts segna con 409 gli elementi validi la cui email appartiene a un altro utente con una password
+// (vedi EmailConflicts) e restituisce i candidati rimasti. In modalità ordered si ferma al primo conflitto.
+func rejectEmailConflicts(ctx context.Context, results []models.BatchItemResult, candidates []int, users []models.User, ordered bool) ([]int, []models.User, error) {
+	conflicts, err := EmailConflicts(ctx, users)
+	if err != nil || len(conflicts) == 0 {
+		return candidates, users, err
+	}
+	var keptCandidates []int
+	var keptUsers []models.User
+	for k, index := range candidates {
+		if err := conflicts[k]; err != nil {
+			results[index] = itemError(index, http.StatusConflict, err)
+			results[index].ID = users[k].ID
+			if ordered {
+				break
+			}
+			continue
+		}
+		keptCandidates = append(keptCandidates, index)
+		keptUsers = append(keptUsers, users[k])
+	}
+	return keptCandidates, keptUsers, nil
+}
+
+// checkBatchID verifica che l'ID sia un ObjectID valido e che l'utente esista
+func checkBatchID(index int, id string, existing map[string]bool) *models.BatchItemResult {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		result := itemError(index, http.StatusBadRequest, errors.New("id is not a valid ObjectID"))
+		return &result
+	}
+	if !existing[id] {
+		result := itemError(index, http.StatusNotFound, errors.New("user not found"))
+		result.ID = id
+		return &result
+	}
+	return nil
+}
+
+// applyBulkResults riporta sugli elementi originali l'esito della bulk write.
+// candidates mappa la posizione nella bulk write sull'indice dell'elemento nella richiesta.
+func applyBulkResults(results []models.BatchItemResult, candidates []int, failures map[int]error, ordered bool, success func(k int) models.BatchItemResult) {
+	for k, index := range candidates {
+		if err, failed := failures[k]; failed {
+			status := http.StatusInternalServerError
+			if mongo.IsDuplicateKeyError(err) {
+				status = http.StatusConflict
+			}
+			results[index] = itemError(index, status, err)
+			if ordered {
+				// MongoDB interrompe la bulk write ordered al primo errore
+				return
+			}
+			continue
+		}
+		result := success(k)
+		result.Index = index
+		results[index] = result
+	}
+}
+
+// summarizeBatch conta successi e fallimenti
+func summarizeBatch(ordered bool, results []models.BatchItemResult) *models.BatchResult {
+	summary := &models.BatchResult{Ordered: ordered, Results: results}
+	for _, result := range results {
+		if result.Status < http.StatusBadRequest {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+func itemError(index, status int, err error) models.BatchItemResult {
+	return models.BatchItemResult{Index: index, Status: status, Error: err.Error()}
+}
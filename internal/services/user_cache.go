@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/cache"
+	"myapp/internal/jsonschema"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	userCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_cache_requests_total",
+		Help: "Letture dalla cache degli utenti per esito (hit, negative_hit, miss)",
+	}, []string{"result"})
+	userCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_cache_evictions_total",
+		Help: "Utenti rimossi dalla cache per mancanza di spazio",
+	})
+	userCacheInvalidations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_cache_invalidations_total",
+		Help: "Utenti rimossi dalla cache in seguito a una modifica",
+	})
+
+	userCacheInstance *userCache
+	userCacheOnce     sync.Once
+)
+
+// userCacheEntry è un utente in cache; found false memorizza un utente inesistente (negative caching)
+type userCacheEntry struct {
+	user  models.User
+	found bool
+}
+
+// userCache è una cache read-through degli utenti per tenant e ID davanti al repository.
+// Le letture concorrenti della stessa chiave non presente vengono unite in una sola query (single-flight)
+// e anche gli utenti inesistenti vengono memorizzati, con una durata più breve.
+// La cache è locale al processo: le modifiche della replica la invalidano subito dopo il commit, quelle delle
+// altre repliche quando il loro evento arriva dal change stream dell'outbox (RunUserEventFeed).
+type userCache struct {
+	entries     *cache.LRU[string, userCacheEntry]
+	group       singleflight.Group
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	// version viene incrementata a ogni invalidazione: una lettura dal database iniziata prima
+	// di una modifica non deve salvare in cache il valore ormai superato
+	mu      sync.Mutex
+	version uint64
+}
+
+// getUserCache ritorna la cache degli utenti, o nil se disabilitata con USER_CACHE_ENABLED=false
+func getUserCache() *userCache {
+	userCacheOnce.Do(func() {
+		if utils.EnvOrDefault("USER_CACHE_ENABLED", "true") != "true" {
+			return
+		}
+		userCacheInstance = &userCache{
+			entries:     cache.NewLRU[string, userCacheEntry](utils.EnvIntOrDefault("USER_CACHE_SIZE", 10000)),
+			ttl:         utils.EnvDurationOrDefault("USER_CACHE_TTL", 5*time.Minute),
+			negativeTTL: utils.EnvDurationOrDefault("USER_CACHE_NEGATIVE_TTL", 30*time.Second),
+		}
+	})
+	return userCacheInstance
+}
+
+// get restituisce l'utente dalla cache o, se assente, lo carica con load.
+// Un utente inesistente è restituito come mongo.ErrNoDocuments, come dal repository.
+func (c *userCache) get(ctx context.Context, id string, load func(ctx context.Context, id string) (*models.User, error)) (*models.User, error) {
+	key := userCacheKey(ctx, id)
+	if cached, ok := c.entries.Get(key); ok {
+		if !cached.found {
+			userCacheRequests.WithLabelValues("negative_hit").Inc()
+			return nil, mongo.ErrNoDocuments
+		}
+		userCacheRequests.WithLabelValues("hit").Inc()
+		user := cloneUser(cached.user)
+		return &user, nil
+	}
+	userCacheRequests.WithLabelValues("miss").Inc()
+
+	value, err, _ := c.group.Do(key, func() (interface{}, error) {
+		version := c.currentVersion()
+		// La query è condivisa tra più richieste: non deve essere annullata se si disconnette la prima
+		user, err := load(context.WithoutCancel(ctx), id)
+		switch {
+		case err == nil:
+			c.store(version, key, userCacheEntry{user: *user, found: true}, c.ttl)
+		case errors.Is(err, mongo.ErrNoDocuments):
+			c.store(version, key, userCacheEntry{}, c.negativeTTL)
+		}
+		return user, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Ogni chiamante riceve una copia, così nessuno può modificare l'utente condiviso
+	user := cloneUser(*value.(*models.User))
+	return &user, nil
+}
+
+// getMany restituisce gli utenti esistenti tra quelli indicati: quelli in cache vengono restituiti subito,
+// gli altri sono caricati con una sola chiamata a load e memorizzati (anche quelli inesistenti)
+func (c *userCache) getMany(ctx context.Context, ids []string, load func(ctx context.Context, ids []string) (map[string]models.User, error)) (map[string]models.User, error) {
+	users := make(map[string]models.User, len(ids))
+	var missing []string
+	for _, id := range ids {
+		cached, ok := c.entries.Get(userCacheKey(ctx, id))
+		switch {
+		case !ok:
+			missing = append(missing, id)
+		case cached.found:
+			users[id] = cloneUser(cached.user)
+			userCacheRequests.WithLabelValues("hit").Inc()
+		default:
+			userCacheRequests.WithLabelValues("negative_hit").Inc()
+		}
+	}
+	if len(missing) == 0 {
+		return users, nil
+	}
+	userCacheRequests.WithLabelValues("miss").Add(float64(len(missing)))
+
+	version := c.currentVersion()
+	loaded, err := load(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range missing {
+		if user, found := loaded[id]; found {
+			users[id] = cloneUser(user)
+			c.store(version, userCacheKey(ctx, id), userCacheEntry{user: user, found: true}, c.ttl)
+		} else {
+			c.store(version, userCacheKey(ctx, id), userCacheEntry{}, c.negativeTTL)
+		}
+	}
+	return users, nil
+}
+
+// invalidate rimuove gli utenti dalla cache (chiavi di userCacheKey); va chiamata dopo ogni modifica
+func (c *userCache) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	for _, key := range keys {
+		c.entries.Delete(key)
+		// Le richieste successive non si uniscono a una lettura iniziata prima della modifica
+		c.group.Forget(key)
+		userCacheInvalidations.Inc()
+	}
+}
+
+// purge svuota la cache; va chiamata quando alcune modifiche potrebbero non essere state notificate
+func (c *userCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	c.entries.Purge()
+}
+
+// currentVersion restituisce la versione corrente della cache
+func (c *userCache) currentVersion() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// store salva il valore solo se nel frattempo non ci sono state invalidazioni
+func (c *userCache) store(version uint64, key string, value userCacheEntry, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version != version {
+		return
+	}
+	if c.entries.Set(key, value, ttl) {
+		userCacheEvictions.Inc()
+	}
+}
+
+// cloneUser copia in profondità l'utente: la data di stato e gli attributi (mappe e array annidati compresi)
+// altrimenti sarebbero condivisi con la cache
+func cloneUser(user models.User) models.User {
+	if user.StatusChangedAt != nil {
+		changedAt := *user.StatusChangedAt
+		user.StatusChangedAt = &changedAt
+	}
+	if user.Attributes != nil {
+		user.Attributes = cloneAttributeValue(user.Attributes).(map[string]interface{})
+	}
+	return user
+}
+
+// cloneAttributeValue copia ricorsivamente un valore degli attributi, come decodificato da JSON o da BSON
+func cloneAttributeValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for name, item := range value {
+			copied[name] = cloneAttributeValue(item)
+		}
+		return copied
+	case primitive.M:
+		return primitive.M(cloneAttributeValue(map[string]interface{}(value)).(map[string]interface{}))
+	case primitive.D:
+		copied := make(primitive.D, len(value))
+		for i, element := range value {
+			copied[i] = primitive.E{Key: element.Key, Value: cloneAttributeValue(element.Value)}
+		}
+		return copied
+	}
+	if items, ok := jsonschema.ToList(value); ok {
+		copied := make([]interface{}, len(items))
+		for i, item := range items {
+			copied[i] = cloneAttributeValue(item)
+		}
+		return copied
+	}
+	return value
+}
+
+// invalidateCachedUsers rimuove dalla cache gli utenti modificati dagli eventi
+func invalidateCachedUsers(events []models.UserEvent) {
+	userCache := getUserCache()
+	if userCache == nil || len(events) == 0 {
+		return
+	}
+	keys := make([]string, len(events))
+	for i, event := range events {
+		keys[i] = tenancy.Key(event.TenantID, event.UserID)
+	}
+	userCache.invalidate(keys...)
+}
+
+// userCacheKey restituisce la chiave della cache di un utente: lo stesso ID in tenant diversi
+// (es. import con ID espliciti in database separati) indica utenti diversi
+func userCacheKey(ctx context.Context, id string) string {
+	tenant, _ := tenancy.FromContext(ctx)
+	return tenancy.Key(tenant, id)
+}
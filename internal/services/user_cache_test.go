@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/cache"
+	"myapp/internal/models"
+	"myapp/internal/tenancy"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// newTestUserCache crea una cache degli utenti con la capacità e le durate indicate
+func newTestUserCache(size int, ttl, negativeTTL time.Duration) *userCache {
+	return &userCache{entries: cache.NewLRU[string, userCacheEntry](size), ttl: ttl, negativeTTL: negativeTTL}
+}
+
+// countingLoader restituisce un loader che conta le letture e trova solo gli utenti in users
+func countingLoader(users map[string]models.User, loads *int) func(context.Context, string) (*models.User, error) {
+	return func(_ context.Context, id string) (*models.User, error) {
+		*loads++
+		user, ok := users[id]
+		if !ok {
+			return nil, mongo.ErrNoDocuments
+		}
+		return &user, nil
+	}
+}
+
+func TestUserCacheReadThrough(t *testing.T) {
+	users := map[string]models.User{"1": {ID: "1", Name: "Ada"}, "2": {ID: "2", Name: "Bob"}}
+	loads := 0
+	load := countingLoader(users, &loads)
+	c := newTestUserCache(1, time.Minute, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if user, err := c.get(ctx, "1", load); err != nil || user.Name != "Ada" {
+			t.Fatalf("get: %v %v", user, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("%d loads, want the second read served from the cache", loads)
+	}
+
+	// Con capacità 1 il secondo utente rimuove il primo
+	c.get(ctx, "2", load)
+	c.get(ctx, "1", load)
+	if loads != 3 {
+		t.Errorf("%d loads, want the least recently used user evicted", loads)
+	}
+
+	// Lo stesso ID in un altro tenant è un altro utente
+	c.get(tenancy.WithTenant(ctx, "acme"), "1", load)
+	if loads != 4 {
+		t.Errorf("%d loads, want tenants cached separately", loads)
+	}
+}
+
+func TestUserCacheTTL(t *testing.T) {
+	loads := 0
+	load := countingLoader(map[string]models.User{"1": {ID: "1"}}, &loads)
+	c := newTestUserCache(10, 30*time.Millisecond, 10*time.Millisecond)
+	ctx := context.Background()
+
+	c.get(ctx, "1", load)
+	if _, err := c.get(ctx, "missing", load); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("missing user: err %v", err)
+	}
+	if _, err := c.get(ctx, "missing", load); !errors.Is(err, mongo.ErrNoDocuments) || loads != 2 {
+		t.Fatalf("negative hit: err %v after %d loads", err, loads)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	c.get(ctx, "1", load)
+	c.get(ctx, "missing", load)
+	if loads != 3 {
+		t.Errorf("%d loads, want only the missing user reloaded after the negative TTL", loads)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	c.get(ctx, "1", load)
+	if loads != 4 {
+		t.Errorf("%d loads, want the user reloaded after the TTL", loads)
+	}
+}
+
+func TestUserCacheInvalidation(t *testing.T) {
+	users := map[string]models.User{"1": {ID: "1", Name: "Ada"}}
+	loads := 0
+	load := countingLoader(users, &loads)
+	c := newTestUserCache(10, time.Minute, time.Minute)
+	ctx := context.Background()
+
+	c.get(ctx, "1", load)
+	users["1"] = models.User{ID: "1", Name: "Ada Lovelace"}
+	c.invalidate(userCacheKey(ctx, "1"))
+	if user, _ := c.get(ctx, "1", load); user.Name != "Ada Lovelace" {
+		t.Errorf("name %q after invalidation", user.Name)
+	}
+
+	// Una lettura iniziata prima di una modifica non salva il valore superato
+	stale := func(ctx context.Context, id string) (*models.User, error) {
+		c.invalidate(userCacheKey(ctx, id))
+		return &models.User{ID: id, Name: "stale"}, nil
+	}
+	c.invalidate(userCacheKey(ctx, "1"))
+	c.get(ctx, "1", stale)
+	if user, _ := c.get(ctx, "1", load); user.Name != "Ada Lovelace" {
+		t.Errorf("name %q, want the stale read not cached", user.Name)
+	}
+
+	c.purge()
+	before := loads
+	c.get(ctx, "1", load)
+	if loads != before+1 {
+		t.Error("user still cached after purge")
+	}
+}
+
+func TestCloneUserIsDeep(t *testing.T) {
+	changedAt := time.Now()
+	user := models.User{
+		ID:              "1",
+		StatusChangedAt: &changedAt,
+		Attributes: map[string]interface{}{
+			"skills":  primitive.A{"go"},
+			"address": map[string]interface{}{"city": "Rome", "tags": []interface{}{"home"}},
+			"profile": primitive.D{{Key: "level", Value: int64(3)}},
+		},
+	}
+	clone := cloneUser(user)
+
+	*clone.StatusChangedAt = changedAt.Add(time.Hour)
+	clone.Attributes["skills"].([]interface{})[0] = "rust"
+	address := clone.Attributes["address"].(map[string]interface{})
+	address["city"] = "Milan"
+	address["tags"].([]interface{})[0] = "work"
+	clone.Attributes["profile"].(primitive.D)[0].Value = int64(5)
+
+	if !user.StatusChangedAt.Equal(changedAt) {
+		t.Error("status date shared with the clone")
+	}
+	if user.Attributes["skills"].(primitive.A)[0] != "go" {
+		t.Error("array attribute shared with the clone")
+	}
+	original := user.Attributes["address"].(map[string]interface{})
+	if original["city"] != "Rome" || original["tags"].([]interface{})[0] != "home" {
+		t.Errorf("nested map shared with the clone: %v", original)
+	}
+	if user.Attributes["profile"].(primitive.D)[0].Value != int64(3) {
+		t.Error("nested document shared with the clone")
+	}
+}
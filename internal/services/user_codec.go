@@ -0,0 +1,278 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"strings"
+	"time"
+)
+
+// userCSVHeader sono le colonne usate per import ed export CSV; all'import solo name ed email sono obbligatorie
+var userCSVHeader = []string{"id", "name", "email", "status", "statusReason", "statusChangedAt"}
+
+// userWriter scrive una sequenza di utenti in uno dei formati di export
+type userWriter interface {
+	WriteUser(user models.User) error
+	Close() error
+}
+
+// newUserWriter crea il writer per il formato richiesto
+func newUserWriter(w io.Writer, format string) (userWriter, error) {
+	switch format {
+	case constants.FORMAT_CSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(userCSVHeader); err != nil {
+			return nil, err
+		}
+		return &csvUserWriter{writer: writer}, nil
+	case constants.FORMAT_NDJSON:
+		return &ndjsonUserWriter{encoder: json.NewEncoder(w)}, nil
+	case constants.FORMAT_JSON:
+		return &jsonArrayUserWriter{w: w}, nil
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+type csvUserWriter struct {
+	writer *csv.Writer
+}
+
+func (c *csvUserWriter) WriteUser(user models.User) error {
+	changedAt := ""
+	if user.StatusChangedAt != nil {
+		changedAt = user.StatusChangedAt.UTC().Format(time.RFC3339Nano)
+	}
+	record := []string{user.ID, user.Name, user.Email, user.Status, user.StatusReason, changedAt}
+	for i, cell := range record {
+		record[i] = escapeCSVCell(cell)
+	}
+	return c.writer.Write(record)
+}
+
+// csvFormulaPrefixes sono i caratteri iniziali con cui un foglio di calcolo interpreta una cella come formula
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// escapeCSVCell antepone un apice alle celle che un foglio di calcolo aprirebbe come formula (CSV injection).
+// Anche una cella che inizia con un apice seguito da una cella da proteggere riceve un apice, così unescapeCSVCell
+// restituisce sempre il valore originale.
+func escapeCSVCell(value string) string {
+	if needsCSVEscape(value) {
+		return "'" + value
+	}
+	return value
+}
+
+// unescapeCSVCell rimuove l'apice aggiunto da escapeCSVCell
+func unescapeCSVCell(value string) string {
+	if strings.HasPrefix(value, "'") && needsCSVEscape(value[1:]) {
+		return value[1:]
+	}
+	return value
+}
+
+func needsCSVEscape(value string) bool {
+	for strings.HasPrefix(value, "'") {
+		value = value[1:]
+	}
+	return value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0]))
+}
+
+func (c *csvUserWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type ndjsonUserWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonUserWriter) WriteUser(user models.User) error {
+	return n.encoder.Encode(user)
+}
+
+func (n *ndjsonUserWriter) Close() error {
+	return nil
+}
+
+// jsonArrayUserWriter scrive gli utenti come array JSON un elemento alla volta
+type jsonArrayUserWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonArrayUserWriter) WriteUser(user models.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	separator := ","
+	if j.count == 0 {
+		separator = "["
+	}
+	j.count++
+	if _, err := io.WriteString(j.w, separator); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonArrayUserWriter) Close() error {
+	closing := "]"
+	if j.count == 0 {
+		closing = "[]"
+	}
+	_, err := io.WriteString(j.w, closing)
+	return err
+}
+
+// recordError è un errore limitato a un singolo record: la lettura può proseguire con il successivo
+type recordError struct {
+	err error
+}
+
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+// userReader legge una sequenza di utenti in uno dei formati di import.
+// Next restituisce io.EOF a fine input, un *recordError per un record non valido
+// e qualsiasi altro errore quando l'input non è più leggibile.
+type userReader interface {
+	Next() (models.User, error)
+}
+
+// newUserReader crea il reader per il formato richiesto
+func newUserReader(r io.Reader, format string) (userReader, error) {
+	switch format {
+	case constants.FORMAT_CSV:
+		return newCSVUserReader(r)
+	case constants.FORMAT_NDJSON:
+		return &ndjsonUserReader{reader: bufio.NewReader(r)}, nil
+	case constants.FORMAT_JSON:
+		decoder := json.NewDecoder(r)
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return nil, errors.New("expected a JSON array")
+		}
+		return &jsonArrayUserReader{decoder: decoder}, nil
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+type csvUserReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+// newCSVUserReader legge l'intestazione per individuare le colonne, che possono essere in qualsiasi ordine
+func newCSVUserReader(r io.Reader) (*csvUserReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing column %q", required)
+		}
+	}
+	return &csvUserReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvUserReader) Next() (models.User, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return models.User{}, &recordError{err: err}
+		}
+		return models.User{}, err
+	}
+	user := models.User{
+		ID:           c.field(record, "id"),
+		Name:         c.field(record, "name"),
+		Email:        c.field(record, "email"),
+		Status:       c.field(record, "status"),
+		StatusReason: c.field(record, "statusreason"),
+	}
+	if value := c.field(record, "statuschangedat"); value != "" {
+		changedAt, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return models.User{}, &recordError{err: fmt.Errorf("statusChangedAt is not an RFC 3339 time: %w", err)}
+		}
+		user.StatusChangedAt = &changedAt
+	}
+	return user, nil
+}
+
+func (c *csvUserReader) field(record []string, name string) string {
+	index, ok := c.columns[name]
+	if !ok || index >= len(record) {
+		return ""
+	}
+	return unescapeCSVCell(strings.TrimSpace(record[index]))
+}
+
+type ndjsonUserReader struct {
+	reader *bufio.Reader
+}
+
+func (n *ndjsonUserReader) Next() (models.User, error) {
+	for {
+		line, err := n.reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return models.User{}, err
+			}
+			// Le righe vuote vengono ignorate
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return models.User{}, err
+		}
+
+		var user models.User
+		if err := json.Unmarshal(line, &user); err != nil {
+			return models.User{}, &recordError{err: err}
+		}
+		return user, nil
+	}
+}
+
+type jsonArrayUserReader struct {
+	decoder *json.Decoder
+}
+
+func (j *jsonArrayUserReader) Next() (models.User, error) {
+	if !j.decoder.More() {
+		return models.User{}, io.EOF
+	}
+	var user models.User
+	if err := j.decoder.Decode(&user); err != nil {
+		// Un errore di tipo consuma comunque l'intero elemento, quindi si può proseguire
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return models.User{}, &recordError{err: err}
+		}
+		return models.User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/stream"
+	"myapp/internal/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Codici di errore MongoDB di un change stream che non può essere ripreso dal resume token
+const (
+	changeStreamFatalErrorCode       = 280
+	changeStreamHistoryLostErrorCode = 286
+)
+
+// RunUserEventFeed segue gli eventi degli utenti salvati nell'outbox da tutte le repliche (change stream)
+// finché il contesto non viene cancellato: per ognuno invalida la cache degli utenti del processo e lo pubblica
+// sul broker degli stream SSE e gRPC, così i client ricevono le modifiche eseguite da qualsiasi replica.
+// Dopo un'interruzione riprende dall'ultimo evento; se non è possibile riparte dagli eventi nuovi e svuota la cache,
+// che potrebbe contenere utenti modificati nel frattempo. Gli errori sono ritentati con backoff esponenziale.
+func RunUserEventFeed(ctx context.Context) {
+	log := utils.WithContext().WithField("function", "RunUserEventFeed")
+	log.Info("User event feed started")
+
+	var token bson.Raw
+	for attempt := 1; ; attempt++ {
+		if token == nil {
+			// Senza resume token gli eventi precedenti all'apertura non arriveranno
+			if userCache := getUserCache(); userCache != nil {
+				userCache.purge()
+			}
+		}
+		previous := token
+		var err error
+		token, err = repository.WatchOutboxEvents(ctx, token, handleUserFeedEvent)
+		if ctx.Err() != nil {
+			log.Info("User event feed stopped")
+			return
+		}
+		if !bytes.Equal(previous, token) {
+			// Sono arrivati eventi: lo stream funzionava, il backoff riparte da capo
+			attempt = 1
+		}
+		var serverErr mongo.ServerError
+		if errors.As(err, &serverErr) && (serverErr.HasErrorCode(changeStreamHistoryLostErrorCode) || serverErr.HasErrorCode(changeStreamFatalErrorCode)) {
+			token = nil
+		}
+		delay := utils.ExponentialBackoff(time.Second, time.Minute, attempt)
+		log.Warnf("User event feed interrupted, reconnecting in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			log.Info("User event feed stopped")
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// handleUserFeedEvent applica al processo un evento letto dall'outbox
+func handleUserFeedEvent(event models.UserEvent) {
+	invalidateCachedUsers([]models.UserEvent{event})
+	stream.GetBroker().Publish(event)
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/stream"
+	"myapp/internal/utils/constants"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUserFeedAppliesEventsOfEveryReplica(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("outbox insert", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		c := getUserCache()
+		ctx := context.Background()
+		loads := 0
+		load := countingLoader(map[string]models.User{"6650f1a2b3c4d5e6f7a8b9c0": {ID: "6650f1a2b3c4d5e6f7a8b9c0"}}, &loads)
+		c.get(ctx, "6650f1a2b3c4d5e6f7a8b9c0", load)
+
+		change := bson.D{
+			{Key: "_id", Value: bson.D{{Key: "_data", Value: "token-1"}}},
+			{Key: "operationType", Value: "insert"},
+			{Key: "fullDocument", Value: bson.D{
+				{Key: "_id", Value: "event-1"},
+				{Key: "type", Value: constants.EVENT_USER_UPDATED},
+				{Key: "userId", Value: "6650f1a2b3c4d5e6f7a8b9c0"},
+				{Key: "status", Value: constants.OUTBOX_PENDING},
+			}},
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "myapp."+constants.OUTBOXCOLLECTION, mtest.FirstBatch, change))
+
+		subscriber, _, _ := stream.GetBroker().Subscribe("", "", 0, false)
+		defer stream.GetBroker().Unsubscribe(subscriber)
+
+		var handled []models.UserEvent
+		token, _ := repository.WatchOutboxEvents(ctx, nil, func(event models.UserEvent) {
+			handled = append(handled, event)
+			handleUserFeedEvent(event)
+		})
+		if len(handled) != 1 || handled[0].ID != "event-1" {
+			mt.Fatalf("handled %+v, want the inserted event", handled)
+		}
+		if token == nil {
+			mt.Error("no resume token after an event")
+		}
+
+		if len(subscriber.C) != 1 {
+			mt.Errorf("%d messages on the broker, want the feed event", len(subscriber.C))
+		}
+		c.get(ctx, "6650f1a2b3c4d5e6f7a8b9c0", load)
+		if loads != 2 {
+			mt.Errorf("%d loads, want the user reloaded after the feed event", loads)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"myapp/internal/config"
+	"myapp/internal/middleware"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"time"
+)
+
+// newUserEvent crea l'evento di dominio per una modifica, con il correlation ID e il tenant della richiesta.
+// Il tipo dipende dagli snapshot: senza before è una creazione, senza after una cancellazione.
+func newUserEvent(ctx context.Context, before, after *models.User) (models.UserEvent, error) {
+	id, err := utils.GenerateUUID()
+	if err != nil {
+		return models.UserEvent{}, err
+	}
+	tenant, _ := tenancy.FromContext(ctx)
+	event := models.UserEvent{
+		ID:            id,
+		Type:          eventTypeFor(before, after),
+		Before:        before,
+		After:         after,
+		CorrelationID: middleware.GetCorrelationID(ctx),
+		TenantID:      tenant,
+		OccurredAt:    time.Now().UTC(),
+	}
+	if after != nil {
+		event.UserID = after.ID
+	} else if before != nil {
+		event.UserID = before.ID
+	}
+	return event, nil
+}
+
+// eventCollectorKey è la chiave del contesto con gli eventi registrati nella transazione corrente
+type eventCollectorKey struct{}
+
+// runUserTransaction esegue fn in una transazione e, solo dopo il commit, invalida la cache degli utenti
+// modificati dagli eventi registrati con recordUserEvents. Gli eventi vengono raccolti di nuovo a ogni tentativo,
+// così quelli di un tentativo annullato non invalidano la cache. Gli stream degli eventi (SSE e gRPC) li ricevono
+// invece dal change stream dell'outbox (RunUserEventFeed), come quelli delle altre repliche.
+func runUserTransaction(ctx context.Context, fn func(txCtx context.Context) error) error {
+	var collected *[]models.UserEvent
+	err := config.WithTransaction(ctx, func(txCtx context.Context) error {
+		collected = new([]models.UserEvent)
+		return fn(context.WithValue(txCtx, eventCollectorKey{}, collected))
+	})
+	if err != nil {
+		return err
+	}
+	invalidateCachedUsers(*collected)
+	return nil
+}
+
+// recordUserEvents salva gli eventi nell'outbox e le corrispondenti voci nell'audit log;
+// txCtx deve essere il contesto ricevuto da runUserTransaction
+func recordUserEvents(txCtx context.Context, events ...models.UserEvent) error {
+	if err := repository.InsertOutboxEvents(txCtx, events); err != nil {
+		return err
+	}
+	entries, err := newAuditEntries(txCtx, events)
+	if err != nil {
+		return err
+	}
+	if err := repository.InsertAuditEntries(txCtx, entries); err != nil {
+		return err
+	}
+	if collected, ok := txCtx.Value(eventCollectorKey{}).(*[]models.UserEvent); ok {
+		*collected = append(*collected, events...)
+	}
+	return nil
+}
+
+// recordUserChange crea e salva nell'outbox l'evento di una singola modifica
+func recordUserChange(txCtx context.Context, before, after *models.User) error {
+	event, err := newUserEvent(txCtx, before, after)
+	if err != nil {
+		return err
+	}
+	return recordUserEvents(txCtx, event)
+}
+
+// eventTypeFor restituisce il tipo di evento per una modifica in base alla presenza degli snapshot
+func eventTypeFor(before, after *models.User) string {
+	switch {
+	case before == nil:
+		return constants.EVENT_USER_CREATED
+	case after == nil:
+		return constants.EVENT_USER_DELETED
+	default:
+		return constants.EVENT_USER_UPDATED
+	}
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrInvalidCursor indica un cursore non generato da QueryUsers o generato con un ordinamento diverso
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrInvalidSortField indica un campo di ordinamento non supportato
+	ErrInvalidSortField = errors.New("invalid sort field")
+)
+
+// userCursor è il contenuto (opaco per i client) dei cursori restituiti da QueryUsers
+type userCursor struct {
+	Field string `json:"f"`
+	Value string `json:"v,omitempty"`
+	ID    string `json:"id"`
+}
+
+// QueryUsers restituisce una pagina di utenti filtrata e ordinata, con i cursori per richiedere le pagine successive
+func QueryUsers(ctx context.Context, query models.UserQuery) (*models.UserPage, error) {
+	if query.SortField == "" {
+		query.SortField = constants.SORT_FIELD_ID
+	}
+	if query.SortField != constants.SORT_FIELD_ID && query.SortField != constants.SORT_FIELD_NAME && query.SortField != constants.SORT_FIELD_EMAIL {
+		return nil, ErrInvalidSortField
+	}
+
+	var after *repository.UserKey
+	if query.After != "" {
+		cursor, err := decodeUserCursor(query.After)
+		if err != nil || cursor.Field != query.SortField || !primitive.IsValidObjectID(cursor.ID) {
+			return nil, ErrInvalidCursor
+		}
+		after = &repository.UserKey{Value: cursor.Value, ID: cursor.ID}
+	}
+
+	// Un utente in più indica se esiste una pagina successiva
+	users, total, err := repository.FindUsersPage(ctx, query, after, int64(query.Limit)+1)
+	if err != nil {
+		utils.WithContext().Errorf("Error querying users: %v", err)
+		return nil, err
+	}
+
+	page := &models.UserPage{TotalCount: total, HasNextPage: len(users) > query.Limit}
+	if page.HasNextPage {
+		users = users[:query.Limit]
+	}
+	page.Items = make([]models.UserPageItem, len(users))
+	for i, user := range users {
+		page.Items[i] = models.UserPageItem{Cursor: encodeUserCursor(query.SortField, user), User: user}
+	}
+	return page, nil
+}
+
+// GetUsersByIDs restituisce gli utenti esistenti tra quelli indicati, indicizzati per ID.
+// Gli utenti presenti in cache non vengono letti dal database; gli altri sono letti con una sola query.
+func GetUsersByIDs(ctx context.Context, ids []string) (map[string]models.User, error) {
+	if userCache := getUserCache(); userCache != nil {
+		return userCache.getMany(ctx, ids, repository.FindUsersByIDs)
+	}
+	return repository.FindUsersByIDs(ctx, ids)
+}
+
+// encodeUserCursor crea il cursore dell'utente per l'ordinamento indicato
+func encodeUserCursor(field string, user models.User) string {
+	cursor := userCursor{Field: field, ID: user.ID}
+	switch field {
+	case constants.SORT_FIELD_NAME:
+		cursor.Value = user.Name
+	case constants.SORT_FIELD_EMAIL:
+		cursor.Value = user.Email
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeUserCursor legge un cursore creato da encodeUserCursor
+func decodeUserCursor(value string) (userCursor, error) {
+	var cursor userCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"html"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"regexp"
+	"strings"
+)
+
+// SearchUsers cerca gli utenti per nome ed email.
+// In modalità text usa l'indice full-text di MongoDB; se l'indice non esiste ricade sulla modalità prefix.
+func SearchUsers(ctx context.Context, query, mode string, page, pageSize int) (*models.UserSearchResult, error) {
+	log := utils.WithContext()
+	log.Infof("Ricerca utenti: %q (mode: %s, page: %d)", query, mode, page)
+
+	terms := strings.Fields(strings.ToLower(query))
+	skip := int64((page - 1) * pageSize)
+	result := &models.UserSearchResult{Query: query, Mode: mode, Page: page, PageSize: pageSize, Hits: []models.UserSearchHit{}}
+
+	if mode == constants.SEARCH_MODE_TEXT {
+		scored, total, err := repository.SearchUsersText(ctx, query, skip, int64(pageSize))
+		if err == nil {
+			result.Total = total
+			for _, user := range scored {
+				result.Hits = append(result.Hits, newSearchHit(user.User, user.Score, terms))
+			}
+			return result, nil
+		}
+		if !errors.Is(err, repository.ErrTextIndexNotFound) {
+			return nil, err
+		}
+		log.Warn("Indice full-text non disponibile, uso la ricerca per prefisso")
+		result.Mode = constants.SEARCH_MODE_PREFIX
+	}
+
+	users, total, err := repository.SearchUsersPrefix(ctx, terms, skip, int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+	result.Total = total
+	for _, user := range users {
+		result.Hits = append(result.Hits, newSearchHit(user, prefixScore(user, terms), terms))
+	}
+	return result, nil
+}
+
+// newSearchHit individua i campi che contengono i termini cercati e li evidenzia
+func newSearchHit(user models.User, score float64, terms []string) models.UserSearchHit {
+	hit := models.UserSearchHit{User: user, Score: score, MatchedFields: []string{}, Highlights: map[string]string{}}
+	pattern := termsPattern(terms)
+	if pattern == nil {
+		return hit
+	}
+	for field, value := range map[string]string{"name": user.Name, "email": user.Email} {
+		if pattern.MatchString(value) {
+			hit.MatchedFields = append(hit.MatchedFields, field)
+			hit.Highlights[field] = highlight(value, pattern)
+		}
+	}
+	// L'ordine di iterazione della mappa non è deterministico
+	if len(hit.MatchedFields) == 2 {
+		hit.MatchedFields = []string{"name", "email"}
+	}
+	return hit
+}
+
+// highlight racchiude in <em></em> le occorrenze dei termini. Il testo è escapato in HTML prima di aggiungere i marcatori:
+// nome ed email sono dati degli utenti e gli highlights sono pensati per essere inseriti in una pagina.
+func highlight(value string, pattern *regexp.Regexp) string {
+	var highlighted strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringIndex(value, -1) {
+		highlighted.WriteString(html.EscapeString(value[last:match[0]]))
+		highlighted.WriteString("<em>" + html.EscapeString(value[match[0]:match[1]]) + "</em>")
+		last = match[1]
+	}
+	highlighted.WriteString(html.EscapeString(value[last:]))
+	return highlighted.String()
+}
+
+// prefixScore calcola un punteggio per la modalità prefix:
+// 2 per campo uguale al termine, 1.5 se il campo inizia con il termine, 1 se lo inizia una parola interna
+func prefixScore(user models.User, terms []string) float64 {
+	score := 0.0
+	for _, term := range terms {
+		best := 0.0
+		for _, value := range []string{strings.ToLower(user.Name), strings.ToLower(user.Email)} {
+			switch {
+			case value == term:
+				best = max(best, 2)
+			case strings.HasPrefix(value, term):
+				best = max(best, 1.5)
+			case strings.Contains(value, term):
+				best = max(best, 1)
+			}
+		}
+		score += best
+	}
+	return score
+}
+
+// termsPattern costruisce una regex case-insensitive che trova uno qualsiasi dei termini
+func termsPattern(terms []string) *regexp.Regexp {
+	if len(terms) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	return regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+}
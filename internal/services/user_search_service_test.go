@@ -0,0 +1,47 @@
+package services
+
+import (
+	"myapp/internal/models"
+	"testing"
+)
+
+func TestNewSearchHitEscapesHighlights(t *testing.T) {
+	user := models.User{Name: `Ada <script>alert("x")</script> Lovelace`, Email: "ada&co@example.com"}
+	hit := newSearchHit(user, 1, []string{"ada"})
+
+	if want := `<em>Ada</em> &lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; Lovelace`; hit.Highlights["name"] != want {
+		t.Errorf("name highlight %q, want %q", hit.Highlights["name"], want)
+	}
+	if want := "<em>ada</em>&amp;co@example.com"; hit.Highlights["email"] != want {
+		t.Errorf("email highlight %q, want %q", hit.Highlights["email"], want)
+	}
+	if len(hit.MatchedFields) != 2 || hit.MatchedFields[0] != "name" || hit.MatchedFields[1] != "email" {
+		t.Errorf("matched fields %v, want [name email]", hit.MatchedFields)
+	}
+}
+
+func TestNewSearchHitWithoutMatches(t *testing.T) {
+	hit := newSearchHit(models.User{Name: "Grace", Email: "grace@example.com"}, 0, []string{"ada"})
+	if len(hit.MatchedFields) != 0 || len(hit.Highlights) != 0 {
+		t.Errorf("matched fields %v highlights %v, want none", hit.MatchedFields, hit.Highlights)
+	}
+}
+
+func TestPrefixScore(t *testing.T) {
+	user := models.User{Name: "Ada Lovelace", Email: "ada@example.com"}
+	cases := []struct {
+		terms []string
+		want  float64
+	}{
+		{[]string{"ada lovelace"}, 2},
+		{[]string{"ada"}, 1.5},
+		{[]string{"love"}, 1},
+		{[]string{"ada", "love"}, 2.5},
+		{[]string{"grace"}, 0},
+	}
+	for _, tc := range cases {
+		if got := prefixScore(user, tc.terms); got != tc.want {
+			t.Errorf("%v: score %v, want %v", tc.terms, got, tc.want)
+		}
+	}
+}
@@ -2,17 +2,21 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"myapp/internal/models"
 	"myapp/internal/repository"
 	"myapp/internal/utils"
+	"time"
 
 	"github.com/openzipkin/zipkin-go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // GetAllUsers retrieves all users from the MongoDB collection
-func GetAllUsers(ctx context.Context, tracer *zipkin.Tracer) ([]models.User, error) {
+// Con il filtro restituisce solo gli utenti in uno degli stati indicati e con i valori indicati degli attributi.
+func GetAllUsers(ctx context.Context, tracer *zipkin.Tracer, filter models.UserListFilter) ([]models.User, error) {
 	log := utils.WithContext()
 	log.Info("Get all users...")
 
@@ -25,7 +29,7 @@ func GetAllUsers(ctx context.Context, tracer *zipkin.Tracer) ([]models.User, err
 	// Crea un nuovo contesto con lo span figlio
 	childCtx := zipkin.NewContext(ctx, childSpan)
 
-	users, err := repository.GetUsers(childCtx, tracer)
+	users, err := repository.GetUsers(childCtx, tracer, filter)
 	if err != nil {
 		log.Errorf("Errore durante la getAll: %v", err)
 	}
@@ -33,93 +37,212 @@ func GetAllUsers(ctx context.Context, tracer *zipkin.Tracer) ([]models.User, err
 }
 
 // CreateUser crea un nuovo utente
-func CreateUser(user models.User) (*models.User, error) {
+func CreateUser(ctx context.Context, user models.User) (*models.User, error) {
 	log := utils.WithContext()
 	// Registra un messaggio di log indicando che la creazione dell'utente è iniziata
-	log.Info("Creo user --> request in ingresso:", user)
+	log.Infof("Creo user --> request in ingresso: %v", user)
 
-	// Chiama la funzione CreateUser del repository per inserire l'utente nel database
-	// La funzione restituisce un risultato che contiene l'ID dell'utente appena creato e un eventuale errore
-	result, err := repository.CreateUser(user)
+	// L'ID viene sempre generato dal database
+	user.ID = ""
+	user.Email = NormalizeEmail(user.Email)
+	if err := ValidateInitialStatus(user.Status); err != nil {
+		return nil, err
+	}
+	// La data del cambio di stato è decisa dal server
+	user.StatusChangedAt = nil
+	initUserStatus(&user, time.Now())
+	schema, err := loadAttributeSchema(ctx)
 	if err != nil {
-		// Se si verifica un errore durante la creazione dell'utente, registra un messaggio di log e restituisce l'errore
-		log.Errorf("Error durante la create user: %v", err)
 		return nil, err
 	}
-
-	// Converte l'ID inserito (InsertedID) in una stringa
-	// InsertedID è di tipo interface{}, quindi deve essere convertito a primitive.ObjectID
-	userID, ok := result.InsertedID.(primitive.ObjectID)
-	if !ok {
-		// Se la conversione fallisce, restituisce un errore
-		return nil, errors.New("errore durante la conversione dell InsertedID to ObjectID")
+	if err := validateUserAttributes(schema, &user, true); err != nil {
+		return nil, err
+	}
+	if err := checkEmailConflict(ctx, user); err != nil {
+		return nil, err
 	}
 
-	// Imposta l'ID dell'utente nella struttura user con il valore dell'ID convertito in stringa
-	//MongoDB utilizza per identificare univocamente i documenti.
-	//Tuttavia, ObjectID non è direttamente leggibile come stringa normale, quindi il metodo Hex() viene utilizzato per convertirlo in una stringa esadecimale leggibile.
-	user.ID = userID.Hex()
+	// L'inserimento e l'evento UserCreated nell'outbox vengono scritti nella stessa transazione.
+	// La funzione può essere rieseguita dal driver, quindi lavora su una copia dell'utente in ingresso.
+	var created models.User
+	err = runUserTransaction(ctx, func(txCtx context.Context) error {
+		// Chiama la funzione CreateUser del repository per inserire l'utente nel database
+		// La funzione restituisce un risultato che contiene l'ID dell'utente appena creato e un eventuale errore
+		result, err := repository.CreateUser(txCtx, user)
+		if err != nil {
+			return err
+		}
+
+		// Converte l'ID inserito (InsertedID) in una stringa
+		// InsertedID è di tipo interface{}, quindi deve essere convertito a primitive.ObjectID
+		userID, ok := result.InsertedID.(primitive.ObjectID)
+		if !ok {
+			// Se la conversione fallisce, restituisce un errore
+			return errors.New("errore durante la conversione dell InsertedID to ObjectID")
+		}
+
+		// Imposta l'ID dell'utente con il valore dell'ID convertito in stringa
+		//MongoDB utilizza per identificare univocamente i documenti.
+		//Tuttavia, ObjectID non è direttamente leggibile come stringa normale, quindi il metodo Hex() viene utilizzato per convertirlo in una stringa esadecimale leggibile.
+		created = user
+		created.ID = userID.Hex()
+
+		return recordUserChange(txCtx, nil, &created)
+	})
+	if err != nil {
+		// Se si verifica un errore durante la creazione dell'utente, registra un messaggio di log e restituisce l'errore
+		log.Errorf("Error durante la create user: %v", err)
+		return nil, err
+	}
 
-	log.Info("User creato con ID: %s", user.ID)
+	log.Infof("User creato con ID: %s", created.ID)
 
 	// Restituisce un puntatore alla struttura user appena creata e nil come errore
 	// Questo evita di copiare l'intera struttura, permette modifiche successive, e utilizza nil per indicare l'assenza di errore
-	return &user, nil
+	return &created, nil
 	//Restituire un puntatore (&user) evita di copiare l'intera struttura user, il che è più efficiente in termini di memoria e prestazioni.
 
 	//Mutabilità:
 	//Con un puntatore, il chiamante della funzione può modificare direttamente i campi della struttura user senza dover lavorare con una copia separata.
 }
 
-// GetUserByID retrieves a user by ID
-func GetUserByID(id string) (*models.User, error) {
+// GetUserByID retrieves a user by ID, through the user cache unless disabled
+func GetUserByID(ctx context.Context, id string) (*models.User, error) {
 	log := utils.WithContext()
 
-	log.Info("Cerco utente Id: %s", id)
-	user, err := repository.GetUserByID(id)
+	log.Infof("Cerco utente Id: %s", id)
+	var user *models.User
+	var err error
+	if userCache := getUserCache(); userCache != nil {
+		user, err = userCache.get(ctx, id, repository.GetUserByID)
+	} else {
+		user, err = repository.GetUserByID(ctx, id)
+	}
 	if err != nil {
 		log.Printf("Error retrieving user by ID: %s, error: %v", id, err)
 	}
 	return user, err
 }
 
-// DeleteUserByID deletes a user by ID
-func DeleteUserByID(id string) error {
+// DeleteUserByID deletes a user by ID.
+// Deleting a user that does not exist is not an error and does not produce an event.
+func DeleteUserByID(ctx context.Context, id string) error {
 	log := utils.WithContext()
 
-	log.Info("Cancello utente con Id: %s", id)
-	err := repository.DeleteUserByID(id)
+	log.Infof("Cancello utente con Id: %s", id)
+	err := runUserTransaction(ctx, func(txCtx context.Context) error {
+		deleted, err := repository.DeleteUserByID(txCtx, id)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := deleteUserDependents(txCtx, []string{id}); err != nil {
+			return err
+		}
+		return recordUserChange(txCtx, deleted, nil)
+	})
 	if err != nil {
 		log.Errorf("Error deleting user by ID: %s, error: %v", id, err)
 	}
 	return err
 }
 
+// deleteUserDependents elimina, nella transazione della cancellazione, i dati collegati agli utenti eliminati:
+// credenziali, refresh token, token inviati per email e appartenenze ai gruppi
+func deleteUserDependents(txCtx context.Context, ids []string) error {
+	if err := repository.DeleteCredentials(txCtx, ids); err != nil {
+		return err
+	}
+	if err := repository.DeleteUserRefreshTokens(txCtx, ids); err != nil {
+		return err
+	}
+	if err := repository.DeleteUserEmailTokens(txCtx, ids); err != nil {
+		return err
+	}
+	return repository.DeleteUserGroupMemberships(txCtx, ids)
+}
+
 // UpdateUser updates a user by ID
 // UpdateUser aggiorna un utente tramite ID
-func UpdateUser(id string, user models.User) (*models.User, error) {
+func UpdateUser(ctx context.Context, id string, user models.User) (*models.User, error) {
 	log := utils.WithContext()
 
 	// Registra un messaggio di log indicando che l'aggiornamento dell'utente è iniziato
-	log.Info("Service: Update utente con ID: %s, e request in ingresso: %v", id, user)
+	log.Infof("Service: Update utente con ID: %s, e request in ingresso: %v", id, user)
+
+	// L'ID è quello della rotta: un eventuale id nel body non deve finire nel $set
+	user.ID = ""
+	user.Email = NormalizeEmail(user.Email)
+	clearUserStatus(&user)
+	// Lo schema serve solo se la richiesta sostituisce gli attributi
+	if user.Attributes != nil {
+		schema, err := loadAttributeSchema(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateUserAttributes(schema, &user, false); err != nil {
+			return nil, err
+		}
+	}
+	if user.Email != "" {
+		// Il controllo usa l'ID della rotta: l'utente può mantenere la propria email
+		candidate := user
+		candidate.ID = id
+		if err := checkEmailConflict(ctx, candidate); err != nil {
+			return nil, err
+		}
+	}
 
-	// Chiama la funzione UpdateUser del repository per aggiornare l'utente nel database
-	// La funzione restituisce un eventuale errore
-	err := repository.UpdateUser(id, user)
+	// Lettura dello stato precedente, aggiornamento ed evento UserUpdated avvengono nella stessa transazione.
+	// L'aggiornamento restituisce direttamente il documento aggiornato, senza una lettura successiva.
+	var updatedUser *models.User
+	err := runUserTransaction(ctx, func(txCtx context.Context) error {
+		before, err := repository.GetUserByID(txCtx, id)
+		if err != nil {
+			return err
+		}
+		updatedUser, err = repository.UpdateUser(txCtx, id, user)
+		if err != nil {
+			return err
+		}
+		return recordUserChange(txCtx, before, updatedUser)
+	})
 	if err != nil {
 		// Se si verifica un errore durante l'aggiornamento dell'utente, registra un messaggio di log e restituisce l'errore
 		log.Errorf("Error updating user by ID: %s, error: %v", id, err)
 		return nil, err
 	}
 
-	// Chiama la funzione GetUserByID del repository per ottenere l'utente aggiornato dal database
-	// La funzione restituisce un puntatore all'utente aggiornato e un eventuale errore
-	updatedUser, err := repository.GetUserByID(id)
-	if err != nil {
-		// Se si verifica un errore durante il recupero dell'utente aggiornato, registra un messaggio di log
-		log.Errorf("Error retrieving updated user by ID: %s, error: %v", id, err)
+	// Restituisce il puntatore all'utente aggiornato
+	return updatedUser, nil
+}
+
+// ErrInvalidPageToken indica un page token non generato da ListUsers
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// ListUsers restituisce una pagina di utenti ordinati per ID e il token della pagina successiva
+// (vuoto se non ce ne sono altre). Il token è opaco per i client.
+func ListUsers(ctx context.Context, pageSize int, pageToken string) ([]models.User, string, error) {
+	afterID := ""
+	if pageToken != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil || !primitive.IsValidObjectID(string(decoded)) {
+			return nil, "", ErrInvalidPageToken
+		}
+		afterID = string(decoded)
 	}
 
-	// Restituisce il puntatore all'utente aggiornato e un eventuale errore
-	return updatedUser, err
+	// Un utente in più indica se esiste una pagina successiva
+	users, err := repository.ListUsersAfter(ctx, afterID, int64(pageSize)+1)
+	if err != nil {
+		utils.WithContext().Errorf("Error listing users: %v", err)
+		return nil, "", err
+	}
+	if len(users) <= pageSize {
+		return users, "", nil
+	}
+	users = users[:pageSize]
+	return users, base64.RawURLEncoding.EncodeToString([]byte(users[pageSize-1].ID)), nil
 }
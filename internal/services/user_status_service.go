@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxStatusReasonLength limita la lunghezza del motivo di un cambio di stato
+const maxStatusReasonLength = 500
+
+var (
+	// ErrInvalidStatusAction indica un'azione sullo stato non supportata
+	ErrInvalidStatusAction = errors.New("unknown status action")
+	// ErrInvalidStatusReason indica un motivo del cambio di stato troppo lungo
+	ErrInvalidStatusReason = fmt.Errorf("reason must be at most %d characters", maxStatusReasonLength)
+	// ErrInvalidStatus indica uno stato utente sconosciuto
+	ErrInvalidStatus = errors.New("status must be one of: pending, active, suspended, deactivated")
+)
+
+// StatusTransitionError indica un'azione non ammessa nello stato attuale dell'utente
+type StatusTransitionError struct {
+	Action string
+	From   string
+}
+
+func (e *StatusTransitionError) Error() string {
+	return fmt.Sprintf("cannot %s a user in status %s", e.Action, e.From)
+}
+
+// statusTransition è l'effetto di un'azione: lo stato di arrivo e gli stati da cui è ammessa
+type statusTransition struct {
+	to   string
+	from []string
+}
+
+// statusTransitions è la macchina a stati del ciclo di vita degli utenti
+var statusTransitions = map[string]statusTransition{
+	constants.USER_ACTION_ACTIVATE: {
+		to:   constants.USER_STATUS_ACTIVE,
+		from: []string{constants.USER_STATUS_PENDING},
+	},
+	constants.USER_ACTION_SUSPEND: {
+		to:   constants.USER_STATUS_SUSPENDED,
+		from: []string{constants.USER_STATUS_ACTIVE},
+	},
+	constants.USER_ACTION_REACTIVATE: {
+		to:   constants.USER_STATUS_ACTIVE,
+		from: []string{constants.USER_STATUS_SUSPENDED, constants.USER_STATUS_DEACTIVATED},
+	},
+	constants.USER_ACTION_DEACTIVATE: {
+		to:   constants.USER_STATUS_DEACTIVATED,
+		from: []string{constants.USER_STATUS_PENDING, constants.USER_STATUS_ACTIVE, constants.USER_STATUS_SUSPENDED},
+	},
+}
+
+// nextStatus restituisce lo stato in cui l'azione porta un utente nello stato current:
+// ErrInvalidStatusAction se l'azione non esiste, *StatusTransitionError se non è ammessa da quello stato
+func nextStatus(action, current string) (string, error) {
+	transition, ok := statusTransitions[action]
+	if !ok {
+		return "", ErrInvalidStatusAction
+	}
+	for _, from := range transition.from {
+		if from == current {
+			return transition.to, nil
+		}
+	}
+	return "", &StatusTransitionError{Action: action, From: current}
+}
+
+// IsUserStatus indica se il valore è uno degli stati del ciclo di vita degli utenti
+func IsUserStatus(status string) bool {
+	switch status {
+	case constants.USER_STATUS_PENDING, constants.USER_STATUS_ACTIVE, constants.USER_STATUS_SUSPENDED, constants.USER_STATUS_DEACTIVATED:
+		return true
+	}
+	return false
+}
+
+// ParseUserStatuses legge una lista di stati separati da virgola (es. il parametro status di GET /users)
+func ParseUserStatuses(value string) ([]string, error) {
+	var statuses []string
+	for _, status := range strings.Split(value, ",") {
+		status = strings.TrimSpace(status)
+		if status == "" {
+			continue
+		}
+		if !IsUserStatus(status) {
+			return nil, ErrInvalidStatus
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// userStatus restituisce lo stato dell'utente; gli utenti creati prima dell'introduzione degli stati sono attivi
+func userStatus(user *models.User) string {
+	if user.Status == "" {
+		return constants.USER_STATUS_ACTIVE
+	}
+	return user.Status
+}
+
+// ValidateInitialStatus verifica lo stato richiesto per un nuovo utente: solo pending o active (o nessuno, cioè active).
+// Gli stati suspended e deactivated si raggiungono solo con ChangeUserStatus, che ne registra il motivo.
+func ValidateInitialStatus(status string) error {
+	switch status {
+	case "", constants.USER_STATUS_PENDING, constants.USER_STATUS_ACTIVE:
+		return nil
+	}
+	return fmt.Errorf("%w: new users must be pending or active", ErrInvalidStatus)
+}
+
+// initUserStatus imposta lo stato di un nuovo utente: quello richiesto (già validato) o active.
+// La data del cambio di stato è quella attuale, salvo per gli utenti importati che la riportano dall'export.
+func initUserStatus(user *models.User, now time.Time) {
+	if user.Status == "" {
+		user.Status = constants.USER_STATUS_ACTIVE
+		user.StatusReason = ""
+		user.StatusChangedAt = nil
+	}
+	if user.StatusChangedAt == nil {
+		changedAt := now.UTC().Truncate(time.Millisecond)
+		user.StatusChangedAt = &changedAt
+	}
+}
+
+// validateImportedStatus verifica lo stato di un utente importato. A differenza delle creazioni è ammesso
+// qualsiasi stato, con motivo e data del cambio, così un export si può reimportare senza riattivare gli utenti
+// sospesi o disattivati.
+func validateImportedStatus(user *models.User) error {
+	if user.Status == "" {
+		user.StatusReason = ""
+		user.StatusChangedAt = nil
+		return nil
+	}
+	if !IsUserStatus(user.Status) {
+		return ErrInvalidStatus
+	}
+	user.StatusReason = strings.TrimSpace(user.StatusReason)
+	if utf8.RuneCountInString(user.StatusReason) > maxStatusReasonLength {
+		return ErrInvalidStatusReason
+	}
+	return nil
+}
+
+// clearUserStatus rimuove i campi di stato da un aggiornamento: lo stato cambia solo con ChangeUserStatus
+func clearUserStatus(user *models.User) {
+	user.Status = ""
+	user.StatusReason = ""
+	user.StatusChangedAt = nil
+}
+
+// ChangeUserStatus applica all'utente un'azione del ciclo di vita (activate, suspend, reactivate, deactivate)
+// registrando motivo e data del cambio. Cambio di stato ed evento UserUpdated avvengono nella stessa transazione;
+// la sospensione e la disattivazione revocano anche i refresh token dell'utente.
+// Errori: ErrInvalidStatusAction, ErrInvalidStatusReason, *StatusTransitionError, mongo.ErrNoDocuments se l'utente non esiste.
+func ChangeUserStatus(ctx context.Context, id, action, reason string) (*models.User, error) {
+	log := utils.WithContext()
+
+	if _, ok := statusTransitions[action]; !ok {
+		return nil, ErrInvalidStatusAction
+	}
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > maxStatusReasonLength {
+		return nil, ErrInvalidStatusReason
+	}
+
+	log.Infof("Azione %s sull'utente %s", action, id)
+	var updated *models.User
+	err := runUserTransaction(ctx, func(txCtx context.Context) error {
+		before, err := repository.GetUserByID(txCtx, id)
+		if err != nil {
+			return err
+		}
+		to, err := nextStatus(action, userStatus(before))
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		updated, err = repository.SetUserStatus(txCtx, id, to, reason, now)
+		if err != nil {
+			return err
+		}
+		if to == constants.USER_STATUS_SUSPENDED || to == constants.USER_STATUS_DEACTIVATED {
+			if err := repository.RevokeUserRefreshTokens(txCtx, id, now); err != nil {
+				return err
+			}
+		}
+		return recordUserChange(txCtx, before, updated)
+	})
+	if err != nil {
+		log.Errorf("Error changing status of user %s: %v", id, err)
+		return nil, err
+	}
+	log.Infof("Utente %s ora in stato %s", id, updated.Status)
+	return updated, nil
+}
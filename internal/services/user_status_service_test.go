@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextStatus(t *testing.T) {
+	const (
+		pending     = constants.USER_STATUS_PENDING
+		active      = constants.USER_STATUS_ACTIVE
+		suspended   = constants.USER_STATUS_SUSPENDED
+		deactivated = constants.USER_STATUS_DEACTIVATED
+	)
+	// Stato di arrivo per ogni azione e stato di partenza; "" indica una transizione non ammessa
+	want := map[string]map[string]string{
+		constants.USER_ACTION_ACTIVATE:   {pending: active, active: "", suspended: "", deactivated: ""},
+		constants.USER_ACTION_SUSPEND:    {pending: "", active: suspended, suspended: "", deactivated: ""},
+		constants.USER_ACTION_REACTIVATE: {pending: "", active: "", suspended: active, deactivated: active},
+		constants.USER_ACTION_DEACTIVATE: {pending: deactivated, active: deactivated, suspended: deactivated, deactivated: ""},
+	}
+	for action, transitions := range want {
+		for from, to := range transitions {
+			got, err := nextStatus(action, from)
+			if to == "" {
+				var transitionErr *StatusTransitionError
+				if !errors.As(err, &transitionErr) || transitionErr.Action != action || transitionErr.From != from {
+					t.Errorf("%s from %s: err %v, want *StatusTransitionError", action, from, err)
+				}
+				continue
+			}
+			if err != nil || got != to {
+				t.Errorf("%s from %s: status %q err %v, want %q", action, from, got, err, to)
+			}
+		}
+	}
+	if _, err := nextStatus("archive", active); !errors.Is(err, ErrInvalidStatusAction) {
+		t.Errorf("unknown action: err %v, want ErrInvalidStatusAction", err)
+	}
+}
+
+func TestChangeUserStatusRejectsInvalidRequests(t *testing.T) {
+	// Azione e motivo sono verificati prima di leggere l'utente
+	if _, err := ChangeUserStatus(context.Background(), "user-1", "archive", ""); !errors.Is(err, ErrInvalidStatusAction) {
+		t.Errorf("unknown action: err %v, want ErrInvalidStatusAction", err)
+	}
+	reason := strings.Repeat("è", maxStatusReasonLength+1)
+	if _, err := ChangeUserStatus(context.Background(), "user-1", constants.USER_ACTION_SUSPEND, reason); !errors.Is(err, ErrInvalidStatusReason) {
+		t.Errorf("long reason: err %v, want ErrInvalidStatusReason", err)
+	}
+}
+
+func TestValidateInitialStatus(t *testing.T) {
+	for status, valid := range map[string]bool{
+		"":                                true,
+		constants.USER_STATUS_PENDING:     true,
+		constants.USER_STATUS_ACTIVE:      true,
+		constants.USER_STATUS_SUSPENDED:   false,
+		constants.USER_STATUS_DEACTIVATED: false,
+		"banned":                          false,
+	} {
+		err := ValidateInitialStatus(status)
+		if valid && err != nil {
+			t.Errorf("%q: unexpected error %v", status, err)
+		}
+		if !valid && !errors.Is(err, ErrInvalidStatus) {
+			t.Errorf("%q: err %v, want ErrInvalidStatus", status, err)
+		}
+	}
+}
+
+func TestInitUserStatus(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	user := models.User{}
+	initUserStatus(&user, now)
+	if user.Status != constants.USER_STATUS_ACTIVE || user.StatusChangedAt == nil || !user.StatusChangedAt.Equal(now.Truncate(time.Millisecond)) {
+		t.Errorf("default status %q changed at %v", user.Status, user.StatusChangedAt)
+	}
+	user = models.User{Status: constants.USER_STATUS_PENDING}
+	initUserStatus(&user, now)
+	if user.Status != constants.USER_STATUS_PENDING {
+		t.Errorf("requested status %q, want pending", user.Status)
+	}
+}
+
+func TestParseUserStatuses(t *testing.T) {
+	statuses, err := ParseUserStatuses(" active, ,suspended")
+	if err != nil || len(statuses) != 2 || statuses[0] != constants.USER_STATUS_ACTIVE || statuses[1] != constants.USER_STATUS_SUSPENDED {
+		t.Errorf("statuses %v err %v", statuses, err)
+	}
+	if _, err := ParseUserStatuses("active,banned"); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("unknown status: err %v, want ErrInvalidStatus", err)
+	}
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"myapp/internal/jsonschema"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxReportedImportErrors = 1000
+
+var (
+	// ErrImportAborted indica che l'import è stato interrotto per un conflitto con la politica "fail"
+	ErrImportAborted = errors.New("import aborted on conflict")
+	// ErrInvalidImport indica che il contenuto da importare non è leggibile nel formato dichiarato
+	ErrInvalidImport = errors.New("invalid import payload")
+	// ErrDuplicateImportID indica un record con lo stesso ID di un record precedente dello stesso file
+	ErrDuplicateImportID = errors.New("duplicate id in the import")
+)
+
+// ExportUsers scrive tutti gli utenti su w nel formato richiesto, leggendoli dal database un batch alla volta
+func ExportUsers(ctx context.Context, w io.Writer, format string) (int, error) {
+	log := utils.WithContext()
+	log.Infof("Export utenti in formato %s", format)
+
+	writer, err := newUserWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = repository.StreamUsers(ctx, func(user models.User) error {
+		count++
+		return writer.WriteUser(user)
+	})
+	if err != nil {
+		log.Errorf("Errore durante l'export: %v", err)
+		return count, err
+	}
+	return count, writer.Close()
+}
+
+// ImportUsers legge gli utenti da r e li scrive in batch applicando la politica di conflitto richiesta.
+// Un conflitto si verifica quando un record specifica l'ID di un utente già esistente.
+// Con la politica "fail" l'import si interrompe al primo conflitto: i batch precedenti restano scritti.
+// Restituisce sempre il report; l'errore è valorizzato se l'input non è leggibile o l'import è stato interrotto.
+func ImportUsers(ctx context.Context, r io.Reader, opts models.ImportOptions) (*models.ImportReport, error) {
+	log := utils.WithContext()
+	log.Infof("Import utenti in formato %s (dryRun: %t, onConflict: %s)", opts.Format, opts.DryRun, opts.OnConflict)
+
+	report := &models.ImportReport{Format: opts.Format, DryRun: opts.DryRun, OnConflict: opts.OnConflict, Errors: []models.ImportError{}}
+
+	reader, err := newUserReader(r, opts.Format)
+	if err != nil {
+		report.Aborted = true
+		return report, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+
+	// Lo schema degli attributi è letto una volta per tutto l'import
+	schema, err := loadAttributeSchema(ctx)
+	if err != nil {
+		report.Aborted = true
+		return report, err
+	}
+
+	batchSize := utils.EnvIntOrDefault("IMPORT_BATCH_SIZE", 500)
+	batch := make([]importRecord, 0, batchSize)
+	// Posizione del primo record di ogni ID: un ID ripetuto nel file è rifiutato, anche in dry-run, invece di dipendere
+	// dal batch in cui cadono i due record (nello stesso batch il secondo inserimento fallirebbe, in batch diversi
+	// il secondo record sarebbe un conflitto con il primo)
+	positions := make(map[string]int)
+	for {
+		user, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		report.Total++
+
+		var recErr *recordError
+		if errors.As(err, &recErr) {
+			report.Invalid++
+			addImportError(report, report.Total, "", recErr)
+			continue
+		}
+		if err != nil {
+			report.Aborted = true
+			return report, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+		}
+
+		if err := validateImportedUser(schema, &user); err != nil {
+			report.Invalid++
+			addImportError(report, report.Total, user.ID, err)
+			continue
+		}
+		if user.ID != "" {
+			if first, seen := positions[user.ID]; seen {
+				report.Invalid++
+				addImportError(report, report.Total, user.ID, fmt.Errorf("%w: first seen at record %d", ErrDuplicateImportID, first))
+				continue
+			}
+			positions[user.ID] = report.Total
+		}
+		report.Valid++
+
+		batch = append(batch, importRecord{position: report.Total, user: user})
+		if len(batch) == batchSize {
+			if err := importBatch(ctx, batch, opts, report); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if err := importBatch(ctx, batch, opts, report); err != nil {
+		return report, err
+	}
+	log.Infof("Import completato: %d inseriti, %d aggiornati, %d saltati, %d non validi", report.Inserted, report.Updated, report.Skipped, report.Invalid)
+	return report, nil
+}
+
+// importRecord associa un utente letto alla sua posizione nel file
+type importRecord struct {
+	position int
+	user     models.User
+}
+
+// importBatch separa i record nuovi da quelli in conflitto e li scrive (salvo dry-run)
+func importBatch(ctx context.Context, batch []importRecord, opts models.ImportOptions, report *models.ImportReport) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(batch))
+	for _, record := range batch {
+		if record.user.ID != "" {
+			ids = append(ids, record.user.ID)
+		}
+	}
+	existing, err := repository.FindExistingUserIDs(ctx, ids)
+	if err != nil {
+		report.Aborted = true
+		return err
+	}
+
+	var inserts, updates []importRecord
+	for _, record := range batch {
+		if record.user.ID == "" || !existing[record.user.ID] {
+			inserts = append(inserts, record)
+			continue
+		}
+		switch opts.OnConflict {
+		case constants.CONFLICT_OVERWRITE:
+			updates = append(updates, record)
+		case constants.CONFLICT_FAIL:
+			report.Failed++
+			addImportError(report, record.position, record.user.ID, errors.New("user already exists"))
+			report.Aborted = true
+			// I record precedenti al conflitto vengono comunque scritti, come in una bulk write ordered
+			if err := writeImport(ctx, inserts, updates, opts, report); err != nil {
+				return err
+			}
+			return ErrImportAborted
+		default:
+			report.Skipped++
+		}
+	}
+	return writeImport(ctx, inserts, updates, opts, report)
+}
+
+// writeImport esegue inserimenti e aggiornamenti non ordinati e aggiorna i contatori del report
+func writeImport(ctx context.Context, inserts, updates []importRecord, opts models.ImportOptions, report *models.ImportReport) error {
+	inserts, updates, err := rejectImportEmailConflicts(ctx, inserts, updates, report)
+	if err != nil {
+		report.Aborted = true
+		return err
+	}
+	if opts.DryRun {
+		report.Inserted += len(inserts)
+		report.Updated += len(updates)
+		return nil
+	}
+
+	if len(inserts) > 0 {
+		_, failures, err := bulkCreateWithEvents(ctx, importUsers(inserts), false)
+		if err != nil {
+			report.Aborted = true
+			return err
+		}
+		report.Inserted += len(inserts) - len(failures)
+		recordImportFailures(report, inserts, failures)
+	}
+	if len(updates) > 0 {
+		failures, err := bulkUpdateWithEvents(ctx, importUsers(updates), false)
+		if err != nil {
+			report.Aborted = true
+			return err
+		}
+		report.Updated += len(updates) - len(failures)
+		recordImportFailures(report, updates, failures)
+	}
+	return nil
+}
+
+// rejectImportEmailConflicts scarta, come falliti, i record la cui email appartiene a un altro utente con una password
+// (vedi EmailConflicts); il controllo vale anche in dry-run
+func rejectImportEmailConflicts(ctx context.Context, inserts, updates []importRecord, report *models.ImportReport) ([]importRecord, []importRecord, error) {
+	conflicts, err := EmailConflicts(ctx, append(importUsers(inserts), importUsers(updates)...))
+	if err != nil || len(conflicts) == 0 {
+		return inserts, updates, err
+	}
+	keep := func(records []importRecord, offset int) []importRecord {
+		kept := make([]importRecord, 0, len(records))
+		for i, record := range records {
+			if err := conflicts[offset+i]; err != nil {
+				report.Failed++
+				addImportError(report, record.position, record.user.ID, err)
+				continue
+			}
+			kept = append(kept, record)
+		}
+		return kept
+	}
+	return keep(inserts, 0), keep(updates, len(inserts)), nil
+}
+
+// validateImportedUser applica la validazione standard, verifica l'eventuale ID e valida gli attributi
+// come in una creazione: ogni record è un utente completo. Lo stato, con motivo e data, è quello dell'export
+// (vedi validateImportedStatus); è applicato solo agli utenti inseriti, perché come negli aggiornamenti
+// un utente sovrascritto conserva il proprio stato.
+func validateImportedUser(schema *jsonschema.Schema, user *models.User) error {
+	user.Email = NormalizeEmail(user.Email)
+	if user.ID != "" {
+		if _, err := primitive.ObjectIDFromHex(user.ID); err != nil {
+			return errors.New("id is not a valid ObjectID")
+		}
+	}
+	if err := ValidateUser(*user); err != nil {
+		return err
+	}
+	if err := validateImportedStatus(user); err != nil {
+		return err
+	}
+	return validateUserAttributes(schema, user, true)
+}
+
+// addImportError aggiunge un errore al report, fino a un massimo di maxReportedImportErrors
+func addImportError(report *models.ImportReport, position int, id string, err error) {
+	if len(report.Errors) >= maxReportedImportErrors {
+		report.ErrorsTruncated = true
+		return
+	}
+	report.Errors = append(report.Errors, models.ImportError{Record: position, ID: id, Error: err.Error()})
+}
+
+// recordImportFailures riporta gli errori di una bulk write sui record corrispondenti
+func recordImportFailures(report *models.ImportReport, records []importRecord, failures map[int]error) {
+	report.Failed += len(failures)
+	for index, err := range failures {
+		addImportError(report, records[index].position, records[index].user.ID, err)
+	}
+}
+
+func importUsers(records []importRecord) []models.User {
+	users := make([]models.User, len(records))
+	for i, record := range records {
+		users[i] = record.user
+	}
+	return users
+}
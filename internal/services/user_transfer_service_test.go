@@ -0,0 +1,145 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestExportImportRoundTripKeepsStatus(t *testing.T) {
+	changedAt := time.Date(2024, 5, 1, 10, 0, 0, 123000000, time.UTC)
+	exported := []models.User{
+		{ID: "6650f1a2b3c4d5e6f7a8b9c0", Name: "Ada", Email: "ada@example.com", Status: constants.USER_STATUS_ACTIVE, StatusChangedAt: &changedAt},
+		{ID: "6650f1a2b3c4d5e6f7a8b9c1", Name: "Bob", Email: "bob@example.com", Status: constants.USER_STATUS_SUSPENDED, StatusReason: "chargeback", StatusChangedAt: &changedAt},
+		{ID: "6650f1a2b3c4d5e6f7a8b9c2", Name: "Eve", Email: "eve@example.com", Status: constants.USER_STATUS_DEACTIVATED, StatusChangedAt: &changedAt},
+	}
+
+	for _, format := range []string{constants.FORMAT_CSV, constants.FORMAT_NDJSON, constants.FORMAT_JSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer, err := newUserWriter(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, user := range exported {
+				if err := writer.WriteUser(user); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := newUserReader(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range exported {
+				user, err := reader.Next()
+				if err != nil {
+					t.Fatalf("record %d: %v", i, err)
+				}
+				if err := validateImportedUser(nil, &user); err != nil {
+					t.Fatalf("record %d: validation: %v", i, err)
+				}
+				initUserStatus(&user, time.Now())
+				if user.Status != want.Status || user.StatusReason != want.StatusReason || user.StatusChangedAt == nil || !user.StatusChangedAt.Equal(changedAt) {
+					t.Errorf("record %d: status %q reason %q changed at %v, want %q %q %v",
+						i, user.Status, user.StatusReason, user.StatusChangedAt, want.Status, want.StatusReason, changedAt)
+				}
+			}
+			if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+				t.Errorf("after the last record: err %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestValidateImportedStatus(t *testing.T) {
+	user := models.User{Name: "Ada", Email: "ada@example.com", Status: "banned"}
+	if err := validateImportedUser(nil, &user); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("unknown status: err %v, want ErrInvalidStatus", err)
+	}
+
+	changedAt := time.Now()
+	user = models.User{Name: "Ada", Email: "ada@example.com", StatusReason: "stale", StatusChangedAt: &changedAt}
+	if err := validateImportedUser(nil, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.StatusReason != "" || user.StatusChangedAt != nil {
+		t.Errorf("record without status kept reason %q and date %v", user.StatusReason, user.StatusChangedAt)
+	}
+}
+
+func TestCSVExportEscapesFormulas(t *testing.T) {
+	names := []string{"=HYPERLINK(\"http://evil\")", "+1", "-2", "@SUM(A1)", "'=quoted", "''@twice", "'plain", "O'Brien", "Ada"}
+
+	var buf bytes.Buffer
+	writer, _ := newUserWriter(&buf, constants.FORMAT_CSV)
+	for _, name := range names {
+		if err := writer.WriteUser(models.User{Name: name, Email: "ada@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer.Close()
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n")[1:] {
+		cells := strings.SplitN(line, ",", 3)
+		name := strings.Trim(cells[1], `"`)
+		if name != "" && strings.ContainsRune("=+-@", rune(name[0])) {
+			t.Errorf("cell %q can be evaluated as a formula", cells[1])
+		}
+	}
+
+	reader, _ := newUserReader(&buf, constants.FORMAT_CSV)
+	for _, want := range names {
+		user, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Name != want {
+			t.Errorf("imported name %q, want %q", user.Name, want)
+		}
+	}
+}
+
+func TestImportRejectsDuplicateIDs(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	users := "myapp." + constants.USERSCOLLECTION
+
+	mt.Run("dry run", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "myapp."+constants.ATTRIBUTESCHEMASCOLLECTION, mtest.FirstBatch), // schema degli attributi
+			mtest.CreateCursorResponse(0, users, mtest.FirstBatch),                                         // ID esistenti
+			mtest.CreateCursorResponse(0, users, mtest.FirstBatch),                                         // email in uso
+			mtest.CreateCursorResponse(0, "myapp."+constants.CREDENTIALSCOLLECTION, mtest.FirstBatch),      // credenziali
+		)
+		input := "id,name,email\n" +
+			"6650f1a2b3c4d5e6f7a8b9c0,Ada,ada@example.com\n" +
+			"6650f1a2b3c4d5e6f7a8b9c1,Bob,bob@example.com\n" +
+			"6650f1a2b3c4d5e6f7a8b9c0,Ada again,ada2@example.com\n"
+
+		report, err := ImportUsers(context.Background(), strings.NewReader(input), models.ImportOptions{
+			Format: constants.FORMAT_CSV, DryRun: true, OnConflict: constants.CONFLICT_SKIP,
+		})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if report.Inserted != 2 || report.Invalid != 1 || len(report.Errors) != 1 {
+			mt.Fatalf("report %+v, want 2 inserted and the repeated ID invalid", report)
+		}
+		if e := report.Errors[0]; e.Record != 3 || !strings.Contains(e.Error, "first seen at record 1") {
+			mt.Errorf("error %+v, want record 3 pointing to record 1", e)
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"errors"
+	"myapp/internal/models"
+	"net/mail"
+	"strings"
+)
+
+// ValidateUser verifica che l'utente abbia un nome, un indirizzo email valido e, se indicato, uno stato esistente
+func ValidateUser(user models.User) error {
+	if strings.TrimSpace(user.Name) == "" {
+		return errors.New("name is required")
+	}
+	if strings.TrimSpace(user.Email) == "" {
+		return errors.New("email is required")
+	}
+	address, err := mail.ParseAddress(user.Email)
+	if err != nil || address.Address != user.Email {
+		return errors.New("email is not valid")
+	}
+	if user.Status != "" && !IsUserStatus(user.Status) {
+		return ErrInvalidStatus
+	}
+	return nil
+}
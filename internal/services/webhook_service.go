@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"myapp/internal/webhooks"
+	"net/url"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// minSecretLength è la lunghezza minima di un secret scelto dal client
+const minSecretLength = 16
+
+var (
+	// ErrInvalidWebhook indica una sottoscrizione non valida (URL, tipi di evento o secret)
+	ErrInvalidWebhook = errors.New("invalid webhook subscription")
+	// ErrWebhookNotFound indica che la sottoscrizione non esiste
+	ErrWebhookNotFound = errors.New("webhook subscription not found")
+	// ErrDeliveryNotFound indica che la consegna non esiste o non è ancora conclusa
+	ErrDeliveryNotFound = errors.New("webhook delivery not found or still in progress")
+)
+
+// webhookEventTypes sono i tipi di evento a cui ci si può sottoscrivere
+var webhookEventTypes = []string{constants.EVENT_USER_CREATED, constants.EVENT_USER_UPDATED, constants.EVENT_USER_DELETED}
+
+// CreateWebhook registra una nuova sottoscrizione. Il secret (generato se non indicato) è restituito solo qui.
+func CreateWebhook(ctx context.Context, req models.WebhookSubscriptionRequest) (*models.WebhookSubscription, error) {
+	log := utils.WithContext()
+
+	subscription, err := webhookFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Secret == "" {
+		if subscription.Secret, err = generateWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+	if subscription.ID, err = utils.GenerateUUID(); err != nil {
+		return nil, err
+	}
+	subscription.CreatedAt = time.Now().UTC()
+	subscription.UpdatedAt = subscription.CreatedAt
+
+	if err := repository.InsertWebhook(ctx, subscription); err != nil {
+		return nil, err
+	}
+	log.Infof("Webhook %s creato per %s", subscription.ID, subscription.URL)
+	return &subscription, nil
+}
+
+// GetWebhooks restituisce tutte le sottoscrizioni, senza secret
+func GetWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	subscriptions, err := repository.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	return subscriptions, nil
+}
+
+// GetWebhookByID restituisce una sottoscrizione, senza secret
+func GetWebhookByID(ctx context.Context, id string) (*models.WebhookSubscription, error) {
+	subscription, err := repository.GetWebhookByID(ctx, id)
+	if err != nil {
+		return nil, webhookError(err)
+	}
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+// UpdateWebhook sostituisce URL, tipi di evento, descrizione e stato di una sottoscrizione.
+// Il secret viene ruotato solo se indicato nella richiesta, ed è restituito solo in quel caso.
+func UpdateWebhook(ctx context.Context, id string, req models.WebhookSubscriptionRequest) (*models.WebhookSubscription, error) {
+	log := utils.WithContext()
+
+	existing, err := repository.GetWebhookByID(ctx, id)
+	if err != nil {
+		return nil, webhookError(err)
+	}
+	subscription, err := webhookFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	rotated := subscription.Secret != ""
+	if !rotated {
+		subscription.Secret = existing.Secret
+	}
+	subscription.ID = existing.ID
+	subscription.CreatedAt = existing.CreatedAt
+	subscription.UpdatedAt = time.Now().UTC()
+
+	if err := repository.ReplaceWebhook(ctx, subscription); err != nil {
+		return nil, webhookError(err)
+	}
+	log.Infof("Webhook %s aggiornato", id)
+	if !rotated {
+		subscription.Secret = ""
+	}
+	return &subscription, nil
+}
+
+// DeleteWebhookByID elimina una sottoscrizione e il suo storico di consegne
+func DeleteWebhookByID(ctx context.Context, id string) error {
+	utils.WithContext().Infof("Cancello webhook con Id: %s", id)
+	return webhookError(repository.DeleteWebhookByID(ctx, id))
+}
+
+// GetWebhookDeliveries restituisce lo storico delle consegne di una sottoscrizione, eventualmente filtrato per stato
+func GetWebhookDeliveries(ctx context.Context, id, status string, page, pageSize int) (*models.WebhookDeliveryPage, error) {
+	if _, err := repository.GetWebhookByID(ctx, id); err != nil {
+		return nil, webhookError(err)
+	}
+	deliveries, total, err := repository.GetWebhookDeliveries(ctx, id, status, int64((page-1)*pageSize), int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+	return &models.WebhookDeliveryPage{Page: page, PageSize: pageSize, Total: total, Deliveries: deliveries}, nil
+}
+
+// RedeliverWebhookDelivery rimette in coda una consegna conclusa, tipicamente dalla dead-letter list
+func RedeliverWebhookDelivery(ctx context.Context, id, deliveryID string) (*models.WebhookDelivery, error) {
+	delivery, err := repository.RequeueWebhookDelivery(ctx, id, deliveryID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	utils.WithContext().Infof("Consegna %s del webhook %s rimessa in coda", deliveryID, id)
+	return delivery, nil
+}
+
+// webhookFromRequest valida la richiesta e la converte in una sottoscrizione (senza ID e date)
+func webhookFromRequest(req models.WebhookSubscriptionRequest) (models.WebhookSubscription, error) {
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return models.WebhookSubscription{}, fmt.Errorf("%w: url must be an absolute http(s) URL", ErrInvalidWebhook)
+	}
+	// Le consegne verso la rete interna sono bloccate anche alla connessione (webhooks.NewClient), dove i nomi DNS sono risolti
+	if !webhooks.AllowPrivateTargets() && webhooks.ValidateTargetHost(target.Hostname()) != nil {
+		return models.WebhookSubscription{}, fmt.Errorf("%w: url must not point to a loopback, private or link-local address", ErrInvalidWebhook)
+	}
+
+	eventTypes := []string{}
+	for _, eventType := range req.EventTypes {
+		if !slices.Contains(webhookEventTypes, eventType) {
+			return models.WebhookSubscription{}, fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, eventType)
+		}
+		if !slices.Contains(eventTypes, eventType) {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+
+	if req.Secret != "" && len(req.Secret) < minSecretLength {
+		return models.WebhookSubscription{}, fmt.Errorf("%w: secret must be at least %d characters", ErrInvalidWebhook, minSecretLength)
+	}
+
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+	return models.WebhookSubscription{
+		URL:         target.String(),
+		EventTypes:  eventTypes,
+		Secret:      req.Secret,
+		Description: req.Description,
+		Active:      active,
+	}, nil
+}
+
+// generateWebhookSecret genera un secret casuale di 256 bit
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// webhookError converte l'assenza del documento in ErrWebhookNotFound
+func webhookError(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrWebhookNotFound
+	}
+	return err
+}
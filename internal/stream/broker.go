@@ -0,0 +1,142 @@
+package stream
+
+import (
+	"myapp/internal/models"
+	"myapp/internal/utils"
+	"sync"
+)
+
+var (
+	brokerInstance *Broker
+	once           sync.Once
+)
+
+// GetBroker ritorna l'istanza singleton del broker delle notifiche sugli utenti
+func GetBroker() *Broker {
+	once.Do(func() {
+		brokerInstance = NewBroker(
+			utils.EnvIntOrDefault("USER_EVENTS_BUFFER", 1000),
+			utils.EnvIntOrDefault("USER_EVENTS_SUBSCRIBER_BUFFER", 64),
+		)
+	})
+	return brokerInstance
+}
+
+// Message è un evento pubblicato sul broker con il suo numero di sequenza, usato come id SSE
+type Message struct {
+	ID    uint64
+	Event models.UserEvent
+}
+
+// Subscriber riceve i messaggi pubblicati dopo la sottoscrizione.
+// Il canale C viene chiuso quando il subscriber viene rimosso, anche perché troppo lento:
+// in quel caso il client può riconnettersi e recuperare i messaggi persi dal buffer di replay.
+type Subscriber struct {
+	C      chan Message
+	tenant string
+	userID string
+}
+
+// Broker distribuisce in processo le notifiche sulle modifiche agli utenti ai client degli stream SSE e gRPC.
+// È alimentato dal change stream dell'outbox (services.RunUserEventFeed), quindi riceve le modifiche di tutte le repliche.
+// Conserva gli ultimi messaggi in un buffer circolare per permettere la ripresa dopo una disconnessione.
+type Broker struct {
+	mu               sync.Mutex
+	buffer           []Message // buffer circolare degli ultimi messaggi
+	start            int       // posizione del messaggio più vecchio in buffer
+	size             int       // messaggi presenti in buffer
+	lastID           uint64
+	subscriberBuffer int
+	subscribers      map[*Subscriber]struct{}
+}
+
+// NewBroker crea un broker che conserva fino a replaySize messaggi per la ripresa
+func NewBroker(replaySize, subscriberBuffer int) *Broker {
+	return &Broker{
+		buffer:           make([]Message, max(replaySize, 1)),
+		subscriberBuffer: subscriberBuffer,
+		subscribers:      make(map[*Subscriber]struct{}),
+	}
+}
+
+// Publish assegna all'evento il prossimo numero di sequenza e lo invia ai subscriber interessati.
+// Non blocca mai: i subscriber che non riescono a tenere il passo vengono rimossi.
+func (b *Broker) Publish(event models.UserEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	message := Message{ID: b.lastID, Event: event}
+	if b.size < len(b.buffer) {
+		b.buffer[(b.start+b.size)%len(b.buffer)] = message
+		b.size++
+	} else {
+		b.buffer[b.start] = message
+		b.start = (b.start + 1) % len(b.buffer)
+	}
+
+	for subscriber := range b.subscribers {
+		if !subscriber.matches(message) {
+			continue
+		}
+		select {
+		case subscriber.C <- message:
+		default:
+			utils.WithContext().WithField("package", "stream").Warn("Dropping slow user events subscriber")
+			b.remove(subscriber)
+		}
+	}
+}
+
+// Subscribe registra un subscriber che riceve solo gli eventi del tenant indicato (vuoto con TENANCY_MODE=off),
+// opzionalmente filtrati per ID utente.
+// Se resume è true restituisce anche i messaggi successivi a lastEventID ancora presenti nel buffer;
+// complete è false se alcuni di quei messaggi non sono più disponibili (buffer superato o riavvio del servizio).
+func (b *Broker) Subscribe(tenant, userID string, lastEventID uint64, resume bool) (subscriber *Subscriber, replay []Message, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscriber = &Subscriber{C: make(chan Message, b.subscriberBuffer), tenant: tenant, userID: userID}
+	b.subscribers[subscriber] = struct{}{}
+
+	if !resume {
+		return subscriber, nil, true
+	}
+
+	oldest := b.lastID - uint64(b.size) + 1
+	complete = lastEventID <= b.lastID && lastEventID+1 >= oldest
+	for i := 0; i < b.size; i++ {
+		message := b.buffer[(b.start+i)%len(b.buffer)]
+		if message.ID > lastEventID && subscriber.matches(message) {
+			replay = append(replay, message)
+		}
+	}
+	return subscriber, replay, complete
+}
+
+// Unsubscribe rimuove il subscriber; può essere chiamata più volte
+func (b *Broker) Unsubscribe(subscriber *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(subscriber)
+}
+
+// Subscribers restituisce il numero di subscriber connessi
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// remove elimina il subscriber e chiude il suo canale; va chiamata con il lock acquisito
+func (b *Broker) remove(subscriber *Subscriber) {
+	if _, ok := b.subscribers[subscriber]; ok {
+		delete(b.subscribers, subscriber)
+		close(subscriber.C)
+	}
+}
+
+// matches indica se il messaggio riguarda il tenant e l'utente filtrati dal subscriber
+func (s *Subscriber) matches(message Message) bool {
+	return s.tenant == message.Event.TenantID && (s.userID == "" || s.userID == message.Event.UserID)
+}
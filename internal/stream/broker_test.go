@@ -0,0 +1,115 @@
+package stream
+
+import (
+	"myapp/internal/models"
+	"testing"
+)
+
+func event(tenant, userID string) models.UserEvent {
+	return models.UserEvent{TenantID: tenant, UserID: userID}
+}
+
+// ids restituisce i numeri di sequenza dei messaggi
+func ids(messages []Message) []uint64 {
+	result := make([]uint64, len(messages))
+	for i, message := range messages {
+		result[i] = message.ID
+	}
+	return result
+}
+
+func equalIDs(got []uint64, want ...uint64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBrokerReplaysMissedMessages(t *testing.T) {
+	broker := NewBroker(3, 10)
+	for i := 0; i < 3; i++ {
+		broker.Publish(event("", "u1"))
+	}
+
+	_, replay, complete := broker.Subscribe("", "", 1, true)
+	if !complete || !equalIDs(ids(replay), 2, 3) {
+		t.Errorf("resume after 1: replay %v complete %v, want [2 3] true", ids(replay), complete)
+	}
+	_, replay, complete = broker.Subscribe("", "", 3, true)
+	if !complete || len(replay) != 0 {
+		t.Errorf("resume after the last message: replay %v complete %v", ids(replay), complete)
+	}
+	_, replay, complete = broker.Subscribe("", "", 0, false)
+	if !complete || replay != nil {
+		t.Errorf("new subscription: replay %v complete %v, want no replay", ids(replay), complete)
+	}
+}
+
+func TestBrokerReplayRingOverwritesTheOldest(t *testing.T) {
+	broker := NewBroker(3, 10)
+	for i := 0; i < 5; i++ {
+		broker.Publish(event("", "u1"))
+	}
+
+	// Il buffer contiene 3, 4 e 5: chi ha ricevuto fino a 2 non ha perso nulla
+	_, replay, complete := broker.Subscribe("", "", 2, true)
+	if !complete || !equalIDs(ids(replay), 3, 4, 5) {
+		t.Errorf("resume after 2: replay %v complete %v, want [3 4 5] true", ids(replay), complete)
+	}
+	// Chi ha ricevuto fino a 1 ha perso il messaggio 2, ormai sovrascritto
+	_, replay, complete = broker.Subscribe("", "", 1, true)
+	if complete || !equalIDs(ids(replay), 3, 4, 5) {
+		t.Errorf("resume after 1: replay %v complete %v, want [3 4 5] false", ids(replay), complete)
+	}
+	// Un ID successivo all'ultimo viene da un'altra istanza o da prima di un riavvio
+	_, replay, complete = broker.Subscribe("", "", 9, true)
+	if complete || len(replay) != 0 {
+		t.Errorf("resume after an unknown ID: replay %v complete %v, want none and incomplete", ids(replay), complete)
+	}
+}
+
+func TestBrokerFiltersByTenantAndUser(t *testing.T) {
+	broker := NewBroker(10, 10)
+	acme, _, _ := broker.Subscribe("acme", "", 0, false)
+	user, _, _ := broker.Subscribe("acme", "u2", 0, false)
+
+	broker.Publish(event("acme", "u1"))
+	broker.Publish(event("globex", "u2"))
+	broker.Publish(event("acme", "u2"))
+
+	if got := len(acme.C); got != 2 {
+		t.Errorf("tenant subscriber received %d messages, want 2", got)
+	}
+	if got := len(user.C); got != 1 {
+		t.Fatalf("user subscriber received %d messages, want 1", got)
+	}
+	if message := <-user.C; message.ID != 3 {
+		t.Errorf("user subscriber received message %d, want 3", message.ID)
+	}
+
+	_, replay, _ := broker.Subscribe("globex", "", 0, true)
+	if !equalIDs(ids(replay), 2) {
+		t.Errorf("globex replay %v, want only its own message", ids(replay))
+	}
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	broker := NewBroker(10, 1)
+	slow, _, _ := broker.Subscribe("", "", 0, false)
+
+	broker.Publish(event("", "u1"))
+	broker.Publish(event("", "u1"))
+	if broker.Subscribers() != 0 {
+		t.Fatalf("%d subscribers, want the slow one removed", broker.Subscribers())
+	}
+	<-slow.C
+	if _, open := <-slow.C; open {
+		t.Error("the channel of a removed subscriber is still open")
+	}
+	broker.Unsubscribe(slow) // già rimosso: non deve chiudere di nuovo il canale
+}
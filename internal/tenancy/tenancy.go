@@ -0,0 +1,150 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/internal/utils"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Modalità di isolamento dei tenant (TENANCY_MODE)
+const (
+	ModeOff        = "off"        // servizio single-tenant, nessuno scoping
+	ModeShared     = "shared"     // collezioni condivise, documenti separati dal campo tenantId
+	ModeCollection = "collection" // una collezione per tenant (es. users_acme)
+	ModeDatabase   = "database"   // un database per tenant (es. myapp_acme)
+)
+
+// Field è il campo che identifica il tenant nei documenti delle collezioni condivise
+const Field = "tenantId"
+
+var (
+	// ErrTenantRequired indica un'operazione su dati di un tenant senza tenant nel contesto
+	ErrTenantRequired = errors.New("tenant required")
+	// ErrInvalidTenant indica un identificativo di tenant non valido
+	ErrInvalidTenant = errors.New("invalid tenant")
+	// ErrUnknownTenant indica un tenant valido ma non registrato in TENANT_ALLOWLIST
+	ErrUnknownTenant = errors.New("unknown tenant")
+
+	// tenantPattern limita gli ID a caratteri validi nei nomi di collezioni e database MongoDB.
+	// Solo minuscole: i nomi dei database non distinguono maiuscole e minuscole su tutti i sistemi.
+	tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,39}$`)
+
+	mode     string
+	modeOnce sync.Once
+
+	allowlist     map[string]bool
+	allowlistOnce sync.Once
+)
+
+// tenantKey è la chiave del contesto con il tenant della richiesta
+type tenantKey struct{}
+
+// Mode restituisce la modalità di isolamento configurata con TENANCY_MODE (default off)
+func Mode() string {
+	modeOnce.Do(func() {
+		mode = utils.EnvOrDefault("TENANCY_MODE", ModeOff)
+		switch mode {
+		case ModeOff, ModeShared, ModeCollection, ModeDatabase:
+		default:
+			utils.WithContext().WithField("package", "tenancy").Fatalf("Invalid TENANCY_MODE %q (off, shared, collection or database)", mode)
+		}
+	})
+	return mode
+}
+
+// Enabled indica se il servizio è multi-tenant
+func Enabled() bool {
+	return Mode() != ModeOff
+}
+
+// Validate verifica che l'ID del tenant sia utilizzabile come suffisso di collezioni e database
+func Validate(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// Allowlist restituisce i tenant registrati dall'amministratore con TENANT_ALLOWLIST (ID separati da virgole),
+// obbligatoria con la tenancy abilitata. Solo questi tenant hanno dati: una richiesta per un altro tenant
+// non deve creare collezioni, database o indici.
+func Allowlist() []string {
+	allowlistOnce.Do(func() {
+		var err error
+		allowlist, err = parseAllowlist(utils.EnvOrDefault("TENANT_ALLOWLIST", ""))
+		if err == nil && Enabled() && len(allowlist) == 0 {
+			err = errors.New("TENANT_ALLOWLIST is required when TENANCY_MODE is not off")
+		}
+		if err != nil {
+			utils.WithContext().WithField("package", "tenancy").Fatalf("Invalid TENANT_ALLOWLIST: %v", err)
+		}
+	})
+	tenants := make([]string, 0, len(allowlist))
+	for tenant := range allowlist {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// Allowed indica se il tenant è registrato in TENANT_ALLOWLIST; ErrUnknownTenant altrimenti
+func Allowed(tenant string) error {
+	Allowlist()
+	if !allowlist[tenant] {
+		return ErrUnknownTenant
+	}
+	return nil
+}
+
+// parseAllowlist legge un elenco di tenant separati da virgole, validandone gli ID
+func parseAllowlist(value string) (map[string]bool, error) {
+	tenants := make(map[string]bool)
+	for _, tenant := range strings.Split(value, ",") {
+		tenant = strings.ToLower(strings.TrimSpace(tenant))
+		if tenant == "" {
+			continue
+		}
+		if err := Validate(tenant); err != nil {
+			return nil, fmt.Errorf("%w: %q", err, tenant)
+		}
+		tenants[tenant] = true
+	}
+	return tenants, nil
+}
+
+// WithTenant restituisce un contesto con il tenant indicato
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// FromContext recupera il tenant dal contesto
+func FromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// Require restituisce il tenant del contesto: vuoto se la tenancy è disabilitata,
+// ErrTenantRequired se è abilitata e il contesto non indica un tenant
+func Require(ctx context.Context) (string, error) {
+	if !Enabled() {
+		return "", nil
+	}
+	tenant, ok := FromContext(ctx)
+	if !ok {
+		return "", ErrTenantRequired
+	}
+	return tenant, nil
+}
+
+// Key qualifica una chiave di cache o di deduplicazione con il tenant, così chiavi uguali di tenant diversi non collidono
+func Key(tenant, key string) string {
+	if tenant == "" {
+		return key
+	}
+	return tenant + "/" + key
+}
@@ -0,0 +1,281 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"myapp/internal/utils"
+	"os"
+	"sync"
+	"time"
+)
+
+// Modalità di verifica dei certificati client (TLS_CLIENT_AUTH)
+const (
+	ClientAuthRequire  = "require"  // connessioni senza un certificato client valido rifiutate
+	ClientAuthOptional = "optional" // il certificato, se presentato, deve essere valido
+)
+
+// ErrNoCertificates indica un file di CA senza certificati PEM
+var ErrNoCertificates = errors.New("no PEM certificates found")
+
+// fileStamp identifica la versione di un file per rilevarne le modifiche
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader mantiene il certificato del server e le CA dei client letti da file e li ricarica quando cambiano,
+// così il rinnovo dei certificati (es. cert-manager, Let's Encrypt) non richiede un riavvio.
+// Un caricamento fallito (es. certificato già aggiornato e chiave non ancora) mantiene i valori precedenti.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamps    map[string]fileStamp
+}
+
+// NewReloader carica certificato, chiave e, se indicato, il bundle delle CA dei client
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files restituisce i file osservati
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// Reload rilegge i file e sostituisce certificato e CA solo se tutti sono validi
+func (r *Reloader) Reload() error {
+	stamps := make(map[string]fileStamp)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate %s: %w", r.certFile, err)
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("loading client CA %s: %w", r.clientCAFile, ErrNoCertificates)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.clientCAs, r.stamps = &cert, clientCAs, stamps
+	r.mu.Unlock()
+	return nil
+}
+
+// changed indica se uno dei file è stato modificato dall'ultimo caricamento riuscito
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			// File temporaneamente assente durante la sostituzione: si riprova al prossimo controllo
+			continue
+		}
+		if stamp := r.stamps[file]; !info.ModTime().Equal(stamp.modTime) || info.Size() != stamp.size {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch controlla i file ogni interval e li ricarica quando cambiano, fino alla cancellazione del contesto
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	log := utils.WithContext().WithField("function", "Reloader.Watch")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Errorf("Error reloading TLS certificates, keeping the previous ones: %v", err)
+				continue
+			}
+			log.Infof("TLS certificates reloaded from %s", r.certFile)
+		}
+	}
+}
+
+// GetCertificate restituisce il certificato corrente (tls.Config.GetCertificate)
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ClientCAs restituisce le CA correnti dei certificati client
+func (r *Reloader) ClientCAs() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.clientCAs
+}
+
+// ServerConfigFromEnv crea la configurazione TLS del server HTTP dalle variabili d'ambiente:
+// TLS_CERT_FILE e TLS_KEY_FILE abilitano HTTPS (nil se non impostati), TLS_CLIENT_CA_FILE abilita il mutual TLS
+// con i certificati client firmati da quelle CA (TLS_CLIENT_AUTH require o optional), TLS_MIN_VERSION (1.2 o 1.3).
+// Il Reloader restituito va avviato con Watch per ricaricare i file modificati.
+func ServerConfigFromEnv() (*tls.Config, *Reloader, error) {
+	certFile := utils.EnvOrDefault("TLS_CERT_FILE", "")
+	keyFile := utils.EnvOrDefault("TLS_KEY_FILE", "")
+	if certFile == "" && keyFile == "" {
+		return nil, nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	clientCAFile := utils.EnvOrDefault("TLS_CLIENT_CA_FILE", "")
+
+	minVersion, err := parseVersion(utils.EnvOrDefault("TLS_MIN_VERSION", "1.2"))
+	if err != nil {
+		return nil, nil, err
+	}
+	reloader, err := NewReloader(certFile, keyFile, clientCAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: reloader.GetCertificate,
+		// Elencati esplicitamente: la configurazione restituita da GetConfigForClient non passa da http.Server,
+		// che altrimenti aggiungerebbe h2
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if clientCAFile == "" {
+		return config, reloader, nil
+	}
+
+	switch clientAuth := utils.EnvOrDefault("TLS_CLIENT_AUTH", ClientAuthRequire); clientAuth {
+	case ClientAuthRequire:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthOptional:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, nil, fmt.Errorf("invalid TLS_CLIENT_AUTH %q (require or optional)", clientAuth)
+	}
+	// Le CA dei client sono lette a ogni handshake, così anche il loro rinnovo non richiede un riavvio
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		handshake := config.Clone()
+		handshake.GetConfigForClient = nil
+		handshake.ClientCAs = reloader.ClientCAs()
+		return handshake, nil
+	}
+	return config, reloader, nil
+}
+
+// ClientConfig crea la configurazione TLS di un client (es. myctl): caFile sostituisce le CA di sistema
+// per verificare il server, certFile e keyFile sono il certificato client per il mutual TLS. nil se nessuno è indicato.
+func ClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("loading CA %s: %w", caFile, ErrNoCertificates)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// parseVersion converte la versione minima di TLS
+func parseVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("invalid TLS_MIN_VERSION %q (1.2 or 1.3)", version)
+	}
+}
+
+// Campi del certificato client usati come identità (TLS_CLIENT_IDENTITY)
+const (
+	IdentityCN    = "cn"    // Common Name del subject
+	IdentityDN    = "dn"    // subject completo (es. CN=billing,OU=batch,O=Example)
+	IdentityEmail = "email" // primo indirizzo email dei Subject Alternative Name
+	IdentityURI   = "uri"   // primo URI dei Subject Alternative Name (es. SPIFFE ID)
+	IdentityDNS   = "dns"   // primo nome DNS dei Subject Alternative Name
+)
+
+// ValidIdentity indica se il campo dell'identità è supportato
+func ValidIdentity(source string) bool {
+	switch source {
+	case IdentityCN, IdentityDN, IdentityEmail, IdentityURI, IdentityDNS:
+		return true
+	default:
+		return false
+	}
+}
+
+// Identity restituisce l'identità del certificato client secondo il campo indicato ("" se il certificato non lo contiene)
+func Identity(cert *x509.Certificate, source string) string {
+	switch source {
+	case IdentityCN:
+		return cert.Subject.CommonName
+	case IdentityDN:
+		return cert.Subject.String()
+	case IdentityEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case IdentityURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case IdentityDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	}
+	return ""
+}
@@ -10,16 +10,201 @@ const (
 
 // Routes
 const (
-	USERS = "/users"
-	BLANK = ""
-	ID    = "/{id}"
+	USERS        = "/users"
+	BLANK        = ""
+	ID           = "/{id}"
+	BATCH_CREATE = ":batchCreate"
+	BATCH_UPDATE = ":batchUpdate"
+	BATCH_DELETE = ":batchDelete"
+	EXPORT       = "/export"
+	IMPORT       = "/import"
+	SEARCH       = "/search"
+	EVENTS       = "/events"
+	HISTORY      = "/{id}/history"
+
+	WEBHOOKS           = "/webhooks"
+	DELIVERIES         = "/{id}/deliveries"
+	DELIVERY_REDELIVER = "/{id}/deliveries/{deliveryId}/redeliver"
+
+	GRAPHQL = "/graphql"
+	HEALTH  = "/health"
+
+	ADMIN                     = "/admin"
+	AUDIT                     = "/audit"
+	API_KEYS                  = "/api-keys"
+	USER_ATTRIBUTES_SCHEMA    = "/user-attributes/schema"
+	USER_ATTRIBUTES_SCHEMAS   = "/user-attributes/schemas"
+	USER_ATTRIBUTES_NAMESPACE = "/user-attributes/schemas/{namespace}"
+
+	AUTH     = "/auth"
+	LOGIN    = "/login"
+	REFRESH  = "/refresh"
+	LOGOUT   = "/logout"
+	PASSWORD = "/{id}/password"
+
+	STATUS_ACTION = "/{id:[^/:]+}:{action}"
+
+	GROUPS      = "/groups"
+	MEMBERS     = "/{id}/members"
+	MEMBER      = "/{id}/members/{userId}"
+	USER_GROUPS = "/{id}/groups"
+
+	EMAIL_VERIFICATION         = "/email-verification"
+	EMAIL_VERIFICATION_CONFIRM = "/email-verification/confirm"
+	PASSWORD_RESET             = "/password-reset"
+	PASSWORD_RESET_CONFIRM     = "/password-reset/confirm"
+)
+
+// Versioni dell'API: prefisso del path e media type application/vnd.myapp.<versione>+json
+const (
+	API_V1             = "/v1"
+	API_VERSION_V1     = "v1"
+	API_UNVERSIONED    = "unversioned"
+	API_VERSION_HEADER = "API-Version"
+)
+
+// Modalità di ricerca utenti
+const (
+	SEARCH_MODE_TEXT   = "text"   // indice full-text di MongoDB, con punteggio di rilevanza
+	SEARCH_MODE_PREFIX = "prefix" // regex case-insensitive sull'inizio delle parole, non richiede indici
+)
+
+// Stati del ciclo di vita degli utenti
+const (
+	USER_STATUS_PENDING     = "pending"     // in attesa di attivazione (es. verifica dell'email)
+	USER_STATUS_ACTIVE      = "active"      // stato iniziale di default
+	USER_STATUS_SUSPENDED   = "suspended"   // sospeso da un amministratore
+	USER_STATUS_DEACTIVATED = "deactivated" // account chiuso
+)
+
+// Azioni che modificano lo stato degli utenti (POST /users/{id}:<azione>)
+const (
+	USER_ACTION_ACTIVATE   = "activate"
+	USER_ACTION_SUSPEND    = "suspend"
+	USER_ACTION_REACTIVATE = "reactivate"
+	USER_ACTION_DEACTIVATE = "deactivate"
+)
+
+// Attributi personalizzati degli utenti: campo del documento e prefisso dei filtri di GET /users (?attributes.team=core)
+const (
+	USER_ATTRIBUTES_FIELD        = "attributes"
+	USER_ATTRIBUTES_QUERY_PREFIX = "attributes."
+	DEFAULT_ATTRIBUTE_NAMESPACE  = "default" // namespace di /admin/user-attributes/schema
+)
+
+// Ruoli dei membri di un gruppo
+const (
+	GROUP_ROLE_OWNER  = "owner"
+	GROUP_ROLE_ADMIN  = "admin"
+	GROUP_ROLE_MEMBER = "member" // ruolo di default
+)
+
+// Campi di ordinamento degli utenti
+const (
+	SORT_FIELD_ID    = "id"
+	SORT_FIELD_NAME  = "name"
+	SORT_FIELD_EMAIL = "email"
+)
+
+// Formati di import/export
+const (
+	FORMAT_CSV    = "csv"
+	FORMAT_NDJSON = "ndjson"
+	FORMAT_JSON   = "json"
+
+	CONTENT_TYPE_CSV    = "text/csv"
+	CONTENT_TYPE_NDJSON = "application/x-ndjson"
+	CONTENT_TYPE_JSON   = "application/json"
+)
+
+// Politiche di conflitto dell'import
+const (
+	CONFLICT_SKIP      = "skip"
+	CONFLICT_OVERWRITE = "overwrite"
+	CONFLICT_FAIL      = "fail"
 )
 
 // mongodb
 const (
-	USERSCOLLECTION = "users"
-	DOCUMENT_ID     = "_id"
-	SET             = "$set"
+	USERSCOLLECTION            = "users"
+	IDEMPOTENCYCOLLECTION      = "idempotency_keys"
+	OUTBOXCOLLECTION           = "outbox"
+	WEBHOOKSCOLLECTION         = "webhooks"
+	DELIVERIESCOLLECTION       = "webhook_deliveries"
+	MIGRATIONSCOLLECTION       = "schema_migrations"
+	MIGRATIONLOCKSCOLLECTION   = "schema_migrations_lock"
+	AUDITCOLLECTION            = "user_audit"
+	APIKEYSCOLLECTION          = "api_keys"
+	CREDENTIALSCOLLECTION      = "user_credentials"
+	REFRESHTOKENSCOLLECTION    = "refresh_tokens"
+	EMAILTOKENSCOLLECTION      = "email_tokens"
+	GROUPSCOLLECTION           = "groups"
+	GROUPMEMBERSCOLLECTION     = "group_members"
+	ATTRIBUTESCHEMASCOLLECTION = "user_attribute_schemas"
+	DOCUMENT_ID                = "_id"
+	SET                        = "$set"
+)
+
+// Idempotency
+const (
+	IDEMPOTENCY_KEY_HEADER      = "Idempotency-Key"
+	IDEMPOTENCY_REPLAYED_HEADER = "Idempotent-Replayed"
+	IDEMPOTENCY_IN_PROGRESS     = "in_progress"
+	IDEMPOTENCY_COMPLETED       = "completed"
+)
+
+// Audit log
+const (
+	ACTOR_HEADER = "X-Actor"
+)
+
+// API key: header dedicato e schema dell'header Authorization (Authorization: ApiKey <chiave>)
+const (
+	API_KEY_HEADER = "X-API-Key"
+	API_KEY_SCHEME = "ApiKey"
+)
+
+// Access token degli utenti: schema dell'header Authorization (Authorization: Bearer <token>)
+const (
+	BEARER_SCHEME = "Bearer"
+)
+
+// Multi-tenancy: sorgenti del tenant (TENANT_SOURCES) e header di default
+const (
+	TENANT_HEADER           = "X-Tenant-ID"
+	TENANT_SOURCE_HEADER    = "header"
+	TENANT_SOURCE_JWT       = "jwt"
+	TENANT_SOURCE_SUBDOMAIN = "subdomain"
+)
+
+// Eventi di dominio
+const (
+	EVENT_USER_CREATED = "UserCreated"
+	EVENT_USER_UPDATED = "UserUpdated"
+	EVENT_USER_DELETED = "UserDeleted"
+)
+
+// Stati dei messaggi dell'outbox
+const (
+	OUTBOX_PENDING    = "pending"
+	OUTBOX_PROCESSING = "processing"
+	OUTBOX_PUBLISHED  = "published"
+	OUTBOX_FAILED     = "failed"
+)
+
+// Stati delle consegne dei webhook
+const (
+	DELIVERY_PENDING    = "pending"
+	DELIVERY_PROCESSING = "processing"
+	DELIVERY_DELIVERED  = "delivered"
+	DELIVERY_DEAD       = "dead"
+)
+
+// Header delle consegne dei webhook
+const (
+	WEBHOOK_ID_HEADER        = "X-Webhook-ID"
+	WEBHOOK_EVENT_HEADER     = "X-Webhook-Event"
+	WEBHOOK_SIGNATURE_HEADER = "X-Webhook-Signature"
 )
 
 //zipkin-Span
@@ -1,10 +1,15 @@
 package utils
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"myapp/internal/codec"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -15,27 +20,34 @@ type Response struct {
 	ErrorMessages map[string]interface{} `json:"errorMessages"`
 }
 
-// RespondWithJSON writes JSON response to the http.ResponseWriter
+// RespondWithJSON writes the response envelope to the http.ResponseWriter in the format negotiated
+// by ContentNegotiationMiddleware (JSON if the route does not negotiate it).
+// Tabular formats (CSV) cannot represent the envelope and receive the payload only.
 func RespondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
 	log := WithContext()
-	log.Info("Responding with JSON")
+	encoder := codec.FromResponseWriter(w)
+	log.Infof("Responding with %s", encoder.MediaType())
 
-	response := Response{
+	var body interface{} = Response{
 		Output:        payload,
 		ErrorMessages: make(map[string]interface{}), // Initialize as an empty map
 	}
+	if codec.IsTabular(encoder) {
+		body = payload
+	}
 
-	responseJSON, err := json.Marshal(response)
-	if err != nil {
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, body); err != nil {
 		log.Errorf("Error marshalling response: %v", err)
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(`{"errorMessages": {"message": "Internal Server Error"}}`))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", encoder.MediaType())
 	w.WriteHeader(status)
-	_, err = w.Write(responseJSON)
+	_, err := w.Write(buf.Bytes())
 	if err != nil {
 		log.Errorf("Error writing response: %v", err)
 	}
@@ -52,6 +64,30 @@ func RespondWithError(w http.ResponseWriter, code int, message string) {
 	RespondWithJSON(w, code, response)
 }
 
+// DecodeRequestBody decodes the request body into v with the codec selected by the Content-Type header
+// (JSON if missing); codec.ErrUnsupportedMediaType if no codec handles it
+func DecodeRequestBody(r *http.Request, v interface{}) error {
+	decoder, err := codec.ForContentType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(r.Body, v)
+}
+
+// RespondWithRequestBodyError writes the error for a request body that could not be decoded:
+// 413 if it exceeds the allowed size, 415 if its format is not supported, 400 otherwise
+func RespondWithRequestBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		RespondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+	case errors.Is(err, codec.ErrUnsupportedMediaType):
+		RespondWithError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Type")
+	default:
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	}
+}
+
 // GenerateUUID generates a new UUID
 func GenerateUUID() (string, error) {
 	id, err := uuid.NewRandom()
@@ -70,6 +106,43 @@ func EnvOrDefault(key, defaultValue string) string {
 	return value
 }
 
+// EnvIntOrDefault returns the environment variable parsed as int, otherwise the default value
+func EnvIntOrDefault(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		WithContext().Warnf("Invalid integer for %s: %q, using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// EnvDurationOrDefault returns the environment variable parsed as time.Duration (es. "30s", "24h"), otherwise the default value
+func EnvDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		WithContext().Warnf("Invalid duration for %s: %q, using default %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// ExponentialBackoff returns base * 2^(attempts-1), capped at max
+func ExponentialBackoff(base, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	return min(delay, max)
+}
+
 // CloseRequestBody closes the request body
 func CloseRequestBody(Body io.ReadCloser) {
 	log := WithContext()
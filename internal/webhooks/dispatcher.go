@@ -0,0 +1,177 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxDrainedBody è la porzione del corpo della risposta letta per riutilizzare la connessione
+const maxDrainedBody = 64 << 10
+
+// errSubscriptionGone indica che la sottoscrizione è stata eliminata o disattivata: la consegna non viene ritentata
+var errSubscriptionGone = errors.New("subscription deleted or disabled")
+
+// Dispatcher esegue le consegne dei webhook: firma il corpo con il secret della sottoscrizione,
+// lo invia con una POST e, se l'endpoint non risponde 2xx, ritenta con backoff esponenziale.
+// Superato MaxAttempts la consegna passa in stato dead (dead-letter) e può essere rimessa in coda dall'API.
+type Dispatcher struct {
+	Client       *http.Client
+	PollInterval time.Duration
+	BatchSize    int
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+// NewDispatcherFromEnv crea il dispatcher leggendo la configurazione dalle variabili d'ambiente
+func NewDispatcherFromEnv() *Dispatcher {
+	timeout := utils.EnvDurationOrDefault("WEBHOOK_TIMEOUT", 10*time.Second)
+	return &Dispatcher{
+		Client:       NewClient(timeout, AllowPrivateTargets()),
+		PollInterval: utils.EnvDurationOrDefault("WEBHOOK_POLL_INTERVAL", time.Second),
+		BatchSize:    utils.EnvIntOrDefault("WEBHOOK_BATCH_SIZE", 100),
+		Lease:        timeout + 5*time.Second,
+		MaxAttempts:  utils.EnvIntOrDefault("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseBackoff:  utils.EnvDurationOrDefault("WEBHOOK_BASE_BACKOFF", 5*time.Second),
+		MaxBackoff:   utils.EnvDurationOrDefault("WEBHOOK_MAX_BACKOFF", time.Hour),
+	}
+}
+
+// Run esegue il dispatcher finché il contesto non viene cancellato
+func (d *Dispatcher) Run(ctx context.Context) {
+	log := utils.WithContext().WithField("package", "webhooks")
+	log.Info("Webhook dispatcher started")
+
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.drain(ctx)
+		select {
+		case <-ctx.Done():
+			log.Info("Webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain esegue fino a BatchSize consegne pronte
+func (d *Dispatcher) drain(ctx context.Context) {
+	for i := 0; i < d.BatchSize && ctx.Err() == nil; i++ {
+		delivery, err := repository.ClaimWebhookDelivery(ctx, d.Lease)
+		if err != nil || delivery == nil {
+			return
+		}
+		d.deliver(ctx, *delivery)
+	}
+}
+
+// deliver esegue un tentativo di consegna e ne registra l'esito
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.WebhookDelivery) {
+	log := utils.WithContext().WithField("package", "webhooks")
+
+	started := time.Now()
+	statusCode, err := d.attempt(ctx, delivery)
+	attempt := models.WebhookAttempt{
+		At:         started.UTC(),
+		StatusCode: statusCode,
+		DurationMs: time.Since(started).Milliseconds(),
+	}
+
+	d.applyResult(&delivery, &attempt, err)
+	if err := repository.RecordWebhookAttempt(ctx, delivery, attempt); err != nil {
+		log.Errorf("Error recording attempt of webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// applyResult aggiorna la consegna e il tentativo con l'esito: consegnata, da ritentare con backoff esponenziale
+// oppure dead se la sottoscrizione non esiste più o i tentativi sono esauriti
+func (d *Dispatcher) applyResult(delivery *models.WebhookDelivery, attempt *models.WebhookAttempt, err error) {
+	log := utils.WithContext().WithField("package", "webhooks")
+
+	delivery.AttemptCount++
+	switch {
+	case err == nil:
+		delivered := time.Now().UTC()
+		delivery.Status = constants.DELIVERY_DELIVERED
+		delivery.DeliveredAt = &delivered
+		delivery.LastError = ""
+	case errors.Is(err, errSubscriptionGone) || delivery.AttemptCount >= d.MaxAttempts:
+		attempt.Error = err.Error()
+		delivery.Status = constants.DELIVERY_DEAD
+		delivery.LastError = err.Error()
+		log.Errorf("Webhook delivery %s to subscription %s is dead after %d attempts: %v", delivery.ID, delivery.SubscriptionID, delivery.AttemptCount, err)
+	default:
+		attempt.Error = err.Error()
+		delivery.Status = constants.DELIVERY_PENDING
+		delivery.NextAttemptAt = time.Now().Add(utils.ExponentialBackoff(d.BaseBackoff, d.MaxBackoff, delivery.AttemptCount))
+		delivery.LastError = err.Error()
+		log.Warnf("Webhook delivery %s to subscription %s failed (attempt %d): %v", delivery.ID, delivery.SubscriptionID, delivery.AttemptCount, err)
+	}
+}
+
+// attempt legge la sottoscrizione nel tenant che ha generato l'evento e vi invia la consegna con send
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.WebhookDelivery) (int, error) {
+	subscription, err := repository.GetWebhookByID(tenancy.WithTenant(ctx, delivery.TenantID), delivery.SubscriptionID)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && !subscription.Active) {
+		return 0, errSubscriptionGone
+	}
+	if err != nil {
+		return 0, err
+	}
+	return d.send(ctx, subscription, delivery)
+}
+
+// send firma l'evento con il secret della sottoscrizione, lo invia con una POST e restituisce lo status HTTP ricevuto
+func (d *Dispatcher) send(ctx context.Context, subscription *models.WebhookSubscription, delivery models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "myapp-webhooks/1.0")
+	// L'ID della consegna è lo stesso in tutti i tentativi: i destinatari lo usano per deduplicare
+	req.Header.Set(constants.WEBHOOK_ID_HEADER, delivery.ID)
+	req.Header.Set(constants.WEBHOOK_EVENT_HEADER, delivery.EventType)
+	req.Header.Set(constants.WEBHOOK_SIGNATURE_HEADER, Sign(subscription.Secret, time.Now(), body))
+	if delivery.Event.CorrelationID != "" {
+		req.Header.Set("X-Correlation-ID", delivery.Event.CorrelationID)
+	}
+
+	resp, err := d.Client.Do(req)
+	if errors.Is(err, ErrForbiddenTarget) {
+		// L'errore della connessione conterrebbe l'indirizzo interno risolto, visibile nello storico delle consegne
+		return 0, ErrForbiddenTarget
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Il corpo non è salvato: lo storico dei tentativi è esposto dall'API e non deve riportare contenuti dell'endpoint
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainedBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,199 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "whsec_0123456789abcdef"
+
+// receiver è un endpoint di test che verifica la firma di ogni consegna e risponde con gli status indicati in sequenza
+type receiver struct {
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	ids      []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("reading body: %v", err)
+	}
+	if err := Verify(testSecret, r.Header.Get(constants.WEBHOOK_SIGNATURE_HEADER), body, time.Minute, time.Now()); err != nil {
+		rc.t.Errorf("signature verification failed: %v", err)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.ids = append(rc.ids, r.Header.Get(constants.WEBHOOK_ID_HEADER))
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, "internal details of the receiver")
+}
+
+func newTestDispatcher(client *http.Client) *Dispatcher {
+	return &Dispatcher{Client: client, MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute}
+}
+
+func newTestDelivery() models.WebhookDelivery {
+	return models.WebhookDelivery{
+		ID:             "delivery-1",
+		SubscriptionID: "subscription-1",
+		EventType:      constants.EVENT_USER_CREATED,
+		Event:          models.UserEvent{ID: "event-1", Type: constants.EVENT_USER_CREATED, UserID: "user-1"},
+		Status:         constants.DELIVERY_PROCESSING,
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":"event-1"}`)
+	now := time.Unix(1700000000, 0)
+	header := Sign(testSecret, now, body)
+
+	if err := Verify(testSecret, header, body, time.Minute, now.Add(30*time.Second)); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := Verify(testSecret, header, []byte(`{"id":"event-2"}`), time.Minute, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered body: err=%v, want ErrInvalidSignature", err)
+	}
+	if err := Verify("whsec_another_secret", header, body, time.Minute, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("wrong secret: err=%v, want ErrInvalidSignature", err)
+	}
+	if err := Verify(testSecret, header, body, time.Minute, now.Add(2*time.Minute)); !errors.Is(err, ErrSignatureExpired) {
+		t.Errorf("old timestamp: err=%v, want ErrSignatureExpired", err)
+	}
+}
+
+func TestDeliveryRetriedUntilDelivered(t *testing.T) {
+	rc := &receiver{t: t, statuses: []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusNoContent}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d := newTestDispatcher(NewClient(5*time.Second, true))
+	subscription := &models.WebhookSubscription{ID: "subscription-1", URL: server.URL, Secret: testSecret, Active: true}
+	delivery := newTestDelivery()
+
+	for i, want := range []string{constants.DELIVERY_PENDING, constants.DELIVERY_PENDING, constants.DELIVERY_DELIVERED} {
+		statusCode, err := d.send(context.Background(), subscription, delivery)
+		attempt := models.WebhookAttempt{StatusCode: statusCode}
+		before := time.Now()
+		d.applyResult(&delivery, &attempt, err)
+
+		if delivery.Status != want {
+			t.Fatalf("attempt %d: status %q, want %q", i+1, delivery.Status, want)
+		}
+		if want == constants.DELIVERY_PENDING {
+			// Backoff esponenziale: 1s dopo il primo fallimento, 2s dopo il secondo
+			backoff := time.Duration(1<<i) * time.Second
+			if delay := delivery.NextAttemptAt.Sub(before); delay < backoff || delay > backoff+time.Second {
+				t.Errorf("attempt %d: next attempt in %v, want about %v", i+1, delay, backoff)
+			}
+			// Lo storico è esposto dall'API: contiene lo status ma non il corpo della risposta
+			if strings.Contains(delivery.LastError, "internal details") || strings.Contains(attempt.Error, "internal details") {
+				t.Errorf("attempt %d: response body stored in the error %q", i+1, delivery.LastError)
+			}
+		}
+	}
+	if delivery.AttemptCount != 3 || delivery.DeliveredAt == nil || delivery.LastError != "" {
+		t.Errorf("delivered: attempts=%d deliveredAt=%v lastError=%q", delivery.AttemptCount, delivery.DeliveredAt, delivery.LastError)
+	}
+	for _, id := range rc.ids {
+		if id != delivery.ID {
+			t.Errorf("delivery ID header %q, want %q in every attempt", id, delivery.ID)
+		}
+	}
+}
+
+func TestDeliveryDeadAfterMaxAttempts(t *testing.T) {
+	server := httptest.NewServer(&receiver{t: t, statuses: []int{500, 500, 500, 500}})
+	defer server.Close()
+
+	d := newTestDispatcher(NewClient(5*time.Second, true))
+	subscription := &models.WebhookSubscription{URL: server.URL, Secret: testSecret, Active: true}
+	delivery := newTestDelivery()
+	for i := 0; i < d.MaxAttempts; i++ {
+		statusCode, err := d.send(context.Background(), subscription, delivery)
+		d.applyResult(&delivery, &models.WebhookAttempt{StatusCode: statusCode}, err)
+	}
+	if delivery.Status != constants.DELIVERY_DEAD {
+		t.Fatalf("status %q after %d failures, want %q", delivery.Status, d.MaxAttempts, constants.DELIVERY_DEAD)
+	}
+	if delivery.LastError != "endpoint responded with status 500" {
+		t.Errorf("last error %q", delivery.LastError)
+	}
+}
+
+func TestClientRefusesPrivateTargets(t *testing.T) {
+	server := httptest.NewServer(&receiver{t: t})
+	defer server.Close()
+
+	// httptest ascolta su 127.0.0.1: senza allowPrivate la connessione è rifiutata dopo la risoluzione
+	d := newTestDispatcher(NewClient(5*time.Second, false))
+	subscription := &models.WebhookSubscription{URL: server.URL, Secret: testSecret, Active: true}
+	if _, err := d.send(context.Background(), subscription, newTestDelivery()); !errors.Is(err, ErrForbiddenTarget) {
+		t.Fatalf("loopback target: err=%v, want ErrForbiddenTarget", err)
+	}
+	subscription.URL = strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	if _, err := d.send(context.Background(), subscription, newTestDelivery()); !errors.Is(err, ErrForbiddenTarget) {
+		t.Fatalf("localhost target: err=%v, want ErrForbiddenTarget", err)
+	}
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	var redirected bool
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { redirected = true }))
+	defer internal.Close()
+	server := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusTemporaryRedirect))
+	defer server.Close()
+
+	d := newTestDispatcher(NewClient(5*time.Second, true))
+	subscription := &models.WebhookSubscription{URL: server.URL, Secret: testSecret, Active: true}
+	statusCode, err := d.send(context.Background(), subscription, newTestDelivery())
+	if err == nil || statusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("redirect: status=%d err=%v, want a failed attempt with status 307", statusCode, err)
+	}
+	if redirected {
+		t.Error("redirect was followed")
+	}
+}
+
+func TestForbiddenAddress(t *testing.T) {
+	for address, forbidden := range map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"100.64.0.1":      true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fe80::1":         true,
+		"fd00::1":         true,
+		"::ffff:10.0.0.1": true,
+		"8.8.8.8":         false,
+		"2001:4860::8888": false,
+	} {
+		if got := ForbiddenAddress(netip.MustParseAddr(address)); got != forbidden {
+			t.Errorf("ForbiddenAddress(%s) = %v, want %v", address, got, forbidden)
+		}
+	}
+	for host, wantErr := range map[string]bool{"localhost": true, "api.localhost": true, "[::1]": true, "169.254.169.254": true, "example.com": false} {
+		if err := ValidateTargetHost(host); (err != nil) != wantErr {
+			t.Errorf("ValidateTargetHost(%s) = %v, want error %v", host, err, wantErr)
+		}
+	}
+}
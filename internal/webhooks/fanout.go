@@ -0,0 +1,62 @@
+package webhooks
+
+import (
+	"context"
+	"myapp/internal/models"
+	"myapp/internal/repository"
+	"myapp/internal/tenancy"
+	"myapp/internal/utils/constants"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// deliveryNamespace è il namespace degli UUID v5 delle consegne (vedi deliveryID)
+var deliveryNamespace = uuid.MustParse("5b0f7c1e-3c2a-4d8e-9a61-2f4b8e0d7c35")
+
+// FanoutSink è il sink dell'outbox che crea una consegna per ogni sottoscrizione interessata all'evento.
+// Le consegne sono poi eseguite dal Dispatcher, con retry indipendenti per ogni sottoscrizione:
+// un endpoint lento o irraggiungibile non blocca la pubblicazione degli altri eventi.
+type FanoutSink struct{}
+
+// NewFanoutSink crea il sink dei webhook
+func NewFanoutSink() *FanoutSink {
+	return &FanoutSink{}
+}
+
+func (s *FanoutSink) Name() string {
+	return "webhooks"
+}
+
+func (s *FanoutSink) Publish(ctx context.Context, event models.UserEvent) error {
+	// Le sottoscrizioni interessate sono solo quelle del tenant dell'evento
+	ctx = tenancy.WithTenant(ctx, event.TenantID)
+	subscriptions, err := repository.FindWebhooksForEvent(ctx, event.Type)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ID:             deliveryID(event.ID, subscription.ID),
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Event:          event,
+			Status:         constants.DELIVERY_PENDING,
+			NextAttemptAt:  now,
+			TenantID:       event.TenantID,
+			CreatedAt:      now,
+		})
+	}
+	return repository.InsertWebhookDeliveries(ctx, deliveries)
+}
+
+// deliveryID restituisce l'ID della consegna di un evento a una sottoscrizione: un UUID v5 derivato dalle due chiavi,
+// così un evento ripubblicato dall'outbox produce la stessa consegna, che l'inserimento ignora come duplicata,
+// e il destinatario riceve sempre lo stesso X-Webhook-ID
+func deliveryID(eventID, subscriptionID string) string {
+	return uuid.NewSHA1(deliveryNamespace, []byte(eventID+"/"+subscriptionID)).String()
+}
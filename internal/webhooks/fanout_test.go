@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"context"
+	"myapp/internal/config"
+	"myapp/internal/models"
+	"myapp/internal/utils/constants"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestDeliveryIDIsDerivedFromEventAndSubscription(t *testing.T) {
+	id := deliveryID("event-1", "subscription-1")
+	if id != deliveryID("event-1", "subscription-1") {
+		t.Error("the same event and subscription produced different delivery IDs")
+	}
+	for _, other := range []string{deliveryID("event-2", "subscription-1"), deliveryID("event-1", "subscription-2")} {
+		if other == id {
+			t.Errorf("delivery ID %q shared by another event or subscription", id)
+		}
+	}
+}
+
+func TestFanoutSinkRepublishesTheSameDeliveries(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("republished event", func(mt *mtest.T) {
+		config.SetDatabase(mt.Client, mt.DB)
+		subscriptions := []bson.D{
+			{{Key: "_id", Value: "subscription-1"}, {Key: "url", Value: "https://a.example.com"}},
+			{{Key: "_id", Value: "subscription-2"}, {Key: "url", Value: "https://b.example.com"}},
+		}
+		event := models.UserEvent{ID: "event-1", Type: constants.EVENT_USER_CREATED}
+
+		var published [][]string
+		for attempt := 0; attempt < 2; attempt++ {
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "myapp."+constants.WEBHOOKSCOLLECTION, mtest.FirstBatch, subscriptions...),
+				mtest.CreateSuccessResponse(),
+			)
+			if err := NewFanoutSink().Publish(context.Background(), event); err != nil {
+				mt.Fatalf("publish: %v", err)
+			}
+			mt.GetStartedEvent() // find delle sottoscrizioni
+			insert := mt.GetStartedEvent()
+			if insert == nil || insert.CommandName != "insert" {
+				mt.Fatal("no deliveries were inserted")
+			}
+			documents, _ := insert.Command.Lookup("documents").Array().Values()
+			var ids []string
+			for _, document := range documents {
+				ids = append(ids, document.Document().Lookup("_id").StringValue())
+			}
+			published = append(published, ids)
+		}
+
+		if len(published[0]) != 2 || published[0][0] == published[0][1] {
+			mt.Fatalf("delivery IDs %v, want one per subscription", published[0])
+		}
+		for i := range published[0] {
+			if published[0][i] != published[1][i] {
+				mt.Errorf("delivery %d has ID %q, then %q when republished", i, published[0][i], published[1][i])
+			}
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature indica che l'header di firma è malformato o non corrisponde al corpo
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrSignatureExpired indica che il timestamp della firma è fuori dalla tolleranza (possibile replay)
+	ErrSignatureExpired = errors.New("webhook signature timestamp outside tolerance")
+)
+
+// Sign calcola l'header X-Webhook-Signature nel formato "t=<unix>,v1=<hex>",
+// dove v1 è l'HMAC-SHA256 con il secret della sottoscrizione di "<unix>.<corpo>".
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", unix, computeSignature(secret, unix, body))
+}
+
+// Verify controlla l'header di firma ricevuto da un endpoint: i destinatari dei webhook possono usarlo
+// per autenticare le consegne. Una tolleranza > 0 rifiuta le firme con timestamp troppo distante da now.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ErrInvalidSignature
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if tolerance > 0 {
+		if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+			return ErrSignatureExpired
+		}
+	}
+
+	expected := []byte(computeSignature(secret, timestamp, body))
+	for _, signature := range signatures {
+		if hmac.Equal(expected, []byte(signature)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// computeSignature restituisce l'HMAC-SHA256 esadecimale di "<timestamp>.<corpo>"
+func computeSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
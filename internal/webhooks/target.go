@@ -0,0 +1,85 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"myapp/internal/utils"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenTarget indica un endpoint su un indirizzo non pubblico (loopback, rete privata, link-local come
+// il servizio di metadata 169.254.169.254, ...): le consegne non devono raggiungere la rete interna del servizio
+var ErrForbiddenTarget = errors.New("webhook target address not allowed")
+
+// sharedAddressSpace è la rete 100.64.0.0/10 del carrier-grade NAT (RFC 6598), non coperta da netip.Addr.IsPrivate
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// ForbiddenAddress indica se un indirizzo non può essere destinatario di un webhook
+func ForbiddenAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() || sharedAddressSpace.Contains(addr)
+}
+
+// AllowPrivateTargets indica se WEBHOOK_ALLOW_PRIVATE_TARGETS=true consente endpoint su indirizzi non pubblici,
+// per gli ambienti di sviluppo in cui il destinatario gira sulla stessa macchina
+func AllowPrivateTargets() bool {
+	return utils.EnvOrDefault("WEBHOOK_ALLOW_PRIVATE_TARGETS", "false") == "true"
+}
+
+// ValidateTargetHost rifiuta alla registrazione gli host che sono già riconoscibili come interni:
+// indirizzi IP non pubblici e localhost. I nomi DNS sono verificati a ogni connessione da NewClient,
+// perché la risoluzione può cambiare dopo la registrazione.
+func ValidateTargetHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrForbiddenTarget
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil && ForbiddenAddress(addr) {
+		return ErrForbiddenTarget
+	}
+	return nil
+}
+
+// NewClient crea il client HTTP delle consegne. Senza allowPrivate ogni connessione verso un indirizzo
+// non pubblico è rifiutata dopo la risoluzione DNS, così un nome che punta alla rete interna (anche cambiato
+// dopo la registrazione) non la raggiunge. I redirect non sono seguiti: la risposta 3xx conta come fallimento.
+// Il proxy d'ambiente non è usato, perché la connessione al proxy nasconderebbe l'indirizzo finale.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = rejectForbiddenAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// rejectForbiddenAddress è il Control del dialer: riceve l'indirizzo già risolto, appena prima della connessione
+func rejectForbiddenAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenTarget, address)
+	}
+	if ForbiddenAddress(addr) {
+		return ErrForbiddenTarget
+	}
+	return nil
+}